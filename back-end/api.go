@@ -3,27 +3,28 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	mod "vaibhavyadav-dev/healthcareServer/databases"
+	"vaibhavyadav-dev/healthcareServer/events"
+	"vaibhavyadav-dev/healthcareServer/i18n"
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+	rd "vaibhavyadav-dev/healthcareServer/redis"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/go-redis/redis/v8"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
-
-	"github.com/rs/cors"
-
-	// for monitoring
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Client, Patient, User refer to same thing their names are used interchangebly !!!!
@@ -38,18 +39,52 @@ const (
 
 type Store interface {
 	// PostgreSQL Methods goes here...
-	SignUpAccount(*mod.HIPInfo) (int64, error)
+	// the mq.Message arguments are written to the outbox in the same transaction as the change
+	SignUpAccount(*mod.HIPInfo, ...mq.Message) (int64, error)
 	LoginUser(*mod.Login) (*mod.HIPInfo, error)
-	ChangePreferance(string, map[string]interface{}) error
+	ChangePreferance(string, map[string]interface{}, ...mq.Message) error
 	GetPreferance(string) (*mod.Preferance, error)
 	GetTotalRequestCount(string) (int, error)
 	CreateClient_stats(string) error
 	GetAppointments_postgres(health_id string, offset, limit int64) ([]*mod.Appointments, error)
 	SetAppointments_postgres(healthcare_id, health_id, status string, id int64) (int64, error)
-	Create_ClientProfile(*mod.PatientDetails) error
+	CheckAppointment(healthcare_id, health_id string, id int64) error
+	Create_ClientProfile(*mod.PatientDetails, ...mq.Message) error
 	Get_ClientProfile(string) (*mod.PatientDetails, error)
-	Update_clientProfile(string, map[string]interface{}) (*mod.PatientDetails, error)
+	Update_clientProfile(health_id string, patch *mod.ProfilePatch, ifVersion int, change mod.ProfileChange) (*mod.PatientDetails, error)
+	ListProfileVersions(health_id string) ([]*mod.ProfileVersion, error)
+	GetProfileVersion(health_id string, version int) (*mod.PatientDetails, error)
+	GetClientProfileAsOf(health_id string, at time.Time) (*mod.PatientDetails, error)
 	GetHealthcare_details_postgres(string) (*mod.HIPInfo, error)
+	GetAddressReviewQueue(healthcare_id string, limit int64) ([]*mod.PatientDetails, error)
+	SearchClientProfiles(healthcare_id string, q *mod.PatientSearch) ([]*mod.PatientMatch, error)
+	FindDuplicates(*mod.PatientDetails) ([]*mod.DuplicateCandidate, error)
+	GetDuplicateQueue(healthcare_id, status string, limit int64) ([]*mod.DuplicateCandidate, error)
+	DismissDuplicate(healthcare_id string, id int64) error
+	MergeClientProfiles(healthcare_id, surviving, merged string) (*mod.PatientMerge, error)
+	UnmergeClientProfiles(healthcare_id string, mergeID int64) (*mod.PatientMerge, error)
+	// merges of patients registered by two HIPs wait for the other HIP to approve them
+	RequestMerge(healthcare_id, surviving, merged string) (*mod.MergeRequest, error)
+	ListMergeRequests(healthcare_id, status string, limit int64) ([]*mod.MergeRequest, error)
+	ApproveMergeRequest(healthcare_id string, id int64) (*mod.PatientMerge, error)
+	RejectMergeRequest(healthcare_id string, id int64) (*mod.MergeRequest, error)
+	// webhooks of the HIP, deliveries are queued from the event log and posted by the store
+	CreateWebhook(*mod.Webhook) error
+	ListWebhooks(healthcare_id string) ([]*mod.Webhook, error)
+	GetWebhook(healthcare_id string, id int64) (*mod.Webhook, error)
+	UpdateWebhook(healthcare_id string, id int64, patch *mod.WebhookPatch) (*mod.Webhook, error)
+	DeleteWebhook(healthcare_id string, id int64) error
+	ListWebhookDeliveries(healthcare_id string, webhookID int64, status string, limit int64) ([]*mod.WebhookDelivery, error)
+	GetWebhookDelivery(healthcare_id string, webhookID, deliveryID int64) (*mod.WebhookDelivery, error)
+	RedeliverWebhook(healthcare_id string, webhookID, deliveryID int64) (*mod.WebhookDelivery, error)
+	// notifications about the HIP and its patients, queued from the event log and sent by the store
+	// a patient's preferences are set by the HIP that registered the patient or has an appointment with them
+	GetNotificationPreferences(healthcare_id, recipientType, recipientID string) (*mod.NotificationPreferences, error)
+	SetNotificationPreferences(healthcare_id string, prefs *mod.NotificationPreferences) error
+	ListNotifications(healthcare_id, status string, limit int64) ([]*mod.Notification, error)
+	GetNotification(healthcare_id string, id int64) (*mod.Notification, error)
+	// a patient's text message answering an appointment reminder
+	AnswerReminder(from, text string) (*mod.ReminderReply, error)
 
 	/////////////////////////////////////////////////////////////////////////////
 	/////////////////////////////////////////////////////////////////////////////
@@ -71,11 +106,8 @@ type Store interface {
 	/////////////////////////////////////////////////////////////////////////////
 	/////////////////////////////////////////////////////////////////////////////
 	// Rabbitmq methods goes here...
-	Push_logs(interface{}, interface{}, interface{}, interface{}, interface{}, interface{}) error
-	Push_update_appointment(appointment map[string]interface{}) error
-	Push_patient_records(map[string]interface{}) error
-	Push_patientbiodata(map[string]interface{}) error
-	Push_counters(string, string) error
+	// Enqueue writes messages to the outbox, a relay publishes them to rabbitmq
+	Enqueue(...mq.Message) error
 
 	/////////////////////////////////////////////////////////////////////////////
 	/////////////////////////////////////////////////////////////////////////////
@@ -83,6 +115,13 @@ type Store interface {
 	Set(string, interface{}) error
 	Get(string) (interface{}, error)
 	Close() error
+	// Idempotency-Key claims and the responses replayed for them (idempotency.go)
+	ClaimIdempotencyKey(key string, req *rd.IdempotentRequest, ttl time.Duration) (*rd.IdempotentRequest, error)
+	SaveIdempotentResponse(key string, req *rd.IdempotentRequest, ttl time.Duration) error
+	ReleaseIdempotencyKey(key string) error
+	// live updates for the HIP's streams (stream.go), the ones after an update ID come from the event log
+	SubscribeLive(healthcare_id string) (<-chan rd.LiveUpdate, func(), error)
+	LiveUpdatesSince(healthcare_id string, after int64) ([]rd.LiveUpdate, error)
 	// rate limiter goes here...
 	IsAllowed(string) (bool, error)
 	IsAllowed_leaky_bucket(string) (bool, error)
@@ -101,88 +140,26 @@ func NewAPIServer(listen string, store Store) *APIServer {
 }
 
 func (s *APIServer) Run() {
-	router := mux.NewRouter()
-	// Add Prometheus middleware to all routes
-	router.Use(PrometheusMiddleware)
-	router.Path("/metrics").Handler(promhttp.Handler())
-
-	router.HandleFunc("/api/v1/healthcare/auth/register", (makeHTTPHandlerFunc(s.SignUp)))
-	router.HandleFunc("/api/v1/healthcare/auth/login", (makeHTTPHandlerFunc(s.LoginUser)))
-
-	// this one will serve from postgres
-	router.HandleFunc("/api/v1/healthcare/preferance/get", withJWTAuth(s.RateLimiter(makeHTTPHandlerFunc(s.GetPreferance))))
-	router.HandleFunc("/api/v1/healthcare/preferance/change", withJWTAuth(s.RateLimiter(makeHTTPHandlerFunc(s.Update_Preferance))))
-	router.HandleFunc("/api/v1/healthcare/delete/account", withJWTAuth(s.RateLimiter(makeHTTPHandlerFunc(s.DeleteAccount))))
-
-	// this is will server from mongodb
-	router.HandleFunc("/api/v1/healthcare/appointments/get", withJWTAuth(s.RateLimiter(makeHTTPHandlerFunc(s.GetAppointments))))
-	router.HandleFunc("/api/v1/healthcare/appointments/set", withJWTAuth(s.RateLimiter(makeHTTPHandlerFunc(s.SetAppointments))))
-	router.HandleFunc("/api/v1/healthcare/details", withJWTAuth(s.RateLimiter(makeHTTPHandlerFunc(s.GetHealthcare_details))))
-
-	router.HandleFunc("/api/v1/healthcare/client/records/create", withJWTAuth(s.RateLimiter(makeHTTPHandlerFunc(s.CreatepatientRecords))))
-	router.HandleFunc("/api/v1/healthcare/client/records/fetch", withJWTAuth(s.RateLimiter(makeHTTPHandlerFunc(s.GetPatientRecords))))
-
-	router.HandleFunc("/api/v1/healthcare/client/profile/create", withJWTAuth(s.RateLimiter(makeHTTPHandlerFunc(s.Create_ClientProfile))))
-	router.HandleFunc("/api/v1/healthcare/client/profile/get", withJWTAuth(s.RateLimiter(makeHTTPHandlerFunc(s.Get_clientProfile))))
-	router.HandleFunc("/api/v1/healthcare/client/profile/update", withJWTAuth(s.RateLimiter(makeHTTPHandlerFunc(s.UpdateClientProfile))))
-
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
-		AllowCredentials: true,
-	})
-
-	// Wrap the router with CORS handler
-	handler := c.Handler(router)
-
 	log.Println("HealthCare Server running on Port: ", s.listenAddr)
-	if err := http.ListenAndServe(s.listenAddr, handler); err != nil {
+	if err := http.ListenAndServe(s.listenAddr, s.Handler()); err != nil {
 		log.Fatal(err)
 	}
 }
 
 func (s *APIServer) SignUp(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "POST" {
-		return writeJSON(w, http.StatusNotAcceptable, map[string]interface{}{
-			"message": "Method Not Allowed",
-		})
-	}
-
 	req := mod.HIPInfo{}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{
-			"message": "Something mishappened from our side :)",
-			"error":   err.Error(),
-		})
+		return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err)
 	}
 
 	user, err := mod.SignUpAccount(&req)
-	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Something mishappened from our side :)",
-			"error":   err.Error(),
-		})
+	if errors.Is(err, mod.ErrInvalidAddress) {
+		return newProblem(http.StatusUnprocessableEntity, i18n.InvalidAddress).withCause(err)
 	}
-
-	// store in postgres !!
-	_, err = s.store.SignUpAccount(user)
 	if err != nil {
-		return writeJSON(w, http.StatusNotAcceptable, map[string]interface{}{
-			"message": "User already exists",
-			"err":     err.Error(),
-		})
+		return internalError(err)
 	}
 
-	// store in mongoDB also !!
-	// _, err = s.store.CreateHealthcare_details(user)
-	// if err != nil {
-	// 	return writeJSON(w, http.StatusNotAcceptable, map[string]interface{}{
-	// 		"message": "User already exists",
-	// 		"err":     err.Error(),
-	// 	})
-	// }
-
 	// GET IP Addrress of user
 	// for logging and monitering purpose only, this will help to
 	// moniter account
@@ -195,17 +172,34 @@ func (s *APIServer) SignUp(w http.ResponseWriter, r *http.Request) error {
 	if ip == "" {
 		ip, _, _ = net.SplitHostPort(r.RemoteAddr)
 	}
-	// send Email to healthcare that his account has been created now
-	err = s.store.Push_logs("hip_accountCreated", user.HealthcareName, user.Email, ip, user.HealthcareName, user.HealthcareID)
+	// send Email to healthcare that his account has been created now,
+	// it goes out only if the account is stored
+	created, err := events.Message(events.HIPAccountCreated{
+		HIP:   events.HIP{HealthcareID: user.HealthcareID, HealthcareName: user.HealthcareName},
+		Email: user.Email, IPAddress: ip,
+	})
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Something mishappened from our side :)",
-			"error":   err.Error(),
-		})
+		return internalError(err)
 	}
 
+	// store in postgres !!
+	_, err = s.store.SignUpAccount(user, created)
+	if err != nil {
+		return newProblem(http.StatusConflict, i18n.HIPAlreadyExists).withCause(err)
+	}
+
+	// store in mongoDB also !!
+	// _, err = s.store.CreateHealthcare_details(user)
+	// if err != nil {
+	// 	return writeJSON(w, http.StatusNotAcceptable, map[string]interface{}{
+	// 		"message": "User already exists",
+	// 		"err":     err.Error(),
+	// 	})
+	// }
+
 	return writeJSON(w, http.StatusCreated, map[string]interface{}{
-		"status": "Successfully Created",
+		"code":   i18n.HIPCreated,
+		"status": msg(r, i18n.HIPCreated),
 		"Healthcare_details": map[string]interface{}{
 			"healthcare_id":      user.HealthcareID,
 			"healthcare_license": user.HealthcareLicense,
@@ -216,41 +210,42 @@ func (s *APIServer) SignUp(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (s *APIServer) LoginUser(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "POST" {
-		return writeJSON(w, http.StatusNotAcceptable, map[string]interface{}{
-			"message": "Method Not Allowed",
-		})
-	}
-
 	login := &mod.Login{}
 	if err := json.NewDecoder(r.Body).Decode(&login); err != nil {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"message": "could not process your request please check your schema",
-		})
+		return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err)
 	}
 
 	// check for total_request
 	ok, err := s.store.IsAllowed(login.HealthcareID)
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Something went wrong from our side",
-		})
+		return internalError(err)
 	}
 
 	// block request if limit exceeded
 	if !ok {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"status":  "Your request quota has been exhausted",
-			"message": "Mail 21vaibhav11@gmail.com with your Id to increase your quota",
-		})
+		return newProblem(http.StatusTooManyRequests, i18n.QuotaExhausted)
 	}
 
 	hip, err := s.store.LoginUser(login)
 	if err != nil {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"message": "No user Found!",
-		})
+		return newProblem(http.StatusUnauthorized, i18n.HIPNotFound).withCause(err)
+	}
+	// check quota limit
+	// from sql database first
+	count, err := s.store.GetTotalRequestCount(login.HealthcareID)
+	if err != nil {
+		return internalError(err)
 	}
+	// if count of request limit reached don't allow user to login
+	// limit the user
+	if count <= 0 {
+		return newProblem(http.StatusTooManyRequests, i18n.QuotaExhausted)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hip.Password), []byte(login.Password)); err != nil {
+		return newProblem(http.StatusUnauthorized, i18n.PasswordMismatch)
+	}
+
 	// GET IP Addrress of user
 	// for logging and monitering purpose only, this will help you to
 	// moniter your account
@@ -263,35 +258,16 @@ func (s *APIServer) LoginUser(w http.ResponseWriter, r *http.Request) error {
 	if ip == "" {
 		ip, _, _ = net.SplitHostPort(r.RemoteAddr)
 	}
-	// Notify user everytime user login !
-	err = s.store.Push_logs("hip_accountLogin", hip.HealthcareName, hip.Email, ip, hip.HealthcareName, hip.HealthcareID)
-	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Something went wrong from our side",
-		})
-	}
-	// check quota limit
-	// from sql database first
-	count, err := s.store.GetTotalRequestCount(login.HealthcareID)
+	// Notify user everytime user login ! the counters projection takes one off the quota for it
+	login_log, err := events.Message(events.HIPLoggedIn{
+		HIP:   events.HIP{HealthcareID: hip.HealthcareID, HealthcareName: hip.HealthcareName},
+		Email: hip.Email, IPAddress: ip,
+	})
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Something mishappened from our side :)",
-			"error":   err.Error(),
-		})
-	}
-	// if count of request limit reached don't allow user to login
-	// limit the user
-	if count <= 0 {
-		return writeJSON(w, http.StatusNotAcceptable, map[string]interface{}{
-			"message": "Your Request Quota Has been reached",
-			"status":  "Quota Limit Reached (mail 21vaibhav11@gmail.com to increase the limit)",
-		})
+		return internalError(err)
 	}
-
-	if err := bcrypt.CompareHashAndPassword([]byte(hip.Password), []byte(login.Password)); err != nil {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"message": "password mismatched",
-		})
+	if err := s.store.Enqueue(login_log); err != nil {
+		return internalError(err)
 	}
 
 	// create token everytime user login !!
@@ -309,95 +285,83 @@ func (s *APIServer) LoginUser(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (s *APIServer) Update_Preferance(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodPatch {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"error": r.Method + " method not allowed",
-		})
-	}
-
 	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "HealthID not found in token"})
+		return missingClaim("healthcareID")
 	}
 
 	// Decode the request body into a map
 	var req map[string]interface{}
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"message": "Error reading request body: " + err.Error(),
-		})
+		return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err)
 	}
 
 	// Define valid fields and their types
-	validFields := map[string]func(interface{}) error{
-		"email": func(value interface{}) error {
+	// each validator returns the message code for the broken rule
+	validFields := map[string]func(interface{}) i18n.Code{
+		"email": func(value interface{}) i18n.Code {
 			str, ok := value.(string)
 			if !ok || !isValidEmail(str) {
-				return fmt.Errorf("invalid email format")
+				return i18n.FieldEmail
 			}
-			return nil
+			return ""
 		},
-		"isAvailable": func(value interface{}) error {
+		"isAvailable": func(value interface{}) i18n.Code {
 			_, ok := value.(bool)
 			if !ok {
-				return fmt.Errorf("isAvailable must be a boolean")
+				return i18n.FieldInvalid
 			}
-			return nil
+			return ""
 		},
-		"scheduled_deletion": func(value interface{}) error {
+		"scheduled_deletion": func(value interface{}) i18n.Code {
 			_, ok := value.(bool)
 			if !ok {
-				return fmt.Errorf("scheduled_deletion must be a boolean")
+				return i18n.FieldInvalid
 			}
-			return nil
+			return ""
 		},
 	}
 
 	// Validate and filter the request fields
 	updates := make(map[string]interface{})
+	invalid := newProblem(http.StatusUnprocessableEntity, i18n.ValidationFailed)
 	for field, validator := range validFields {
 		if value, exists := req[field]; exists {
-			if err := validator(value); err != nil {
-				return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-					"message": fmt.Sprintf("Validation error on field '%s': %s", field, err.Error()),
-				})
+			if code := validator(value); code != "" {
+				invalid.Errors = append(invalid.Errors, FieldProblem{Field: field, Code: code, args: []any{field}})
+				continue
 			}
 			updates[field] = value
 		}
 	}
+	if len(invalid.Errors) > 0 {
+		return invalid
+	}
 
 	// No fields has been provided
 	if len(updates) == 0 {
-		return writeJSON(w, http.StatusBadRequest, map[string]string{
-			"message": "No valid fields to update",
-		})
+		return newProblem(http.StatusBadRequest, i18n.NoFieldsToUpdate)
 	}
 
 	// Perform the update in the postgresDB
 	err = s.store.ChangePreferance(healthcareID, updates)
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"error": "Failed to update preferences: " + err.Error(),
-		})
+		return internalError(err)
 	}
 
 	return writeJSON(w, http.StatusOK, map[string]interface{}{
-		"status":      "Preferences updated successfully",
+		"code":        i18n.PreferencesUpdated,
+		"status":      msg(r, i18n.PreferencesUpdated),
 		"preferances": updates,
 	})
 }
 
 func (s *APIServer) GetPreferance(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "GET" {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"error": r.Method + " method not allowed",
-		})
-	}
 	pref := &mod.Preferance{}
 	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "HealthID not found in token"})
+		return missingClaim("healthcareID")
 	}
 
 	// check if cache are needed or not
@@ -411,9 +375,7 @@ func (s *APIServer) GetPreferance(w http.ResponseWriter, r *http.Request) error
 		fetched, err := s.store.Get("hip:pref:" + healthcareID)
 		if err != redis.Nil {
 			if err != nil {
-				return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-					"error": err.Error(),
-				})
+				return internalError(err)
 			}
 
 			if fetched != nil {
@@ -423,17 +385,13 @@ func (s *APIServer) GetPreferance(w http.ResponseWriter, r *http.Request) error
 				})
 
 				if !ok {
-					return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-						"error": "Failed to convert data to expected format",
-					})
+					return internalError(fmt.Errorf("cached value is %T", fetched))
 				}
 
 				var jsonBody *mod.Preferance
 				err = json.Unmarshal([]byte(fetchedData.Value), &jsonBody)
 				if err != nil {
-					return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-						"error": "Failed to parse the data",
-					})
+					return internalError(err)
 				}
 
 				return writeJSON(w, http.StatusOK, map[string]interface{}{
@@ -447,19 +405,13 @@ func (s *APIServer) GetPreferance(w http.ResponseWriter, r *http.Request) error
 	// fetch from database
 	pref, err := s.store.GetPreferance(healthcareID)
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Something mishappened from our side :)",
-			"error":   err.Error(),
-		})
+		return internalError(err)
 	}
 
 	// Store into redis
 	err = s.store.Set("hip:pref:"+healthcareID, pref)
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Something mishappened from our side :)",
-			"error":   err.Error(),
-		})
+		return internalError(err)
 	}
 
 	return writeJSON(w, http.StatusOK, map[string]interface{}{
@@ -468,56 +420,47 @@ func (s *APIServer) GetPreferance(w http.ResponseWriter, r *http.Request) error
 }
 
 func (s *APIServer) DeleteAccount(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "DELETE" {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"error": r.Method + " method not allowed",
-		})
-	}
 	req := map[string]interface{}{
 		"scheduled_deletion": true,
 	}
 	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "HealthID not found in token"})
+		return missingClaim("healthcareID")
 	}
 	email_healthcareID, ok := r.Context().Value(contextKeyEmailHealthCareID).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "HealthID not found in token"})
+		return missingClaim("healthcare_email")
 	}
 	healthcare_name, ok := r.Context().Value(contextKeyHealthCareName).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "HealthID not found in token"})
+		return missingClaim("healthcare_name")
 	}
-	err := s.store.ChangePreferance(healthcareID, req)
+	// Send email to user, with the deletion scheduled in the same transaction
+	deletion, err := events.Message(events.HIPDeletionScheduled{
+		HIP:   events.HIP{HealthcareID: healthcareID, HealthcareName: healthcare_name},
+		Email: email_healthcareID,
+	})
 	if err != nil {
-		return writeJSON(w, http.StatusNotImplemented, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return internalError(err)
 	}
-
-	// Send email to user
-	err = s.store.Push_logs("hip_deleteAccount", healthcare_name, email_healthcareID, nil, healthcare_name, healthcareID)
+	err = s.store.ChangePreferance(healthcareID, req, deletion)
 	if err != nil {
-		return writeJSON(w, http.StatusNotImplemented, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return internalError(err)
 	}
 
 	return writeJSON(w, http.StatusOK, map[string]interface{}{
-		"status":  "Account deletion scheduled",
-		"message": "mail tron21vaibhav@gmail.com to remove deletion ASAP.",
+		"code":    i18n.AccountDeletionScheduled,
+		"status":  msg(r, i18n.AccountDeletionScheduled),
+		"message": msg(r, i18n.AccountDeletionScheduled),
 	})
 }
 
 /////////////////////////////// MONGODB METHODS GOES HERE //////////////////////////////////
 
 func (s *APIServer) GetAppointments(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "GET" {
-		return fmt.Errorf("%s method is not allowed", r.Method)
-	}
 	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "HealthID not found in token"})
+		return missingClaim("healthcareID")
 	}
 	query := r.URL.Query()
 	listStr := query.Get("limit")
@@ -526,17 +469,12 @@ func (s *APIServer) GetAppointments(w http.ResponseWriter, r *http.Request) erro
 		var err error
 		list, err = strconv.Atoi(listStr)
 		if err != nil {
-			return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"message": "Server error: " + err.Error(),
-			})
+			return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "limit").withCause(err)
 		}
 	}
 	appointments, err := s.store.GetAppointments_postgres(healthcareID, 0, int64(list))
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"status": "Something went wrong from our side",
-			"error":  "error: " + err.Error(),
-		})
+		return internalError(err)
 	}
 
 	// print [] array always if appointis empty
@@ -551,39 +489,34 @@ func (s *APIServer) GetAppointments(w http.ResponseWriter, r *http.Request) erro
 
 // Set status of appointments
 func (s *APIServer) SetAppointments(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "POST" {
-		return fmt.Errorf("%s method is not allowed", r.Method)
-	}
 	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "HealthID not found in token"})
+		return missingClaim("healthcareID")
 	}
 
-	// append healthcare ID
 	update := &mod.UpdateAppointment{}
-	update.HealthcareID = healthcareID
 	err := json.NewDecoder(r.Body).Decode(&update)
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"err":     err.Error(),
-			"message": "Internal Server Error: could not process data",
-		})
+		return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err)
+	}
+	// the HIP is the one in the token, never one from the body
+	update.HealthcareID = healthcareID
+	if id, ok, err := pathID(r, "id"); err != nil {
+		return err
+	} else if ok {
+		update.ID = id
 	}
 
-	if update.Status != "Confirmed" && update.Status != "Rejected" && update.Status != "Pending" && update.Status != "Not Available" {
-		return writeJSON(w, http.StatusNotAcceptable, map[string]interface{}{
-			"message": "Invalid status. Status must be one of [\"Pending\", \"Confirmed\", \"Rejected\", \"Not Available\"]",
-		})
+	// Cancelled is what a patient's reply to a reminder sets, see databases/reminders.go
+	if update.Status != "Confirmed" && update.Status != "Rejected" && update.Status != "Pending" && update.Status != "Not Available" && update.Status != "Cancelled" {
+		return newProblem(http.StatusUnprocessableEntity, i18n.InvalidAppointmentStatus, `["Pending", "Confirmed", "Rejected", "Not Available", "Cancelled"]`)
 	}
 
 	// Validate the struct fields
 	validate := validator.New()
 	err = validate.Struct(update)
 	if err != nil {
-		return writeJSON(w, http.StatusNotAcceptable, map[string]interface{}{
-			"status":  "Constraints Violeted",
-			"message": "Invalid data provided, please check your payload",
-		})
+		return newProblem(http.StatusUnprocessableEntity, i18n.ValidationFailed).withCause(err)
 	}
 
 	// Check if struct fields are populated (non-zero values)
@@ -592,9 +525,10 @@ func (s *APIServer) SetAppointments(w http.ResponseWriter, r *http.Request) erro
 		field := val.Type().Field(i)
 		value := val.Field(i)
 		if value.IsZero() {
-			return writeJSON(w, http.StatusNotAcceptable, map[string]interface{}{
-				"message": fmt.Errorf("field %s is required but not provided", field.Name),
-			})
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			p := newProblem(http.StatusUnprocessableEntity, i18n.ValidationFailed)
+			p.Errors = []FieldProblem{{Field: name, Code: i18n.FieldRequired, args: []any{name}}}
+			return p
 		}
 	}
 
@@ -617,138 +551,145 @@ func (s *APIServer) SetAppointments(w http.ResponseWriter, r *http.Request) erro
 	// 	})
 	// }
 
-	//push into queue for processing
-	notify_appointment := map[string]interface{}{
-		"update": update,
+	// only the HIP the appointment was booked with changes it
+	err = s.store.CheckAppointment(healthcareID, update.HealthID, update.ID)
+	if errors.Is(err, mod.ErrAppointmentNotFound) {
+		return newProblem(http.StatusNotFound, i18n.AppointmentNotFound).withCause(err)
 	}
-	err = s.store.Push_update_appointment(notify_appointment)
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Server error: " + err.Error(),
-		})
+		return internalError(err)
+	}
+
+	//push into queue for processing
+	queued, err := events.Message(events.AppointmentStatusChanged{
+		HealthcareID: update.HealthcareID, AppointmentID: update.ID, HealthID: update.HealthID, Status: update.Status,
+	})
+	if err != nil {
+		return internalError(err)
+	}
+	if err := s.store.Enqueue(queued); err != nil {
+		return internalError(err)
 	}
 
 	return writeJSON(w, http.StatusOK, map[string]interface{}{
-		"status":       "Updation Queued",
-		"message":      "appointment will be updated shortly",
+		"code":         i18n.AppointmentUpdateQueued,
+		"status":       msg(r, i18n.AppointmentUpdateQueued),
+		"message":      msg(r, i18n.AppointmentUpdateQueued),
 		"appointments": update,
 	})
 }
 
 func (s *APIServer) Create_ClientProfile(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "POST" {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"error": r.Method + " method not allowed",
-		})
-	}
 	patient := &mod.PatientDetails{}
 	err := json.NewDecoder(r.Body).Decode(&patient)
 	if err != nil {
-		return err
+		return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err)
 	}
 	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "HealthID not found in token"})
+		return missingClaim("healthcareID")
 	}
 
 	// healthcare Name for logs
 	healthcare_name, ok := r.Context().Value(contextKeyHealthCareName).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "HealthID not found in token"})
+		return missingClaim("healthcare_name")
 	}
 
 	// create client_profile using function
 	client_profile, err := mod.Create_clientProfile(healthcareID, patient)
 	if err != nil {
-		return err
+		return validationProblem(err)
+	}
+
+	created, err := events.Message(events.PatientProfileCreated{
+		HIP:     events.HIP{HealthcareID: healthcareID, HealthcareName: healthcare_name},
+		Patient: eventPatient(client_profile),
+	})
+	if err != nil {
+		return internalError(err)
 	}
 
-	// store into posgres directly
-	err = s.store.Create_ClientProfile(client_profile)
+	// store into posgres directly, the log is written with the profile
+	err = s.store.Create_ClientProfile(client_profile, created)
 	if err != nil {
-		return writeJSON(w, http.StatusNotAcceptable, map[string]interface{}{
-			"err":     err.Error(),
-			"message": "User Already exists",
-		})
+		return newProblem(http.StatusConflict, i18n.PatientAlreadyExists).withCause(err)
 	}
 
 	// create stats for this patient also
 	err = s.store.CreateClient_stats(client_profile.HealthID)
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Something Went Wrong from our side :(",
-			"err":     err.Error(),
-		})
+		return internalError(err)
 	}
 
-	err = s.store.Push_logs("profile_updated", client_profile.FirstName, client_profile.Email, client_profile.HealthID, healthcare_name, healthcareID)
+	// the same person may already be registered here or at another facility,
+	// a failed check must not fail the registration, the pair will come up on the next one
+	duplicates, err := s.store.FindDuplicates(client_profile)
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Something Went Wrong from our side :(",
-			"err":     err.Error(),
+		log.Printf("duplicate check failed for %s: %s", client_profile.HealthID, err)
+	}
+	possibleDuplicates := []map[string]interface{}{}
+	for _, duplicate := range duplicates {
+		possibleDuplicates = append(possibleDuplicates, map[string]interface{}{
+			"id":                  duplicate.ID,
+			"candidate_health_id": duplicate.CandidateHealthID,
+			"score":               duplicate.Score,
 		})
 	}
 
 	return writeJSON(w, http.StatusCreated, map[string]interface{}{
-		"message":   "data has been successfully created!",
+		"code":      i18n.PatientCreated,
+		"message":   msg(r, i18n.PatientCreated),
 		"status":    "created",
 		"email":     client_profile.Email,
 		"health_id": client_profile.HealthID,
 		"fullname":  client_profile.FirstName + " " + client_profile.LastName,
+
+		"possible_duplicates": possibleDuplicates,
 	})
 }
 
 func (s *APIServer) Get_clientProfile(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "GET" {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"error": r.Method + " method not allowed",
-		})
-	}
 	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "HealthID not found in token"})
+		return missingClaim("healthcareID")
 	}
-	query := r.URL.Query()
-	// Get the healthID from the query parameters
-	healthID := query.Get("healthID")
+	// Get the healthID from the path or the query parameters
+	healthID := pathOrQuery(r, "healthID")
 	if healthID == "" {
-		http.Error(w, "Missing healthID in URL", http.StatusBadRequest)
-		return fmt.Errorf("missing healthID in URL")
+		return newProblem(http.StatusBadRequest, i18n.HealthIDMissing)
 	}
 	// healthcare_name
 	healthcare_name, ok := r.Context().Value(contextKeyHealthCareName).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "healthcare_name not found in token"})
+		return missingClaim("healthcare_name")
 	}
 
 	patientDetails, err := s.store.Get_ClientProfile(healthID)
 	if err != nil {
-		return writeJSON(w, http.StatusNotFound, map[string]interface{}{
-			"message": "No Patient Found :(",
-		})
+		return newProblem(http.StatusNotFound, i18n.PatientNotFound).withCause(err)
 	}
 
 	// Notify user via email
-	err = s.store.Push_logs("profile_viewed", patientDetails.FirstName, patientDetails.Email, patientDetails.HealthID, healthcare_name, healthcareID)
+	viewed, err := events.Message(events.PatientProfileViewed{
+		HIP:     events.HIP{HealthcareID: healthcareID, HealthcareName: healthcare_name},
+		Patient: eventPatient(patientDetails),
+	})
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"err":     err.Error(),
-			"message": "something went wrong from our side :(",
-		})
+		return internalError(err)
+	}
+	if err := s.store.Enqueue(viewed); err != nil {
+		return internalError(err)
 	}
 
+	w.Header().Set("ETag", mod.ProfileETag(patientDetails))
 	return writeJSON(w, http.StatusOK, map[string]interface{}{"client_profile": patientDetails})
 }
 
 func (s *APIServer) GetHealthcare_details(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "GET" {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"error": r.Method + " method not allowed",
-		})
-	}
 	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
 	if !ok {
-		return writeJSON(w, http.StatusBadRequest, map[string]string{"HealthCareID": "HealthCareID not found in token"})
+		return missingClaim("healthcareID")
 	}
 
 	// check if cache are needed or not
@@ -763,9 +704,7 @@ func (s *APIServer) GetHealthcare_details(w http.ResponseWriter, r *http.Request
 		fetched, err := s.store.Get("hip:details:" + healthcareID)
 		if err != redis.Nil {
 			if err != nil {
-				return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-					"error": err.Error(),
-				})
+				return internalError(err)
 			}
 			if fetched != nil {
 				fetchedData, ok := fetched.(struct {
@@ -774,9 +713,7 @@ func (s *APIServer) GetHealthcare_details(w http.ResponseWriter, r *http.Request
 				})
 
 				if !ok {
-					return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-						"error": "Failed to convert data to expected format",
-					})
+					return internalError(fmt.Errorf("cached value is %T", fetched))
 				}
 				var jsonBody *mod.HIPInfo
 				err = json.Unmarshal([]byte(fetchedData.Value), &jsonBody)
@@ -794,18 +731,13 @@ func (s *APIServer) GetHealthcare_details(w http.ResponseWriter, r *http.Request
 	// fetch from database now!!
 	hipdetails, err := s.store.GetHealthcare_details_postgres(healthcareID)
 	if err != nil {
-		return writeJSON(w, http.StatusNotFound, map[string]interface{}{
-			"message": "Healthcare Not Found!",
-		})
+		return newProblem(http.StatusNotFound, i18n.HIPNotFound).withCause(err)
 	}
 
 	// Store into redis!!!
 	err = s.store.Set("hip:details:"+healthcareID, hipdetails)
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Something mishappened from our side :)",
-			"error":   err.Error(),
-		})
+		return internalError(err)
 	}
 
 	return writeJSON(w, http.StatusOK, map[string]interface{}{
@@ -814,33 +746,28 @@ func (s *APIServer) GetHealthcare_details(w http.ResponseWriter, r *http.Request
 }
 
 func (s *APIServer) CreatepatientRecords(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "POST" {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"error": r.Method + " method not allowed",
-		})
-	}
 	patientrecords := &mod.PatientRecords{}
 	err := json.NewDecoder(r.Body).Decode(&patientrecords)
 	if err != nil {
-		return writeJSON(w, http.StatusNoContent, map[string]interface{}{
-			"message": err,
-		})
+		return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err)
+	}
+	// v2 names the patient in the path
+	if healthID := pathOrQuery(r, "healthID"); healthID != "" {
+		patientrecords.HealthID = healthID
 	}
 
 	if patientrecords.MedicalSeverity != "High" && patientrecords.MedicalSeverity != "Low" && patientrecords.MedicalSeverity != "Severe" && patientrecords.MedicalSeverity != "Normal" {
-		return writeJSON(w, http.StatusNotAcceptable, map[string]interface{}{
-			"message": "medical_severity value not acceptable must be one of [High, Low, Severe, Normal]",
-		})
+		return newProblem(http.StatusUnprocessableEntity, i18n.InvalidMedicalSeverity, "[High, Low, Severe, Normal]")
 	}
 
 	healthcareId, ok := r.Context().Value(contextKeyHealthCareID).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "StatusUnauthorized"})
+		return missingClaim("healthcareID")
 	}
 
 	healthcare_name, ok := r.Context().Value(contextKeyHealthCareName).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "healthcare_name not found in token"})
+		return missingClaim("healthcare_name")
 	}
 
 	// assign healthcareId
@@ -858,34 +785,20 @@ func (s *APIServer) CreatepatientRecords(w http.ResponseWriter, r *http.Request)
 
 	patientrecords, err = mod.CreatePatientRecords(healthcareId, patientrecords)
 	if err != nil {
-		return writeJSON(w, http.StatusNotAcceptable, map[string]interface{}{
-			"err":     err.Error(),
-			"message": "Wrong Payload provided by User!",
-		})
+		return validationProblem(err)
 	}
 
-	// Convert into body format
-	body := map[string]interface{}{
-		"record": patientrecords,
-	}
-	// Push it intoRabbitMq
-	err = s.store.Push_patient_records(body)
+	// the records consumer stores it, notifications go out from the same event
+	created, err := events.Message(events.PatientRecordCreated{
+		HIP:      events.HIP{HealthcareID: healthcareId, HealthcareName: healthcare_name},
+		HealthID: patientrecords.HealthID, Issue: patientrecords.Issue, Description: patientrecords.Description,
+		MedicalSeverity: patientrecords.MedicalSeverity, CreatedAt: time.Now().UTC(),
+	})
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Something bad (Please Mail 21vaibhav11@gmail.com for this issue)",
-			"status":  "Server Could not Process your Request",
-			"err":     err.Error(),
-		})
+		return internalError(err)
 	}
-
-	// Notify user via email
-	err = s.store.Push_logs("records_created", nil, nil, patientrecords.HealthID, healthcare_name, healthcareId)
-	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Something Mishappened (Please Mail 21vaibhav11@gmail.com for this issue)",
-			"status":  "Server Could not Process your Request",
-			"err":     err.Error(),
-		})
+	if err := s.store.Enqueue(created); err != nil {
+		return internalError(err)
 	}
 	// counters
 	// err = s.store.Push_counters("hip:recordscreated_counter", healthcareId)
@@ -898,23 +811,17 @@ func (s *APIServer) CreatepatientRecords(w http.ResponseWriter, r *http.Request)
 	// }
 
 	return writeJSON(w, http.StatusOK, map[string]interface{}{
-		"message": "successfully processed, within few hours records will be created",
+		"code":    i18n.RecordQueued,
+		"message": msg(r, i18n.RecordQueued),
 		"status":  "pending",
 	})
 }
 
 func (s *APIServer) GetPatientRecords(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "GET" {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"error": r.Method + " method not allowed",
-		})
-	}
 	query := r.URL.Query()
-	health_id := query.Get("healthID")
+	health_id := pathOrQuery(r, "healthID")
 	if health_id == "" {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"message": "Health Id not Provided",
-		})
+		return newProblem(http.StatusBadRequest, i18n.HealthIDMissing)
 	}
 	listStr := query.Get("list")
 	list := 5
@@ -922,9 +829,7 @@ func (s *APIServer) GetPatientRecords(w http.ResponseWriter, r *http.Request) er
 		var err error
 		list, err = strconv.Atoi(listStr)
 		if err != nil {
-			return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"message": "Could not fetch Records",
-			})
+			return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "list").withCause(err)
 		}
 	}
 
@@ -933,26 +838,28 @@ func (s *APIServer) GetPatientRecords(w http.ResponseWriter, r *http.Request) er
 	severity := query.Get("severity")
 	patientRecords, err := s.store.GetPatientRecords(health_id, severity, list)
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "patient not found :(",
-		})
+		return internalError(err)
 	}
 	healthcareId, ok := r.Context().Value(contextKeyHealthCareID).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "StatusUnauthorized"})
+		return missingClaim("healthcareID")
 	}
 	// healthcare_name
 	healthcare_name, ok := r.Context().Value(contextKeyHealthCareName).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "healthcare_name not found in token"})
+		return missingClaim("healthcare_name")
 	}
 
 	// push logs that your records_has been viewed and send notifications
-	err = s.store.Push_logs("records_viewed", nil, nil, health_id, healthcare_name, healthcareId)
+	viewed, err := events.Message(events.PatientRecordsViewed{
+		HIP:      events.HIP{HealthcareID: healthcareId, HealthcareName: healthcare_name},
+		HealthID: health_id, Severity: severity,
+	})
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Internal Server Error: could not process data",
-		})
+		return internalError(err)
+	}
+	if err := s.store.Enqueue(viewed); err != nil {
+		return internalError(err)
 	}
 
 	// counters (Will be removed soon)
@@ -976,58 +883,544 @@ func (s *APIServer) GetPatientRecords(w http.ResponseWriter, r *http.Request) er
 }
 
 func (s *APIServer) UpdateClientProfile(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "PATCH" {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"error": r.Method + " method not allowed",
-		})
-	}
 	// healthcare_name
 	healthcareId, ok := r.Context().Value(contextKeyHealthCareID).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "StatusUnauthorized"})
+		return missingClaim("healthcareID")
 	}
 	// healthcare_name
 	healthcare_name, ok := r.Context().Value(contextKeyHealthCareName).(string)
 	if !ok {
-		return writeJSON(w, http.StatusUnauthorized, map[string]string{"HealthID": "healthcare_name not found in token"})
+		return missingClaim("healthcare_name")
 	}
-	healthID := r.URL.Query().Get("healthID")
+	healthID := pathOrQuery(r, "healthID")
 	if healthID == "" {
-		return writeJSON(w, http.StatusBadRequest, map[string]interface{}{
-			"message": "Provide health Id",
-		})
+		return newProblem(http.StatusBadRequest, i18n.HealthIDMissing)
 	}
 
-	updates := make(map[string]interface{})
-	err := json.NewDecoder(r.Body).Decode(&updates)
-	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Internal Server Error: could not process data",
-		})
+	// the client has to say which version it is changing, otherwise two edits can silently overwrite each other.
+	// v1 clients were written before If-Match, for them a missing header means any version
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		if !strings.HasPrefix(r.URL.Path, v1Prefix+"/") {
+			return newProblem(http.StatusPreconditionRequired, i18n.PreconditionRequired)
+		}
+		ifMatch = "*"
+	}
+	ifVersion, ok := parseIfMatch(ifMatch)
+	if !ok {
+		return newProblem(http.StatusPreconditionFailed, i18n.ProfileChanged)
+	}
+	if mediaType := strings.TrimSpace(strings.SplitN(r.Header.Get("Content-Type"), ";", 2)[0]); mediaType != "" &&
+		mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		return newProblem(http.StatusUnsupportedMediaType, i18n.UnsupportedMediaType, "application/merge-patch+json")
 	}
 
-	// Update client directly in postgres database
-	updatedPatient, err := s.store.Update_clientProfile(healthID, updates)
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
 	if err != nil {
-		return writeJSON(w, http.StatusNotAcceptable, map[string]interface{}{
-			"err":     err.Error(),
-			"message": "Wrong Payload Provided by User",
-		})
+		return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err)
+	}
+	patch, err := mod.ParseProfilePatch(body)
+	if err != nil {
+		var verr *mod.ValidationError
+		switch {
+		case errors.As(err, &verr):
+			return validationProblem(err)
+		case errors.Is(err, mod.ErrEmptyPatch):
+			return newProblem(http.StatusBadRequest, i18n.NothingToUpdate)
+		}
+		return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err)
 	}
 
-	// push the logs into queue
-	err = s.store.Push_logs("profile_updated", updatedPatient.FirstName, updatedPatient.Email, updatedPatient.HealthID, healthcare_name, healthcareId)
+	// Update client directly in postgres database, the replaced version is kept with who changed it and why
+	change := mod.ProfileChange{ChangedBy: healthcareId, Reason: r.Header.Get("X-Change-Reason"),
+		// push the logs into queue, with the update
+		Announce: func(previous, updated *mod.PatientDetails) ([]mq.Message, error) {
+			event, err := events.Message(events.PatientProfileUpdated{
+				HIP:     events.HIP{HealthcareID: healthcareId, HealthcareName: healthcare_name},
+				Patient: eventPatient(updated), Version: updated.Version, ChangedFields: mod.ChangedFields(previous, updated),
+			})
+			return []mq.Message{event}, err
+		},
+	}
+	updatedPatient, err := s.store.Update_clientProfile(healthID, patch, ifVersion, change)
 	if err != nil {
-		return writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "Internal Server Error: could not process data",
-		})
+		switch {
+		case errors.Is(err, mod.ErrVersionMismatch):
+			if updatedPatient != nil {
+				w.Header().Set("ETag", mod.ProfileETag(updatedPatient))
+			}
+			return newProblem(http.StatusPreconditionFailed, i18n.ProfileChanged)
+		case errors.Is(err, mod.ErrPatientNotFound):
+			return newProblem(http.StatusNotFound, i18n.PatientNotFound)
+		}
+		// the patch is checked against the stored profile, anything but a failed check is on our side
+		var verr *mod.ValidationError
+		if errors.As(err, &verr) || errors.Is(err, mod.ErrInvalidAddress) {
+			return validationProblem(err)
+		}
+		return internalError(err)
 	}
 
+	w.Header().Set("ETag", mod.ProfileETag(updatedPatient))
 	return writeJSON(w, http.StatusAccepted, map[string]interface{}{
 		"updated_details": updatedPatient,
 	})
 }
 
+// If-Match holds one ETag from ProfileETag or *, the version 0 means any version
+func parseIfMatch(header string) (int, bool) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return 0, true
+	}
+	tag := strings.TrimPrefix(header, "W/")
+	if !strings.HasPrefix(tag, `"v`) || !strings.HasSuffix(tag, `"`) || len(tag) < 4 {
+		return 0, false
+	}
+	version, err := strconv.Atoi(tag[2 : len(tag)-1])
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// Search this HIP's patients by name (Ge'ez or Latin), father's name, phone, dob and location
+func (s *APIServer) SearchClientProfiles(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	query := r.URL.Query()
+	search := &mod.PatientSearch{
+		Name:       query.Get("name"),
+		FatherName: query.Get("fathername"),
+		Phone:      query.Get("phone"),
+		DOB:        query.Get("dob"),
+		Region:     query.Get("region"),
+		Zone:       query.Get("zone"),
+		Woreda:     query.Get("woreda"),
+		Limit:      10,
+	}
+	if search.Empty() {
+		return newProblem(http.StatusBadRequest, i18n.SearchCriteriaMissing)
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 50 {
+			return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "limit")
+		}
+		search.Limit = limit
+	}
+
+	matches, err := s.store.SearchClientProfiles(healthcareID, search)
+	if err != nil {
+		return internalError(err)
+	}
+	if matches == nil {
+		matches = []*mod.PatientMatch{}
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"matches": matches,
+		"fetched": len(matches),
+	})
+}
+
+/////////////////////////////// PROFILE HISTORY GOES HERE //////////////////////////////////
+
+func (s *APIServer) ListProfileVersions(w http.ResponseWriter, r *http.Request) error {
+	if _, ok := r.Context().Value(contextKeyHealthCareID).(string); !ok {
+		return missingClaim("healthcareID")
+	}
+	healthID := pathOrQuery(r, "healthID")
+	if healthID == "" {
+		return newProblem(http.StatusBadRequest, i18n.HealthIDMissing)
+	}
+	current, err := s.store.Get_ClientProfile(healthID)
+	if err != nil {
+		return newProblem(http.StatusNotFound, i18n.PatientNotFound).withCause(err)
+	}
+	versions, err := s.store.ListProfileVersions(current.HealthID)
+	if err != nil {
+		return internalError(err)
+	}
+	if versions == nil {
+		versions = []*mod.ProfileVersion{}
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"health_id":       current.HealthID,
+		"current_version": current.Version,
+		"versions":        versions,
+		"fetched":         len(versions),
+	})
+}
+
+// Field by field changes between two versions, to defaults to the current one
+func (s *APIServer) DiffProfileVersions(w http.ResponseWriter, r *http.Request) error {
+	if _, ok := r.Context().Value(contextKeyHealthCareID).(string); !ok {
+		return missingClaim("healthcareID")
+	}
+	query := r.URL.Query()
+	healthID := pathOrQuery(r, "healthID")
+	if healthID == "" {
+		return newProblem(http.StatusBadRequest, i18n.HealthIDMissing)
+	}
+	current, err := s.store.Get_ClientProfile(healthID)
+	if err != nil {
+		return newProblem(http.StatusNotFound, i18n.PatientNotFound).withCause(err)
+	}
+	from, err := strconv.Atoi(query.Get("from"))
+	if err != nil || from <= 0 {
+		return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "from")
+	}
+	to := current.Version
+	if toStr := query.Get("to"); toStr != "" {
+		to, err = strconv.Atoi(toStr)
+		if err != nil || to <= 0 {
+			return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "to")
+		}
+	}
+
+	versions := make([]*mod.PatientDetails, 0, 2)
+	for _, version := range []int{from, to} {
+		profile, err := s.store.GetProfileVersion(current.HealthID, version)
+		if err != nil {
+			return versionProblem(err)
+		}
+		versions = append(versions, profile)
+	}
+	changes, err := mod.DiffProfiles(versions[0], versions[1])
+	if err != nil {
+		return versionProblem(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"health_id": current.HealthID,
+		"from":      from,
+		"to":        to,
+		"changes":   changes,
+	})
+}
+
+// The profile as it was on a date (YYYY-MM-DD, end of that day) or at an RFC 3339 time
+func (s *APIServer) GetClientProfileAsOf(w http.ResponseWriter, r *http.Request) error {
+	if _, ok := r.Context().Value(contextKeyHealthCareID).(string); !ok {
+		return missingClaim("healthcareID")
+	}
+	query := r.URL.Query()
+	healthID := pathOrQuery(r, "healthID")
+	if healthID == "" {
+		return newProblem(http.StatusBadRequest, i18n.HealthIDMissing)
+	}
+	at, err := mod.ParseAsOf(query.Get("date"))
+	if err != nil {
+		return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "date").withCause(err)
+	}
+	profile, err := s.store.GetClientProfileAsOf(healthID, at)
+	if err != nil {
+		return versionProblem(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"as_of":          at,
+		"client_profile": profile,
+	})
+}
+
+func versionProblem(err error) *Problem {
+	switch {
+	case errors.Is(err, mod.ErrVersionNotFound):
+		return newProblem(http.StatusNotFound, i18n.VersionNotFound).withCause(err)
+	case errors.Is(err, mod.ErrPatientNotFound):
+		return newProblem(http.StatusNotFound, i18n.PatientNotFound).withCause(err)
+	}
+	return internalError(err)
+}
+
+/////////////////////////////// DUPLICATES AND MERGES GOES HERE //////////////////////////////////
+
+func (s *APIServer) GetDuplicateQueue(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status == "" {
+		status = "pending"
+	}
+	if status != "pending" && status != "merged" && status != "dismissed" {
+		return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "status")
+	}
+	limit := 20
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "limit")
+		}
+	}
+	duplicates, err := s.store.GetDuplicateQueue(healthcareID, status, int64(limit))
+	if err != nil {
+		return internalError(err)
+	}
+	if duplicates == nil {
+		duplicates = []*mod.DuplicateCandidate{}
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"duplicates": duplicates,
+		"fetched":    len(duplicates),
+	})
+}
+
+func (s *APIServer) DismissDuplicate(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	id, ok, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		req := struct {
+			ID int64 `json:"id"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID <= 0 {
+			return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody)
+		}
+		id = req.ID
+	}
+	err = s.store.DismissDuplicate(healthcareID, id)
+	if errors.Is(err, mod.ErrDuplicateNotFound) {
+		return newProblem(http.StatusNotFound, i18n.DuplicateNotFound)
+	}
+	if err != nil {
+		return internalError(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"code":    i18n.DuplicateDismissed,
+		"message": msg(r, i18n.DuplicateDismissed),
+		"id":      id,
+	})
+}
+
+// merged_health_id is folded into surviving_health_id when the HIP registered both. When it
+// registered only one of them a merge request goes to the HIP that registered the other
+func (s *APIServer) MergeClientProfiles(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	req := struct {
+		SurvivingHealthID string `json:"surviving_health_id"`
+		MergedHealthID    string `json:"merged_health_id"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SurvivingHealthID == "" || req.MergedHealthID == "" {
+		return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody)
+	}
+	merge, err := s.store.MergeClientProfiles(healthcareID, req.SurvivingHealthID, req.MergedHealthID)
+	if errors.Is(err, mod.ErrMergeApprovalRequired) {
+		request, err := s.store.RequestMerge(healthcareID, req.SurvivingHealthID, req.MergedHealthID)
+		if err != nil {
+			return mergeProblem(err)
+		}
+		return writeJSON(w, http.StatusAccepted, map[string]interface{}{
+			"code":          i18n.MergeRequested,
+			"message":       msg(r, i18n.MergeRequested),
+			"merge_request": request,
+		})
+	}
+	if err != nil {
+		return mergeProblem(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"code":    i18n.PatientsMerged,
+		"message": msg(r, i18n.PatientsMerged),
+		"merge":   merge,
+	})
+}
+
+func (s *APIServer) UnmergeClientProfiles(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	mergeID, ok, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		req := struct {
+			MergeID int64 `json:"merge_id"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MergeID <= 0 {
+			return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody)
+		}
+		mergeID = req.MergeID
+	}
+	merge, err := s.store.UnmergeClientProfiles(healthcareID, mergeID)
+	if err != nil {
+		return mergeProblem(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"code":    i18n.PatientsUnmerged,
+		"message": msg(r, i18n.PatientsUnmerged),
+		"merge":   merge,
+	})
+}
+
+func (s *APIServer) ListMergeRequests(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status != "" && status != "pending" && status != "approved" && status != "rejected" {
+		return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "status")
+	}
+	limit := 20
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "limit")
+		}
+	}
+	requests, err := s.store.ListMergeRequests(healthcareID, status, int64(limit))
+	if err != nil {
+		return internalError(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"merge_requests": requests,
+		"fetched":        len(requests),
+	})
+}
+
+// only the HIP the request waits for approves it, the merge happens then
+func (s *APIServer) ApproveMergeRequest(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	id, _, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	merge, err := s.store.ApproveMergeRequest(healthcareID, id)
+	if err != nil {
+		return mergeProblem(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"code":    i18n.PatientsMerged,
+		"message": msg(r, i18n.PatientsMerged),
+		"merge":   merge,
+	})
+}
+
+// either HIP can reject a pending request
+func (s *APIServer) RejectMergeRequest(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	id, _, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	request, err := s.store.RejectMergeRequest(healthcareID, id)
+	if err != nil {
+		return mergeProblem(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"code":          i18n.MergeRequestRejected,
+		"message":       msg(r, i18n.MergeRequestRejected),
+		"merge_request": request,
+	})
+}
+
+func mergeProblem(err error) *Problem {
+	switch {
+	case errors.Is(err, mod.ErrPatientNotFound):
+		return newProblem(http.StatusNotFound, i18n.PatientNotFound).withCause(err)
+	case errors.Is(err, mod.ErrMergeNotFound):
+		return newProblem(http.StatusNotFound, i18n.MergeNotFound).withCause(err)
+	case errors.Is(err, mod.ErrMergeRequestNotFound):
+		return newProblem(http.StatusNotFound, i18n.MergeRequestNotFound).withCause(err)
+	case errors.Is(err, mod.ErrAlreadyMerged):
+		return newProblem(http.StatusConflict, i18n.PatientAlreadyMerged).withCause(err)
+	case errors.Is(err, mod.ErrMergeForbidden):
+		return newProblem(http.StatusForbidden, i18n.MergeForbidden).withCause(err)
+	case errors.Is(err, mod.ErrUnmergeForbidden):
+		return newProblem(http.StatusForbidden, i18n.UnmergeForbidden).withCause(err)
+	}
+	return internalError(err)
+}
+
+/////////////////////////////// ADDRESS HIERARCHY GOES HERE //////////////////////////////////
+
+func (s *APIServer) GetRegions(w http.ResponseWriter, r *http.Request) error {
+	regions := mod.GetAdminAreas().ListRegions()
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"regions": regions,
+		"fetched": len(regions),
+	})
+}
+
+func (s *APIServer) GetZones(w http.ResponseWriter, r *http.Request) error {
+	return s.listAdminAreas(w, r, "region", "zones", mod.GetAdminAreas().ListZones)
+}
+
+func (s *APIServer) GetWoredas(w http.ResponseWriter, r *http.Request) error {
+	return s.listAdminAreas(w, r, "zone", "woredas", mod.GetAdminAreas().ListWoredas)
+}
+
+func (s *APIServer) GetKebeles(w http.ResponseWriter, r *http.Request) error {
+	return s.listAdminAreas(w, r, "woreda", "kebeles", mod.GetAdminAreas().ListKebeles)
+}
+
+// children of one area, parent code comes from the path or the query e.g. ?region=ET-AM
+func (s *APIServer) listAdminAreas(w http.ResponseWriter, r *http.Request, parent, key string, list func(string) ([]mod.AdminArea, error)) error {
+	code := strings.ToUpper(strings.TrimSpace(pathOrQuery(r, parent)))
+	if code == "" {
+		return newProblem(http.StatusBadRequest, i18n.QueryParamMissing, parent)
+	}
+	areas, err := list(code)
+	if err != nil {
+		return newProblem(http.StatusNotFound, i18n.InvalidAddress).withCause(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		key:       areas,
+		"fetched": len(areas),
+	})
+}
+
+// Patients whose address is legacy free text and has to be re-entered
+func (s *APIServer) GetAddressReviewQueue(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "limit")
+		}
+	}
+	profiles, err := s.store.GetAddressReviewQueue(healthcareID, int64(limit))
+	if err != nil {
+		return internalError(err)
+	}
+	if profiles == nil {
+		profiles = []*mod.PatientDetails{}
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"client_profiles": profiles,
+		"fetched":         len(profiles),
+	})
+}
+
 // ///////////////////////////// ///////////////////// ///////////////// //////////// /////////////// ////////////// /
 /////////////////////////// ///  	 Utility Functions  	///////////////////////// ////////////////// ///////////// ///////
 
@@ -1036,33 +1429,27 @@ func (s *APIServer) RateLimiter(handlerFunc http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
 		if !ok {
-			writeJSON(w, http.StatusForbidden, apiError{Error: "Invalid token"})
+			writeProblem(w, r, newProblem(http.StatusUnauthorized, i18n.InvalidToken))
 			return
 		}
 		allowed_fixed_window, err := s.store.IsAllowed(healthcareID)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, apiError{Error: "Something bad happened from our side :("})
+			writeProblem(w, r, internalError(err))
 			return
 		}
 		if !allowed_fixed_window {
-			writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
-				"status":  "Request Blocked",
-				"message": "Too many request from your side, please login again",
-			})
+			writeProblem(w, r, newProblem(http.StatusTooManyRequests, i18n.RateLimited))
 			return
 		}
 		// only check for at max 10,000 request per second at any given time
 		// this one checks for leaky bucket rate-limiting
 		allowed_leaky_bucket, err := s.store.IsAllowed_leaky_bucket(healthcareID)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, apiError{Error: "Something bad happened from our side :("})
+			writeProblem(w, r, internalError(err))
 			return
 		}
 		if !allowed_leaky_bucket {
-			writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
-				"status":  "Request Blocked",
-				"message": "Too many request from your side, we've suspended all of your for 5 minutes",
-			})
+			writeProblem(w, r, newProblem(http.StatusTooManyRequests, i18n.RateLimitedSuspended))
 			return
 		}
 
@@ -1098,18 +1485,18 @@ func withJWTAuth(handlerFunc http.HandlerFunc) http.HandlerFunc {
 		tokenString := r.Header.Get("Authorization")
 		// this will extract token from Bearer keyword
 		if tokenString == "" || len(tokenString) < 7 || tokenString[:7] != "Bearer " {
-			writeJSON(w, http.StatusNotAcceptable, apiError{Error: "Authorization header format must be Bearer <token>"})
+			writeProblem(w, r, newProblem(http.StatusUnauthorized, i18n.AuthHeaderInvalid))
 			return
 		}
 		tokenString = tokenString[7:]
 		token, err := validateJWT(tokenString)
 		if err != nil {
-			writeJSON(w, http.StatusNotAcceptable, apiError{Error: fmt.Sprintf("Token Not Valid: %v", err)})
+			writeProblem(w, r, newProblem(http.StatusUnauthorized, i18n.InvalidToken))
 			return
 		}
 
 		if !token.Valid {
-			writeJSON(w, http.StatusForbidden, apiError{Error: "Invalid token"})
+			writeProblem(w, r, newProblem(http.StatusUnauthorized, i18n.InvalidToken))
 			return
 		}
 
@@ -1120,17 +1507,17 @@ func withJWTAuth(handlerFunc http.HandlerFunc) http.HandlerFunc {
 
 			// Block the request if healthcareID is missing or invalid
 			if healthcareID == "" {
-				writeJSON(w, http.StatusForbidden, apiError{Error: "Invalid Token: healthcareID missing"})
+				writeProblem(w, r, missingClaim("healthcareID"))
 				return
 			}
 
 			// Block the request if emailHealthcareID is missing or invalid
 			if emailHealthcareID == "" {
-				writeJSON(w, http.StatusForbidden, apiError{Error: "Invalid Token: healthcare_email missing"})
+				writeProblem(w, r, missingClaim("healthcare_email"))
 				return
 			}
 			if nameHealthcare == "" {
-				writeJSON(w, http.StatusForbidden, apiError{Error: "Invalid Token: healthcare name missing"})
+				writeProblem(w, r, missingClaim("healthcare_name"))
 				return
 			}
 
@@ -1140,7 +1527,7 @@ func withJWTAuth(handlerFunc http.HandlerFunc) http.HandlerFunc {
 
 			handlerFunc(w, r.WithContext(ctx))
 		} else {
-			writeJSON(w, http.StatusForbidden, apiError{Error: "Invalid token claims"})
+			writeProblem(w, r, newProblem(http.StatusUnauthorized, i18n.InvalidToken))
 			return
 		}
 	}
@@ -1164,18 +1551,84 @@ func writeJSON(w http.ResponseWriter, status int, v any) error {
 }
 
 type apiFunc func(http.ResponseWriter, *http.Request) error
-type apiError struct {
-	Error string `json:"error"`
-}
 
+// makeHTTPHandlerFunc writes the error a handler returns as a problem, see problem.go
 func makeHTTPHandlerFunc(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
-			writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+			writeProblem(w, r, err)
 		}
 	}
 }
 
+// msg translates a message code into the language negotiated for this request
+func msg(r *http.Request, code i18n.Code, args ...any) string {
+	return i18n.T(i18n.FromRequest(r), code, args...)
+}
+
+// eventPatient is who a patient event is about
+func eventPatient(p *mod.PatientDetails) events.Patient {
+	return events.Patient{HealthID: p.HealthID, Name: strings.TrimSpace(p.FirstName + " " + p.LastName), Email: p.Email}
+}
+
+// validationProblem reports model validation failures per field, translated when written
+func validationProblem(err error) *Problem {
+	if errors.Is(err, mod.ErrInvalidAddress) {
+		return newProblem(http.StatusUnprocessableEntity, i18n.InvalidAddress).withCause(err)
+	}
+	var verr *mod.ValidationError
+	if !errors.As(err, &verr) {
+		return newProblem(http.StatusUnprocessableEntity, i18n.ValidationFailed).withCause(err)
+	}
+	p := newProblem(http.StatusUnprocessableEntity, i18n.ValidationFailed)
+	for _, field := range verr.Fields {
+		code, args := fieldMessage(field)
+		p.Errors = append(p.Errors, FieldProblem{Field: field.Field, Code: code, args: args})
+	}
+	return p
+}
+
+// a value of a oneof rule, one with spaces is in single quotes like the validator reads it
+var oneofValue = regexp.MustCompile(`'[^']*'|\S+`)
+
+// oneofValues are the values a oneof rule allows
+func oneofValues(param string) []string {
+	values := oneofValue.FindAllString(param, -1)
+	for i, value := range values {
+		values[i] = strings.Trim(value, "'")
+	}
+	return values
+}
+
+// maps a validator rule to its message code, min/max read differently for numbers and strings
+func fieldMessage(field mod.FieldError) (i18n.Code, []any) {
+	switch field.Rule {
+	case "required":
+		return i18n.FieldRequired, []any{field.Field}
+	case "min", "gte":
+		if field.Numeric {
+			return i18n.FieldMinValue, []any{field.Field, field.Param}
+		}
+		return i18n.FieldMin, []any{field.Field, field.Param}
+	case "max", "lte":
+		if field.Numeric {
+			return i18n.FieldMaxValue, []any{field.Field, field.Param}
+		}
+		return i18n.FieldMax, []any{field.Field, field.Param}
+	case "email":
+		return i18n.FieldEmail, []any{field.Field}
+	case "oneof":
+		return i18n.FieldOneOf, []any{field.Field, "[" + strings.Join(oneofValues(field.Param), ", ") + "]"}
+	case "type":
+		return i18n.FieldType, []any{field.Field}
+	case "readonly":
+		return i18n.FieldReadOnly, []any{field.Field}
+	case "unknown":
+		return i18n.FieldUnknown, []any{field.Field}
+	}
+	return i18n.FieldInvalid, []any{field.Field}
+}
+
 // isvalid email
 // isValidEmail validates the email format
 func isValidEmail(email string) bool {
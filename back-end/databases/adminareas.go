@@ -0,0 +1,250 @@
+package databases
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Ethiopian administrative hierarchy: region -> zone -> woreda -> kebele
+// Codes are hierarchical, every child code starts with the code of its parent
+// e.g. ET-AM (Amhara) -> ET-AM-01 (Bahir Dar Special Zone) -> ET-AM-01-01 -> ET-AM-01-01-03
+
+//go:embed data/admin_areas.json
+var bundledAdminAreas []byte
+
+var ErrInvalidAddress = errors.New("invalid address")
+
+type AdminArea struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	NameAm string `json:"name_am,omitempty"`
+}
+
+type Kebele struct {
+	AdminArea
+}
+
+type Woreda struct {
+	AdminArea
+	Kebeles []Kebele `json:"kebeles,omitempty"`
+}
+
+type Zone struct {
+	AdminArea
+	Woredas []Woreda `json:"woredas"`
+}
+
+type Region struct {
+	AdminArea
+	Zones []Zone `json:"zones"`
+}
+
+type AdminAreas struct {
+	Version string   `json:"version"`
+	Country string   `json:"country"`
+	Regions []Region `json:"regions"`
+
+	// lookup indexes, built once on load
+	regions map[string]*Region
+	zones   map[string]*Zone
+	woredas map[string]*Woreda
+	kebeles map[string]*Kebele
+}
+
+var (
+	adminAreasMu sync.RWMutex
+	adminAreas   *AdminAreas
+)
+
+func init() {
+	areas, err := ParseAdminAreas(bundledAdminAreas)
+	if err != nil {
+		panic(fmt.Sprintf("bundled admin_areas.json is broken: %s", err))
+	}
+	adminAreas = areas
+}
+
+// LoadAdminAreas reads a replacement dataset from disk, same format as data/admin_areas.json
+func LoadAdminAreas(path string) (*AdminAreas, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin areas file: %w", err)
+	}
+	return ParseAdminAreas(data)
+}
+
+func ParseAdminAreas(data []byte) (*AdminAreas, error) {
+	areas := &AdminAreas{}
+	if err := json.Unmarshal(data, areas); err != nil {
+		return nil, fmt.Errorf("failed to parse admin areas: %w", err)
+	}
+	if len(areas.Regions) == 0 {
+		return nil, fmt.Errorf("admin areas dataset has no regions")
+	}
+
+	areas.regions = map[string]*Region{}
+	areas.zones = map[string]*Zone{}
+	areas.woredas = map[string]*Woreda{}
+	areas.kebeles = map[string]*Kebele{}
+
+	seen := map[string]bool{}
+	checkCode := func(code, parent string) error {
+		if code == "" {
+			return fmt.Errorf("empty code under %q", parent)
+		}
+		if seen[code] {
+			return fmt.Errorf("duplicate code %q", code)
+		}
+		if parent != "" && !strings.HasPrefix(code, parent+"-") {
+			return fmt.Errorf("code %q is not nested under %q", code, parent)
+		}
+		seen[code] = true
+		return nil
+	}
+
+	for ri := range areas.Regions {
+		region := &areas.Regions[ri]
+		if err := checkCode(region.Code, ""); err != nil {
+			return nil, err
+		}
+		areas.regions[region.Code] = region
+		for zi := range region.Zones {
+			zone := &region.Zones[zi]
+			if err := checkCode(zone.Code, region.Code); err != nil {
+				return nil, err
+			}
+			areas.zones[zone.Code] = zone
+			for wi := range zone.Woredas {
+				woreda := &zone.Woredas[wi]
+				if err := checkCode(woreda.Code, zone.Code); err != nil {
+					return nil, err
+				}
+				areas.woredas[woreda.Code] = woreda
+				for ki := range woreda.Kebeles {
+					kebele := &woreda.Kebeles[ki]
+					if err := checkCode(kebele.Code, woreda.Code); err != nil {
+						return nil, err
+					}
+					areas.kebeles[kebele.Code] = kebele
+				}
+			}
+		}
+	}
+	return areas, nil
+}
+
+// UseAdminAreas swaps the dataset used for address validation and lookups
+func UseAdminAreas(areas *AdminAreas) {
+	adminAreasMu.Lock()
+	defer adminAreasMu.Unlock()
+	adminAreas = areas
+}
+
+func GetAdminAreas() *AdminAreas {
+	adminAreasMu.RLock()
+	defer adminAreasMu.RUnlock()
+	return adminAreas
+}
+
+func (a *AdminAreas) ListRegions() []AdminArea {
+	list := make([]AdminArea, 0, len(a.Regions))
+	for _, region := range a.Regions {
+		list = append(list, region.AdminArea)
+	}
+	return list
+}
+
+func (a *AdminAreas) ListZones(regionCode string) ([]AdminArea, error) {
+	region, ok := a.regions[regionCode]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown region %q", ErrInvalidAddress, regionCode)
+	}
+	list := make([]AdminArea, 0, len(region.Zones))
+	for _, zone := range region.Zones {
+		list = append(list, zone.AdminArea)
+	}
+	return list, nil
+}
+
+func (a *AdminAreas) ListWoredas(zoneCode string) ([]AdminArea, error) {
+	zone, ok := a.zones[zoneCode]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown zone %q", ErrInvalidAddress, zoneCode)
+	}
+	list := make([]AdminArea, 0, len(zone.Woredas))
+	for _, woreda := range zone.Woredas {
+		list = append(list, woreda.AdminArea)
+	}
+	return list, nil
+}
+
+func (a *AdminAreas) ListKebeles(woredaCode string) ([]AdminArea, error) {
+	woreda, ok := a.woredas[woredaCode]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown woreda %q", ErrInvalidAddress, woredaCode)
+	}
+	list := make([]AdminArea, 0, len(woreda.Kebeles))
+	for _, kebele := range woreda.Kebeles {
+		list = append(list, kebele.AdminArea)
+	}
+	return list, nil
+}
+
+// ValidateAddress checks region/zone/woreda (and kebele if given) against the dataset
+// and rewrites country, state and city with the canonical names so reports group properly.
+// Landmark stays free text. Clients written before the hierarchy send only country, state and
+// city, that address is kept as it is and flagged for review.
+func (a *AdminAreas) ValidateAddress(addr *Address) error {
+	addr.Region = strings.ToUpper(strings.TrimSpace(addr.Region))
+	addr.Zone = strings.ToUpper(strings.TrimSpace(addr.Zone))
+	addr.Woreda = strings.ToUpper(strings.TrimSpace(addr.Woreda))
+	addr.Kebele = strings.ToUpper(strings.TrimSpace(addr.Kebele))
+
+	if addr.Region == "" && addr.Zone == "" && addr.Woreda == "" && addr.Kebele == "" {
+		addr.Country = strings.TrimSpace(addr.Country)
+		addr.State = strings.TrimSpace(addr.State)
+		addr.City = strings.TrimSpace(addr.City)
+		if addr.Country == "" || addr.State == "" || addr.City == "" {
+			return fmt.Errorf("%w: region, zone and woreda are required", ErrInvalidAddress)
+		}
+		addr.NeedsReview = true
+		return nil
+	}
+	if addr.Region == "" || addr.Zone == "" || addr.Woreda == "" {
+		return fmt.Errorf("%w: region, zone and woreda are required", ErrInvalidAddress)
+	}
+	region, ok := a.regions[addr.Region]
+	if !ok {
+		return fmt.Errorf("%w: unknown region %q", ErrInvalidAddress, addr.Region)
+	}
+	zone, ok := a.zones[addr.Zone]
+	if !ok || !strings.HasPrefix(zone.Code, region.Code+"-") {
+		return fmt.Errorf("%w: zone %q does not belong to region %q", ErrInvalidAddress, addr.Zone, addr.Region)
+	}
+	woreda, ok := a.woredas[addr.Woreda]
+	if !ok || !strings.HasPrefix(woreda.Code, zone.Code+"-") {
+		return fmt.Errorf("%w: woreda %q does not belong to zone %q", ErrInvalidAddress, addr.Woreda, addr.Zone)
+	}
+	if addr.Kebele != "" {
+		kebele, ok := a.kebeles[addr.Kebele]
+		if !ok || !strings.HasPrefix(kebele.Code, woreda.Code+"-") {
+			return fmt.Errorf("%w: kebele %q does not belong to woreda %q", ErrInvalidAddress, addr.Kebele, addr.Woreda)
+		}
+	}
+
+	addr.Country = a.Country
+	addr.State = region.Name
+	addr.City = zone.Name
+	addr.NeedsReview = false
+	return nil
+}
+
+// ValidateAddress validates against the currently loaded dataset
+func ValidateAddress(addr *Address) error {
+	return GetAdminAreas().ValidateAddress(addr)
+}
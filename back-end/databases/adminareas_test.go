@@ -0,0 +1,89 @@
+package databases
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testAdminAreas = `{"version": "test", "country": "Ethiopia", "regions": [
+	{"code": "ET-AA", "name": "Addis Ababa", "zones": [
+		{"code": "ET-AA-01", "name": "Addis Ketema", "woredas": [
+			{"code": "ET-AA-01-01", "name": "Woreda 01", "kebeles": [{"code": "ET-AA-01-01-01", "name": "Kebele 01"}]}]}]},
+	{"code": "ET-OR", "name": "Oromia", "zones": [
+		{"code": "ET-OR-01", "name": "East Shewa", "woredas": [{"code": "ET-OR-01-01", "name": "Adama"}]}]}]}`
+
+func TestParseAdminAreas(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		// part of the error, empty when the dataset is valid
+		err string
+	}{
+		{"valid", testAdminAreas, ""},
+		{"not json", `{"regions": [`, "failed to parse"},
+		{"no regions", `{"version": "test", "country": "Ethiopia", "regions": []}`, "no regions"},
+		{"empty code", strings.Replace(testAdminAreas, `"ET-OR-01-01"`, `""`, 1), "empty code"},
+		{"duplicate code", strings.Replace(testAdminAreas, `"ET-OR"`, `"ET-AA"`, 1), "duplicate code"},
+		{"zone under the wrong region", strings.Replace(testAdminAreas, `"ET-OR-01"`, `"ET-AA-02"`, 1), "not nested"},
+		{"orphaned woreda", strings.Replace(testAdminAreas, `"ET-OR-01-01"`, `"ET-AA-01-02"`, 1), "not nested"},
+		{"orphaned kebele", strings.Replace(testAdminAreas, `"ET-AA-01-01-01"`, `"ET-OR-01-01-01"`, 1), "not nested"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			areas, err := ParseAdminAreas([]byte(c.data))
+			if c.err == "" {
+				if err != nil || len(areas.ListRegions()) != 2 {
+					t.Fatalf("areas = %+v, %v", areas, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("err = %v, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	areas, err := ParseAdminAreas([]byte(testAdminAreas))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		address Address
+		want    Address
+		invalid bool
+	}{
+		{name: "valid", address: Address{Region: " et-aa", Zone: "ET-AA-01", Woreda: "ET-AA-01-01", Kebele: "ET-AA-01-01-01", Landmark: "market"},
+			want: Address{Country: "Ethiopia", State: "Addis Ababa", City: "Addis Ketema", Landmark: "market",
+				Region: "ET-AA", Zone: "ET-AA-01", Woreda: "ET-AA-01-01", Kebele: "ET-AA-01-01-01"}},
+		{name: "codes replace the free text", address: Address{Country: "ET", State: "AA", City: "Adama", Region: "ET-OR", Zone: "ET-OR-01", Woreda: "ET-OR-01-01"},
+			want: Address{Country: "Ethiopia", State: "Oromia", City: "East Shewa", Region: "ET-OR", Zone: "ET-OR-01", Woreda: "ET-OR-01-01"}},
+		{name: "legacy free text", address: Address{Country: " Ethiopia", State: "Oromia ", City: "Adama", Landmark: "bus station"},
+			want: Address{Country: "Ethiopia", State: "Oromia", City: "Adama", Landmark: "bus station", NeedsReview: true}},
+		{name: "legacy without a city", address: Address{Country: "Ethiopia", State: "Oromia"}, invalid: true},
+		{name: "nothing", address: Address{}, invalid: true},
+		{name: "woreda missing", address: Address{Region: "ET-AA", Zone: "ET-AA-01"}, invalid: true},
+		{name: "unknown region", address: Address{Region: "ET-XX", Zone: "ET-XX-01", Woreda: "ET-XX-01-01"}, invalid: true},
+		{name: "zone of another region", address: Address{Region: "ET-AA", Zone: "ET-OR-01", Woreda: "ET-OR-01-01"}, invalid: true},
+		{name: "woreda of another zone", address: Address{Region: "ET-AA", Zone: "ET-AA-01", Woreda: "ET-OR-01-01"}, invalid: true},
+		{name: "kebele of another woreda", address: Address{Region: "ET-OR", Zone: "ET-OR-01", Woreda: "ET-OR-01-01", Kebele: "ET-AA-01-01-01"}, invalid: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			address := c.address
+			err := areas.ValidateAddress(&address)
+			if c.invalid {
+				if !errors.Is(err, ErrInvalidAddress) {
+					t.Errorf("err = %v, want ErrInvalidAddress", err)
+				}
+				return
+			}
+			if err != nil || address != c.want {
+				t.Errorf("address = %+v, %v, want %+v", address, err, c.want)
+			}
+		})
+	}
+}
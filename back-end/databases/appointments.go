@@ -0,0 +1,19 @@
+package databases
+
+import "errors"
+
+var ErrAppointmentNotFound = errors.New("appointment not found")
+
+// checkAppointment makes sure appointment id is the patient's and was booked with the healthcare provider
+func checkAppointment(db queryRower, healthcare_id, health_id string, id int64) error {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM appointments WHERE id = $1 AND healthcare_id = $2 AND health_id = $3;`,
+		id, healthcare_id, health_id).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAppointmentNotFound
+	}
+	return nil
+}
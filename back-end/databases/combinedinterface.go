@@ -1,10 +1,13 @@
 package databases
 
 import (
+	"errors"
 	"fmt"
 	"time"
+	"vaibhavyadav-dev/healthcareServer/notify"
 	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
 	rd "vaibhavyadav-dev/healthcareServer/redis"
+	"vaibhavyadav-dev/healthcareServer/webhooks"
 
 	// "go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -14,6 +17,17 @@ type CombinedStore struct {
 	mongodb   *MongoStore
 	rabbitmq  *mq.Rabbitmq
 	redisconn *rd.Redisconn
+	// publishes what the postgres outbox holds to rabbitmq, projects the event log and
+	// publishes the live updates to redis
+	relay *outboxRelay
+	// posts the webhook deliveries the projection queued
+	webhooks *outboxRelay
+	// sends the notifications the projection queued, once EnableNotifications gave it channels
+	notifications *notifier
+	// logs the appointment reminders that are due, once EnableReminders gave it a schedule
+	reminders *reminders
+	// nil unless EnableShadowReads was called
+	shadow *shadowReader
 }
 
 // redis will contain url, limit -> no request allowed in window time
@@ -46,28 +60,53 @@ func Combinedstore(redisURL string, limit int64, window time.Duration, rabbitMqU
 		return nil, fmt.Errorf("failed to init postgres: %s", err.Error())
 	}
 
-	return &CombinedStore{
+	store := &CombinedStore{
 		postgres:  postgres,
 		mongodb:   mongodb,
 		rabbitmq:  rabbitmqconn,
 		redisconn: redisconn,
-	}, nil
+		webhooks: startOutboxRelay("webhook deliveries", func() (int, error) {
+			return postgres.DeliverWebhooks(webhooks.Post)
+		}),
+		notifications: startNotifier(postgres.SendNotifications),
+	}
+	store.relay = startOutboxRelay("outbox relay", func() (int, error) {
+		sent, err := postgres.RelayOutbox(rabbitmqconn.Publish)
+		// the counters and webhook deliveries follow the event log in the same loop
+		projected, projectErr := postgres.ProjectEvents()
+		if projected > 0 {
+			store.webhooks.notify()
+			store.notifications.loop.notify()
+		}
+		streamed, streamErr := postgres.StreamEvents(redisconn.PublishLive)
+		return max(sent, projected, streamed), errors.Join(err, projectErr, streamErr)
+	})
+	store.reminders = startReminders(func(schedule ReminderSchedule) (int, error) {
+		reminded, err := postgres.RemindAppointments(schedule)
+		if reminded > 0 {
+			store.relay.notify()
+		}
+		return reminded, err
+	})
+	return store, nil
 }
 
 // for each methods define which database methods will be called
 // Since we have two database each one of have it's own methods
 // This allows us to add more databases sequentially
 
-func (s *CombinedStore) SignUpAccount(hipinfo *HIPInfo) (int64, error) {
-	return s.postgres.SignUpAccount(hipinfo)
+func (s *CombinedStore) SignUpAccount(hipinfo *HIPInfo, messages ...mq.Message) (int64, error) {
+	defer s.relay.notify()
+	return s.postgres.SignUpAccount(hipinfo, messages...)
 }
 
 func (s *CombinedStore) LoginUser(login *Login) (*HIPInfo, error) {
 	return s.postgres.LoginUser(login)
 }
 
-func (s *CombinedStore) ChangePreferance(id string, pref map[string]interface{}) error {
-	return s.postgres.ChangePreferance(id, pref)
+func (s *CombinedStore) ChangePreferance(id string, pref map[string]interface{}, messages ...mq.Message) error {
+	defer s.relay.notify()
+	return s.postgres.ChangePreferance(id, pref, messages...)
 }
 
 func (s *CombinedStore) GetPreferance(id string) (*Preferance, error) {
@@ -84,29 +123,213 @@ func (s *CombinedStore) CreateClient_stats(health_id string) error {
 func (s *CombinedStore) GetAppointments_postgres(health_id string, offset, limit int64) ([]*Appointments, error) {
 	return s.postgres.GetAppointments(health_id, offset, limit)
 }
+func (s *CombinedStore) CheckAppointment(healthcare_id, health_id string, id int64) error {
+	return s.postgres.CheckAppointment(healthcare_id, health_id, id)
+}
 func (s *CombinedStore) SetAppointments_postgres(healthcare_id, health_id, status string, id int64) (int64, error) {
 	return s.postgres.SetAppointments(healthcare_id, health_id, status, id)
 }
 // Get Healthcare_Profile
 func (s *CombinedStore) GetHealthcare_details_postgres(healthcare_id string) (*HIPInfo, error){
-	return s.postgres.GetHealthcare_details(healthcare_id)
+	hip, err := s.postgres.GetHealthcare_details(healthcare_id)
+	if err == nil {
+		s.shadow.compare("healthcare", "postgres", healthcare_id, hip, func() (interface{}, error) {
+			return s.mongodb.GetHealthcare_details(healthcare_id)
+		})
+	}
+	return hip, err
 }
 
 // Create Client_Profile
-func (s *CombinedStore) Create_ClientProfile(client *PatientDetails) error {
-	return s.postgres.Create_ClientProfile(client)
+func (s *CombinedStore) Create_ClientProfile(client *PatientDetails, messages ...mq.Message) error {
+	defer s.relay.notify()
+	return s.postgres.Create_ClientProfile(client, messages...)
 }
 
 // Get Client_Profile
 func (s *CombinedStore) Get_ClientProfile(health_id string) (*PatientDetails, error) {
-	return s.postgres.Get_ClientProfile(health_id)
+	patient, err := s.postgres.Get_ClientProfile(health_id)
+	if err == nil {
+		s.shadow.compare("patient", "postgres", health_id, patient, func() (interface{}, error) {
+			return s.mongodb.GetPatient_bioData(health_id)
+		})
+	}
+	return patient, err
 }
 
 // Update Client_Profile
-func (s *CombinedStore) Update_clientProfile(health_id string, update map[string]interface{}) (*PatientDetails, error){
-	return s.postgres.UpdateClientProfile(health_id, update);
+func (s *CombinedStore) Update_clientProfile(health_id string, patch *ProfilePatch, ifVersion int, change ProfileChange) (*PatientDetails, error) {
+	defer s.relay.notify()
+	return s.postgres.UpdateClientProfile(health_id, patch, ifVersion, change)
+}
+
+// Client_Profile history
+func (s *CombinedStore) ListProfileVersions(health_id string) ([]*ProfileVersion, error) {
+	return s.postgres.ListProfileVersions(health_id)
+}
+
+func (s *CombinedStore) GetProfileVersion(health_id string, version int) (*PatientDetails, error) {
+	return s.postgres.GetProfileVersion(health_id, version)
+}
+
+func (s *CombinedStore) GetClientProfileAsOf(health_id string, at time.Time) (*PatientDetails, error) {
+	return s.postgres.GetClientProfileAsOf(health_id, at)
+}
+
+// Client_Profiles with legacy free-text address
+func (s *CombinedStore) GetAddressReviewQueue(healthcare_id string, limit int64) ([]*PatientDetails, error) {
+	return s.postgres.GetAddressReviewQueue(healthcare_id, limit)
 }
 
+func (s *CombinedStore) SearchClientProfiles(healthcare_id string, q *PatientSearch) ([]*PatientMatch, error) {
+	return s.postgres.SearchClientProfiles(healthcare_id, q)
+}
+
+// Duplicate detection and merges
+func (s *CombinedStore) FindDuplicates(patient *PatientDetails) ([]*DuplicateCandidate, error) {
+	return s.postgres.FindDuplicates(patient)
+}
+
+func (s *CombinedStore) GetDuplicateQueue(healthcare_id, status string, limit int64) ([]*DuplicateCandidate, error) {
+	return s.postgres.GetDuplicateQueue(healthcare_id, status, limit)
+}
+
+func (s *CombinedStore) DismissDuplicate(healthcare_id string, id int64) error {
+	return s.postgres.DismissDuplicate(healthcare_id, id)
+}
+
+// postgres moves appointments and stats in one transaction, then the mongo records follow.
+// If mongo fails the postgres half is unmerged again so nothing is left half done
+func (s *CombinedStore) MergeClientProfiles(healthcare_id, surviving, merged string) (*PatientMerge, error) {
+	merge, err := s.postgres.MergeClientProfiles(healthcare_id, surviving, merged)
+	if err != nil {
+		return nil, err
+	}
+	return s.moveMergedRecords(healthcare_id, merge)
+}
+
+func (s *CombinedStore) RequestMerge(healthcare_id, surviving, merged string) (*MergeRequest, error) {
+	return s.postgres.RequestMerge(healthcare_id, surviving, merged)
+}
+
+func (s *CombinedStore) ListMergeRequests(healthcare_id, status string, limit int64) ([]*MergeRequest, error) {
+	return s.postgres.ListMergeRequests(healthcare_id, status, limit)
+}
+
+// the approved merge is done like MergeClientProfiles
+func (s *CombinedStore) ApproveMergeRequest(healthcare_id string, id int64) (*PatientMerge, error) {
+	merge, err := s.postgres.ApproveMergeRequest(healthcare_id, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.moveMergedRecords(healthcare_id, merge)
+}
+
+func (s *CombinedStore) RejectMergeRequest(healthcare_id string, id int64) (*MergeRequest, error) {
+	return s.postgres.RejectMergeRequest(healthcare_id, id)
+}
+
+// moveMergedRecords moves the mongo records of a merge postgres has done. When that fails the
+// records that did move are handed back and the merge is undone. If they cannot be handed back the
+// merge stays, with the records on the survivor where it expects them, and the error says so
+func (s *CombinedStore) moveMergedRecords(healthcare_id string, merge *PatientMerge) (*PatientMerge, error) {
+	records, err := s.mongodb.MovePatientRecords(merge.MergedHealthID, merge.SurvivingHealthID)
+	if err == nil {
+		if err = s.postgres.SetMergedRecords(merge.ID, records); err == nil {
+			merge.Moved.Records = records
+			return merge, nil
+		}
+	}
+	if restoreErr := s.mongodb.RestorePatientRecords(records, merge.MergedHealthID); restoreErr != nil {
+		return nil, fmt.Errorf("merge %d is done but failed to record its patient records %v: %w (moving them back failed: %w)",
+			merge.ID, records, err, restoreErr)
+	}
+	if _, undoErr := s.postgres.UnmergeClientProfiles(healthcare_id, merge.ID); undoErr != nil {
+		return nil, fmt.Errorf("failed to move patient records: %w (undo failed: %w)", err, undoErr)
+	}
+	return nil, fmt.Errorf("failed to move patient records: %w", err)
+}
+
+// postgres puts back appointments and stats, then the mongo records follow. A failure there is
+// returned, the records the merge moved are listed in patient_merges.moved for moving them by hand
+func (s *CombinedStore) UnmergeClientProfiles(healthcare_id string, mergeID int64) (*PatientMerge, error) {
+	merge, err := s.postgres.UnmergeClientProfiles(healthcare_id, mergeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mongodb.RestorePatientRecords(merge.Moved.Records, merge.MergedHealthID); err != nil {
+		return nil, fmt.Errorf("merge %d is undone but its patient records %v are still with %s: %w",
+			merge.ID, merge.Moved.Records, merge.SurvivingHealthID, err)
+	}
+	return merge, nil
+}
+
+// Webhooks, the projection queues deliveries and s.webhooks posts them
+func (s *CombinedStore) CreateWebhook(webhook *Webhook) error {
+	return s.postgres.CreateWebhook(webhook)
+}
+
+func (s *CombinedStore) ListWebhooks(healthcare_id string) ([]*Webhook, error) {
+	return s.postgres.ListWebhooks(healthcare_id)
+}
+
+func (s *CombinedStore) GetWebhook(healthcare_id string, id int64) (*Webhook, error) {
+	return s.postgres.GetWebhook(healthcare_id, id)
+}
+
+func (s *CombinedStore) UpdateWebhook(healthcare_id string, id int64, patch *WebhookPatch) (*Webhook, error) {
+	defer s.webhooks.notify()
+	return s.postgres.UpdateWebhook(healthcare_id, id, patch)
+}
+
+func (s *CombinedStore) DeleteWebhook(healthcare_id string, id int64) error {
+	return s.postgres.DeleteWebhook(healthcare_id, id)
+}
+
+func (s *CombinedStore) ListWebhookDeliveries(healthcare_id string, webhookID int64, status string, limit int64) ([]*WebhookDelivery, error) {
+	return s.postgres.ListWebhookDeliveries(healthcare_id, webhookID, status, limit)
+}
+
+func (s *CombinedStore) GetWebhookDelivery(healthcare_id string, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	return s.postgres.GetWebhookDelivery(healthcare_id, webhookID, deliveryID)
+}
+
+func (s *CombinedStore) RedeliverWebhook(healthcare_id string, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	defer s.webhooks.notify()
+	return s.postgres.RedeliverWebhook(healthcare_id, webhookID, deliveryID)
+}
+
+// EnableReminders starts reminding patients of their appointments on schedule
+func (s *CombinedStore) EnableReminders(schedule ReminderSchedule) {
+	s.reminders.enable(schedule)
+}
+
+// AnswerReminder confirms or cancels the appointment a patient's text message answers
+func (s *CombinedStore) AnswerReminder(from, text string) (*ReminderReply, error) {
+	defer s.relay.notify()
+	return s.postgres.AnswerReminder(from, text)
+}
+
+// EnableNotifications starts sending the queued notifications on channels, until then they wait
+func (s *CombinedStore) EnableNotifications(channels notify.Channels) {
+	s.notifications.enable(channels)
+}
+
+func (s *CombinedStore) GetNotificationPreferences(healthcare_id, recipientType, recipientID string) (*NotificationPreferences, error) {
+	return s.postgres.GetNotificationPreferences(healthcare_id, recipientType, recipientID)
+}
+
+func (s *CombinedStore) SetNotificationPreferences(healthcare_id string, prefs *NotificationPreferences) error {
+	return s.postgres.SetNotificationPreferences(healthcare_id, prefs)
+}
+
+func (s *CombinedStore) ListNotifications(healthcare_id, status string, limit int64) ([]*Notification, error) {
+	return s.postgres.ListNotifications(healthcare_id, status, limit)
+}
+
+func (s *CombinedStore) GetNotification(healthcare_id string, id int64) (*Notification, error) {
+	return s.postgres.GetNotification(healthcare_id, id)
+}
 
 // mongodb methods goes here.....
 func (s *CombinedStore) GetAppointments(id string, list int64) ([]*Appointments, error) {
@@ -122,11 +345,23 @@ func (s *CombinedStore) CreatePatient_bioData(id string, details *PatientDetails
 }
 
 func (s *CombinedStore) GetPatient_bioData(healthID string) (*PatientDetails, error) {
-	return s.mongodb.GetPatient_bioData(healthID)
+	patient, err := s.mongodb.GetPatient_bioData(healthID)
+	if err == nil {
+		s.shadow.compare("patient", "mongo", healthID, patient, func() (interface{}, error) {
+			return s.postgres.Get_ClientProfile(healthID)
+		})
+	}
+	return patient, err
 }
 
 func (s *CombinedStore) GetHealthcare_details(id string) (*HIPInfo, error) {
-	return s.mongodb.GetHealthcare_details(id)
+	hip, err := s.mongodb.GetHealthcare_details(id)
+	if err == nil {
+		s.shadow.compare("healthcare", "mongo", id, hip, func() (interface{}, error) {
+			return s.postgres.GetHealthcare_details(id)
+		})
+	}
+	return hip, err
 }
 
 func (s *CombinedStore) CreatepatientRecords(healthID string, records *PatientRecords) (*PatientRecords, error) {
@@ -163,31 +398,41 @@ func (s *CombinedStore) CreateHealthcare_details(healthcare_info *HIPInfo) (*HIP
 ///////////////////////////////////////////////////////
 
 // rabbitmq implementation goes here
-func (s *CombinedStore) Push_counters(category, healthcare_id string) error {
-	return s.rabbitmq.Push_counters(category, healthcare_id)
+// Enqueue goes through the postgres outbox, the relay publishes to rabbitmq
+func (s *CombinedStore) Enqueue(messages ...mq.Message) error {
+	defer s.relay.notify()
+	return s.postgres.Enqueue(messages...)
+}
+
+// Redis implementation
+func (s *CombinedStore) Set(key string, value interface{}) error {
+	return s.redisconn.Set(key, value)
 }
-func (s *CombinedStore) Push_logs(category, name, email, health_id, healthcare_name, healthcare_id interface{}) error {
-	return s.rabbitmq.Push_logs(category, name, email, health_id, healthcare_name, healthcare_id)
+
+func (s *CombinedStore) Get(key string) (interface{}, error) {
+	return s.redisconn.Get(key)
 }
-func (s *CombinedStore) Push_update_appointment(appointment map[string]interface{}) error {
-	return s.rabbitmq.Push_update_appointment(appointment)
+
+func (s *CombinedStore) ClaimIdempotencyKey(key string, req *rd.IdempotentRequest, ttl time.Duration) (*rd.IdempotentRequest, error) {
+	return s.redisconn.ClaimIdempotencyKey(key, req, ttl)
 }
 
-func (s *CombinedStore) Push_patient_records(record map[string]interface{}) error {
-	return s.rabbitmq.Push_patient_records(record)
+func (s *CombinedStore) SaveIdempotentResponse(key string, req *rd.IdempotentRequest, ttl time.Duration) error {
+	return s.redisconn.SaveIdempotentResponse(key, req, ttl)
 }
 
-func (s *CombinedStore) Push_patientbiodata(biodata map[string]interface{}) error {
-	return s.rabbitmq.Push_patientbiodata(biodata)
+func (s *CombinedStore) ReleaseIdempotencyKey(key string) error {
+	return s.redisconn.ReleaseIdempotencyKey(key)
 }
 
-// Redis implementation
-func (s *CombinedStore) Set(key string, value interface{}) error {
-	return s.redisconn.Set(key, value)
+// Live updates come through redis from whichever replica's relay published them, the ones
+// a stream missed from the postgres event log
+func (s *CombinedStore) SubscribeLive(healthcare_id string) (<-chan rd.LiveUpdate, func(), error) {
+	return s.redisconn.SubscribeLive(healthcare_id)
 }
 
-func (s *CombinedStore) Get(key string) (interface{}, error) {
-	return s.redisconn.Get(key)
+func (s *CombinedStore) LiveUpdatesSince(healthcare_id string, after int64) ([]rd.LiveUpdate, error) {
+	return s.postgres.LiveUpdatesSince(healthcare_id, after)
 }
 
 //	RATE LIMITER GOES HERE...
@@ -202,5 +447,10 @@ func (s *CombinedStore) IsAllowed_leaky_bucket(healthcare_id string) (bool, erro
 }
 
 func (s *CombinedStore) Close() error {
+	s.relay.Close()
+	s.webhooks.Close()
+	s.notifications.loop.Close()
+	s.reminders.loop.Close()
+	s.rabbitmq.Close()
 	return s.redisconn.Close()
 }
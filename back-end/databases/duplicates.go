@@ -0,0 +1,308 @@
+package databases
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"vaibhavyadav-dev/healthcareServer/ethiopic"
+)
+
+// Every facility issues its own HID, so one person registered at two facilities ends up as two patients.
+// New profiles are compared against existing ones, pairs that score high enough land in duplicate_candidates
+// for a human to merge or dismiss. A merge re-points appointments, client_stats and patient records
+// to the surviving health_id and remembers what it moved so it can be undone.
+//
+// A HIP merges on its own only patients it registered both of. When the other patient belongs to
+// another HIP the merge becomes a merge request, that HIP approves it (and the merge happens then)
+// or rejects it.
+
+const (
+	// pairs scoring at least this much are queued for review
+	duplicateReviewScore = 0.75
+	// how many existing profiles are compared against a new one
+	duplicateCandidateLimit = 200
+)
+
+// relative weight of each field, only fields present on both profiles count
+var duplicateWeights = map[string]float64{
+	"national_id": 3,
+	"name":        2,
+	"fathername":  1.5,
+	"dob":         1.5,
+	"phone":       1.5,
+	"mothername":  1,
+	"sex":         0.5,
+}
+
+var (
+	ErrPatientNotFound   = errors.New("patient not found")
+	ErrAlreadyMerged     = errors.New("patient has already been merged")
+	ErrMergeForbidden    = errors.New("healthcare provider owns neither patient")
+	ErrMergeNotFound     = errors.New("merge not found")
+	ErrUnmergeForbidden  = errors.New("healthcare provider did not register the surviving patient")
+	ErrDuplicateNotFound = errors.New("duplicate candidate not found")
+	// the other patient belongs to another healthcare provider, see RequestMerge
+	ErrMergeApprovalRequired = errors.New("the other healthcare provider has to approve the merge")
+	ErrMergeRequestNotFound  = errors.New("merge request not found")
+)
+
+type DuplicateCandidate struct {
+	ID                int64              `json:"id"`
+	HealthID          string             `json:"health_id"`
+	CandidateHealthID string             `json:"candidate_health_id"`
+	Score             float64            `json:"score"`
+	Fields            map[string]float64 `json:"fields"`
+	Status            string             `json:"status"`
+	CreatedAt         time.Time          `json:"created_at"`
+	ReviewedBy        string             `json:"reviewed_by,omitempty"`
+
+	// filled for the review queue: the reviewing HIP's own patient in full, of the other one
+	// (possibly another HIP's) only why it looks alike
+	Patient   *PatientDetails   `json:"patient,omitempty"`
+	Candidate *DuplicateSummary `json:"candidate,omitempty"`
+}
+
+// DuplicateSummary is a patient of a pair without its personal details
+type DuplicateSummary struct {
+	HealthID string   `json:"health_id"`
+	Score    float64  `json:"score"`
+	Reasons  []string `json:"reasons"`
+}
+
+// the fields that scored at least this much are why a pair looks alike
+const duplicateReasonScore = 0.75
+
+type ClientStats struct {
+	ProfileViewed  int `json:"profile_viewed"`
+	ProfileUpdated int `json:"profile_updated"`
+	RecordsViewed  int `json:"records_viewed"`
+	RecordsCreated int `json:"records_created"`
+}
+
+// MergeMoves is everything a merge touched, unmerge puts exactly these back
+type MergeMoves struct {
+	Appointments []int64     `json:"appointments"`
+	Records      []string    `json:"records"`
+	Stats        ClientStats `json:"stats"`
+}
+
+type PatientMerge struct {
+	ID                int64      `json:"id"`
+	SurvivingHealthID string     `json:"surviving_health_id"`
+	MergedHealthID    string     `json:"merged_health_id"`
+	MergedBy          string     `json:"merged_by"`
+	MergedAt          time.Time  `json:"merged_at"`
+	UnmergedBy        string     `json:"unmerged_by,omitempty"`
+	UnmergedAt        *time.Time `json:"unmerged_at,omitempty"`
+	Moved             MergeMoves `json:"moved"`
+}
+
+// MergeRequest is a merge of patients registered by two HIPs, waiting for the Approver
+type MergeRequest struct {
+	ID                int64      `json:"id"`
+	SurvivingHealthID string     `json:"surviving_health_id"`
+	MergedHealthID    string     `json:"merged_health_id"`
+	RequestedBy       string     `json:"requested_by"`
+	Approver          string     `json:"approver"`
+	Status            string     `json:"status"`
+	MergeID           *int64     `json:"merge_id,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	DecidedAt         *time.Time `json:"decided_at,omitempty"`
+}
+
+// mergeApprover is the HIP that has to agree to a merge healthcare_id asks for, "" when
+// healthcare_id registered both patients. owners maps both health_ids to the HIPs that registered them
+func mergeApprover(owners map[string]string, healthcare_id string) (string, error) {
+	approver, owned := "", false
+	for _, owner := range owners {
+		if owner == healthcare_id {
+			owned = true
+		} else {
+			approver = owner
+		}
+	}
+	if !owned {
+		return "", ErrMergeForbidden
+	}
+	return approver, nil
+}
+
+// ScoreDuplicate says how likely two profiles are the same person (0..1) and how each field compared
+func ScoreDuplicate(a, b *PatientDetails) (float64, map[string]float64) {
+	fields := map[string]float64{}
+
+	if idA, idB := normalizeID(a.AadhaarNumber), normalizeID(b.AadhaarNumber); idA != "" && idB != "" {
+		fields["national_id"] = boolScore(idA == idB)
+	}
+	firstName := -1.0
+	if present(a.FirstName, b.FirstName) {
+		firstName = ethiopic.Similarity(a.FirstName, b.FirstName)
+		fields["name"] = firstName
+		if present(a.LastName, b.LastName) {
+			fields["name"] = (firstName + ethiopic.Similarity(a.LastName, b.LastName)) / 2
+		}
+	}
+	if present(a.FatherName, b.FatherName) {
+		fields["fathername"] = ethiopic.Similarity(a.FatherName, b.FatherName)
+	}
+	if present(a.MotherName, b.MotherName) {
+		fields["mothername"] = ethiopic.Similarity(a.MotherName, b.MotherName)
+	}
+	if present(a.DOB, b.DOB) {
+		fields["dob"] = dobScore(a.DOB, b.DOB)
+	}
+	if phones := samePhone(a, b); phones >= 0 {
+		fields["phone"] = phones
+	}
+	if present(a.Sex, b.Sex) {
+		fields["sex"] = boolScore(sexKey(a.Sex) == sexKey(b.Sex))
+	}
+
+	total, weights := 0.0, 0.0
+	for field, score := range fields {
+		total += score * duplicateWeights[field]
+		weights += duplicateWeights[field]
+	}
+	if weights == 0 {
+		return 0, fields
+	}
+	score := total / weights
+	// two different national IDs is strong evidence of two different people
+	if id, ok := fields["national_id"]; ok && id == 0 {
+		score *= 0.6
+	}
+	// siblings and twins share parents, phone and sometimes the birthday, only the given name tells them apart
+	if firstName >= 0 && firstName < 0.75 {
+		score *= 0.7
+	}
+	return score, fields
+}
+
+func present(a, b string) bool {
+	return strings.TrimSpace(a) != "" && strings.TrimSpace(b) != "" && a != "N/A" && b != "N/A"
+}
+
+func boolScore(ok bool) float64 {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+func normalizeID(id string) string {
+	id = strings.ToUpper(strings.TrimSpace(id))
+	if id == "N/A" {
+		return ""
+	}
+	return strings.NewReplacer(" ", "", "-", "").Replace(id)
+}
+
+// exact date is a match, same year or day/month swapped is half way there
+func dobScore(a, b string) float64 {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	if a == b {
+		return 1
+	}
+	ta, errA := time.Parse("2006-01-02", a)
+	tb, errB := time.Parse("2006-01-02", b)
+	if errA != nil || errB != nil {
+		return 0
+	}
+	if ta.Year() == tb.Year() && int(ta.Month()) == tb.Day() && ta.Day() == int(tb.Month()) {
+		return 0.7
+	}
+	if ta.Year() == tb.Year() {
+		return 0.4
+	}
+	return 0
+}
+
+// the ways sex is written, in every supported language
+var sexKeys = map[string]string{
+	"m": "m", "male": "m", "ወንድ": "m", "dhiira": "m",
+	"f": "f", "female": "f", "ሴት": "f", "dhalaa": "f", "dubartii": "f",
+}
+
+// sexKey is m or f for the known spellings, any other value is compared as it is written
+func sexKey(sex string) string {
+	sex = strings.ToLower(strings.TrimSpace(sex))
+	if key, ok := sexKeys[sex]; ok {
+		return key
+	}
+	return sex
+}
+
+// nationalNumber is the national part of a phone number, "" when it is shorter than that. A few
+// trailing digits say nothing about whose number it is
+func nationalNumber(phone string) string {
+	if digits := phoneDigits(phone); len(digits) == phoneKeyDigits {
+		return digits
+	}
+	return ""
+}
+
+// 1 if any number of one profile is a number of the other, -1 when there is nothing to compare
+func samePhone(a, b *PatientDetails) float64 {
+	numbersA := []string{nationalNumber(a.MobileNumber), nationalNumber(a.EmergencyNumber)}
+	numbersB := []string{nationalNumber(b.MobileNumber), nationalNumber(b.EmergencyNumber)}
+	if numbersA[0] == "" || numbersB[0] == "" {
+		return -1
+	}
+	for _, x := range numbersA {
+		for _, y := range numbersB {
+			if x != "" && x == y {
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// reviewDuplicate fills the review side of a pair, profile loads a patient's full profile
+func reviewDuplicate(duplicate *DuplicateCandidate, ownsPatient bool, profile func(healthID string) (*PatientDetails, error)) error {
+	own, other := duplicate.HealthID, duplicate.CandidateHealthID
+	if !ownsPatient {
+		own, other = other, own
+	}
+	patient, err := profile(own)
+	if err != nil {
+		return err
+	}
+	reasons := []string{}
+	for field, score := range duplicate.Fields {
+		if score >= duplicateReasonScore {
+			reasons = append(reasons, field)
+		}
+	}
+	sort.Strings(reasons)
+	duplicate.Patient = patient
+	duplicate.Candidate = &DuplicateSummary{HealthID: other, Score: duplicate.Score, Reasons: reasons}
+	return nil
+}
+
+// RankDuplicates keeps the candidates worth a human look, best first
+func RankDuplicates(patient *PatientDetails, candidates []*PatientDetails) []*DuplicateCandidate {
+	duplicates := []*DuplicateCandidate{}
+	for _, candidate := range candidates {
+		if candidate.HealthID == patient.HealthID {
+			continue
+		}
+		score, fields := ScoreDuplicate(patient, candidate)
+		if score < duplicateReviewScore {
+			continue
+		}
+		duplicates = append(duplicates, &DuplicateCandidate{
+			HealthID:          patient.HealthID,
+			CandidateHealthID: candidate.HealthID,
+			Score:             score,
+			Fields:            fields,
+			Status:            "pending",
+		})
+	}
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		return duplicates[i].Score > duplicates[j].Score
+	})
+	return duplicates
+}
@@ -0,0 +1,157 @@
+package databases
+
+import (
+	"math"
+	"testing"
+)
+
+// duplicateOf is testPatient("HID-2", "Almaz") changed by change
+func duplicateOf(change func(p *PatientDetails)) *PatientDetails {
+	p := testPatient("HID-2", "Almaz")
+	change(p)
+	return p
+}
+
+func TestScoreDuplicate(t *testing.T) {
+	all := map[string]float64{"name": 1, "fathername": 1, "mothername": 1, "dob": 1, "phone": 1, "sex": 1}
+	with := func(changes map[string]float64, drop ...string) map[string]float64 {
+		fields := map[string]float64{}
+		for field, score := range all {
+			fields[field] = score
+		}
+		for field, score := range changes {
+			fields[field] = score
+		}
+		for _, field := range drop {
+			delete(fields, field)
+		}
+		return fields
+	}
+	cases := []struct {
+		name      string
+		candidate *PatientDetails
+		score     float64
+		fields    map[string]float64
+		// the national ID of the patient, N/A when empty
+		nationalID string
+	}{
+		{"same person", duplicateOf(func(p *PatientDetails) {}), 1, all, ""},
+		{"sex spelled out", duplicateOf(func(p *PatientDetails) { p.Sex = " female" }), 1, all, ""},
+		{"sex in Amharic", duplicateOf(func(p *PatientDetails) { p.Sex = "ሴት" }), 1, all, ""},
+		{"other sex", duplicateOf(func(p *PatientDetails) { p.Sex = "M" }), 7.5 / 8, with(map[string]float64{"sex": 0}), ""},
+		{"unknown sex is compared whole", duplicateOf(func(p *PatientDetails) { p.Sex = "Fx" }), 7.5 / 8, with(map[string]float64{"sex": 0}), ""},
+		{"no sex", duplicateOf(func(p *PatientDetails) { p.Sex = " " }), 1, with(nil, "sex"), ""},
+		{"day and month swapped", duplicateOf(func(p *PatientDetails) { p.DOB = "1990-01-05" }), (8 - 1.5*0.3) / 8, with(map[string]float64{"dob": 0.7}), ""},
+		{"same year", duplicateOf(func(p *PatientDetails) { p.DOB = "1990-11-21" }), (8 - 1.5*0.6) / 8, with(map[string]float64{"dob": 0.4}), ""},
+		{"phone written differently", duplicateOf(func(p *PatientDetails) { p.MobileNumber = "0911-234-567" }), 1, all, ""},
+		{"emergency number is the mobile", duplicateOf(func(p *PatientDetails) {
+			p.MobileNumber, p.EmergencyNumber = "0922000000", "+251911234567"
+		}), 1, all, ""},
+		{"other phone", duplicateOf(func(p *PatientDetails) { p.MobileNumber, p.EmergencyNumber = "0922000000", "" }),
+			6.5 / 8, with(map[string]float64{"phone": 0}), ""},
+		{"short numbers are not compared", duplicateOf(func(p *PatientDetails) { p.MobileNumber = "4567" }), 1, with(nil, "phone"), ""},
+		{"same national ID", duplicateOf(func(p *PatientDetails) { p.AadhaarNumber = "1234 5678" }), 1,
+			with(map[string]float64{"national_id": 1}), "1234-5678"},
+		{"other national ID", duplicateOf(func(p *PatientDetails) { p.AadhaarNumber = "8765 4321" }), 8.0 / 11 * 0.6,
+			with(map[string]float64{"national_id": 0}), "1234-5678"},
+		{"one national ID", duplicateOf(func(p *PatientDetails) { p.AadhaarNumber = "1234 5678" }), 1, all, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			patient := testPatient("HID-1", "Almaz")
+			if c.nationalID != "" {
+				patient.AadhaarNumber = c.nationalID
+			}
+			score, fields := ScoreDuplicate(patient, c.candidate)
+			if math.Abs(score-c.score) > 1e-9 {
+				t.Errorf("score = %v, want %v", score, c.score)
+			}
+			if len(fields) != len(c.fields) {
+				t.Errorf("fields = %v, want %v", fields, c.fields)
+			}
+			for field, want := range c.fields {
+				if got, ok := fields[field]; !ok || math.Abs(got-want) > 1e-9 {
+					t.Errorf("%s = %v, want %v", field, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestScoreDuplicatePenalties(t *testing.T) {
+	patient := testPatient("HID-1", "Almaz")
+	patient.AadhaarNumber = "1234 5678"
+	cases := []struct {
+		name      string
+		candidate *PatientDetails
+		review    bool
+	}{
+		{"same person", duplicateOf(func(p *PatientDetails) {}), true},
+		{"name spelled in Ge'ez", duplicateOf(func(p *PatientDetails) { p.FirstName, p.LastName = "አልማዝ", "ተስፋዬ" }), true},
+		// everything else matches, the national IDs tell them apart
+		{"other national ID", duplicateOf(func(p *PatientDetails) { p.AadhaarNumber = "8765 4321" }), false},
+		// a twin shares the parents, the birthday and the phone
+		{"twin", duplicateOf(func(p *PatientDetails) { p.FirstName = "Hanna" }), false},
+		{"stranger", duplicateOf(func(p *PatientDetails) {
+			p.FirstName, p.LastName, p.FatherName, p.MotherName = "Dawit", "Girma", "Girma", "Tigist"
+			p.DOB, p.MobileNumber, p.EmergencyNumber, p.Sex = "1975-02-11", "0933111111", "", "M"
+		}), false},
+	}
+	for _, c := range cases {
+		score, fields := ScoreDuplicate(patient, c.candidate)
+		if review := score >= duplicateReviewScore; review != c.review {
+			t.Errorf("%s: score %v (%v), want review %v", c.name, score, fields, c.review)
+		}
+	}
+	if score, fields := ScoreDuplicate(&PatientDetails{}, &PatientDetails{Sex: "F"}); score != 0 || len(fields) != 0 {
+		t.Errorf("nothing to compare: %v %v", score, fields)
+	}
+}
+
+func TestRankDuplicates(t *testing.T) {
+	patient := testPatient("HID-1", "Almaz")
+	candidates := []*PatientDetails{
+		duplicateOf(func(p *PatientDetails) { p.HealthID, p.FirstName = "HID-twin", "Hanna" }),
+		duplicateOf(func(p *PatientDetails) { p.HealthID, p.DOB = "HID-swapped", "1990-01-05" }),
+		duplicateOf(func(p *PatientDetails) { p.HealthID = "HID-same" }),
+		testPatient("HID-1", "Almaz"),
+	}
+	duplicates := RankDuplicates(patient, candidates)
+	if len(duplicates) != 2 {
+		t.Fatalf("duplicates = %+v", duplicates)
+	}
+	for i, want := range []string{"HID-same", "HID-swapped"} {
+		d := duplicates[i]
+		if d.CandidateHealthID != want || d.HealthID != "HID-1" || d.Status != "pending" || d.Score < duplicateReviewScore {
+			t.Errorf("duplicate %d = %+v, want %s", i, d, want)
+		}
+	}
+	if len(RankDuplicates(patient, nil)) != 0 {
+		t.Error("duplicates without candidates")
+	}
+}
+
+func TestReviewDuplicate(t *testing.T) {
+	patient, candidate := testPatient("HID-1", "Almaz"), duplicateOf(func(p *PatientDetails) { p.DOB = "1990-01-05" })
+	score, fields := ScoreDuplicate(patient, candidate)
+	duplicate := &DuplicateCandidate{HealthID: "HID-1", CandidateHealthID: "HID-2", Score: score, Fields: fields}
+	profiles := map[string]*PatientDetails{"HID-1": patient, "HID-2": candidate}
+	profile := func(healthID string) (*PatientDetails, error) { return profiles[healthID], nil }
+
+	// the reviewer registered the candidate
+	if err := reviewDuplicate(duplicate, false, profile); err != nil {
+		t.Fatal(err)
+	}
+	if duplicate.Patient != candidate || duplicate.Candidate.HealthID != "HID-1" || duplicate.Candidate.Score != score {
+		t.Errorf("review = %+v %+v", duplicate.Patient, duplicate.Candidate)
+	}
+	want := []string{"fathername", "mothername", "name", "phone", "sex"}
+	if len(duplicate.Candidate.Reasons) != len(want) {
+		t.Fatalf("reasons = %v, want %v", duplicate.Candidate.Reasons, want)
+	}
+	for i := range want {
+		if duplicate.Candidate.Reasons[i] != want[i] {
+			t.Errorf("reasons = %v, want %v", duplicate.Candidate.Reasons, want)
+		}
+	}
+}
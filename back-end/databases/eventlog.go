@@ -0,0 +1,235 @@
+package databases
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+)
+
+// Every event written to the outbox is also appended to event_log, in the same transaction.
+// The outbox is a delivery queue and may be cleared, the log is the history: nothing updates
+// or deletes its rows (a trigger refuses it) and it is where derived state is rebuilt from.
+//
+// The counters projection derives the usage counters of HealthCare_pref and client_stats from
+// the log. It runs after every outbox relay round from its checkpoint, the last position it
+// applied, so a counter is never written by a handler. RebuildProjections resets the counters to
+// their baselines and replays the log after them, for when a bug has counted wrong. The webhooks
+// projection (databases/webhooks.go) and the notifications one (databases/notifications.go)
+// follow the log the same way and are never replayed, that would deliver every past event again.
+
+const (
+	countersProjection = "counters"
+	// the position the counter baselines were taken at
+	countersBaseline = "counters_baseline"
+	// totalrequest_count of a new HIP, each login takes one off
+	loginQuota = 100
+	// appends take it until commit, so positions become visible in order and a checkpoint can't skip one
+	eventLogLockID = 720_410_046
+	// one replica projects at a time
+	projectionLockID = 720_410_047
+)
+
+// eventCounters is the counter an event type adds one to, for the HIP in HealthCare_pref
+// and for the patient in client_stats
+var eventCounters = map[string]string{
+	"patient.profile.viewed":  "profile_viewed",
+	"patient.profile.updated": "profile_updated",
+	"patient.record.created":  "records_created",
+	"patient.records.viewed":  "records_viewed",
+}
+
+// EventLogStatus is how far the counters projection is
+type EventLogStatus struct {
+	Events int64
+	// position of the newest event
+	Head       int64
+	Checkpoint int64
+}
+
+// eventHeader is the part of an event's envelope the log keeps in columns
+type eventHeader struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Version    int       `json:"version"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+func parseEvent(msg mq.Message) (*eventHeader, error) {
+	header := &eventHeader{}
+	if err := json.Unmarshal(msg.Body, header); err != nil {
+		return nil, fmt.Errorf("%s message is not an event: %w", msg.RoutingKey, err)
+	}
+	if header.ID == "" || header.Type != msg.RoutingKey {
+		return nil, fmt.Errorf("%s message is not an event envelope", msg.RoutingKey)
+	}
+	return header, nil
+}
+
+type loggedEvent struct {
+	Position int64
+	Type     string
+	Body     []byte
+}
+
+// projectAll applies the next batch of every projection and returns the most events one of them applied
+func projectAll(tx *sql.Tx) (int, error) {
+	counted, err := projectEvents(tx, countersProjection, applyCounters)
+	if err != nil {
+		return 0, err
+	}
+	fannedOut, err := projectEvents(tx, webhooksProjection, fanOutWebhooks)
+	if err != nil {
+		return 0, err
+	}
+	// after the counters, a login notification tells how many are left
+	notified, err := projectEvents(tx, notificationsProjection, queueNotifications)
+	return max(counted, fannedOut, notified), err
+}
+
+// projectEvents applies one batch of the events after the projection's checkpoint
+func projectEvents(tx *sql.Tx, projection string, apply func(*sql.Tx, loggedEvent) error) (int, error) {
+	checkpoint, err := projectionCheckpoint(tx, projection)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := tx.Query(`SELECT position, type, body FROM event_log WHERE position > $1 ORDER BY position LIMIT $2;`,
+		checkpoint, outboxBatch)
+	if err != nil {
+		return 0, err
+	}
+	var batch []loggedEvent
+	for rows.Next() {
+		var e loggedEvent
+		if err := rows.Scan(&e.Position, &e.Type, &e.Body); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	for _, e := range batch {
+		if err := apply(tx, e); err != nil {
+			return 0, fmt.Errorf("%s projection stopped at event %d (%s): %w", projection, e.Position, e.Type, err)
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO projection_checkpoints (name, position) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET position = excluded.position;`, projection, batch[len(batch)-1].Position)
+	return len(batch), err
+}
+
+func projectionCheckpoint(tx *sql.Tx, projection string) (int64, error) {
+	var checkpoint int64
+	err := tx.QueryRow(`SELECT position FROM projection_checkpoints WHERE name = $1;`, projection).Scan(&checkpoint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return checkpoint, err
+}
+
+func applyCounters(tx *sql.Tx, e loggedEvent) error {
+	var event struct {
+		Data struct {
+			HealthcareID string `json:"healthcare_id"`
+			HealthID     string `json:"health_id"`
+			Patient      struct {
+				HealthID string `json:"health_id"`
+			} `json:"patient"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(e.Body, &event); err != nil {
+		return err
+	}
+	data := event.Data
+
+	if e.Type == "hip.account.logged_in" {
+		_, err := tx.Exec(`UPDATE HealthCare_pref SET totalrequest_count = totalrequest_count - 1 WHERE healthcare_id = $1;`, data.HealthcareID)
+		return err
+	}
+	counter, ok := eventCounters[e.Type]
+	if !ok {
+		return nil
+	}
+	// counter is a column name from eventCounters, never from the event
+	_, err := tx.Exec(`UPDATE HealthCare_pref SET `+counter+` = COALESCE(`+counter+`, 0) + 1 WHERE healthcare_id = $1;`, data.HealthcareID)
+	if err != nil {
+		return err
+	}
+	healthID := data.HealthID
+	if healthID == "" {
+		healthID = data.Patient.HealthID
+	}
+	// a merged patient's events count for the surviving profile, like MergeClientProfiles moves its counters
+	_, err = tx.Exec(`UPDATE client_stats SET `+counter+` = `+counter+` + 1
+		WHERE health_id = (SELECT COALESCE(merged_into, health_id) FROM client_profile WHERE health_id = $1);`, healthID)
+	return err
+}
+
+// resetCounters puts the counters back to their baselines, what migration 0015 found before the log
+// counted anything, and the checkpoint at the last event the baselines include. A merged patient's
+// baseline counts for the surviving profile. HIPs and patients that came later start like new ones
+func resetCounters(tx *sql.Tx) error {
+	hip := func(counter string, start int) string {
+		return counter + ` = COALESCE((SELECT b.` + counter + ` FROM hip_counter_baselines b
+			WHERE b.healthcare_id = HealthCare_pref.healthcare_id), ` + fmt.Sprint(start) + `)`
+	}
+	_, err := tx.Exec(`UPDATE HealthCare_pref SET ` + hip("profile_viewed", 0) + `, ` + hip("profile_updated", 0) + `, ` +
+		hip("records_created", 0) + `, ` + hip("records_viewed", 0) + `, ` + hip("totalrequest_count", loginQuota) + `;`)
+	if err != nil {
+		return fmt.Errorf("failed to reset HealthCare_pref counters: %w", err)
+	}
+	patient := func(counter string) string {
+		return counter + ` = (SELECT COALESCE(SUM(b.` + counter + `), 0) FROM patient_counter_baselines b
+			LEFT JOIN client_profile p ON p.health_id = b.health_id
+			WHERE COALESCE(p.merged_into, b.health_id) = client_stats.health_id)`
+	}
+	_, err = tx.Exec(`UPDATE client_stats SET ` + patient("profile_viewed") + `, ` + patient("profile_updated") + `, ` +
+		patient("records_viewed") + `, ` + patient("records_created") + `;`)
+	if err != nil {
+		return fmt.Errorf("failed to reset client_stats counters: %w", err)
+	}
+	baseline, err := projectionCheckpoint(tx, countersBaseline)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO projection_checkpoints (name, position) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET position = excluded.position;`, countersProjection, baseline)
+	return err
+}
+
+// rebuildCounters replays the log from the start in tx, readers see the old counters until it commits
+func rebuildCounters(tx *sql.Tx) (int, error) {
+	if err := resetCounters(tx); err != nil {
+		return 0, err
+	}
+	total := 0
+	for {
+		n, err := projectEvents(tx, countersProjection, applyCounters)
+		total += n
+		if err != nil || n < outboxBatch {
+			return total, err
+		}
+	}
+}
+
+func eventLogStatus(db *sql.DB) (*EventLogStatus, error) {
+	status := &EventLogStatus{}
+	err := db.QueryRow(`SELECT COUNT(*), COALESCE(MAX(position), 0) FROM event_log;`).Scan(&status.Events, &status.Head)
+	if err != nil {
+		return nil, err
+	}
+	err = db.QueryRow(`SELECT position FROM projection_checkpoints WHERE name = $1;`, countersProjection).Scan(&status.Checkpoint)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return status, nil
+}
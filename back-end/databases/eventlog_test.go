@@ -0,0 +1,195 @@
+package databases
+
+import (
+	"testing"
+	"vaibhavyadav-dev/healthcareServer/events"
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+)
+
+func mustEvent(t *testing.T, data events.Payload) mq.Message {
+	t.Helper()
+	msg, err := events.Message(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestEventLogAppendOnly(t *testing.T) {
+	store := newTestSQLite(t)
+	if err := store.Enqueue(testEvent("patient.profile.viewed", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.db.Exec(`UPDATE event_log SET type = 'patient.profile.updated';`); err == nil {
+		t.Error("an event was updated")
+	}
+	if _, err := store.db.Exec(`DELETE FROM event_log;`); err == nil {
+		t.Error("an event was deleted")
+	}
+	// clearing the outbox leaves the log alone
+	if _, err := store.db.Exec(`DELETE FROM outbox;`); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := store.EventLogStatus(); err != nil || status.Events != 1 {
+		t.Errorf("status = %+v, %v", status, err)
+	}
+
+	// a message that isn't an event is refused with the change
+	if err := store.Enqueue(mq.Message{RoutingKey: "patient.profile.viewed", Body: []byte(`{"n":1}`)}); err == nil {
+		t.Error("a body without an envelope was written")
+	}
+}
+
+func TestCountersProjection(t *testing.T) {
+	store := newTestSQLite(t)
+	hip := &HIPInfo{HealthcareID: "HIP-0001", HealthcareLicense: "LIC-1", HealthcareName: "Adama Hospital", Email: "hip@example.com",
+		Availability: "24x7", TotalFacilities: 5, TotalMBBSDoc: 5, TotalWorker: 5, NoOfBeds: 5, Password: "hash", About: "general hospital"}
+	if _, err := store.SignUpAccount(hip); err != nil {
+		t.Fatal(err)
+	}
+	for _, healthID := range []string{"HID-1", "HID-2"} {
+		if err := store.Create_ClientProfile(testPatient(healthID, "Almaz")); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CreateClient_stats(healthID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	at := events.HIP{HealthcareID: "HIP-0001", HealthcareName: "Adama Hospital"}
+	err := store.Enqueue(
+		mustEvent(t, events.HIPLoggedIn{HIP: at, Email: "hip@example.com"}),
+		mustEvent(t, events.PatientProfileViewed{HIP: at, Patient: events.Patient{HealthID: "HID-1"}}),
+		mustEvent(t, events.PatientProfileViewed{HIP: at, Patient: events.Patient{HealthID: "HID-2"}}),
+		mustEvent(t, events.PatientRecordsViewed{HIP: at, HealthID: "HID-1"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.ProjectEvents(); err != nil || n != 4 {
+		t.Fatalf("projected %d, %v", n, err)
+	}
+	// nothing is applied twice
+	if n, err := store.ProjectEvents(); err != nil || n != 0 {
+		t.Fatalf("second run projected %d, %v", n, err)
+	}
+
+	check := func(when string) {
+		t.Helper()
+		pref, err := store.GetPreferance("HIP-0001")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pref.Profile_viewed != 2 || pref.Records_viewed != 1 || pref.Records_created != 0 {
+			t.Errorf("%s: HIP counters %+v", when, pref)
+		}
+		if quota, _ := store.GetTotalRequestCount("HIP-0001"); quota != loginQuota-1 {
+			t.Errorf("%s: quota %d after one login", when, quota)
+		}
+		var viewed, recordsViewed int
+		err = store.db.QueryRow(`SELECT profile_viewed, records_viewed FROM client_stats WHERE health_id = 'HID-1';`).Scan(&viewed, &recordsViewed)
+		if err != nil || viewed != 1 || recordsViewed != 1 {
+			t.Errorf("%s: HID-1 stats %d %d, %v", when, viewed, recordsViewed, err)
+		}
+	}
+	check("projected")
+
+	// a bug counted wrong, the log puts it right
+	if _, err := store.db.Exec(`UPDATE HealthCare_pref SET profile_viewed = 40, totalrequest_count = 0;`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.db.Exec(`UPDATE client_stats SET profile_viewed = 7;`); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.RebuildProjections(); err != nil || n != 4 {
+		t.Fatalf("rebuild replayed %d, %v", n, err)
+	}
+	check("rebuilt")
+	status, err := store.EventLogStatus()
+	if err != nil || status.Checkpoint != status.Head || status.Events != 4 {
+		t.Errorf("status = %+v, %v", status, err)
+	}
+}
+
+func TestRebuildKeepsCounterBaselines(t *testing.T) {
+	store := newTestSQLite(t)
+	hip := &HIPInfo{HealthcareID: "HIP-0001", HealthcareLicense: "LIC-1", HealthcareName: "Adama Hospital", Email: "hip@example.com",
+		Availability: "24x7", TotalFacilities: 5, TotalMBBSDoc: 5, TotalWorker: 5, NoOfBeds: 5, Password: "hash", About: "general hospital"}
+	if _, err := store.SignUpAccount(hip); err != nil {
+		t.Fatal(err)
+	}
+	for _, healthID := range []string{"HID-1", "HID-2"} {
+		if err := store.Create_ClientProfile(testPatient(healthID, "Almaz")); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CreateClient_stats(healthID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	at := events.HIP{HealthcareID: "HIP-0001", HealthcareName: "Adama Hospital"}
+
+	// counted before the log: by handlers, and one event the projection counted
+	if _, err := store.db.Exec(`UPDATE HealthCare_pref SET profile_viewed = 10, totalrequest_count = 60;`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.db.Exec(`UPDATE client_stats SET profile_viewed = 3 WHERE health_id = 'HID-2';`); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Enqueue(mustEvent(t, events.PatientProfileViewed{HIP: at, Patient: events.Patient{HealthID: "HID-1"}})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ProjectEvents(); err != nil {
+		t.Fatal(err)
+	}
+	// the database is upgraded, the baselines are taken now
+	if _, err := store.db.Exec(`DELETE FROM projection_checkpoints WHERE name = $1;`, countersBaseline); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// HID-2's counts move to HID-1 with the merge, and so do its events
+	if _, err := store.MergeClientProfiles("HIP-0001", "HID-1", "HID-2"); err != nil {
+		t.Fatal(err)
+	}
+	err := store.Enqueue(
+		mustEvent(t, events.HIPLoggedIn{HIP: at, Email: "hip@example.com"}),
+		mustEvent(t, events.PatientProfileViewed{HIP: at, Patient: events.Patient{HealthID: "HID-2"}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ProjectEvents(); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(when string) {
+		t.Helper()
+		pref, err := store.GetPreferance("HIP-0001")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pref.Profile_viewed != 12 {
+			t.Errorf("%s: HIP profile_viewed %d", when, pref.Profile_viewed)
+		}
+		if quota, _ := store.GetTotalRequestCount("HIP-0001"); quota != 59 {
+			t.Errorf("%s: quota %d, the login before the log was refunded", when, quota)
+		}
+		for healthID, want := range map[string]int{"HID-1": 5, "HID-2": 0} {
+			var viewed int
+			err := store.db.QueryRow(`SELECT profile_viewed FROM client_stats WHERE health_id = $1;`, healthID).Scan(&viewed)
+			if err != nil || viewed != want {
+				t.Errorf("%s: %s profile_viewed %d, want %d, %v", when, healthID, viewed, want, err)
+			}
+		}
+	}
+	check("projected")
+	if _, err := store.db.Exec(`UPDATE HealthCare_pref SET profile_viewed = 0, totalrequest_count = 100;`); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.RebuildProjections(); err != nil || n != 2 {
+		t.Fatalf("rebuild replayed %d, %v", n, err)
+	}
+	check("rebuilt")
+}
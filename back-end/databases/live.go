@@ -0,0 +1,154 @@
+package databases
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	rd "vaibhavyadav-dev/healthcareServer/redis"
+)
+
+// The live projection feeds the streams the HIPs keep open (GET /api/v2/stream). It follows the
+// event log like the others and publishes what a HIP's dashboard shows, the event's position in
+// the log is the update's ID. A stream that reconnects gets what it missed from the log itself
+// (liveUpdatesSince), so a publish that never arrived is only late.
+
+const (
+	liveProjection = "live"
+	// one replica publishes at a time
+	liveLockID = 720_410_048
+	// how far behind a stream may resume, one further back starts over
+	liveReplayWindow = 1000
+	// logins left from which every login warns the HIP
+	quotaWarningAt = 10
+	// the update a login with quotaWarningAt or fewer left becomes
+	quotaLowType = "hip.quota.low"
+)
+
+var ErrLiveReplayTooOld = errors.New("stream is too far behind to resume")
+
+// liveEventTypes are shown on the streams as they are, logins only as a quota warning
+var liveEventTypes = map[string]bool{
+	"appointment.status.changed": true,
+	"patient.record.created":     true,
+}
+
+// quotaLow is the data of a quota warning, remaining is the count when the update was made
+type quotaLow struct {
+	HealthcareID string `json:"healthcare_id"`
+	Remaining    int    `json:"remaining"`
+	Quota        int    `json:"quota"`
+}
+
+// liveUpdate is what the streams of the event's HIP show for e, ok is false when they show nothing
+func liveUpdate(tx *sql.Tx, e loggedEvent) (healthcareID string, update rd.LiveUpdate, ok bool, err error) {
+	if !liveEventTypes[e.Type] && e.Type != "hip.account.logged_in" {
+		return "", update, false, nil
+	}
+	var event struct {
+		ID         string    `json:"id"`
+		OccurredAt time.Time `json:"occurred_at"`
+		Data       struct {
+			HealthcareID string `json:"healthcare_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(e.Body, &event); err != nil {
+		return "", update, false, err
+	}
+	healthcareID = event.Data.HealthcareID
+	if liveEventTypes[e.Type] {
+		return healthcareID, rd.LiveUpdate{ID: e.Position, Type: e.Type, Data: e.Body}, true, nil
+	}
+
+	// the counters projection has taken this login off already, it runs first
+	var remaining int
+	err = tx.QueryRow(`SELECT totalrequest_count FROM HealthCare_pref WHERE healthcare_id = $1;`, healthcareID).Scan(&remaining)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && remaining > quotaWarningAt) {
+		return "", update, false, nil
+	}
+	if err != nil {
+		return "", update, false, err
+	}
+	// shaped like the other events
+	data, err := json.Marshal(map[string]interface{}{
+		"id":          event.ID,
+		"type":        quotaLowType,
+		"version":     1,
+		"occurred_at": event.OccurredAt,
+		"data":        quotaLow{HealthcareID: healthcareID, Remaining: remaining, Quota: loginQuota},
+	})
+	if err != nil {
+		return "", update, false, err
+	}
+	return healthcareID, rd.LiveUpdate{ID: e.Position, Type: quotaLowType, Data: data}, true, nil
+}
+
+// streamEvents publishes the live updates of the next batch. A failed publish rolls the batch back,
+// it is published again next round with the updates before it, streams skip IDs they have seen
+func streamEvents(tx *sql.Tx, publish func(healthcareID string, update rd.LiveUpdate) error) (int, error) {
+	// the streams open now have no use for the past, a new projection starts at the head of the log
+	var checkpoint int64
+	err := tx.QueryRow(`SELECT position FROM projection_checkpoints WHERE name = $1;`, liveProjection).Scan(&checkpoint)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = tx.Exec(`INSERT INTO projection_checkpoints (name, position)
+			SELECT $1, COALESCE(MAX(position), 0) FROM event_log;`, liveProjection)
+		return 0, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return projectEvents(tx, liveProjection, func(tx *sql.Tx, e loggedEvent) error {
+		healthcareID, update, ok, err := liveUpdate(tx, e)
+		if err != nil || !ok {
+			return err
+		}
+		return publish(healthcareID, update)
+	})
+}
+
+// liveUpdatesSince is what the streams of the HIP showed after the update with ID after, oldest first
+func liveUpdatesSince(db *sql.DB, healthcareID string, after int64) ([]rd.LiveUpdate, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var head int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(position), 0) FROM event_log;`).Scan(&head); err != nil {
+		return nil, err
+	}
+	if head-after > liveReplayWindow {
+		return nil, ErrLiveReplayTooOld
+	}
+	rows, err := tx.Query(`SELECT position, type, body FROM event_log WHERE position > $1 ORDER BY position;`, after)
+	if err != nil {
+		return nil, err
+	}
+	var missed []loggedEvent
+	for rows.Next() {
+		var e loggedEvent
+		if err := rows.Scan(&e.Position, &e.Type, &e.Body); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		missed = append(missed, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var updates []rd.LiveUpdate
+	for _, e := range missed {
+		hip, update, ok, err := liveUpdate(tx, e)
+		if err != nil {
+			return nil, err
+		}
+		if ok && hip == healthcareID {
+			updates = append(updates, update)
+		}
+	}
+	return updates, nil
+}
@@ -0,0 +1,115 @@
+package databases
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"vaibhavyadav-dev/healthcareServer/events"
+	rd "vaibhavyadav-dev/healthcareServer/redis"
+)
+
+func TestStreamEvents(t *testing.T) {
+	store := newTestSQLite(t)
+	for _, id := range []string{"HIP-0001", "HIP-0002"} {
+		hip := &HIPInfo{HealthcareID: id, HealthcareLicense: "LIC-" + id, HealthcareName: id + " Hospital", Email: id + "@example.com",
+			Availability: "24x7", TotalFacilities: 5, TotalMBBSDoc: 5, TotalWorker: 5, NoOfBeds: 5, Password: "hash", About: "general hospital"}
+		if _, err := store.SignUpAccount(hip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	published := map[string][]rd.LiveUpdate{}
+	publish := func(healthcareID string, update rd.LiveUpdate) error {
+		published[healthcareID] = append(published[healthcareID], update)
+		return nil
+	}
+	round := func() {
+		t.Helper()
+		if _, err := store.ProjectEvents(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.StreamEvents(publish); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// what was logged before the first round is not published
+	round()
+	if len(published) != 0 {
+		t.Fatalf("published the past: %v", published)
+	}
+
+	at := events.HIP{HealthcareID: "HIP-0001", HealthcareName: "HIP-0001 Hospital"}
+	err := store.Enqueue(
+		mustEvent(t, events.AppointmentStatusChanged{HealthcareID: "HIP-0001", AppointmentID: 7, HealthID: "HID-1", Status: "Confirmed"}),
+		mustEvent(t, events.PatientProfileViewed{HIP: at, Patient: events.Patient{HealthID: "HID-1"}}),
+		mustEvent(t, events.PatientRecordCreated{HIP: at, HealthID: "HID-1", Issue: "fever", Description: "two days", MedicalSeverity: "High"}),
+		mustEvent(t, events.AppointmentStatusChanged{HealthcareID: "HIP-0002", AppointmentID: 8, HealthID: "HID-2", Status: "Cancelled"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	round()
+	got := published["HIP-0001"]
+	if len(got) != 2 || got[0].Type != "appointment.status.changed" || got[1].Type != "patient.record.created" || got[0].ID >= got[1].ID {
+		t.Fatalf("HIP-0001 got %+v", got)
+	}
+	if len(published["HIP-0002"]) != 1 {
+		t.Errorf("HIP-0002 got %+v", published["HIP-0002"])
+	}
+
+	// a stream that saw the appointment gets the record again from the log, and nothing of the other HIP
+	missed, err := store.LiveUpdatesSince("HIP-0001", got[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missed) != 1 || missed[0].ID != got[1].ID || string(missed[0].Data) != string(got[1].Data) {
+		t.Errorf("missed %+v", missed)
+	}
+	if _, err := store.LiveUpdatesSince("HIP-0001", got[0].ID-liveReplayWindow-1); !errors.Is(err, ErrLiveReplayTooOld) {
+		t.Errorf("resumed from too far back: %v", err)
+	}
+
+	// logins warn once quotaWarningAt or fewer are left
+	if _, err := store.db.Exec(`UPDATE HealthCare_pref SET totalrequest_count = $1 WHERE healthcare_id = 'HIP-0001';`, quotaWarningAt+2); err != nil {
+		t.Fatal(err)
+	}
+	for _, remaining := range []int{quotaWarningAt + 1, quotaWarningAt} {
+		published = map[string][]rd.LiveUpdate{}
+		if err := store.Enqueue(mustEvent(t, events.HIPLoggedIn{HIP: at, Email: "HIP-0001@example.com"})); err != nil {
+			t.Fatal(err)
+		}
+		round()
+		warned := len(published["HIP-0001"]) == 1
+		if warned != (remaining <= quotaWarningAt) {
+			t.Fatalf("%d left, published %+v", remaining, published)
+		}
+	}
+	warning := published["HIP-0001"][0]
+	var body struct {
+		Type string   `json:"type"`
+		Data quotaLow `json:"data"`
+	}
+	if err := json.Unmarshal(warning.Data, &body); err != nil {
+		t.Fatal(err)
+	}
+	if warning.Type != quotaLowType || body.Type != quotaLowType || body.Data.Remaining != quotaWarningAt || body.Data.Quota != loginQuota {
+		t.Errorf("warning %+v %s", warning, warning.Data)
+	}
+
+	// redis is down, the batch is published again next round
+	down := func(string, rd.LiveUpdate) error { return errors.New("redis is down") }
+	if err := store.Enqueue(mustEvent(t, events.AppointmentStatusChanged{HealthcareID: "HIP-0001", AppointmentID: 7, HealthID: "HID-1", Status: "Completed"})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ProjectEvents(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.StreamEvents(down); err == nil {
+		t.Fatal("no error from a failed publish")
+	}
+	published = map[string][]rd.LiveUpdate{}
+	round()
+	if len(published["HIP-0001"]) != 1 {
+		t.Errorf("after redis came back %+v", published)
+	}
+}
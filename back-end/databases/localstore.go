@@ -0,0 +1,315 @@
+package databases
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"vaibhavyadav-dev/healthcareServer/notify"
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+	rd "vaibhavyadav-dev/healthcareServer/redis"
+	"vaibhavyadav-dev/healthcareServer/webhooks"
+)
+
+// LocalStore is CombinedStore without any services: SQLite for what postgres and mongo
+// keep, an in-process queue for rabbitmq and an in-memory limiter and cache for redis.
+type LocalStore struct {
+	sqlite *SQLiteStore
+	queue  *mq.Memory
+	cache  *rd.Memory
+	relay  *outboxRelay
+	// posts webhook deliveries, to whatever URLs were registered
+	webhooks *outboxRelay
+	// sends the notifications the projection queued, once EnableNotifications gave it channels
+	notifications *notifier
+	// logs the appointment reminders that are due, once EnableReminders gave it a schedule
+	reminders *reminders
+}
+
+// Localstore opens the SQLite file at path (":memory:" for a throwaway one),
+// limit and window configure the rate limiter like in Combinedstore
+func Localstore(path string, limit int64, window time.Duration) (*LocalStore, error) {
+	sqlite, err := ConnectToSQLite(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite: %s", err.Error())
+	}
+	if err := sqlite.Init(); err != nil {
+		return nil, fmt.Errorf("failed to init sqlite: %s", err.Error())
+	}
+	queue := mq.NewMemory()
+	cache := rd.NewMemory(limit, window)
+	store := &LocalStore{
+		sqlite: sqlite,
+		queue:  queue,
+		cache:  cache,
+		webhooks: startOutboxRelay("webhook deliveries", func() (int, error) {
+			return sqlite.DeliverWebhooks(webhooks.Post)
+		}),
+		notifications: startNotifier(sqlite.SendNotifications),
+	}
+	store.relay = startOutboxRelay("outbox relay", func() (int, error) {
+		sent, err := sqlite.RelayOutbox(queue.Publish)
+		projected, projectErr := sqlite.ProjectEvents()
+		if projected > 0 {
+			store.webhooks.notify()
+			store.notifications.loop.notify()
+		}
+		streamed, streamErr := sqlite.StreamEvents(cache.PublishLive)
+		return max(sent, projected, streamed), errors.Join(err, projectErr, streamErr)
+	})
+	store.reminders = startReminders(func(schedule ReminderSchedule) (int, error) {
+		reminded, err := sqlite.RemindAppointments(schedule)
+		if reminded > 0 {
+			store.relay.notify()
+		}
+		return reminded, err
+	})
+	return store, nil
+}
+
+// Queue gives in-process consumers (and tests) the published messages
+func (s *LocalStore) Queue() *mq.Memory {
+	return s.queue
+}
+
+func (s *LocalStore) SignUpAccount(hipinfo *HIPInfo, messages ...mq.Message) (int64, error) {
+	defer s.relay.notify()
+	return s.sqlite.SignUpAccount(hipinfo, messages...)
+}
+
+func (s *LocalStore) LoginUser(login *Login) (*HIPInfo, error) {
+	return s.sqlite.LoginUser(login)
+}
+
+func (s *LocalStore) ChangePreferance(id string, pref map[string]interface{}, messages ...mq.Message) error {
+	defer s.relay.notify()
+	return s.sqlite.ChangePreferance(id, pref, messages...)
+}
+
+func (s *LocalStore) GetPreferance(id string) (*Preferance, error) {
+	return s.sqlite.GetPreferance(id)
+}
+
+func (s *LocalStore) GetTotalRequestCount(healthcare_id string) (int, error) {
+	return s.sqlite.GetTotalRequestCount(healthcare_id)
+}
+
+func (s *LocalStore) CreateClient_stats(health_id string) error {
+	return s.sqlite.CreateClient_stats(health_id)
+}
+
+func (s *LocalStore) GetAppointments_postgres(health_id string, offset, limit int64) ([]*Appointments, error) {
+	return s.sqlite.GetAppointments(health_id, offset, limit)
+}
+
+func (s *LocalStore) SetAppointments_postgres(healthcare_id, health_id, status string, id int64) (int64, error) {
+	return s.sqlite.SetAppointments(healthcare_id, health_id, status, id)
+}
+
+func (s *LocalStore) BookAppointment(a *Appointments) (int64, error) {
+	return s.sqlite.BookAppointment(a)
+}
+
+func (s *LocalStore) CheckAppointment(healthcare_id, health_id string, id int64) error {
+	return s.sqlite.CheckAppointment(healthcare_id, health_id, id)
+}
+
+func (s *LocalStore) GetHealthcare_details_postgres(healthcare_id string) (*HIPInfo, error) {
+	return s.sqlite.GetHealthcare_details(healthcare_id)
+}
+
+func (s *LocalStore) Create_ClientProfile(client *PatientDetails, messages ...mq.Message) error {
+	defer s.relay.notify()
+	return s.sqlite.Create_ClientProfile(client, messages...)
+}
+
+func (s *LocalStore) Get_ClientProfile(health_id string) (*PatientDetails, error) {
+	return s.sqlite.Get_ClientProfile(health_id)
+}
+
+func (s *LocalStore) Update_clientProfile(health_id string, patch *ProfilePatch, ifVersion int, change ProfileChange) (*PatientDetails, error) {
+	defer s.relay.notify()
+	return s.sqlite.UpdateClientProfile(health_id, patch, ifVersion, change)
+}
+
+func (s *LocalStore) ListProfileVersions(health_id string) ([]*ProfileVersion, error) {
+	return s.sqlite.ListProfileVersions(health_id)
+}
+
+func (s *LocalStore) GetProfileVersion(health_id string, version int) (*PatientDetails, error) {
+	return s.sqlite.GetProfileVersion(health_id, version)
+}
+
+func (s *LocalStore) GetClientProfileAsOf(health_id string, at time.Time) (*PatientDetails, error) {
+	return s.sqlite.GetClientProfileAsOf(health_id, at)
+}
+
+func (s *LocalStore) GetAddressReviewQueue(healthcare_id string, limit int64) ([]*PatientDetails, error) {
+	return s.sqlite.GetAddressReviewQueue(healthcare_id, limit)
+}
+
+func (s *LocalStore) SearchClientProfiles(healthcare_id string, q *PatientSearch) ([]*PatientMatch, error) {
+	return s.sqlite.SearchClientProfiles(healthcare_id, q)
+}
+
+func (s *LocalStore) FindDuplicates(patient *PatientDetails) ([]*DuplicateCandidate, error) {
+	return s.sqlite.FindDuplicates(patient)
+}
+
+func (s *LocalStore) GetDuplicateQueue(healthcare_id, status string, limit int64) ([]*DuplicateCandidate, error) {
+	return s.sqlite.GetDuplicateQueue(healthcare_id, status, limit)
+}
+
+func (s *LocalStore) DismissDuplicate(healthcare_id string, id int64) error {
+	return s.sqlite.DismissDuplicate(healthcare_id, id)
+}
+
+// records are in the same database, so unlike CombinedStore a merge is a single transaction
+func (s *LocalStore) MergeClientProfiles(healthcare_id, surviving, merged string) (*PatientMerge, error) {
+	return s.sqlite.MergeClientProfiles(healthcare_id, surviving, merged)
+}
+
+func (s *LocalStore) RequestMerge(healthcare_id, surviving, merged string) (*MergeRequest, error) {
+	return s.sqlite.RequestMerge(healthcare_id, surviving, merged)
+}
+
+func (s *LocalStore) ListMergeRequests(healthcare_id, status string, limit int64) ([]*MergeRequest, error) {
+	return s.sqlite.ListMergeRequests(healthcare_id, status, limit)
+}
+
+func (s *LocalStore) ApproveMergeRequest(healthcare_id string, id int64) (*PatientMerge, error) {
+	return s.sqlite.ApproveMergeRequest(healthcare_id, id)
+}
+
+func (s *LocalStore) RejectMergeRequest(healthcare_id string, id int64) (*MergeRequest, error) {
+	return s.sqlite.RejectMergeRequest(healthcare_id, id)
+}
+
+func (s *LocalStore) UnmergeClientProfiles(healthcare_id string, mergeID int64) (*PatientMerge, error) {
+	return s.sqlite.UnmergeClientProfiles(healthcare_id, mergeID)
+}
+
+func (s *LocalStore) CreatepatientRecords(healthID string, records *PatientRecords) (*PatientRecords, error) {
+	return s.sqlite.CreatepatientRecords(healthID, records)
+}
+
+func (s *LocalStore) GetPatientRecords(healthID, severity string, limit int) (*[]PatientRecords, error) {
+	return s.sqlite.GetPatientRecords(healthID, severity, limit)
+}
+
+func (s *LocalStore) CreateWebhook(webhook *Webhook) error {
+	return s.sqlite.CreateWebhook(webhook)
+}
+
+func (s *LocalStore) ListWebhooks(healthcare_id string) ([]*Webhook, error) {
+	return s.sqlite.ListWebhooks(healthcare_id)
+}
+
+func (s *LocalStore) GetWebhook(healthcare_id string, id int64) (*Webhook, error) {
+	return s.sqlite.GetWebhook(healthcare_id, id)
+}
+
+func (s *LocalStore) UpdateWebhook(healthcare_id string, id int64, patch *WebhookPatch) (*Webhook, error) {
+	defer s.webhooks.notify()
+	return s.sqlite.UpdateWebhook(healthcare_id, id, patch)
+}
+
+func (s *LocalStore) DeleteWebhook(healthcare_id string, id int64) error {
+	return s.sqlite.DeleteWebhook(healthcare_id, id)
+}
+
+func (s *LocalStore) ListWebhookDeliveries(healthcare_id string, webhookID int64, status string, limit int64) ([]*WebhookDelivery, error) {
+	return s.sqlite.ListWebhookDeliveries(healthcare_id, webhookID, status, limit)
+}
+
+func (s *LocalStore) GetWebhookDelivery(healthcare_id string, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	return s.sqlite.GetWebhookDelivery(healthcare_id, webhookID, deliveryID)
+}
+
+func (s *LocalStore) RedeliverWebhook(healthcare_id string, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	defer s.webhooks.notify()
+	return s.sqlite.RedeliverWebhook(healthcare_id, webhookID, deliveryID)
+}
+
+// EnableReminders starts reminding patients of their appointments on schedule
+func (s *LocalStore) EnableReminders(schedule ReminderSchedule) {
+	s.reminders.enable(schedule)
+}
+
+// AnswerReminder confirms or cancels the appointment a patient's text message answers
+func (s *LocalStore) AnswerReminder(from, text string) (*ReminderReply, error) {
+	defer s.relay.notify()
+	return s.sqlite.AnswerReminder(from, text)
+}
+
+// EnableNotifications starts sending the queued notifications on channels, until then they wait
+func (s *LocalStore) EnableNotifications(channels notify.Channels) {
+	s.notifications.enable(channels)
+}
+
+func (s *LocalStore) GetNotificationPreferences(healthcare_id, recipientType, recipientID string) (*NotificationPreferences, error) {
+	return s.sqlite.GetNotificationPreferences(healthcare_id, recipientType, recipientID)
+}
+
+func (s *LocalStore) SetNotificationPreferences(healthcare_id string, prefs *NotificationPreferences) error {
+	return s.sqlite.SetNotificationPreferences(healthcare_id, prefs)
+}
+
+func (s *LocalStore) ListNotifications(healthcare_id, status string, limit int64) ([]*Notification, error) {
+	return s.sqlite.ListNotifications(healthcare_id, status, limit)
+}
+
+func (s *LocalStore) GetNotification(healthcare_id string, id int64) (*Notification, error) {
+	return s.sqlite.GetNotification(healthcare_id, id)
+}
+
+// queue, through the sqlite outbox like CombinedStore
+func (s *LocalStore) Enqueue(messages ...mq.Message) error {
+	defer s.relay.notify()
+	return s.sqlite.Enqueue(messages...)
+}
+
+// cache and rate limiter
+func (s *LocalStore) Set(key string, value interface{}) error {
+	return s.cache.Set(key, value)
+}
+
+func (s *LocalStore) Get(key string) (interface{}, error) {
+	return s.cache.Get(key)
+}
+
+func (s *LocalStore) ClaimIdempotencyKey(key string, req *rd.IdempotentRequest, ttl time.Duration) (*rd.IdempotentRequest, error) {
+	return s.cache.ClaimIdempotencyKey(key, req, ttl)
+}
+
+func (s *LocalStore) SaveIdempotentResponse(key string, req *rd.IdempotentRequest, ttl time.Duration) error {
+	return s.cache.SaveIdempotentResponse(key, req, ttl)
+}
+
+func (s *LocalStore) ReleaseIdempotencyKey(key string) error {
+	return s.cache.ReleaseIdempotencyKey(key)
+}
+
+func (s *LocalStore) SubscribeLive(healthcare_id string) (<-chan rd.LiveUpdate, func(), error) {
+	return s.cache.SubscribeLive(healthcare_id)
+}
+
+func (s *LocalStore) LiveUpdatesSince(healthcare_id string, after int64) ([]rd.LiveUpdate, error) {
+	return s.sqlite.LiveUpdatesSince(healthcare_id, after)
+}
+
+func (s *LocalStore) IsAllowed(healthcare_id string) (bool, error) {
+	return s.cache.IsAllowed(healthcare_id)
+}
+
+func (s *LocalStore) IsAllowed_leaky_bucket(healthcare_id string) (bool, error) {
+	return s.cache.IsAllowed_leaky_bucket(healthcare_id)
+}
+
+func (s *LocalStore) Close() error {
+	s.relay.Close()
+	s.webhooks.Close()
+	s.notifications.loop.Close()
+	s.reminders.loop.Close()
+	s.cache.Close()
+	return s.sqlite.Close()
+}
@@ -0,0 +1,105 @@
+package databases
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Merge requests: a HIP that registered only one of two duplicates asks the HIP that registered
+// the other to merge them. The request names that HIP as its approver, only the approver can
+// approve it and the merge is done in the same transaction, under the requester's name. Either
+// side can reject it. One pair has at most one pending request, asking again returns it.
+//
+// These take a transaction of either store, the stores lock the pair and do the merge themselves.
+
+const mergeRequestColumns = `id, surviving_health_id, merged_health_id, requested_by, approver, status, merge_id, created_at, decided_at`
+
+func scanMergeRequest(row interface{ Scan(...interface{}) error }) (*MergeRequest, error) {
+	request := &MergeRequest{}
+	var mergeID sql.NullInt64
+	err := row.Scan(&request.ID, &request.SurvivingHealthID, &request.MergedHealthID, &request.RequestedBy,
+		&request.Approver, &request.Status, &mergeID, &request.CreatedAt, &request.DecidedAt)
+	if err != nil {
+		return nil, err
+	}
+	if mergeID.Valid {
+		request.MergeID = &mergeID.Int64
+	}
+	return request, nil
+}
+
+// mergeRequestApprover is mergeApprover, a HIP that registered both patients approves its own request
+func mergeRequestApprover(owners map[string]string, healthcare_id string) (string, error) {
+	approver, err := mergeApprover(owners, healthcare_id)
+	if approver == "" {
+		approver = healthcare_id
+	}
+	return approver, err
+}
+
+// requestMerge records a pending merge request of a locked pair, or returns the one already pending
+func requestMerge(tx *sql.Tx, owners map[string]string, healthcare_id, surviving, merged string, now time.Time) (*MergeRequest, error) {
+	approver, err := mergeRequestApprover(owners, healthcare_id)
+	if err != nil {
+		return nil, err
+	}
+	request, err := scanMergeRequest(tx.QueryRow(`INSERT INTO merge_requests (surviving_health_id, merged_health_id, requested_by, approver, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING
+		RETURNING `+mergeRequestColumns+`;`, surviving, merged, healthcare_id, approver, now))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return request, err
+	}
+	return scanMergeRequest(tx.QueryRow(`SELECT `+mergeRequestColumns+` FROM merge_requests
+		WHERE status = 'pending' AND ((surviving_health_id = $1 AND merged_health_id = $2) OR (surviving_health_id = $2 AND merged_health_id = $1));`,
+		surviving, merged))
+}
+
+// decideMergeRequest approves or rejects a pending request, approving is up to its approver
+func decideMergeRequest(tx *sql.Tx, healthcare_id string, id int64, status string, now time.Time) (*MergeRequest, error) {
+	request, err := scanMergeRequest(tx.QueryRow(`UPDATE merge_requests SET status = $3, decided_at = $4
+		WHERE id = $1 AND status = 'pending' AND (approver = $2 OR ($3 = 'rejected' AND requested_by = $2))
+		RETURNING `+mergeRequestColumns+`;`, id, healthcare_id, status, now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMergeRequestNotFound
+	}
+	return request, err
+}
+
+// checkMergeRequest makes sure the patients of an approved request are still registered by the
+// two HIPs it was made between
+func checkMergeRequest(owners map[string]string, request *MergeRequest) error {
+	approver, err := mergeRequestApprover(owners, request.RequestedBy)
+	if err != nil || approver != request.Approver {
+		return fmt.Errorf("%w: the patients of merge request %d changed hands", ErrMergeForbidden, request.ID)
+	}
+	return nil
+}
+
+func setMergeRequestMerge(tx *sql.Tx, request *MergeRequest, mergeID int64) error {
+	request.MergeID = &mergeID
+	_, err := tx.Exec(`UPDATE merge_requests SET merge_id = $2 WHERE id = $1;`, request.ID, mergeID)
+	return err
+}
+
+// listMergeRequests lists the requests a HIP made or has to decide, newest first
+func listMergeRequests(db *sql.DB, healthcare_id, status string, limit int64) ([]*MergeRequest, error) {
+	rows, err := db.Query(`SELECT `+mergeRequestColumns+` FROM merge_requests
+		WHERE (requested_by = $1 OR approver = $1) AND ($2 = '' OR status = $2)
+		ORDER BY id DESC LIMIT $3;`, healthcare_id, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+	requests := []*MergeRequest{}
+	for rows.Next() {
+		request, err := scanMergeRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		requests = append(requests, request)
+	}
+	return requests, rows.Err()
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS appointments;
+DROP TABLE IF EXISTS HealthCare_pref;
+DROP TABLE IF EXISTS client_stats;
+DROP TABLE IF EXISTS client_profile;
+DROP TABLE IF EXISTS HIP_TABLE;
@@ -0,0 +1,90 @@
+-- tables as they were created before versioned migrations,
+-- IF NOT EXISTS lets existing databases adopt this version without changes
+
+CREATE TABLE IF NOT EXISTS HIP_TABLE (
+	Id SERIAL PRIMARY KEY,
+	healthcare_id TEXT NOT NULL UNIQUE,
+	healthcare_license TEXT NOT NULL UNIQUE,
+	healthcare_name TEXT NOT NULL UNIQUE,
+	email VARCHAR(100) NOT NULL UNIQUE,
+	availability VARCHAR(15) NOT NULL,
+	total_facilities INTEGER NOT NULL, 
+	total_mbbs_doc INTEGER NOT NULL,
+	total_worker INTEGER NOT NULL, 
+	no_of_beds INTEGER NOT NULL,
+	date_of_registration TIMESTAMP DEFAULT NOW(),
+	password TEXT NOT NULL,
+	about VARCHAR(300) NOT NULL,
+	country VARCHAR(30) NOT NULL,
+	state VARCHAR(20) NOT NULL,
+	city VARCHAR(30) NOT NULL,
+	landmark VARCHAR(45) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS client_profile (
+	id SERIAL PRIMARY KEY,
+	health_id VARCHAR(150) NOT NULL UNIQUE,
+	first_name VARCHAR(150) NOT NULL,
+	middle_name VARCHAR(150),
+	last_name VARCHAR(150) NOT NULL, 
+	sex VARCHAR(150) NOT NULL,
+	healthcare_id VARCHAR NOT NULL,
+	dob VARCHAR(150) NOT NULL, -- Increased length here
+	blood_group VARCHAR(150) NOT NULL,
+	bmi VARCHAR(150) NOT NULL,
+	marriage_status VARCHAR(150) NOT NULL,
+	weight VARCHAR(150) NOT NULL, 
+	email VARCHAR(150) NOT NULL,
+	mobile_number VARCHAR(150) NOT NULL,
+	aadhaar_number VARCHAR(150) NOT NULL,
+	primary_location VARCHAR(150) NOT NULL,
+	sibling VARCHAR(150) NOT NULL,
+	twin VARCHAR(150) NOT NULL,
+	father_name VARCHAR(150) NOT NULL,
+	mother_name VARCHAR(150) NOT NULL,
+	emergency_number VARCHAR(150) NOT NULL,
+	created_at TIMESTAMP DEFAULT NOW(),
+	updated_at TIMESTAMP DEFAULT NOW(),
+	country VARCHAR(150) NOT NULL,
+	state VARCHAR(150) NOT NULL,
+	city VARCHAR(150) NOT NULL,
+	landmark VARCHAR(150) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS client_stats (
+	health_id VARCHAR PRIMARY KEY UNIQUE,
+	account_status VARCHAR CHECK (account_status IN ('Trial', 'Testing', 'Beta', 'Premium')) NOT NULL DEFAULT 'Trial',
+	available_money VARCHAR NOT NULL DEFAULT '5000',
+	profile_viewed INTEGER NOT NULL DEFAULT 0,
+	profile_updated INTEGER NOT NULL DEFAULT 0,
+	records_viewed INTEGER NOT NULL DEFAULT 0,
+	records_created INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY (health_id) REFERENCES client_profile(health_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS HealthCare_pref (
+	Id SERIAL PRIMARY KEY,
+	healthcare_id TEXT NOT NULL,
+	scheduled_deletion VARCHAR(20),
+	profile_viewed INTEGER,
+	profile_updated INTEGER NOT NULL,
+	account_locked VARCHAR(15) NOT NULL,
+	records_created INTEGER NOT NULL,
+	records_viewed INTEGER NOT NULL,
+	totalrequest_count INTEGER NOT NULL,
+	appointmentFee INTEGER NOT NULL,
+	isAvailable VARCHAR(20) NOT NULL,
+	FOREIGN KEY (healthcare_id) REFERENCES HIP_TABLE(healthcare_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS appointments (
+	id SERIAL PRIMARY KEY,
+	health_id VARCHAR(150) NOT NULL,
+	healthcare_id VARCHAR(150) NOT NULL,
+	appointment_date TIMESTAMP NOT NULL,
+	status VARCHAR(50) NOT NULL DEFAULT 'pending',
+	notes TEXT,
+	created_at TIMESTAMP DEFAULT NOW(),
+	updated_at TIMESTAMP DEFAULT NOW(),
+	FOREIGN KEY (health_id) REFERENCES client_profile(health_id) ON DELETE CASCADE
+);
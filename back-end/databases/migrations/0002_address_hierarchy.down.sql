@@ -0,0 +1,16 @@
+DROP INDEX IF EXISTS client_profile_address_review_idx;
+
+ALTER TABLE client_profile
+	DROP COLUMN IF EXISTS region_code,
+	DROP COLUMN IF EXISTS zone_code,
+	DROP COLUMN IF EXISTS woreda_code,
+	DROP COLUMN IF EXISTS kebele_code,
+	DROP COLUMN IF EXISTS address_needs_review;
+
+-- state and city stay VARCHAR(80), shortening them could fail on existing rows
+ALTER TABLE HIP_TABLE
+	DROP COLUMN IF EXISTS region_code,
+	DROP COLUMN IF EXISTS zone_code,
+	DROP COLUMN IF EXISTS woreda_code,
+	DROP COLUMN IF EXISTS kebele_code,
+	DROP COLUMN IF EXISTS address_needs_review;
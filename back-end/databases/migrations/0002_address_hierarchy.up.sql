@@ -0,0 +1,22 @@
+-- administrative address hierarchy (region -> zone -> woreda -> kebele)
+-- free-text addresses written before this are kept but flagged for cleanup
+ALTER TABLE HIP_TABLE
+	ALTER COLUMN state TYPE VARCHAR(80),
+	ALTER COLUMN city TYPE VARCHAR(80),
+	ADD COLUMN IF NOT EXISTS region_code VARCHAR(20),
+	ADD COLUMN IF NOT EXISTS zone_code VARCHAR(20),
+	ADD COLUMN IF NOT EXISTS woreda_code VARCHAR(20),
+	ADD COLUMN IF NOT EXISTS kebele_code VARCHAR(20),
+	ADD COLUMN IF NOT EXISTS address_needs_review BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE client_profile
+	ADD COLUMN IF NOT EXISTS region_code VARCHAR(20),
+	ADD COLUMN IF NOT EXISTS zone_code VARCHAR(20),
+	ADD COLUMN IF NOT EXISTS woreda_code VARCHAR(20),
+	ADD COLUMN IF NOT EXISTS kebele_code VARCHAR(20),
+	ADD COLUMN IF NOT EXISTS address_needs_review BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE HIP_TABLE SET address_needs_review = TRUE WHERE region_code IS NULL AND NOT address_needs_review;
+UPDATE client_profile SET address_needs_review = TRUE WHERE region_code IS NULL AND NOT address_needs_review;
+
+CREATE INDEX IF NOT EXISTS client_profile_address_review_idx ON client_profile (healthcare_id) WHERE address_needs_review;
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS client_profile_first_name_key_idx;
+DROP INDEX IF EXISTS client_profile_last_name_key_idx;
+DROP INDEX IF EXISTS client_profile_father_name_key_idx;
+
+ALTER TABLE client_profile
+	DROP COLUMN IF EXISTS first_name_key,
+	DROP COLUMN IF EXISTS middle_name_key,
+	DROP COLUMN IF EXISTS last_name_key,
+	DROP COLUMN IF EXISTS father_name_key;
@@ -0,0 +1,11 @@
+-- phonetic keys of the names (ethiopic.Key) so Ge'ez and Latin spellings can be searched together,
+-- they are computed in Go, PostgresStore.Init fills them for existing rows
+ALTER TABLE client_profile
+	ADD COLUMN IF NOT EXISTS first_name_key VARCHAR(150),
+	ADD COLUMN IF NOT EXISTS middle_name_key VARCHAR(150),
+	ADD COLUMN IF NOT EXISTS last_name_key VARCHAR(150),
+	ADD COLUMN IF NOT EXISTS father_name_key VARCHAR(150);
+
+CREATE INDEX IF NOT EXISTS client_profile_first_name_key_idx ON client_profile (healthcare_id, first_name_key varchar_pattern_ops);
+CREATE INDEX IF NOT EXISTS client_profile_last_name_key_idx ON client_profile (healthcare_id, last_name_key varchar_pattern_ops);
+CREATE INDEX IF NOT EXISTS client_profile_father_name_key_idx ON client_profile (healthcare_id, father_name_key varchar_pattern_ops);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS patient_merges;
+DROP TABLE IF EXISTS duplicate_candidates;
+DROP INDEX IF EXISTS client_profile_aadhaar_number_idx;
+ALTER TABLE client_profile DROP COLUMN IF EXISTS merged_into;
@@ -0,0 +1,32 @@
+-- duplicate detection and merges, a merged profile points at the one that survived
+ALTER TABLE client_profile ADD COLUMN IF NOT EXISTS merged_into VARCHAR(150);
+CREATE INDEX IF NOT EXISTS client_profile_aadhaar_number_idx ON client_profile (aadhaar_number);
+
+CREATE TABLE IF NOT EXISTS duplicate_candidates (
+	id SERIAL PRIMARY KEY,
+	health_id VARCHAR(150) NOT NULL,
+	candidate_health_id VARCHAR(150) NOT NULL,
+	score NUMERIC(4, 3) NOT NULL,
+	fields JSONB NOT NULL DEFAULT '{}',
+	status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'merged', 'dismissed')),
+	created_at TIMESTAMP DEFAULT NOW(),
+	reviewed_by TEXT,
+	reviewed_at TIMESTAMP,
+	FOREIGN KEY (health_id) REFERENCES client_profile(health_id) ON DELETE CASCADE,
+	FOREIGN KEY (candidate_health_id) REFERENCES client_profile(health_id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS duplicate_candidates_pair_idx ON duplicate_candidates (LEAST(health_id, candidate_health_id), GREATEST(health_id, candidate_health_id));
+
+CREATE TABLE IF NOT EXISTS patient_merges (
+	id SERIAL PRIMARY KEY,
+	surviving_health_id VARCHAR(150) NOT NULL,
+	merged_health_id VARCHAR(150) NOT NULL,
+	merged_by TEXT NOT NULL,
+	merged_at TIMESTAMP DEFAULT NOW(),
+	unmerged_by TEXT,
+	unmerged_at TIMESTAMP,
+	moved JSONB NOT NULL DEFAULT '{}',
+	FOREIGN KEY (surviving_health_id) REFERENCES client_profile(health_id) ON DELETE CASCADE,
+	FOREIGN KEY (merged_health_id) REFERENCES client_profile(health_id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS client_profile_versions;
+DROP FUNCTION IF EXISTS client_profile_versions_append_only();
+ALTER TABLE client_profile DROP COLUMN IF EXISTS version;
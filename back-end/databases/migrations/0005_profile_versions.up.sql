@@ -0,0 +1,25 @@
+-- profile history, one row per replaced version, append only
+ALTER TABLE client_profile ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS client_profile_versions (
+	id SERIAL PRIMARY KEY,
+	health_id VARCHAR(150) NOT NULL,
+	version INTEGER NOT NULL,
+	snapshot JSONB NOT NULL,
+	changed_fields JSONB NOT NULL DEFAULT '[]',
+	changed_by TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	valid_from TIMESTAMP NOT NULL,
+	changed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	UNIQUE (health_id, version)
+);
+
+CREATE OR REPLACE FUNCTION client_profile_versions_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'client_profile_versions is append only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS client_profile_versions_append_only ON client_profile_versions;
+CREATE TRIGGER client_profile_versions_append_only BEFORE UPDATE OR DELETE ON client_profile_versions
+	FOR EACH ROW EXECUTE FUNCTION client_profile_versions_append_only();
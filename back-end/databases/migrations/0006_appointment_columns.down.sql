@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS appointments_health_id_idx;
+DROP INDEX IF EXISTS appointments_healthcare_id_idx;
+
+ALTER TABLE appointments
+	ALTER COLUMN note DROP NOT NULL,
+	ALTER COLUMN note DROP DEFAULT;
+ALTER TABLE appointments RENAME COLUMN note TO notes;
+
+ALTER TABLE appointments
+	DROP COLUMN IF EXISTS appointment_time,
+	DROP COLUMN IF EXISTS department,
+	DROP COLUMN IF EXISTS fullname,
+	DROP COLUMN IF EXISTS healthcare_name;
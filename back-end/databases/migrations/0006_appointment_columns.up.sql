@@ -0,0 +1,15 @@
+-- GetAppointments reads the fields of Appointments, columns the table never had
+ALTER TABLE appointments
+	ADD COLUMN IF NOT EXISTS appointment_time VARCHAR(20) NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS department VARCHAR(100) NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS fullname VARCHAR(150) NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS healthcare_name VARCHAR(150) NOT NULL DEFAULT '';
+
+ALTER TABLE appointments RENAME COLUMN notes TO note;
+UPDATE appointments SET note = '' WHERE note IS NULL;
+ALTER TABLE appointments
+	ALTER COLUMN note SET DEFAULT '',
+	ALTER COLUMN note SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS appointments_healthcare_id_idx ON appointments (healthcare_id);
+CREATE INDEX IF NOT EXISTS appointments_health_id_idx ON appointments (health_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS mongo_import_issues;
+DROP TABLE IF EXISTS mongo_imported;
+DROP TABLE IF EXISTS mongo_import_progress;
@@ -0,0 +1,35 @@
+-- bookkeeping of the one-off import of the legacy mongo collections (see databases/mongoimport.go)
+
+-- where each collection's import got to, last_id is the extended JSON of the last _id committed
+CREATE TABLE IF NOT EXISTS mongo_import_progress (
+	collection VARCHAR(50) PRIMARY KEY,
+	last_id JSONB,
+	imported INTEGER NOT NULL DEFAULT 0,
+	skipped INTEGER NOT NULL DEFAULT 0,
+	conflicts INTEGER NOT NULL DEFAULT 0,
+	rejected INTEGER NOT NULL DEFAULT 0,
+	started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	finished_at TIMESTAMP
+);
+
+-- every mongo document that made it into postgres, so nothing is imported twice
+CREATE TABLE IF NOT EXISTS mongo_imported (
+	collection VARCHAR(50) NOT NULL,
+	mongo_id TEXT NOT NULL,
+	target_key TEXT NOT NULL,
+	imported_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (collection, mongo_id)
+);
+
+-- documents that were not imported, the latest reason per document
+CREATE TABLE IF NOT EXISTS mongo_import_issues (
+	id SERIAL PRIMARY KEY,
+	collection VARCHAR(50) NOT NULL,
+	mongo_id TEXT NOT NULL,
+	kind VARCHAR(20) NOT NULL CHECK (kind IN ('conflict', 'rejected')),
+	reason TEXT NOT NULL,
+	document JSONB NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	UNIQUE (collection, mongo_id)
+);
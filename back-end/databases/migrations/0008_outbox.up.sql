@@ -0,0 +1,14 @@
+-- queue messages committed in the same transaction as the change they announce,
+-- the relay (databases/outbox.go) publishes them to rabbitmq afterwards
+CREATE TABLE IF NOT EXISTS outbox (
+	id BIGSERIAL PRIMARY KEY,
+	queue VARCHAR(100) NOT NULL,
+	body JSONB NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	last_error TEXT,
+	published_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id) WHERE published_at IS NULL;
@@ -0,0 +1,2 @@
+-- events go to a topic exchange now, the outbox keeps their routing key instead of a queue name
+ALTER TABLE outbox RENAME COLUMN queue TO routing_key;
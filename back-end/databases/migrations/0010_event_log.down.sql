@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS projection_checkpoints;
+DROP TABLE IF EXISTS event_log;
+DROP FUNCTION IF EXISTS event_log_append_only();
@@ -0,0 +1,34 @@
+-- every event the server publishes, written with the outbox row (databases/eventlog.go).
+-- Nothing updates or deletes a row, projections are rebuilt from here
+CREATE TABLE IF NOT EXISTS event_log (
+	position BIGSERIAL PRIMARY KEY,
+	event_id UUID NOT NULL UNIQUE,
+	type VARCHAR(100) NOT NULL,
+	version INTEGER NOT NULL,
+	occurred_at TIMESTAMPTZ NOT NULL,
+	body JSONB NOT NULL,
+	recorded_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE OR REPLACE FUNCTION event_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'event_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER event_log_append_only BEFORE UPDATE OR DELETE ON event_log
+	FOR EACH ROW EXECUTE FUNCTION event_log_append_only();
+
+-- the last event_log position each projection applied
+CREATE TABLE IF NOT EXISTS projection_checkpoints (
+	name VARCHAR(100) PRIMARY KEY,
+	position BIGINT NOT NULL
+);
+
+-- events still in the outbox from before the log existed
+INSERT INTO event_log (event_id, type, version, occurred_at, body, recorded_at)
+SELECT (body->>'id')::uuid, body->>'type', (body->>'version')::int, (body->>'occurred_at')::timestamptz, body, created_at
+FROM outbox
+WHERE body ? 'id' AND body ? 'type' AND body ? 'version' AND body ? 'occurred_at'
+ORDER BY id
+ON CONFLICT (event_id) DO NOTHING;
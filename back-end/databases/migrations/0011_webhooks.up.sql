@@ -0,0 +1,46 @@
+-- endpoints HIPs registered for their events, see databases/webhooks.go
+CREATE TABLE IF NOT EXISTS webhooks (
+	id BIGSERIAL PRIMARY KEY,
+	healthcare_id TEXT NOT NULL,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	-- topic patterns, e.g. ["appointment.status.changed", "patient.profile.*"]
+	events JSONB NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	FOREIGN KEY (healthcare_id) REFERENCES HIP_TABLE(healthcare_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_healthcare_id_idx ON webhooks (healthcare_id);
+
+-- one logged event to one webhook, the body is the event_log row's
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	webhook_id BIGINT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	position BIGINT NOT NULL REFERENCES event_log(position),
+	status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL,
+	last_status_code INTEGER,
+	last_error TEXT,
+	delivered_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL,
+	UNIQUE (webhook_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+-- every request made for a delivery
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+	id BIGSERIAL PRIMARY KEY,
+	delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+	status_code INTEGER,
+	error TEXT,
+	response TEXT NOT NULL DEFAULT '',
+	duration_ms INTEGER NOT NULL,
+	attempted_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_attempts_delivery_id_idx ON webhook_attempts (delivery_id);
@@ -0,0 +1,44 @@
+-- how a HIP or a patient wants to be notified, see databases/notifications.go
+CREATE TABLE IF NOT EXISTS notification_preferences (
+	recipient_type VARCHAR(10) NOT NULL CHECK (recipient_type IN ('hip', 'patient')),
+	-- the healthcare_id of a HIP, the health_id of a patient
+	recipient_id TEXT NOT NULL,
+	language VARCHAR(10) NOT NULL,
+	email BOOLEAN NOT NULL,
+	sms BOOLEAN NOT NULL,
+	-- notification type patterns that are not sent, e.g. ["hip.account.logged_in"]
+	muted JSONB NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (recipient_type, recipient_id)
+);
+
+-- one notification of a logged event to one address, rendered when it was queued
+CREATE TABLE IF NOT EXISTS notifications (
+	id BIGSERIAL PRIMARY KEY,
+	position BIGINT NOT NULL REFERENCES event_log(position),
+	type TEXT NOT NULL,
+	-- the HIP the notification is about, it sees it in its list
+	healthcare_id TEXT NOT NULL,
+	recipient_type VARCHAR(10) NOT NULL CHECK (recipient_type IN ('hip', 'patient')),
+	recipient_id TEXT NOT NULL,
+	channel VARCHAR(10) NOT NULL CHECK (channel IN ('email', 'sms')),
+	address TEXT NOT NULL,
+	language VARCHAR(10) NOT NULL,
+	subject TEXT NOT NULL DEFAULT '',
+	body TEXT NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed')),
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL,
+	last_error TEXT,
+	sent_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL,
+	UNIQUE (position, type, recipient_type, recipient_id, channel)
+);
+
+CREATE INDEX IF NOT EXISTS notifications_healthcare_id_idx ON notifications (healthcare_id, id);
+CREATE INDEX IF NOT EXISTS notifications_due_idx ON notifications (next_attempt_at) WHERE status = 'pending';
+
+-- the events logged before notifications existed are not notified
+INSERT INTO projection_checkpoints (name, position)
+SELECT 'notifications', COALESCE(MAX(position), 0) FROM event_log
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,19 @@
+-- one reminder of an appointment, see databases/reminders.go
+CREATE TABLE IF NOT EXISTS appointment_reminders (
+	id BIGSERIAL PRIMARY KEY,
+	appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+	healthcare_id VARCHAR(150) NOT NULL,
+	health_id VARCHAR(150) NOT NULL,
+	-- how long before the appointment it was due
+	before_minutes INTEGER NOT NULL,
+	reply_code VARCHAR(10) NOT NULL,
+	-- the last digits of the patient's mobile number, answers are matched on them
+	phone VARCHAR(20) NOT NULL,
+	-- confirmed or cancelled once the patient answered
+	reply VARCHAR(20) CHECK (reply IN ('confirmed', 'cancelled')),
+	replied_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL,
+	UNIQUE (appointment_id, before_minutes)
+);
+
+CREATE INDEX IF NOT EXISTS appointment_reminders_open_idx ON appointment_reminders (phone, id) WHERE reply IS NULL;
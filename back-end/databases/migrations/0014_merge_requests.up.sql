@@ -0,0 +1,19 @@
+-- merges of patients registered by two HIPs wait for the other HIP, see databases/mergerequests.go
+CREATE TABLE IF NOT EXISTS merge_requests (
+	id BIGSERIAL PRIMARY KEY,
+	surviving_health_id VARCHAR(150) NOT NULL REFERENCES client_profile(health_id) ON DELETE CASCADE,
+	merged_health_id VARCHAR(150) NOT NULL REFERENCES client_profile(health_id) ON DELETE CASCADE,
+	requested_by VARCHAR(150) NOT NULL,
+	-- the HIP that registered the other patient
+	approver VARCHAR(150) NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+	merge_id INTEGER REFERENCES patient_merges(id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	decided_at TIMESTAMPTZ
+);
+
+-- one pending request per pair, whichever way round
+CREATE UNIQUE INDEX IF NOT EXISTS merge_requests_pending_idx ON merge_requests
+	(LEAST(surviving_health_id, merged_health_id), GREATEST(surviving_health_id, merged_health_id)) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS merge_requests_approver_idx ON merge_requests (approver, id);
+CREATE INDEX IF NOT EXISTS merge_requests_requested_by_idx ON merge_requests (requested_by, id);
@@ -0,0 +1,3 @@
+DELETE FROM projection_checkpoints WHERE name = 'counters_baseline';
+DROP TABLE IF EXISTS patient_counter_baselines;
+DROP TABLE IF EXISTS hip_counter_baselines;
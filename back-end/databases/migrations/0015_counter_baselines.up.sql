@@ -0,0 +1,36 @@
+-- the counters as they are now, before the event log counts anything more. Counts from before the
+-- log existed are in here and nowhere else, a rebuild starts from them (databases/eventlog.go)
+CREATE TABLE IF NOT EXISTS hip_counter_baselines (
+	healthcare_id TEXT PRIMARY KEY,
+	profile_viewed INTEGER NOT NULL,
+	profile_updated INTEGER NOT NULL,
+	records_created INTEGER NOT NULL,
+	records_viewed INTEGER NOT NULL,
+	totalrequest_count INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS patient_counter_baselines (
+	health_id VARCHAR(150) PRIMARY KEY,
+	profile_viewed INTEGER NOT NULL,
+	profile_updated INTEGER NOT NULL,
+	records_viewed INTEGER NOT NULL,
+	records_created INTEGER NOT NULL
+);
+
+-- the events 0010 copied from the outbox were counted by the handlers that published them
+INSERT INTO projection_checkpoints (name, position)
+SELECT 'counters', COALESCE(MAX(position), 0) FROM event_log
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO hip_counter_baselines (healthcare_id, profile_viewed, profile_updated, records_created, records_viewed, totalrequest_count)
+SELECT healthcare_id, COALESCE(profile_viewed, 0), profile_updated, records_created, records_viewed, totalrequest_count
+FROM HealthCare_pref
+ON CONFLICT (healthcare_id) DO NOTHING;
+
+INSERT INTO patient_counter_baselines (health_id, profile_viewed, profile_updated, records_viewed, records_created)
+SELECT health_id, profile_viewed, profile_updated, records_viewed, records_created
+FROM client_stats;
+
+-- the last event the baselines include
+INSERT INTO projection_checkpoints (name, position)
+SELECT 'counters_baseline', position FROM projection_checkpoints WHERE name = 'counters';
@@ -0,0 +1,9 @@
+ALTER TABLE event_log ALTER COLUMN recorded_at TYPE TIMESTAMP;
+
+ALTER TABLE client_profile_versions
+	ALTER COLUMN valid_from TYPE TIMESTAMP,
+	ALTER COLUMN changed_at TYPE TIMESTAMP;
+
+ALTER TABLE client_profile
+	ALTER COLUMN created_at TYPE TIMESTAMP,
+	ALTER COLUMN updated_at TYPE TIMESTAMP;
@@ -0,0 +1,13 @@
+-- profile history and the event log were stored without a time zone, so as-of lookups compared
+-- instants with wall clock times. client_profile goes too, a version is valid from its updated_at.
+-- The stored values were written by NOW() in the server's time zone, which is the zone postgres
+-- reads a TIMESTAMP in when it becomes a TIMESTAMPTZ.
+ALTER TABLE client_profile
+	ALTER COLUMN created_at TYPE TIMESTAMPTZ,
+	ALTER COLUMN updated_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE client_profile_versions
+	ALTER COLUMN valid_from TYPE TIMESTAMPTZ,
+	ALTER COLUMN changed_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE event_log ALTER COLUMN recorded_at TYPE TIMESTAMPTZ;
@@ -2,6 +2,7 @@ package databases
 
 import (
 	"fmt"
+	"reflect"
 	"regexp"
 	"strings"
 	"time"
@@ -17,6 +18,14 @@ type Address struct {
 	State    string `json:"state" validate:"required,max=80"`    // Required, max 20 characters
 	City     string `json:"city" validate:"required,max=80"`     // Required, max 30 characters
 	Landmark string `json:"landmark" validate:"required,max=85"` // Required, max 45 characters
+
+	// administrative hierarchy codes (see adminareas.go), country/state/city are derived from them
+	Region string `bson:"region,omitempty" json:"region,omitempty" validate:"max=20"`
+	Zone   string `bson:"zone,omitempty" json:"zone,omitempty" validate:"max=20"`
+	Woreda string `bson:"woreda,omitempty" json:"woreda,omitempty" validate:"max=20"`
+	Kebele string `bson:"kebele,omitempty" json:"kebele,omitempty" validate:"max=20"`
+	// legacy free-text address written before the hierarchy existed, needs cleanup
+	NeedsReview bool `bson:"-" json:"needs_review,omitempty"`
 }
 
 type HIPInfo struct {
@@ -68,6 +77,10 @@ func SignUpAccount(hip *HIPInfo) (*HIPInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	address := hip.Address
+	if err := ValidateAddress(&address); err != nil {
+		return nil, err
+	}
 	uniquehealthID := uuid.New().String()[:20]
 	return &HIPInfo{
 		HealthcareID:       "HCID" + uniquehealthID,
@@ -82,12 +95,7 @@ func SignUpAccount(hip *HIPInfo) (*HIPInfo, error) {
 		DateOfRegistration: hip.DateOfRegistration,
 		About:              hip.About,
 		Password:           string(encpw),
-		Address: Address{
-			Country:  hip.Address.Country,
-			State:    hip.Address.State,
-			City:     hip.Address.City,
-			Landmark: hip.Address.Landmark,
-		},
+		Address:            address,
 	}, nil
 }
 
@@ -108,7 +116,7 @@ type UpdateAppointment struct {
 	ID           int64  `bson:"_id, omitempty" json:"id"`
 	HealthID     string `json:"health_id" bson:"health_id" validate:"required,min=10,max=30"`
 	HealthcareID string `json:"healthcare_id" bson:"healthcare_id" validate:"required,min=10,max=30"`
-	Status       string `json:"status" bson:"status" validate:"required"`
+	Status       string `json:"status" bson:"status" validate:"required,oneof=Pending Confirmed Rejected 'Not Available' Cancelled"`
 }
 
 type PatientDetails struct {
@@ -135,6 +143,7 @@ type PatientDetails struct {
 	EmergencyNumber string    `bson:"emergencynumber" json:"emergencynumber" validate:"required,min=1,max=15"`
 	CreatedAt       time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt       time.Time `bson:"updated_at" json:"updated_at"`
+	Version         int       `bson:"-" json:"version"`
 
 	Address Address `bson:"address" json:"address" validate:"required"`
 }
@@ -151,10 +160,80 @@ func validateAadhaar(fl validator.FieldLevel) bool {
 	return matched
 }
 
-func Create_clientProfile(HealthcareID string, patient *PatientDetails) (*PatientDetails, error) {
+// FieldError is one broken validation rule, Field is the JSON path of the field (e.g. address.city)
+type FieldError struct {
+	Field   string
+	Rule    string
+	Param   string
+	Numeric bool
+	Value   interface{}
+}
+
+// ValidationError carries every broken rule so the API can report (and translate) them per field
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var errorMessages []string
+	for _, field := range e.Fields {
+		errorMessages = append(errorMessages, fmt.Sprintf(
+			"Field: %s, Error: %s, Value: %v",
+			field.Field,
+			field.Rule,
+			field.Value,
+		))
+	}
+	return fmt.Sprintf("validation failed: %s", strings.Join(errorMessages, "; "))
+}
+
+// validator reporting fields by their json names
+func newValidator() *validator.Validate {
 	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
 	validate.RegisterValidation("phone", validatePhoneNumber)
 	validate.RegisterValidation("aadhaar", validateAadhaar)
+	return validate
+}
+
+func toValidationError(err error) error {
+	if _, ok := err.(*validator.InvalidValidationError); ok {
+		return fmt.Errorf("validation error: %v", err)
+	}
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return err
+	}
+	verr := &ValidationError{}
+	for _, err := range validationErrors {
+		// namespace is Struct.field.subfield, drop the struct name
+		field := err.Namespace()
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+		kind := err.Kind()
+		verr.Fields = append(verr.Fields, FieldError{
+			Field:   field,
+			Rule:    err.Tag(),
+			Param:   err.Param(),
+			Numeric: kind >= reflect.Int && kind <= reflect.Float64,
+			Value:   err.Value(),
+		})
+	}
+	return verr
+}
+
+func Create_clientProfile(HealthcareID string, patient *PatientDetails) (*PatientDetails, error) {
+	validate := newValidator()
 
 	uniquehealthID := uuid.New().String()[:20]
 	newPatient := &PatientDetails{
@@ -185,24 +264,19 @@ func Create_clientProfile(HealthcareID string, patient *PatientDetails) (*Patien
 			State:    strings.TrimSpace(patient.Address.State),
 			City:     strings.TrimSpace(patient.Address.City),
 			Landmark: strings.TrimSpace(patient.Address.Landmark),
+			Region:   patient.Address.Region,
+			Zone:     patient.Address.Zone,
+			Woreda:   patient.Address.Woreda,
+			Kebele:   patient.Address.Kebele,
 		},
 	}
 
-	if err := validate.Struct(newPatient); err != nil {
-		if _, ok := err.(*validator.InvalidValidationError); ok {
-			return nil, fmt.Errorf("validation error: %v", err)
-		}
+	if err := ValidateAddress(&newPatient.Address); err != nil {
+		return nil, err
+	}
 
-		var errorMessages []string
-		for _, err := range err.(validator.ValidationErrors) {
-			errorMessages = append(errorMessages, fmt.Sprintf(
-				"Field: %s, Error: %s, Value: %v",
-				err.Field(),
-				err.Tag(),
-				err.Value(),
-			))
-		}
-		return nil, fmt.Errorf("validation failed: %s", strings.Join(errorMessages, "; "))
+	if err := validate.Struct(newPatient); err != nil {
+		return nil, toValidationError(err)
 	}
 	return newPatient, nil
 }
@@ -219,7 +293,7 @@ type PatientRecords struct {
 }
 
 func CreatePatientRecords(healthcare_id string, patientRecords *PatientRecords) (*PatientRecords, error) {
-	validate := validator.New()
+	validate := newValidator()
 
 	new_records := &PatientRecords{
 		Issue:           strings.TrimSpace(patientRecords.Issue),
@@ -231,20 +305,7 @@ func CreatePatientRecords(healthcare_id string, patientRecords *PatientRecords)
 	}
 
 	if err := validate.Struct(new_records); err != nil {
-		if _, ok := err.(*validator.InvalidValidationError); ok {
-			return nil, fmt.Errorf("validation error: %v", err)
-		}
-
-		var errorMessages []string
-		for _, err := range err.(validator.ValidationErrors) {
-			errorMessages = append(errorMessages, fmt.Sprintf(
-				"Field: %s, Error: %s, Value: %v",
-				err.Field(),
-				err.Tag(),
-				err.Value(),
-			))
-		}
-		return nil, fmt.Errorf("validation failed: %s", strings.Join(errorMessages, "; "))
+		return nil, toValidationError(err)
 	}
 	return new_records, nil
 }
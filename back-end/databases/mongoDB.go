@@ -222,6 +222,60 @@ func (m *MongoStore) GetPatientRecords(health_id, severity string, list int) (*[
 	return &patientRecords, nil
 }
 
+// MovePatientRecords re-points every record of one patient to another, returns the ids it moved
+func (m *MongoStore) MovePatientRecords(fromHealthID, toHealthID string) ([]string, error) {
+	coll := m.db.Database(m.database).Collection("patient_records")
+	filter := bson.D{{Key: "health_id", Value: fromHealthID}}
+	cursor, err := coll.Find(context.TODO(), filter, options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("error finding patient records: %w", err)
+	}
+	var found []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err = cursor.All(context.TODO(), &found); err != nil {
+		return nil, fmt.Errorf("error decoding patient records: %w", err)
+	}
+	ids := []string{}
+	objectIDs := []primitive.ObjectID{}
+	for _, record := range found {
+		ids = append(ids, record.ID.Hex())
+		objectIDs = append(objectIDs, record.ID)
+	}
+	if len(objectIDs) == 0 {
+		return ids, nil
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "health_id", Value: toHealthID}}}}
+	_, err = coll.UpdateMany(context.TODO(), bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: objectIDs}}}}, update)
+	if err != nil {
+		// some may have moved, the caller hands all of them back
+		return ids, fmt.Errorf("error moving patient records: %w", err)
+	}
+	return ids, nil
+}
+
+// RestorePatientRecords hands the given records back to healthID, used by unmerge
+func (m *MongoStore) RestorePatientRecords(ids []string, healthID string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	objectIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return fmt.Errorf("invalid record id %s: %w", id, err)
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+	coll := m.db.Database(m.database).Collection("patient_records")
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "health_id", Value: healthID}}}}
+	_, err := coll.UpdateMany(context.TODO(), bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: objectIDs}}}}, update)
+	if err != nil {
+		return fmt.Errorf("error restoring patient records: %w", err)
+	}
+	return nil
+}
+
 func (m *MongoStore) UpdatePatientBioData(healthID string, updates map[string]interface{}) (*PatientDetails, error) {
 	coll := m.db.Database(m.database).Collection("patient_details")
 
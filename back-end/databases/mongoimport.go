@@ -0,0 +1,684 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Moves what is left in the legacy mongo collections (healthcare_info, patient_details, appointments)
+// into postgres. Documents are streamed in _id order and every batch is committed together with the
+// position it reached, so an interrupted run continues where it stopped. Each imported document is
+// remembered in mongo_imported, running it again never imports anything twice.
+// Documents that can't be imported are kept in mongo_import_issues:
+//   conflict  postgres already has a different row with the same key, postgres wins
+//   rejected  the document is incomplete or breaks the rules the API enforces, fix it in mongo and retry
+
+const defaultImportBatch = 500
+
+// collections in the order they have to be imported, appointments need their patient first
+var ImportCollections = []string{"healthcare_info", "patient_details", "appointments"}
+
+type ImportStats struct {
+	Collection string     `json:"collection"`
+	Imported   int        `json:"imported"`
+	Skipped    int        `json:"skipped"`
+	Conflicts  int        `json:"conflicts"`
+	Rejected   int        `json:"rejected"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type ImportIssue struct {
+	Collection string    `json:"collection"`
+	MongoID    string    `json:"mongo_id"`
+	Kind       string    `json:"kind"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// a document the importer refuses, the reason is stored with it
+type importReject struct {
+	reason string
+}
+
+func (e *importReject) Error() string {
+	return e.reason
+}
+
+func rejectf(format string, args ...any) error {
+	return &importReject{reason: fmt.Sprintf(format, args...)}
+}
+
+var errImportConflict = errors.New("conflict")
+
+type MongoImporter struct {
+	mongo    *MongoStore
+	postgres *PostgresStore
+	// documents per transaction
+	BatchSize int
+}
+
+func NewMongoImporter(mongo *MongoStore, postgres *PostgresStore) *MongoImporter {
+	return &MongoImporter{mongo: mongo, postgres: postgres, BatchSize: defaultImportBatch}
+}
+
+// how one collection is written to postgres, insert returns the postgres key of the new row
+type importTarget struct {
+	insert func(tx *sql.Tx, doc bson.M) (string, error)
+	// runs once the batch is committed, with the keys that were imported
+	afterCommit func(keys []string)
+}
+
+func (i *MongoImporter) targets() map[string]importTarget {
+	return map[string]importTarget{
+		"healthcare_info": {insert: insertImportedHIP},
+		"patient_details": {insert: insertImportedPatient, afterCommit: i.queueDuplicates},
+		"appointments":    {insert: insertImportedAppointment},
+	}
+}
+
+// Run imports the given collections (all of them when empty), retryRejected first gives
+// documents rejected by an earlier run another go
+func (i *MongoImporter) Run(ctx context.Context, collections []string, retryRejected bool) ([]*ImportStats, error) {
+	if len(collections) == 0 {
+		collections = ImportCollections
+	}
+	targets := i.targets()
+	all := []*ImportStats{}
+	for _, collection := range collections {
+		target, ok := targets[collection]
+		if !ok {
+			return all, fmt.Errorf("unknown collection %s, expected one of %s", collection, strings.Join(ImportCollections, ", "))
+		}
+		if retryRejected {
+			if err := i.retryRejected(ctx, collection, target); err != nil {
+				return all, err
+			}
+		}
+		if err := i.importCollection(ctx, collection, target); err != nil {
+			return all, err
+		}
+		stats, err := i.progress(collection)
+		if err != nil {
+			return all, err
+		}
+		all = append(all, stats)
+	}
+	return all, nil
+}
+
+func (i *MongoImporter) importCollection(ctx context.Context, collection string, target importTarget) error {
+	_, err := i.postgres.db.Exec(`INSERT INTO mongo_import_progress (collection) VALUES ($1) ON CONFLICT DO NOTHING;`, collection)
+	if err != nil {
+		return fmt.Errorf("failed to start import of %s: %w", collection, err)
+	}
+	var lastID []byte
+	if err := i.postgres.db.QueryRow(`SELECT last_id FROM mongo_import_progress WHERE collection = $1;`, collection).Scan(&lastID); err != nil {
+		return fmt.Errorf("failed to read import progress of %s: %w", collection, err)
+	}
+
+	filter := bson.M{}
+	if lastID != nil {
+		var last bson.M
+		if err := bson.UnmarshalExtJSON(lastID, true, &last); err != nil {
+			return fmt.Errorf("broken import position of %s: %w", collection, err)
+		}
+		// $expr compares across bson types, a plain $gt would only see _ids of the same type
+		filter = bson.M{"$expr": bson.M{"$gt": bson.A{"$_id", last["_id"]}}}
+	}
+	coll := i.mongo.db.Database(i.mongo.database).Collection(collection)
+	cursor, err := coll.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetBatchSize(int32(i.BatchSize)))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", collection, err)
+	}
+	defer cursor.Close(ctx)
+
+	for {
+		batch := []bson.M{}
+		for len(batch) < i.BatchSize && cursor.Next(ctx) {
+			var doc bson.M
+			if err := cursor.Decode(&doc); err != nil {
+				return fmt.Errorf("failed to decode %s document: %w", collection, err)
+			}
+			batch = append(batch, doc)
+		}
+		if err := cursor.Err(); err != nil {
+			return fmt.Errorf("failed to read %s: %w", collection, err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		if err := i.importBatch(collection, target, batch, true); err != nil {
+			return err
+		}
+	}
+	_, err = i.postgres.db.Exec(`UPDATE mongo_import_progress SET finished_at = NOW(), updated_at = NOW() WHERE collection = $1;`, collection)
+	return err
+}
+
+// one transaction per batch: the rows, the issues and the position all commit together
+func (i *MongoImporter) importBatch(collection string, target importTarget, batch []bson.M, advance bool) error {
+	tx, err := i.postgres.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stats := ImportStats{}
+	keys := []string{}
+	for _, doc := range batch {
+		key, err := importDocument(tx, collection, target, doc, &stats)
+		if err != nil {
+			return err
+		}
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	position := sql.NullString{}
+	if advance {
+		last, err := bson.MarshalExtJSON(bson.M{"_id": batch[len(batch)-1]["_id"]}, true, false)
+		if err != nil {
+			return fmt.Errorf("failed to encode import position: %w", err)
+		}
+		position = sql.NullString{String: string(last), Valid: true}
+	}
+	_, err = tx.Exec(`UPDATE mongo_import_progress SET
+		last_id = COALESCE($2::jsonb, last_id),
+		imported = imported + $3, skipped = skipped + $4, conflicts = conflicts + $5, rejected = rejected + $6,
+		updated_at = NOW()
+		WHERE collection = $1;`,
+		collection, position, stats.Imported, stats.Skipped, stats.Conflicts, stats.Rejected)
+	if err != nil {
+		return fmt.Errorf("failed to save import progress: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("import %s: %d imported, %d skipped, %d conflicts, %d rejected", collection, stats.Imported, stats.Skipped, stats.Conflicts, stats.Rejected)
+	if target.afterCommit != nil && len(keys) > 0 {
+		target.afterCommit(keys)
+	}
+	return nil
+}
+
+// importDocument writes one document inside a savepoint so a bad row doesn't abort the batch.
+// It returns the postgres key when the document was imported.
+func importDocument(tx *sql.Tx, collection string, target importTarget, doc bson.M, stats *ImportStats) (string, error) {
+	mongoID := importID(doc["_id"])
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM mongo_imported WHERE collection = $1 AND mongo_id = $2);`, collection, mongoID).Scan(&exists)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		stats.Skipped++
+		return "", nil
+	}
+
+	if _, err := tx.Exec(`SAVEPOINT import_document;`); err != nil {
+		return "", err
+	}
+	key, insertErr := target.insert(tx, doc)
+	if insertErr == nil {
+		_, err = tx.Exec(`INSERT INTO mongo_imported (collection, mongo_id, target_key) VALUES ($1, $2, $3);`, collection, mongoID, key)
+		if err != nil {
+			return "", err
+		}
+		// it may have been rejected by an earlier run
+		if _, err := tx.Exec(`DELETE FROM mongo_import_issues WHERE collection = $1 AND mongo_id = $2;`, collection, mongoID); err != nil {
+			return "", err
+		}
+		if _, err := tx.Exec(`RELEASE SAVEPOINT import_document;`); err != nil {
+			return "", err
+		}
+		stats.Imported++
+		return key, nil
+	}
+
+	// whatever the insert did is undone, postgres errors (too long, bad date, missing patient) are rejects too
+	if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT import_document;`); err != nil {
+		return "", err
+	}
+	kind := "rejected"
+	if errors.Is(insertErr, errImportConflict) {
+		kind = "conflict"
+		stats.Conflicts++
+	} else {
+		stats.Rejected++
+	}
+	document, err := bson.MarshalExtJSON(doc, true, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s document %s: %w", collection, mongoID, err)
+	}
+	_, err = tx.Exec(`INSERT INTO mongo_import_issues (collection, mongo_id, kind, reason, document)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (collection, mongo_id) DO UPDATE SET kind = $3, reason = $4, document = $5, created_at = NOW();`,
+		collection, mongoID, kind, insertErr.Error(), string(document))
+	return "", err
+}
+
+// documents rejected earlier are read again from mongo, they may have been fixed there
+func (i *MongoImporter) retryRejected(ctx context.Context, collection string, target importTarget) error {
+	rows, err := i.postgres.db.Query(`SELECT document FROM mongo_import_issues WHERE collection = $1 AND kind = 'rejected' ORDER BY id;`, collection)
+	if err != nil {
+		return fmt.Errorf("failed to read rejected %s documents: %w", collection, err)
+	}
+	ids := bson.A{}
+	for rows.Next() {
+		var document []byte
+		if err := rows.Scan(&document); err != nil {
+			rows.Close()
+			return err
+		}
+		var doc bson.M
+		if err := bson.UnmarshalExtJSON(document, true, &doc); err != nil {
+			rows.Close()
+			return fmt.Errorf("broken rejected %s document: %w", collection, err)
+		}
+		ids = append(ids, doc["_id"])
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	coll := i.mongo.db.Database(i.mongo.database).Collection(collection)
+	for start := 0; start < len(ids); start += i.BatchSize {
+		chunk := ids[start:min(start+i.BatchSize, len(ids))]
+		cursor, err := coll.Find(ctx, bson.M{"_id": bson.M{"$in": chunk}}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", collection, err)
+		}
+		batch := []bson.M{}
+		if err := cursor.All(ctx, &batch); err != nil {
+			return fmt.Errorf("failed to decode %s documents: %w", collection, err)
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		// the counters only track the first pass, a retried document is one less reject
+		if err := i.importBatch(collection, target, batch, false); err != nil {
+			return err
+		}
+	}
+	_, err = i.postgres.db.Exec(`UPDATE mongo_import_progress p SET rejected = (
+		SELECT COUNT(*) FROM mongo_import_issues WHERE collection = p.collection AND kind = 'rejected')
+		WHERE collection = $1;`, collection)
+	return err
+}
+
+// Status reports every collection that has been imported, or started to be
+func (i *MongoImporter) Status() ([]*ImportStats, error) {
+	rows, err := i.postgres.db.Query(`SELECT collection FROM mongo_import_progress ORDER BY started_at;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import progress: %w", err)
+	}
+	collections := []string{}
+	for rows.Next() {
+		var collection string
+		if err := rows.Scan(&collection); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		collections = append(collections, collection)
+	}
+	rows.Close()
+
+	all := []*ImportStats{}
+	for _, collection := range collections {
+		stats, err := i.progress(collection)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, stats)
+	}
+	return all, nil
+}
+
+func (i *MongoImporter) progress(collection string) (*ImportStats, error) {
+	stats := &ImportStats{Collection: collection}
+	err := i.postgres.db.QueryRow(`SELECT imported, skipped, conflicts, rejected, updated_at, finished_at
+		FROM mongo_import_progress WHERE collection = $1;`, collection).Scan(
+		&stats.Imported, &stats.Skipped, &stats.Conflicts, &stats.Rejected, &stats.UpdatedAt, &stats.FinishedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import progress of %s: %w", collection, err)
+	}
+	return stats, nil
+}
+
+// Issues lists conflicts and rejects, of one collection or of all when collection is empty
+func (i *MongoImporter) Issues(collection string, limit int) ([]*ImportIssue, error) {
+	rows, err := i.postgres.db.Query(`SELECT collection, mongo_id, kind, reason, created_at
+		FROM mongo_import_issues WHERE $1 = '' OR collection = $1
+		ORDER BY collection, id LIMIT $2;`, collection, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import issues: %w", err)
+	}
+	defer rows.Close()
+
+	issues := []*ImportIssue{}
+	for rows.Next() {
+		issue := &ImportIssue{}
+		if err := rows.Scan(&issue.Collection, &issue.MongoID, &issue.Kind, &issue.Reason, &issue.CreatedAt); err != nil {
+			return nil, err
+		}
+		issues = append(issues, issue)
+	}
+	return issues, rows.Err()
+}
+
+// imported patients are checked against the existing ones like newly registered patients are
+func (i *MongoImporter) queueDuplicates(healthIDs []string) {
+	for _, healthID := range healthIDs {
+		patient, err := i.postgres.Get_ClientProfile(healthID)
+		if err != nil {
+			log.Printf("import: duplicate check of %s skipped: %v", healthID, err)
+			continue
+		}
+		if _, err := i.postgres.FindDuplicates(patient); err != nil {
+			log.Printf("import: duplicate check of %s failed: %v", healthID, err)
+		}
+	}
+}
+
+//////////////////////////////// TRANSFORMS ////////////////////////////////
+
+func insertImportedHIP(tx *sql.Tx, doc bson.M) (string, error) {
+	hip, err := hipFromMongo(doc)
+	if err != nil {
+		return "", err
+	}
+	result, err := tx.Exec(`INSERT INTO HIP_TABLE (healthcare_id, healthcare_license,
+		healthcare_name, email, availability, total_facilities,
+		total_mbbs_doc, total_worker, no_of_beds, date_of_registration, password, about, country,
+		state, city, landmark, region_code, zone_code, woreda_code, kebele_code, address_needs_review)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16,
+		NULLIF($17, ''), NULLIF($18, ''), NULLIF($19, ''), NULLIF($20, ''), $21)
+	ON CONFLICT DO NOTHING;`,
+		hip.HealthcareID, hip.HealthcareLicense, hip.HealthcareName, hip.Email, hip.Availability, hip.TotalFacilities,
+		hip.TotalMBBSDoc, hip.TotalWorker, hip.NoOfBeds, hip.DateOfRegistration, hip.Password, hip.About,
+		hip.Address.Country, hip.Address.State, hip.Address.City, hip.Address.Landmark,
+		hip.Address.Region, hip.Address.Zone, hip.Address.Woreda, hip.Address.Kebele, hip.Address.NeedsReview)
+	if err != nil {
+		return "", err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return "", fmt.Errorf("%w: healthcare_id, license, name or email of %s already exists in postgres", errImportConflict, hip.HealthcareID)
+	}
+	// same defaults SignUpAccount gives a new account
+	_, err = tx.Exec(`INSERT INTO HealthCare_pref (healthcare_id, scheduled_deletion, profile_viewed,
+		profile_updated, account_locked, records_created, records_viewed,
+		totalRequest_count, appointmentFee, isAvailable)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		hip.HealthcareID, "false", 0, 0, "false", 0, 0, loginQuota, 100, "true")
+	if err != nil {
+		return "", err
+	}
+	return hip.HealthcareID, nil
+}
+
+func insertImportedPatient(tx *sql.Tx, doc bson.M) (string, error) {
+	p, err := patientFromMongo(doc)
+	if err != nil {
+		return "", err
+	}
+	firstKey, middleKey, lastKey, fatherKey := NameKeys(p)
+	result, err := tx.Exec(`INSERT INTO client_profile (
+		health_id, first_name, middle_name, last_name, sex, healthcare_id,
+		dob, blood_group, bmi, marriage_status, weight, email,
+		mobile_number, aadhaar_number, primary_location, sibling, twin,
+		father_name, mother_name, emergency_number, created_at, updated_at, country, city, state, landmark,
+		region_code, zone_code, woreda_code, kebele_code, address_needs_review,
+		first_name_key, middle_name_key, last_name_key, father_name_key
+	) VALUES (
+		$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17,
+		$18, $19, $20, $21, $22, $23, $24, $25, $26,
+		NULLIF($27, ''), NULLIF($28, ''), NULLIF($29, ''), NULLIF($30, ''), $31,
+		$32, $33, $34, $35
+	) ON CONFLICT (health_id) DO NOTHING;`,
+		p.HealthID, p.FirstName, p.MiddleName, p.LastName, p.Sex, p.HealthcareID,
+		p.DOB, p.BloodGroup, p.BMI, p.MarriageStatus, p.Weight, p.Email,
+		p.MobileNumber, p.AadhaarNumber, p.PrimaryLocation, p.Sibling, p.Twin,
+		p.FatherName, p.MotherName, p.EmergencyNumber, p.CreatedAt, p.UpdatedAt,
+		p.Address.Country, p.Address.City, p.Address.State, p.Address.Landmark,
+		p.Address.Region, p.Address.Zone, p.Address.Woreda, p.Address.Kebele, p.Address.NeedsReview,
+		firstKey, middleKey, lastKey, fatherKey)
+	if err != nil {
+		return "", err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return "", fmt.Errorf("%w: health_id %s already exists in postgres", errImportConflict, p.HealthID)
+	}
+	_, err = tx.Exec(`INSERT INTO client_stats (health_id) VALUES ($1) ON CONFLICT DO NOTHING;`, p.HealthID)
+	if err != nil {
+		return "", err
+	}
+	return p.HealthID, nil
+}
+
+func insertImportedAppointment(tx *sql.Tx, doc bson.M) (string, error) {
+	a, date, err := appointmentFromMongo(doc)
+	if err != nil {
+		return "", err
+	}
+	var patientExists bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM client_profile WHERE health_id = $1);`, a.HealthID).Scan(&patientExists)
+	if err != nil {
+		return "", err
+	}
+	if !patientExists {
+		return "", rejectf("patient %s is not in postgres, import patient_details first", a.HealthID)
+	}
+	var id int64
+	err = tx.QueryRow(`INSERT INTO appointments (health_id, healthcare_id, appointment_date, appointment_time,
+		status, department, note, fullname, healthcare_name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8,
+			COALESCE((SELECT healthcare_name FROM HIP_TABLE WHERE healthcare_id = $2), ''))
+		RETURNING id;`,
+		a.HealthID, a.HealthcareID, date, a.AppointmentTime, a.Status, a.Department, a.Note, a.FullName).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+// patientFromMongo maps a patient_details document (bson names fname, lname, mobilenumber, ...)
+// onto PatientDetails and checks it with the rules profile creation uses
+func patientFromMongo(doc bson.M) (*PatientDetails, error) {
+	p := &PatientDetails{
+		HealthID:        mongoString(doc, "health_id"),
+		FirstName:       mongoString(doc, "fname"),
+		MiddleName:      mongoString(doc, "middlename"),
+		LastName:        mongoString(doc, "lname"),
+		Sex:             mongoString(doc, "sex"),
+		HealthcareID:    mongoString(doc, "healthcare_id"),
+		DOB:             mongoString(doc, "dob"),
+		BloodGroup:      mongoString(doc, "bloodgrp"),
+		BMI:             mongoString(doc, "bmi"),
+		MarriageStatus:  mongoString(doc, "marriage_status"),
+		Weight:          mongoString(doc, "weight"),
+		Email:           mongoString(doc, "email"),
+		MobileNumber:    mongoString(doc, "mobilenumber"),
+		AadhaarNumber:   mongoString(doc, "aadhaar_number"),
+		PrimaryLocation: mongoString(doc, "primary_location"),
+		Sibling:         mongoString(doc, "sibling"),
+		Twin:            mongoString(doc, "twin"),
+		FatherName:      mongoString(doc, "fathername"),
+		MotherName:      mongoString(doc, "mothername"),
+		EmergencyNumber: mongoString(doc, "emergencynumber"),
+		CreatedAt:       mongoTime(doc, "created_at"),
+		UpdatedAt:       mongoTime(doc, "updated_at"),
+		Address:         addressFromMongo(doc),
+	}
+	// the oldest documents predate the timestamps
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = time.Now().UTC()
+	}
+	if p.UpdatedAt.Before(p.CreatedAt) {
+		p.UpdatedAt = p.CreatedAt
+	}
+	if err := newValidator().StructExcept(p, "Address"); err != nil {
+		return nil, &importReject{reason: toValidationError(err).Error()}
+	}
+	return p, nil
+}
+
+func hipFromMongo(doc bson.M) (*HIPInfo, error) {
+	hip := &HIPInfo{
+		HealthcareID:       mongoString(doc, "healthcare_id"),
+		HealthcareLicense:  mongoString(doc, "healthcare_license"),
+		HealthcareName:     mongoString(doc, "name"),
+		Email:              mongoString(doc, "email"),
+		Availability:       mongoString(doc, "availability"),
+		TotalFacilities:    mongoInt(doc, "total_facilities"),
+		TotalMBBSDoc:       mongoInt(doc, "total_mbbs_doc"),
+		TotalWorker:        mongoInt(doc, "total_worker"),
+		NoOfBeds:           mongoInt(doc, "no_of_beds"),
+		About:              mongoString(doc, "about"),
+		DateOfRegistration: mongoTime(doc, "date_of_registration"),
+		Password:           mongoString(doc, "password"),
+		Address:            addressFromMongo(doc),
+	}
+	if hip.HealthcareID == "" {
+		return nil, rejectf("healthcare_id is missing")
+	}
+	if err := newValidator().StructExcept(hip, "Address"); err != nil {
+		return nil, &importReject{reason: toValidationError(err).Error()}
+	}
+	return hip, nil
+}
+
+// appointmentFromMongo also returns the appointment date parsed, postgres keeps it as a timestamp
+func appointmentFromMongo(doc bson.M) (*Appointments, time.Time, error) {
+	a := &Appointments{
+		HealthID:        mongoString(doc, "health_id"),
+		HealthcareID:    mongoString(doc, "healthcare_id"),
+		AppointmentDate: mongoString(doc, "appointment_date"),
+		AppointmentTime: mongoString(doc, "appointment_time"),
+		Department:      mongoString(doc, "department"),
+		Note:            mongoString(doc, "note"),
+		FullName:        mongoString(doc, "fullname"),
+		Status:          strings.ToLower(mongoString(doc, "status")),
+	}
+	if a.HealthID == "" || a.HealthcareID == "" {
+		return nil, time.Time{}, rejectf("health_id and healthcare_id are required")
+	}
+	if a.Status == "" {
+		a.Status = "pending"
+	}
+	date := mongoTime(doc, "appointment_date")
+	if date.IsZero() {
+		return nil, time.Time{}, rejectf("appointment_date %q is not a date", a.AppointmentDate)
+	}
+	return a, date, nil
+}
+
+// legacy addresses are free text, they are imported as they are and flagged for review
+// unless they already carry codes that resolve in the hierarchy
+func addressFromMongo(doc bson.M) Address {
+	raw, _ := doc["address"].(bson.M)
+	address := Address{
+		Country:  mongoString(raw, "country"),
+		State:    mongoString(raw, "state"),
+		City:     mongoString(raw, "city"),
+		Landmark: mongoString(raw, "landmark"),
+		Region:   mongoString(raw, "region"),
+		Zone:     mongoString(raw, "zone"),
+		Woreda:   mongoString(raw, "woreda"),
+		Kebele:   mongoString(raw, "kebele"),
+	}
+	resolved := address
+	if err := ValidateAddress(&resolved); err == nil {
+		return resolved
+	}
+	address.Region, address.Zone, address.Woreda, address.Kebele = "", "", "", ""
+	address.NeedsReview = true
+	return address
+}
+
+// mongo documents were written by several versions of the API, numbers and dates show up where strings are expected
+func mongoString(doc bson.M, key string) string {
+	switch v := doc[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case int32:
+		return strconv.Itoa(int(v))
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case primitive.DateTime:
+		return v.Time().UTC().Format("2006-01-02")
+	case primitive.ObjectID:
+		return v.Hex()
+	default:
+		return strings.TrimSpace(fmt.Sprint(v))
+	}
+}
+
+func mongoInt(doc bson.M, key string) int {
+	switch v := doc[key].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(strings.TrimSpace(v))
+		return n
+	}
+	return 0
+}
+
+var importDateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02", "02/01/2006"}
+
+// zero when the value is missing or not a date
+func mongoTime(doc bson.M, key string) time.Time {
+	switch v := doc[key].(type) {
+	case primitive.DateTime:
+		return v.Time().UTC()
+	case primitive.Timestamp:
+		return time.Unix(int64(v.T), 0).UTC()
+	case string:
+		for _, layout := range importDateLayouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+				return t.UTC()
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// ObjectIDs by their hex, anything else as its extended JSON
+func importID(id interface{}) string {
+	if oid, ok := id.(primitive.ObjectID); ok {
+		return oid.Hex()
+	}
+	value, err := bson.MarshalExtJSON(bson.M{"_id": id}, true, false)
+	if err != nil {
+		return fmt.Sprint(id)
+	}
+	var wrapped map[string]json.RawMessage
+	if json.Unmarshal(value, &wrapped) == nil {
+		return string(wrapped["_id"])
+	}
+	return string(value)
+}
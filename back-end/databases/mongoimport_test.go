@@ -0,0 +1,73 @@
+package databases
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPatientFromMongo(t *testing.T) {
+	created := time.Date(2023, 4, 2, 9, 0, 0, 0, time.UTC)
+	doc := bson.M{
+		"_id":              primitive.NewObjectID(),
+		"health_id":        "ET-1234-5678",
+		"fname":            " Abebe ",
+		"middlename":       "Kebede",
+		"lname":            "Tesfaye",
+		"sex":              "M",
+		"healthcare_id":    "HIP-00001",
+		"dob":              "1990-05-01",
+		"bloodgrp":         "O+",
+		"bmi":              "22.5",
+		"marriage_status":  "married",
+		"weight":           int32(70),
+		"email":            "abebe@example.com",
+		"mobilenumber":     int64(911234567),
+		"aadhaar_number":   "123456789012",
+		"primary_location": "Adama",
+		"sibling":          "2",
+		"twin":             false,
+		"fathername":       "Kebede",
+		"mothername":       "Almaz",
+		"emergencynumber":  "0911000000",
+		"created_at":       primitive.NewDateTimeFromTime(created),
+		"address":          bson.M{"country": "Ethiopia", "city": "Adama", "landmark": "near the bus station"},
+	}
+	p, err := patientFromMongo(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.FirstName != "Abebe" || p.FatherName != "Kebede" || p.MobileNumber != "911234567" || p.Weight != "70" || p.Twin != "false" {
+		t.Errorf("patient = %+v", p)
+	}
+	if !p.CreatedAt.Equal(created) || !p.UpdatedAt.Equal(created) {
+		t.Errorf("created_at = %v, updated_at = %v, want %v", p.CreatedAt, p.UpdatedAt, created)
+	}
+	// free text addresses are kept but flagged
+	if !p.Address.NeedsReview || p.Address.City != "Adama" || p.Address.Region != "" {
+		t.Errorf("address = %+v, want legacy address flagged for review", p.Address)
+	}
+
+	delete(doc, "fname")
+	_, err = patientFromMongo(doc)
+	var reject *importReject
+	if !errors.As(err, &reject) {
+		t.Errorf("err = %v, want a reject for the missing fname", err)
+	}
+}
+
+func TestAppointmentFromMongo(t *testing.T) {
+	a, date, err := appointmentFromMongo(bson.M{"health_id": "ET-1234-5678", "healthcare_id": "HIP1", "appointment_date": "2024-01-15"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Status != "pending" || !date.Equal(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("status = %q, date = %v", a.Status, date)
+	}
+	if _, _, err := appointmentFromMongo(bson.M{"health_id": "ET-1234-5678", "healthcare_id": "HIP1", "appointment_date": "soon"}); err == nil {
+		t.Error("want a reject for an unparsable appointment_date")
+	}
+}
@@ -0,0 +1,527 @@
+package databases
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vaibhavyadav-dev/healthcareServer/i18n"
+	"vaibhavyadav-dev/healthcareServer/notify"
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+)
+
+// The notifications projection follows the event log like the webhooks one and queues what HIPs
+// and patients are told: a HIP gets email about its account (created, signed in, out of logins,
+// scheduled for deletion), a patient gets email and a text message when an appointment changes
+// and before it starts (see reminders.go). Each is rendered from the notify templates in the
+// recipient's language when it is queued, and only on the channels the recipient's preferences
+// allow. The checkpoint starts at the head of the log, the events from before notifications
+// existed are not told.
+//
+// The send loop works like the webhook deliveries: due notifications are leased, sent on their
+// channel and retried with backoff (notificationRetry) until notificationMaxAttempts. One whose
+// channel is not configured fails right away, its status tells why it never arrived.
+
+const (
+	notificationsProjection = "notifications"
+	notificationMaxAttempts = 5
+	notificationMaxBackoff  = time.Hour
+	notificationLease       = time.Minute
+	notificationConcurrency = 5
+	// the notification a login that took the last one of the quota adds
+	hipLockedType = "hip.account.locked"
+
+	RecipientHIP     = "hip"
+	RecipientPatient = "patient"
+)
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// NotificationPreferences is how a HIP or patient wants to be notified. Muted holds notification
+// type patterns (hip.account.logged_in, hip.account.*) that are never sent to them
+type NotificationPreferences struct {
+	RecipientType string     `json:"recipient_type"`
+	RecipientID   string     `json:"recipient_id"`
+	Language      string     `json:"language" validate:"required,oneof=en am om"`
+	Email         bool       `json:"email"`
+	SMS           bool       `json:"sms"`
+	Muted         []string   `json:"muted" validate:"max=20,dive,required"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+}
+
+// DefaultNotificationPreferences are the preferences of whoever hasn't set any, everything in English
+func DefaultNotificationPreferences(recipientType, recipientID string) *NotificationPreferences {
+	return &NotificationPreferences{
+		RecipientType: recipientType,
+		RecipientID:   recipientID,
+		Language:      string(i18n.English),
+		Email:         true,
+		SMS:           true,
+		Muted:         []string{},
+	}
+}
+
+func (p *NotificationPreferences) check() error {
+	if p.Muted == nil {
+		p.Muted = []string{}
+	}
+	if err := newValidator().Struct(p); err != nil {
+		return toValidationError(err)
+	}
+	for _, filter := range p.Muted {
+		if !matchesNotificationType(filter) {
+			return &EventFilterError{Filter: filter}
+		}
+	}
+	return nil
+}
+
+func (p *NotificationPreferences) muted(kind string) bool {
+	for _, pattern := range p.Muted {
+		if mq.TopicMatch(pattern, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesNotificationType(filter string) bool {
+	for _, kind := range notify.Types() {
+		if mq.TopicMatch(filter, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+type Notification struct {
+	ID            int64  `json:"id"`
+	EventID       string `json:"event_id"`
+	Type          string `json:"type"`
+	RecipientType string `json:"recipient_type"`
+	RecipientID   string `json:"recipient_id"`
+	Channel       string `json:"channel"`
+	Address       string `json:"address"`
+	Language      string `json:"language"`
+	Subject       string `json:"subject,omitempty"`
+	Body          string `json:"body"`
+	// pending, sent or failed
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// only while pending
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// notificationRetry is how long a notification waits after its nth failed attempt, 30s doubling up to an hour
+func notificationRetry(attempts int) time.Duration {
+	return min(30*time.Second<<min(max(attempts-1, 0), 7), notificationMaxBackoff)
+}
+
+// recipient is who a notification of an event goes to and what its template is filled with
+type recipient struct {
+	kind   string
+	typ    string
+	id     string
+	email  string
+	mobile string
+	data   map[string]string
+}
+
+// queueNotifications is the apply of the notifications projection
+func queueNotifications(tx *sql.Tx, e loggedEvent) error {
+	recipients, healthcareID, err := notificationRecipients(tx, e)
+	if err != nil || len(recipients) == 0 {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, r := range recipients {
+		prefs, err := notificationPreferences(tx, r.typ, r.id)
+		if err != nil {
+			return err
+		}
+		if prefs.muted(r.kind) {
+			continue
+		}
+		addresses := map[string]string{}
+		if prefs.Email && r.email != "" {
+			addresses[notify.Email] = r.email
+		}
+		if prefs.SMS && r.mobile != "" {
+			addresses[notify.SMS] = r.mobile
+		}
+		for _, channel := range []string{notify.Email, notify.SMS} {
+			address, ok := addresses[channel]
+			if !ok {
+				continue
+			}
+			msg, err := notify.Render(r.kind, i18n.Lang(prefs.Language), channel, address, r.data)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT INTO notifications (position, type, healthcare_id, recipient_type, recipient_id, channel, address,
+				language, subject, body, next_attempt_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11)
+				ON CONFLICT (position, type, recipient_type, recipient_id, channel) DO NOTHING;`,
+				e.Position, r.kind, healthcareID, r.typ, r.id, channel, address, prefs.Language, msg.Subject, msg.Body, now)
+			if err != nil {
+				return fmt.Errorf("failed to queue %s notification: %w", channel, err)
+			}
+		}
+	}
+	return nil
+}
+
+// notificationRecipients is who is told about e, and the HIP whose list the notifications show in
+func notificationRecipients(tx *sql.Tx, e loggedEvent) ([]recipient, string, error) {
+	var event struct {
+		Data struct {
+			HealthcareID  string `json:"healthcare_id"`
+			IPAddress     string `json:"ip_address"`
+			AppointmentID int64  `json:"appointment_id"`
+			HealthID      string `json:"health_id"`
+			Status        string `json:"status"`
+			ReplyCode     string `json:"reply_code"`
+		} `json:"data"`
+	}
+	switch e.Type {
+	case "hip.account.created", "hip.account.logged_in", "hip.account.deletion_scheduled", "appointment.status.changed",
+		"appointment.reminder.due":
+	default:
+		return nil, "", nil
+	}
+	if err := json.Unmarshal(e.Body, &event); err != nil {
+		return nil, "", err
+	}
+	data := event.Data
+
+	hip := recipient{kind: e.Type, typ: RecipientHIP, id: data.HealthcareID, data: map[string]string{"healthcare_id": data.HealthcareID}}
+	var name string
+	err := tx.QueryRow(`SELECT healthcare_name, email FROM HIP_TABLE WHERE healthcare_id = $1;`, data.HealthcareID).Scan(&name, &hip.email)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the HIP is gone, there is nobody to tell
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	hip.data["healthcare_name"] = name
+
+	switch e.Type {
+	case "hip.account.logged_in":
+		// the counters projection has taken this login off already, it runs first
+		var remaining int
+		err := tx.QueryRow(`SELECT totalrequest_count FROM HealthCare_pref WHERE healthcare_id = $1;`, data.HealthcareID).Scan(&remaining)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, "", err
+		}
+		hip.data["ip_address"] = data.IPAddress
+		hip.data["remaining"] = strconv.Itoa(max(remaining, 0))
+		recipients := []recipient{hip}
+		if err == nil && remaining <= 0 {
+			locked := hip
+			locked.kind = hipLockedType
+			recipients = append(recipients, locked)
+		}
+		return recipients, data.HealthcareID, nil
+	case "appointment.status.changed", "appointment.reminder.due":
+		patient, err := appointmentRecipient(tx, data.HealthcareID, data.HealthID, data.AppointmentID)
+		if err != nil || patient == nil {
+			return nil, "", err
+		}
+		patient.kind = e.Type
+		patient.data["status"] = data.Status
+		patient.data["reply_code"] = data.ReplyCode
+		if patient.data["healthcare_name"] == "" {
+			patient.data["healthcare_name"] = name
+		}
+		return []recipient{*patient}, data.HealthcareID, nil
+	}
+	return []recipient{hip}, data.HealthcareID, nil
+}
+
+// appointmentRecipient is the patient of an appointment with its details, nil when the patient is gone
+// or the appointment isn't the patient's with the healthcare provider, then nobody is told
+func appointmentRecipient(tx *sql.Tx, healthcareID, healthID string, appointmentID int64) (*recipient, error) {
+	var date time.Time
+	var appointmentTime, department, healthcareName string
+	err := tx.QueryRow(`SELECT appointment_date, appointment_time, department, healthcare_name FROM appointments
+		WHERE id = $1 AND healthcare_id = $2 AND health_id = $3;`, appointmentID, healthcareID, healthID).
+		Scan(&date, &appointmentTime, &department, &healthcareName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var first, last, email, mobile string
+	err = tx.QueryRow(`SELECT first_name, last_name, email, mobile_number FROM client_profile WHERE health_id = $1;`, healthID).
+		Scan(&first, &last, &email, &mobile)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &recipient{typ: RecipientPatient, id: healthID, email: email, mobile: mobile, data: map[string]string{
+		"health_id":        healthID,
+		"name":             strings.TrimSpace(first + " " + last),
+		"appointment_date": date.Format("2006-01-02"),
+		"appointment_time": appointmentTime,
+		"department":       department,
+		"healthcare_name":  healthcareName,
+	}}, nil
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// notificationPreferences are the recipient's preferences, the defaults when it has set none
+func notificationPreferences(db queryRower, recipientType, recipientID string) (*NotificationPreferences, error) {
+	prefs := DefaultNotificationPreferences(recipientType, recipientID)
+	var muted []byte
+	var updatedAt time.Time
+	err := db.QueryRow(`SELECT language, email, sms, muted, updated_at FROM notification_preferences
+		WHERE recipient_type = $1 AND recipient_id = $2;`, recipientType, recipientID).
+		Scan(&prefs.Language, &prefs.Email, &prefs.SMS, &muted, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return prefs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prefs.UpdatedAt = &updatedAt
+	return prefs, json.Unmarshal(muted, &prefs.Muted)
+}
+
+// recipientExists reports whether the HIP or patient the preferences are for is there and the
+// healthcare provider's to set: the HIP itself, a patient it registered or has an appointment with
+func recipientExists(db *sql.DB, healthcare_id, recipientType, recipientID string) (bool, error) {
+	var n int
+	var err error
+	if recipientType == RecipientHIP {
+		if recipientID != healthcare_id {
+			return false, nil
+		}
+		err = db.QueryRow(`SELECT COUNT(*) FROM HIP_TABLE WHERE healthcare_id = $1;`, recipientID).Scan(&n)
+	} else {
+		err = db.QueryRow(`SELECT COUNT(*) FROM client_profile p WHERE p.health_id = $1 AND (p.healthcare_id = $2
+			OR EXISTS (SELECT 1 FROM appointments a WHERE a.health_id = p.health_id AND a.healthcare_id = $2));`,
+			recipientID, healthcare_id).Scan(&n)
+	}
+	return n > 0, err
+}
+
+func getNotificationPreferences(db *sql.DB, healthcare_id, recipientType, recipientID string) (*NotificationPreferences, error) {
+	exists, err := recipientExists(db, healthcare_id, recipientType, recipientID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrPatientNotFound
+	}
+	return notificationPreferences(db, recipientType, recipientID)
+}
+
+// setNotificationPreferences replaces the recipient's preferences with prefs
+func setNotificationPreferences(db *sql.DB, healthcare_id string, prefs *NotificationPreferences) error {
+	if err := prefs.check(); err != nil {
+		return err
+	}
+	exists, err := recipientExists(db, healthcare_id, prefs.RecipientType, prefs.RecipientID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrPatientNotFound
+	}
+	muted, err := json.Marshal(prefs.Muted)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = db.Exec(`INSERT INTO notification_preferences (recipient_type, recipient_id, language, email, sms, muted, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (recipient_type, recipient_id) DO UPDATE SET language = excluded.language, email = excluded.email,
+		sms = excluded.sms, muted = excluded.muted, updated_at = excluded.updated_at;`,
+		prefs.RecipientType, prefs.RecipientID, prefs.Language, prefs.Email, prefs.SMS, string(muted), now)
+	if err != nil {
+		return fmt.Errorf("failed to save notification preferences: %w", err)
+	}
+	prefs.UpdatedAt = &now
+	return nil
+}
+
+const notificationColumns = `n.id, e.event_id, n.type, n.recipient_type, n.recipient_id, n.channel, n.address, n.language,
+	n.subject, n.body, n.status, n.attempts, n.next_attempt_at, n.last_error, n.sent_at, n.created_at`
+
+func scanNotification(row rowScanner) (*Notification, error) {
+	n := &Notification{}
+	var nextAttemptAt, sentAt sql.NullTime
+	var lastError sql.NullString
+	err := row.Scan(&n.ID, &n.EventID, &n.Type, &n.RecipientType, &n.RecipientID, &n.Channel, &n.Address, &n.Language,
+		&n.Subject, &n.Body, &n.Status, &n.Attempts, &nextAttemptAt, &lastError, &sentAt, &n.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if n.Status == "pending" && nextAttemptAt.Valid {
+		n.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if sentAt.Valid {
+		n.SentAt = &sentAt.Time
+	}
+	n.LastError = lastError.String
+	return n, nil
+}
+
+// listNotifications is the newest notifications about the HIP and its patients, status "" for all of them
+func listNotifications(db *sql.DB, healthcare_id, status string, limit int64) ([]*Notification, error) {
+	rows, err := db.Query(`SELECT `+notificationColumns+`
+		FROM notifications n JOIN event_log e ON e.position = n.position
+		WHERE n.healthcare_id = $1 AND ($2 = '' OR n.status = $2)
+		ORDER BY n.id DESC LIMIT $3;`, healthcare_id, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notifications := []*Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func getNotification(db *sql.DB, healthcare_id string, id int64) (*Notification, error) {
+	n, err := scanNotification(db.QueryRow(`SELECT `+notificationColumns+`
+		FROM notifications n JOIN event_log e ON e.position = n.position
+		WHERE n.id = $1 AND n.healthcare_id = $2;`, id, healthcare_id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotificationNotFound
+	}
+	return n, err
+}
+
+type claimedNotification struct {
+	id       int64
+	attempts int
+	channel  string
+	msg      notify.Message
+}
+
+// claimNotifications leases a batch of due notifications, lock keeps two replicas from claiming the same rows
+func claimNotifications(db *sql.DB, lock string) ([]*claimedNotification, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	rows, err := tx.Query(`SELECT id, attempts, channel, address, subject, body FROM notifications
+		WHERE status = 'pending' AND next_attempt_at <= $1
+		ORDER BY next_attempt_at, id LIMIT $2 `+lock+`;`, now, outboxBatch)
+	if err != nil {
+		return nil, err
+	}
+	var claimed []*claimedNotification
+	for rows.Next() {
+		c := &claimedNotification{}
+		if err := rows.Scan(&c.id, &c.attempts, &c.channel, &c.msg.To, &c.msg.Subject, &c.msg.Body); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		claimed = append(claimed, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, c := range claimed {
+		if _, err := tx.Exec(`UPDATE notifications SET next_attempt_at = $2 WHERE id = $1;`, c.id, now.Add(notificationLease)); err != nil {
+			return nil, err
+		}
+	}
+	return claimed, tx.Commit()
+}
+
+// sendNotifications sends a claimed batch on channels and records how each went, it returns how many were attempted
+func sendNotifications(db *sql.DB, lock string, channels notify.Channels) (int, error) {
+	claimed, err := claimNotifications(db, lock)
+	if err != nil || len(claimed) == 0 {
+		return 0, err
+	}
+	results := make([]error, len(claimed))
+	slots := make(chan struct{}, notificationConcurrency)
+	var wg sync.WaitGroup
+	for i, c := range claimed {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = channels.Send(c.channel, c.msg)
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	for i, c := range claimed {
+		if err := recordNotificationAttempt(db, c, results[i]); err != nil {
+			errs = append(errs, fmt.Errorf("notification %d: %w", c.id, err))
+		}
+	}
+	return len(claimed), errors.Join(errs...)
+}
+
+func recordNotificationAttempt(db *sql.DB, c *claimedNotification, sendErr error) error {
+	now := time.Now().UTC()
+	attempts := c.attempts + 1
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = db.Exec(`UPDATE notifications SET status = 'sent', attempts = $2, last_error = NULL, sent_at = $3 WHERE id = $1;`,
+			c.id, attempts, now)
+	case errors.Is(sendErr, notify.ErrNoChannel) || attempts >= notificationMaxAttempts:
+		_, err = db.Exec(`UPDATE notifications SET status = 'failed', attempts = $2, last_error = $3 WHERE id = $1;`,
+			c.id, attempts, sendErr.Error())
+	default:
+		_, err = db.Exec(`UPDATE notifications SET attempts = $2, last_error = $3, next_attempt_at = $4 WHERE id = $1;`,
+			c.id, attempts, sendErr.Error(), now.Add(notificationRetry(attempts)))
+	}
+	return err
+}
+
+// notifier is the send loop of a store, it sends nothing until channels are set
+type notifier struct {
+	channels atomic.Pointer[notify.Channels]
+	loop     *outboxRelay
+}
+
+func startNotifier(send func(notify.Channels) (int, error)) *notifier {
+	n := &notifier{}
+	n.loop = startOutboxRelay("notifications", func() (int, error) {
+		channels := n.channels.Load()
+		if channels == nil {
+			return 0, nil
+		}
+		return send(*channels)
+	})
+	return n
+}
+
+func (n *notifier) enable(channels notify.Channels) {
+	n.channels.Store(&channels)
+	n.loop.notify()
+}
@@ -0,0 +1,211 @@
+package databases
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"vaibhavyadav-dev/healthcareServer/events"
+	"vaibhavyadav-dev/healthcareServer/notify"
+)
+
+// recordingChannel keeps what it was given to send, and refuses it while fail is set
+type recordingChannel struct {
+	mu   sync.Mutex
+	sent []notify.Message
+	fail error
+}
+
+func (c *recordingChannel) Send(msg notify.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail != nil {
+		return c.fail
+	}
+	c.sent = append(c.sent, msg)
+	return nil
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	store := newTestSQLite(t)
+	if err := store.Create_ClientProfile(testPatient("HID-1", "Almaz")); err != nil {
+		t.Fatal(err)
+	}
+	// registered by another HIP, HID-2 has an appointment with HIP-0001
+	for _, healthID := range []string{"HID-2", "HID-3"} {
+		patient := testPatient(healthID, "Almaz")
+		patient.HealthcareID = "HIP-0002"
+		if err := store.Create_ClientProfile(patient); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := store.BookAppointment(&Appointments{HealthcareID: "HIP-0001", HealthID: "HID-2", AppointmentDate: "2026-10-21T00:00:00Z", Status: "Pending"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefs, err := store.GetNotificationPreferences("HIP-0001", RecipientPatient, "HID-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefs.Language != "en" || !prefs.Email || !prefs.SMS || prefs.UpdatedAt != nil {
+		t.Errorf("defaults %+v", prefs)
+	}
+
+	cases := []struct {
+		name  string
+		prefs NotificationPreferences
+		check func(error) bool
+	}{
+		{"unknown language", NotificationPreferences{RecipientType: RecipientPatient, RecipientID: "HID-1", Language: "fr"},
+			func(err error) bool { var verr *ValidationError; return errors.As(err, &verr) }},
+		{"muting nothing", NotificationPreferences{RecipientType: RecipientPatient, RecipientID: "HID-1", Language: "am", Muted: []string{"appointment.created"}},
+			func(err error) bool { var ferr *EventFilterError; return errors.As(err, &ferr) }},
+		{"unknown patient", NotificationPreferences{RecipientType: RecipientPatient, RecipientID: "HID-9", Language: "am"},
+			func(err error) bool { return errors.Is(err, ErrPatientNotFound) }},
+		{"another HIP's patient", NotificationPreferences{RecipientType: RecipientPatient, RecipientID: "HID-3", Language: "am"},
+			func(err error) bool { return errors.Is(err, ErrPatientNotFound) }},
+		{"another HIP", NotificationPreferences{RecipientType: RecipientHIP, RecipientID: "HIP-0002", Language: "am"},
+			func(err error) bool { return errors.Is(err, ErrPatientNotFound) }},
+		{"patient with an appointment", NotificationPreferences{RecipientType: RecipientPatient, RecipientID: "HID-2", Language: "am"},
+			func(err error) bool { return err == nil }},
+	}
+	for _, c := range cases {
+		if err := store.SetNotificationPreferences("HIP-0001", &c.prefs); !c.check(err) {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+
+	set := &NotificationPreferences{RecipientType: RecipientPatient, RecipientID: "HID-1", Language: "am", SMS: true, Muted: []string{"appointment.#"}}
+	if err := store.SetNotificationPreferences("HIP-0001", set); err != nil {
+		t.Fatal(err)
+	}
+	prefs, err = store.GetNotificationPreferences("HIP-0001", RecipientPatient, "HID-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefs.Language != "am" || prefs.Email || !prefs.SMS || len(prefs.Muted) != 1 || prefs.UpdatedAt == nil {
+		t.Errorf("saved %+v", prefs)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	store := newTestSQLite(t)
+	hip := &HIPInfo{HealthcareID: "HIP-0001", HealthcareLicense: "LIC-1", HealthcareName: "Adama Hospital", Email: "hip@example.com",
+		Availability: "24x7", TotalFacilities: 5, TotalMBBSDoc: 5, TotalWorker: 5, NoOfBeds: 5, Password: "hash", About: "general hospital"}
+	if _, err := store.SignUpAccount(hip); err != nil {
+		t.Fatal(err)
+	}
+	other := *hip
+	other.HealthcareID, other.HealthcareLicense, other.HealthcareName, other.Email = "HIP-0002", "LIC-2", "Nazret Hospital", "other@example.com"
+	if _, err := store.SignUpAccount(&other); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create_ClientProfile(testPatient("HID-1", "Almaz")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := store.db.Exec(`INSERT INTO appointments (id, health_id, healthcare_id, appointment_date, appointment_time, department, healthcare_name)
+		VALUES (7, 'HID-1', 'HIP-0001', $1, '09:30', 'Cardiology', 'Adama Hospital');`, time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the patient reads Amharic text messages, the HIP doesn't want to hear about its logins
+	for _, prefs := range []*NotificationPreferences{
+		{RecipientType: RecipientPatient, RecipientID: "HID-1", Language: "am", SMS: true},
+		{RecipientType: RecipientHIP, RecipientID: "HIP-0001", Language: "en", Email: true, Muted: []string{"hip.account.logged_in"}},
+	} {
+		if err := store.SetNotificationPreferences("HIP-0001", prefs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the login takes the last one
+	if _, err := store.db.Exec(`UPDATE HealthCare_pref SET totalrequest_count = 1 WHERE healthcare_id = 'HIP-0001';`); err != nil {
+		t.Fatal(err)
+	}
+
+	at := events.HIP{HealthcareID: "HIP-0001", HealthcareName: "Adama Hospital"}
+	err = store.Enqueue(
+		mustEvent(t, events.HIPAccountCreated{HIP: at, Email: "hip@example.com"}),
+		mustEvent(t, events.AppointmentStatusChanged{HealthcareID: "HIP-0001", AppointmentID: 7, HealthID: "HID-1", Status: "Confirmed"}),
+		// neither is an appointment of the patient with the HIP, the patient isn't told
+		mustEvent(t, events.AppointmentStatusChanged{HealthcareID: "HIP-0002", AppointmentID: 7, HealthID: "HID-1", Status: "Rejected"}),
+		mustEvent(t, events.AppointmentStatusChanged{HealthcareID: "HIP-0001", AppointmentID: 8, HealthID: "HID-1", Status: "Rejected"}),
+		mustEvent(t, events.PatientProfileViewed{HIP: at, Patient: events.Patient{HealthID: "HID-1"}}),
+		mustEvent(t, events.HIPLoggedIn{HIP: at, Email: "hip@example.com", IPAddress: "10.0.0.1"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		// a second round doesn't queue them again
+		if _, err := store.ProjectEvents(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queued, err := store.ListNotifications("HIP-0001", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]*Notification{}
+	for _, n := range queued {
+		got[n.Type+"/"+n.Channel] = n
+	}
+	if len(queued) != 3 || got["hip.account.created/email"] == nil || got["hip.account.locked/email"] == nil || got["appointment.status.changed/sms"] == nil {
+		t.Fatalf("queued %d: %v", len(queued), got)
+	}
+	sms := got["appointment.status.changed/sms"]
+	if sms.Address != "+251 911 234 567" || sms.Language != "am" || sms.Subject != "" ||
+		!strings.Contains(sms.Body, "2026-10-21 09:30") || !strings.Contains(sms.Body, "Confirmed") {
+		t.Errorf("sms %+v", sms)
+	}
+	if welcome := got["hip.account.created/email"]; welcome.Address != "hip@example.com" || !strings.Contains(welcome.Subject, "Adama Hospital") {
+		t.Errorf("welcome %+v", welcome)
+	}
+	if spoofed, err := store.ListNotifications("HIP-0002", "", 10); err != nil || len(spoofed) != 0 {
+		t.Errorf("another HIP's status change queued %+v, %v", spoofed, err)
+	}
+
+	// no sms channel is configured, the mail server is down
+	email := &recordingChannel{fail: errors.New("connection refused")}
+	channels := notify.Channels{notify.Email: email}
+	if n, err := store.SendNotifications(channels); n != 3 || err != nil {
+		t.Fatalf("sent %d, %v", n, err)
+	}
+	sms, err = store.GetNotification("HIP-0001", sms.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sms.Status != "failed" || sms.LastError != notify.ErrNoChannel.Error() {
+		t.Errorf("sms without a channel %+v", sms)
+	}
+	pending, err := store.ListNotifications("HIP-0001", "pending", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Attempts != 1 || pending[0].LastError != "connection refused" || pending[0].NextAttemptAt == nil {
+		t.Fatalf("pending %+v", pending)
+	}
+	// not due again before the backoff
+	if n, _ := store.SendNotifications(channels); n != 0 {
+		t.Errorf("retried %d right away", n)
+	}
+
+	email.fail = nil
+	if _, err := store.db.Exec(`UPDATE notifications SET next_attempt_at = $1 WHERE status = 'pending';`, time.Now().UTC().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.SendNotifications(channels); n != 2 || err != nil {
+		t.Fatalf("sent %d, %v", n, err)
+	}
+	sent, err := store.ListNotifications("HIP-0001", "sent", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 || sent[0].SentAt == nil || sent[0].NextAttemptAt != nil || len(email.sent) != 2 {
+		t.Errorf("sent %+v, mailed %+v", sent, email.sent)
+	}
+	if _, err := store.GetNotification("HIP-0002", sms.ID); !errors.Is(err, ErrNotificationNotFound) {
+		t.Errorf("another HIP's notification: %v", err)
+	}
+}
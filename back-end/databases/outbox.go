@@ -0,0 +1,141 @@
+package databases
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+)
+
+// The outbox makes an event part of the database change it announces: it is inserted
+// in the same transaction, so either both are committed or neither is, and a broker outage
+// no longer fails the request. A relay goroutine per store publishes pending rows in id order.
+// A message that fails is retried with backoff (outboxRetry) and holds back the ones after it
+// with the same routing key, so consumers still get each event type in order. Published rows are kept.
+
+const (
+	outboxBatch        = 100
+	outboxPollInterval = time.Second
+	outboxMaxBackoff   = 5 * time.Minute
+	// only one replica relays at a time, the others would publish out of order
+	outboxLockID = 720_410_043
+)
+
+// OutboxMessage is a message waiting in the outbox
+type OutboxMessage struct {
+	ID         int64
+	RoutingKey string
+	Body       []byte
+	Attempts   int
+	// next_attempt_at has passed, decided by the database clock
+	Due bool
+}
+
+// outboxRetry is how long a message waits after its nth failed publish, 2s doubling up to 5 minutes
+func outboxRetry(attempts int) time.Duration {
+	return min(time.Second<<min(attempts, 9), outboxMaxBackoff)
+}
+
+// relayBatch publishes the due messages of pending, which is in id order. A routing key stops at its
+// first message that isn't due yet or fails, and everything stops while the broker is unreachable
+func relayBatch(pending []*OutboxMessage, publish func(mq.Message) error) (sent []int64, failed map[*OutboxMessage]error) {
+	failed = map[*OutboxMessage]error{}
+	held := map[string]bool{}
+	for _, msg := range pending {
+		if held[msg.RoutingKey] {
+			continue
+		}
+		if !msg.Due {
+			held[msg.RoutingKey] = true
+			continue
+		}
+		err := publish(mq.Message{RoutingKey: msg.RoutingKey, Body: msg.Body})
+		if errors.Is(err, mq.ErrUnavailable) {
+			break
+		}
+		if err != nil {
+			failed[msg] = err
+			held[msg.RoutingKey] = true
+			continue
+		}
+		sent = append(sent, msg.ID)
+	}
+	return sent, failed
+}
+
+func scanOutbox(rows *sql.Rows) ([]*OutboxMessage, error) {
+	defer rows.Close()
+	var pending []*OutboxMessage
+	for rows.Next() {
+		msg := &OutboxMessage{}
+		if err := rows.Scan(&msg.ID, &msg.RoutingKey, &msg.Body, &msg.Attempts, &msg.Due); err != nil {
+			return nil, err
+		}
+		pending = append(pending, msg)
+	}
+	return pending, rows.Err()
+}
+
+// outboxRelay runs relay every outboxPollInterval, and right away after notify.
+// The webhook deliveries run in one too
+type outboxRelay struct {
+	name  string
+	relay func() (int, error)
+	wake  chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func startOutboxRelay(name string, relay func() (int, error)) *outboxRelay {
+	r := &outboxRelay{
+		name:  name,
+		relay: relay,
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+func (r *outboxRelay) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		sent, err := r.relay()
+		if err != nil {
+			log.Printf("%s: %v", r.name, err)
+		}
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+		// a full batch went out, there may be more waiting
+		if sent == outboxBatch {
+			continue
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-r.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// notify is called after a commit that wrote to the outbox
+func (r *outboxRelay) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close waits for a running batch to finish, what is left is sent after the next start
+func (r *outboxRelay) Close() {
+	close(r.stop)
+	<-r.done
+}
@@ -0,0 +1,107 @@
+package databases
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+
+	"github.com/google/uuid"
+)
+
+// testEvent is an event envelope of type routingKey, its data is {"n": n}
+func testEvent(routingKey string, n int) mq.Message {
+	body, _ := json.Marshal(map[string]interface{}{
+		"id": uuid.NewString(), "type": routingKey, "version": 1, "occurred_at": time.Now().UTC(),
+		"data": map[string]int{"n": n},
+	})
+	return mq.Message{RoutingKey: routingKey, Body: body}
+}
+
+func eventData(t *testing.T, msg mq.Message) int {
+	t.Helper()
+	var event struct {
+		Data struct{ N int } `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Body, &event); err != nil {
+		t.Fatal(err)
+	}
+	return event.Data.N
+}
+
+func TestOutboxWrittenWithChange(t *testing.T) {
+	store := newTestSQLite(t)
+	created := testEvent("patient.profile.created", 1)
+	if err := store.Create_ClientProfile(testPatient("HID-1", "Almaz"), created); err != nil {
+		t.Fatal(err)
+	}
+	// the second insert fails, its message must not be left behind
+	if err := store.Create_ClientProfile(testPatient("HID-1", "Almaz"), created); err == nil {
+		t.Fatal("duplicate health_id was stored")
+	}
+
+	var published []mq.Message
+	sent, err := store.RelayOutbox(func(msg mq.Message) error {
+		published = append(published, msg)
+		return nil
+	})
+	if err != nil || sent != 1 || len(published) != 1 {
+		t.Fatalf("relayed %d (%d published), %v", sent, len(published), err)
+	}
+	if string(published[0].Body) != string(created.Body) {
+		t.Errorf("published %s", published[0].Body)
+	}
+	// published rows are not sent again
+	if sent, err := store.RelayOutbox(func(mq.Message) error { return nil }); sent != 0 || err != nil {
+		t.Errorf("second relay sent %d, %v", sent, err)
+	}
+}
+
+func TestOutboxRelayKeepsOrder(t *testing.T) {
+	store := newTestSQLite(t)
+	viewed, created := "patient.profile.viewed", "patient.record.created"
+	if err := store.Enqueue(testEvent(viewed, 1), testEvent(created, 1), testEvent(viewed, 2), testEvent(created, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the broker is away, nothing is tried after the first message
+	tried := 0
+	sent, err := store.RelayOutbox(func(mq.Message) error {
+		tried++
+		return mq.ErrUnavailable
+	})
+	if err != nil || sent != 0 || tried != 1 {
+		t.Fatalf("while unavailable: sent %d, tried %d, %v", sent, tried, err)
+	}
+
+	// a rejected event holds back the later ones of its type, the other type goes on
+	var published []string
+	sent, err = store.RelayOutbox(func(msg mq.Message) error {
+		if msg.RoutingKey == viewed {
+			return errors.New("rejected")
+		}
+		published = append(published, fmt.Sprintf("%s %d", msg.RoutingKey, eventData(t, msg)))
+		return nil
+	})
+	if err != nil || sent != 2 {
+		t.Fatalf("sent %d, %v", sent, err)
+	}
+	if fmt.Sprint(published) != `[patient.record.created 1 patient.record.created 2]` {
+		t.Errorf("published %v", published)
+	}
+
+	var attempts int
+	var lastError string
+	if err := store.db.QueryRow(`SELECT attempts, last_error FROM outbox WHERE routing_key = $1 ORDER BY id LIMIT 1;`, viewed).Scan(&attempts, &lastError); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 || lastError != "rejected" {
+		t.Errorf("failed message: attempts %d, last_error %q", attempts, lastError)
+	}
+	// it waits out its backoff before the next try
+	if sent, err := store.RelayOutbox(func(mq.Message) error { return nil }); sent != 0 || err != nil {
+		t.Errorf("relay during backoff sent %d, %v", sent, err)
+	}
+}
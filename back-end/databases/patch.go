@@ -0,0 +1,241 @@
+package databases
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Profile updates are JSON Merge Patches (RFC 7396) over the API representation of a profile:
+// a field that is left out stays as it is, a value replaces it and null clears it.
+// Only the fields listed below can be patched, each maps to a fixed column so nothing
+// the client sends ever ends up in the SQL itself.
+
+var (
+	ErrEmptyPatch      = errors.New("patch does not change anything")
+	ErrVersionMismatch = errors.New("profile was changed by someone else")
+)
+
+type patchField struct {
+	column string
+	// struct field for validator.StructPartial
+	name  string
+	value func(p *PatientDetails) *string
+}
+
+// API field name -> column, the same names PatientDetails uses in JSON
+var profilePatchFields = map[string]patchField{
+	"fname":            {"first_name", "FirstName", func(p *PatientDetails) *string { return &p.FirstName }},
+	"middlename":       {"middle_name", "MiddleName", func(p *PatientDetails) *string { return &p.MiddleName }},
+	"lname":            {"last_name", "LastName", func(p *PatientDetails) *string { return &p.LastName }},
+	"sex":              {"sex", "Sex", func(p *PatientDetails) *string { return &p.Sex }},
+	"dob":              {"dob", "DOB", func(p *PatientDetails) *string { return &p.DOB }},
+	"bloodgrp":         {"blood_group", "BloodGroup", func(p *PatientDetails) *string { return &p.BloodGroup }},
+	"bmi":              {"bmi", "BMI", func(p *PatientDetails) *string { return &p.BMI }},
+	"marriage_status":  {"marriage_status", "MarriageStatus", func(p *PatientDetails) *string { return &p.MarriageStatus }},
+	"weight":           {"weight", "Weight", func(p *PatientDetails) *string { return &p.Weight }},
+	"email":            {"email", "Email", func(p *PatientDetails) *string { return &p.Email }},
+	"mobilenumber":     {"mobile_number", "MobileNumber", func(p *PatientDetails) *string { return &p.MobileNumber }},
+	"aadhar_number":    {"aadhaar_number", "AadhaarNumber", func(p *PatientDetails) *string { return &p.AadhaarNumber }},
+	"primary_location": {"primary_location", "PrimaryLocation", func(p *PatientDetails) *string { return &p.PrimaryLocation }},
+	"sibling":          {"sibling", "Sibling", func(p *PatientDetails) *string { return &p.Sibling }},
+	"twin":             {"twin", "Twin", func(p *PatientDetails) *string { return &p.Twin }},
+	"fathername":       {"father_name", "FatherName", func(p *PatientDetails) *string { return &p.FatherName }},
+	"mothername":       {"mother_name", "MotherName", func(p *PatientDetails) *string { return &p.MotherName }},
+	"emergencynumber":  {"emergency_number", "EmergencyNumber", func(p *PatientDetails) *string { return &p.EmergencyNumber }},
+}
+
+// address is patched by its codes and landmark, country/state/city follow from the codes
+var addressPatchFields = map[string]patchField{
+	"region":   {"region_code", "Address.Region", func(p *PatientDetails) *string { return &p.Address.Region }},
+	"zone":     {"zone_code", "Address.Zone", func(p *PatientDetails) *string { return &p.Address.Zone }},
+	"woreda":   {"woreda_code", "Address.Woreda", func(p *PatientDetails) *string { return &p.Address.Woreda }},
+	"kebele":   {"kebele_code", "Address.Kebele", func(p *PatientDetails) *string { return &p.Address.Kebele }},
+	"landmark": {"landmark", "Address.Landmark", func(p *PatientDetails) *string { return &p.Address.Landmark }},
+}
+
+// fields clients see but can never change
+var readOnlyFields = map[string]bool{
+	"health_id":     true,
+	"healthcare_id": true,
+	"created_at":    true,
+	"updated_at":    true,
+	"version":       true,
+	"country":       true,
+	"state":         true,
+	"city":          true,
+	"needs_review":  true,
+}
+
+// ProfilePatch is a parsed merge patch, a nil value is an explicit null
+type ProfilePatch struct {
+	fields  map[string]*string
+	address map[string]*string
+}
+
+// ParseProfilePatch checks every member of the patch against the allow-list, problems are reported
+// per field like any other validation error
+func ParseProfilePatch(body []byte) (*ProfilePatch, error) {
+	members := map[string]json.RawMessage{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if err := decoder.Decode(&members); err != nil {
+		return nil, err
+	}
+
+	patch := &ProfilePatch{fields: map[string]*string{}}
+	verr := &ValidationError{}
+	for name, raw := range members {
+		if name == "address" {
+			patch.address = parseAddressPatch(raw, verr)
+			continue
+		}
+		if _, ok := profilePatchFields[name]; !ok {
+			verr.Fields = append(verr.Fields, unpatchable(name))
+			continue
+		}
+		value, ok := patchString(raw)
+		if !ok {
+			verr.Fields = append(verr.Fields, FieldError{Field: name, Rule: "type", Value: string(raw)})
+			continue
+		}
+		patch.fields[name] = value
+	}
+	if len(verr.Fields) > 0 {
+		sortFieldErrors(verr)
+		return nil, verr
+	}
+	if len(patch.fields) == 0 && len(patch.address) == 0 {
+		return nil, ErrEmptyPatch
+	}
+	return patch, nil
+}
+
+func parseAddressPatch(raw json.RawMessage, verr *ValidationError) map[string]*string {
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		verr.Fields = append(verr.Fields, FieldError{Field: "address", Rule: "required"})
+		return nil
+	}
+	members := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &members); err != nil {
+		verr.Fields = append(verr.Fields, FieldError{Field: "address", Rule: "type", Value: string(raw)})
+		return nil
+	}
+	address := map[string]*string{}
+	for name, member := range members {
+		field := "address." + name
+		if _, ok := addressPatchFields[name]; !ok {
+			verr.Fields = append(verr.Fields, unpatchable(field))
+			continue
+		}
+		value, ok := patchString(member)
+		if !ok {
+			verr.Fields = append(verr.Fields, FieldError{Field: field, Rule: "type", Value: string(member)})
+			continue
+		}
+		address[name] = value
+	}
+	return address
+}
+
+func unpatchable(field string) FieldError {
+	name := field[strings.LastIndex(field, ".")+1:]
+	if readOnlyFields[name] {
+		return FieldError{Field: field, Rule: "readonly"}
+	}
+	return FieldError{Field: field, Rule: "unknown"}
+}
+
+// a string or null, anything else is the wrong type
+func patchString(raw json.RawMessage) (*string, bool) {
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil, true
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, false
+	}
+	value = strings.TrimSpace(value)
+	return &value, true
+}
+
+func sortFieldErrors(verr *ValidationError) {
+	sort.Slice(verr.Fields, func(i, j int) bool {
+		return verr.Fields[i].Field < verr.Fields[j].Field
+	})
+}
+
+// Apply merges the patch into a copy of the profile and validates the touched fields with the
+// PatientDetails rules. It returns the patched profile and the column values to write.
+func (p *ProfilePatch) Apply(current *PatientDetails) (*PatientDetails, map[string]interface{}, error) {
+	patched := *current
+	columns := map[string]interface{}{}
+	validate := []string{}
+
+	for name, value := range p.fields {
+		field := profilePatchFields[name]
+		*field.value(&patched) = derefPatch(value)
+		columns[field.column] = *field.value(&patched)
+		validate = append(validate, field.name)
+	}
+
+	if p.address != nil {
+		for name, value := range p.address {
+			field := addressPatchFields[name]
+			*field.value(&patched) = derefPatch(value)
+			validate = append(validate, field.name)
+		}
+		if err := ValidateAddress(&patched.Address); err != nil {
+			return nil, nil, err
+		}
+		// the whole address is written, an address with valid codes also clears the legacy review flag
+		columns["country"] = patched.Address.Country
+		columns["state"] = patched.Address.State
+		columns["city"] = patched.Address.City
+		columns["landmark"] = patched.Address.Landmark
+		columns["region_code"] = nullIfEmpty(patched.Address.Region)
+		columns["zone_code"] = nullIfEmpty(patched.Address.Zone)
+		columns["woreda_code"] = nullIfEmpty(patched.Address.Woreda)
+		columns["kebele_code"] = nullIfEmpty(patched.Address.Kebele)
+		columns["address_needs_review"] = patched.Address.NeedsReview
+	}
+
+	if err := newValidator().StructPartial(&patched, validate...); err != nil {
+		verr := toValidationError(err)
+		if ve, ok := verr.(*ValidationError); ok {
+			sortFieldErrors(ve)
+		}
+		return nil, nil, verr
+	}
+
+	// keep the search keys in step with the names
+	first, middle, last, father := NameKeys(&patched)
+	for column, key := range map[string]string{"first_name": first, "middle_name": middle, "last_name": last, "father_name": father} {
+		if _, ok := columns[column]; ok {
+			columns[column+"_key"] = key
+		}
+	}
+	return &patched, columns, nil
+}
+
+func derefPatch(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// the address codes are NULL on legacy addresses, like the rows written before the hierarchy
+func nullIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// ProfileETag is the entity tag of a profile version, used with If-Match to prevent lost updates
+func ProfileETag(p *PatientDetails) string {
+	return `"v` + strconv.Itoa(p.Version) + `"`
+}
@@ -0,0 +1,65 @@
+package databases
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseProfilePatchRejectsUnpatchableFields(t *testing.T) {
+	_, err := ParseProfilePatch([]byte(`{"first_name": "x", "health_id": "y", "fname": 3, "address": {"city": "Adama"}}`))
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("err = %v, want *ValidationError", err)
+	}
+	want := []FieldError{
+		{Field: "address.city", Rule: "readonly"},
+		{Field: "first_name", Rule: "unknown"},
+		{Field: "fname", Rule: "type"},
+		{Field: "health_id", Rule: "readonly"},
+	}
+	if len(verr.Fields) != len(want) {
+		t.Fatalf("got %d field errors, want %d: %v", len(verr.Fields), len(want), verr)
+	}
+	for i, field := range verr.Fields {
+		if field.Field != want[i].Field || field.Rule != want[i].Rule {
+			t.Errorf("field error %d = %s/%s, want %s/%s", i, field.Field, field.Rule, want[i].Field, want[i].Rule)
+		}
+	}
+}
+
+func TestProfilePatchApply(t *testing.T) {
+	current := &PatientDetails{HealthID: "HID1", FirstName: "Abebe", MiddleName: "Kebede", Email: "abebe@example.com", Version: 2}
+
+	patch, err := ParseProfilePatch([]byte(`{"fname": " Almaz "}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, columns, err := patch.Apply(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.FirstName != "Almaz" || current.FirstName != "Abebe" {
+		t.Errorf("FirstName = %q (current %q), want Almaz (Abebe)", patched.FirstName, current.FirstName)
+	}
+	if first, _, _, _ := NameKeys(patched); len(columns) != 2 || columns["first_name"] != "Almaz" || columns["first_name_key"] != first {
+		t.Errorf("columns = %v, want first_name and first_name_key", columns)
+	}
+
+	// null clears a field, which the PatientDetails rules don't allow here
+	patch, err = ParseProfilePatch([]byte(`{"middlename": null, "email": "not-an-email"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = patch.Apply(current)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Fields) != 2 {
+		t.Fatalf("err = %v, want email and middlename errors", err)
+	}
+	if verr.Fields[0].Field != "email" || verr.Fields[1].Field != "middlename" {
+		t.Errorf("field errors = %v", verr.Fields)
+	}
+
+	if _, err := ParseProfilePatch([]byte(`{}`)); !errors.Is(err, ErrEmptyPatch) {
+		t.Errorf("empty patch err = %v, want ErrEmptyPatch", err)
+	}
+}
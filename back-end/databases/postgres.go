@@ -2,10 +2,18 @@ package databases
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"log"
+	"sort"
 	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"vaibhavyadav-dev/healthcareServer/notify"
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+	rd "vaibhavyadav-dev/healthcareServer/redis"
+	"vaibhavyadav-dev/healthcareServer/webhooks"
 )
 
 type PostgresStore struct {
@@ -27,102 +35,47 @@ func ConnectToPostgreSQL(url string) (*PostgresStore, error) {
 	}, nil
 }
 
+// Init brings the schema up to date (see migrate.go) and fills what SQL can't
 func (s *PostgresStore) Init() error {
-	return s.CreateTable()
-}
-
-func (s *PostgresStore) CreateTable() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS HIP_TABLE (
-			Id SERIAL PRIMARY KEY,
-			healthcare_id TEXT NOT NULL UNIQUE,
-			healthcare_license TEXT NOT NULL UNIQUE,
-			healthcare_name TEXT NOT NULL UNIQUE,
-			email VARCHAR(100) NOT NULL UNIQUE,
-			availability VARCHAR(15) NOT NULL,
-			total_facilities INTEGER NOT NULL, 
-			total_mbbs_doc INTEGER NOT NULL,
-			total_worker INTEGER NOT NULL, 
-			no_of_beds INTEGER NOT NULL,
-			date_of_registration TIMESTAMP DEFAULT NOW(),
-			password TEXT NOT NULL,
-			about VARCHAR(300) NOT NULL,
-			country VARCHAR(30) NOT NULL,
-			state VARCHAR(20) NOT NULL,
-			city VARCHAR(30) NOT NULL,
-			landmark VARCHAR(45) NOT NULL
-		);`,
-
-		`CREATE TABLE IF NOT EXISTS client_profile (
-			id SERIAL PRIMARY KEY,
-			health_id VARCHAR(150) NOT NULL UNIQUE,
-			first_name VARCHAR(150) NOT NULL,
-			middle_name VARCHAR(150),
-			last_name VARCHAR(150) NOT NULL, 
-			sex VARCHAR(150) NOT NULL,
-			healthcare_id VARCHAR NOT NULL,
-			dob VARCHAR(150) NOT NULL, -- Increased length here
-			blood_group VARCHAR(150) NOT NULL,
-			bmi VARCHAR(150) NOT NULL,
-			marriage_status VARCHAR(150) NOT NULL,
-			weight VARCHAR(150) NOT NULL, 
-			email VARCHAR(150) NOT NULL,
-			mobile_number VARCHAR(150) NOT NULL,
-			aadhaar_number VARCHAR(150) NOT NULL,
-			primary_location VARCHAR(150) NOT NULL,
-			sibling VARCHAR(150) NOT NULL,
-			twin VARCHAR(150) NOT NULL,
-			father_name VARCHAR(150) NOT NULL,
-			mother_name VARCHAR(150) NOT NULL,
-			emergency_number VARCHAR(150) NOT NULL,
-			created_at TIMESTAMP DEFAULT NOW(),
-			updated_at TIMESTAMP DEFAULT NOW(),
-			country VARCHAR(150) NOT NULL,
-			state VARCHAR(150) NOT NULL,
-			city VARCHAR(150) NOT NULL,
-			landmark VARCHAR(150) NOT NULL
-		);`,
-
-		`CREATE TABLE IF NOT EXISTS client_stats (
-			health_id VARCHAR PRIMARY KEY UNIQUE,
-			account_status VARCHAR CHECK (account_status IN ('Trial', 'Testing', 'Beta', 'Premium')) NOT NULL DEFAULT 'Trial',
-			available_money VARCHAR NOT NULL DEFAULT '5000',
-			profile_viewed INTEGER NOT NULL DEFAULT 0,
-			profile_updated INTEGER NOT NULL DEFAULT 0,
-			records_viewed INTEGER NOT NULL DEFAULT 0,
-			records_created INTEGER NOT NULL DEFAULT 0,
-			FOREIGN KEY (health_id) REFERENCES client_profile(health_id) ON DELETE CASCADE
-		);`,
-
-		`CREATE TABLE IF NOT EXISTS HealthCare_pref (
-			Id SERIAL PRIMARY KEY,
-			healthcare_id TEXT NOT NULL,
-			scheduled_deletion VARCHAR(20),
-			profile_viewed INTEGER,
-			profile_updated INTEGER NOT NULL,
-			account_locked VARCHAR(15) NOT NULL,
-			records_created INTEGER NOT NULL,
-			records_viewed INTEGER NOT NULL,
-			totalrequest_count INTEGER NOT NULL,
-			appointmentFee INTEGER NOT NULL,
-			isAvailable VARCHAR(20) NOT NULL,
-			FOREIGN KEY (healthcare_id) REFERENCES HIP_TABLE(healthcare_id) ON DELETE CASCADE
-		);`,
-		// Appointments table
-		`CREATE TABLE IF NOT EXISTS appointments (
-			id SERIAL PRIMARY KEY,
-			health_id VARCHAR(150) NOT NULL,
-			healthcare_id VARCHAR(150) NOT NULL,
-			appointment_date TIMESTAMP NOT NULL,
-			status VARCHAR(50) NOT NULL DEFAULT 'pending',
-			notes TEXT,
-			created_at TIMESTAMP DEFAULT NOW(),
-			updated_at TIMESTAMP DEFAULT NOW(),
-			FOREIGN KEY (health_id) REFERENCES client_profile(health_id) ON DELETE CASCADE
-		);`,
-	}
-	for _, query := range queries {
-		_, err := s.db.Exec(query)
+	migrator, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up()
+	if err != nil {
+		return err
+	}
+	for _, migration := range applied {
+		log.Printf("applied migration %04d_%s", migration.Version, migration.Name)
+	}
+	return s.backfillNameKeys()
+}
+
+// profiles created before the name keys existed, keys are computed in Go so SQL can't fill them
+func (s *PostgresStore) backfillNameKeys() error {
+	rows, err := s.db.Query(`SELECT health_id, first_name, COALESCE(middle_name, ''), last_name, father_name
+		FROM client_profile WHERE first_name_key IS NULL;`)
+	if err != nil {
+		return err
+	}
+	var pending []*PatientDetails
+	for rows.Next() {
+		p := &PatientDetails{}
+		if err := rows.Scan(&p.HealthID, &p.FirstName, &p.MiddleName, &p.LastName, &p.FatherName); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range pending {
+		first, middle, last, father := NameKeys(p)
+		_, err := s.db.Exec(`UPDATE client_profile SET first_name_key = $1, middle_name_key = $2, last_name_key = $3, father_name_key = $4
+			WHERE health_id = $5;`, first, middle, last, father, p.HealthID)
 		if err != nil {
 			return err
 		}
@@ -130,12 +83,13 @@ func (s *PostgresStore) CreateTable() error {
 	return nil
 }
 
-func (s *PostgresStore) SignUpAccount(hip *HIPInfo) (int64, error) {
+// SignUpAccount creates the HIP, messages go to the outbox in the same transaction
+func (s *PostgresStore) SignUpAccount(hip *HIPInfo, messages ...mq.Message) (int64, error) {
 	query := `INSERT INTO HIP_TABLE (healthcare_id, healthcare_license, 
 		healthcare_name, email, availability, total_facilities, 
 		total_mbbs_doc, total_worker, no_of_beds, password, about, country, 
-		state, city, landmark)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) RETURNING healthcare_id`
+		state, city, landmark, region_code, zone_code, woreda_code, kebele_code, address_needs_review)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, NULLIF($16, ''), NULLIF($17, ''), NULLIF($18, ''), NULLIF($19, ''), $20) RETURNING healthcare_id`
 
 	query1 := `INSERT INTO HealthCare_pref (healthcare_id, scheduled_deletion, profile_viewed, 
 		profile_updated, account_locked, records_created, records_viewed, 
@@ -151,18 +105,30 @@ func (s *PostgresStore) SignUpAccount(hip *HIPInfo) (int64, error) {
 		return 0, fmt.Errorf("email %s already exists", hip.Email)
 	}
 
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	// Insert into HIP_TABLE and get the generated healthcare_id
 	var healthcareID string
-	err = s.db.QueryRow(query, hip.HealthcareID, hip.HealthcareLicense, hip.HealthcareName, hip.Email, hip.Availability, hip.TotalFacilities, hip.TotalMBBSDoc, hip.TotalWorker, hip.NoOfBeds, hip.Password, hip.About, hip.Address.Country, hip.Address.State, hip.Address.City, hip.Address.Landmark).Scan(&healthcareID)
+	err = tx.QueryRow(query, hip.HealthcareID, hip.HealthcareLicense, hip.HealthcareName, hip.Email, hip.Availability, hip.TotalFacilities, hip.TotalMBBSDoc, hip.TotalWorker, hip.NoOfBeds, hip.Password, hip.About, hip.Address.Country, hip.Address.State, hip.Address.City, hip.Address.Landmark, hip.Address.Region, hip.Address.Zone, hip.Address.Woreda, hip.Address.Kebele, hip.Address.NeedsReview).Scan(&healthcareID)
 	if err != nil {
 		return 0, err
 	}
 
 	// Insert into HealthCare_Logs using the healthcare_id
-	Id, err := s.db.Exec(query1, healthcareID, "false", 0, 0, "false", 0, 0, 100, 100, "true")
+	Id, err := tx.Exec(query1, healthcareID, "false", 0, 0, "false", 0, 0, loginQuota, 100, "true")
 	if err != nil {
 		return 0, err
 	}
+	if err := insertOutbox(tx, messages); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
 	Inserted_id, _ := Id.LastInsertId()
 	return Inserted_id, nil
 }
@@ -179,10 +145,16 @@ func (s *PostgresStore) LoginUser(acc *Login) (*HIPInfo, error) {
 	return &hip, nil
 }
 
-func (s *PostgresStore) ChangePreferance(healthcareId string, preferance map[string]interface{}) error {
+func (s *PostgresStore) ChangePreferance(healthcareId string, preferance map[string]interface{}, messages ...mq.Message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	for key, value := range preferance {
 		if key == "email" && value != "" {
-			_, err := s.db.Exec("UPDATE HIP_TABLE set email = $1 WHERE healthcare_id = $2", value, healthcareId)
+			_, err := tx.Exec("UPDATE HIP_TABLE set email = $1 WHERE healthcare_id = $2", value, healthcareId)
 			if err != nil {
 				return err
 			}
@@ -190,7 +162,7 @@ func (s *PostgresStore) ChangePreferance(healthcareId string, preferance map[str
 	}
 	for key, value := range preferance {
 		if key == "scheduled_deletion" && value != "" {
-			_, err := s.db.Exec("UPDATE HealthCare_pref set scheduled_deletion = $1 WHERE healthcare_id = $2", value, healthcareId)
+			_, err := tx.Exec("UPDATE HealthCare_pref set scheduled_deletion = $1 WHERE healthcare_id = $2", value, healthcareId)
 			if err != nil {
 				return err
 			}
@@ -198,13 +170,16 @@ func (s *PostgresStore) ChangePreferance(healthcareId string, preferance map[str
 	}
 	for key, value := range preferance {
 		if key == "isAvailable" && value != "" {
-			_, err := s.db.Exec("UPDATE HealthCare_pref set isAvailable = $1 WHERE healthcare_id = $2", value, healthcareId)
+			_, err := tx.Exec("UPDATE HealthCare_pref set isAvailable = $1 WHERE healthcare_id = $2", value, healthcareId)
 			if err != nil {
 				return err
 			}
 		}
 	}
-	return nil
+	if err := insertOutbox(tx, messages); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (s *PostgresStore) GetPreferance(healthcareId string) (*Preferance, error) {
@@ -239,7 +214,9 @@ func (s *PostgresStore) GetHealthcare_details(healthcare_id string) (*HIPInfo, e
 	query := `SELECT 
 		healthcare_id, healthcare_license, healthcare_name, email, availability, 
 		total_facilities, total_mbbs_doc, total_worker, no_of_beds, 
-		date_of_registration, password, about, country, state, city, landmark
+		date_of_registration, password, about, country, state, city, landmark,
+		COALESCE(region_code, ''), COALESCE(zone_code, ''), COALESCE(woreda_code, ''),
+		COALESCE(kebele_code, ''), address_needs_review
 		FROM HIP_TABLE
 		WHERE healthcare_id = $1;`
 
@@ -251,6 +228,8 @@ func (s *PostgresStore) GetHealthcare_details(healthcare_id string) (*HIPInfo, e
 		&hip.TotalFacilities, &hip.TotalMBBSDoc, &hip.TotalWorker, &hip.NoOfBeds,
 		&hip.DateOfRegistration, &hip.Password, &hip.About, &hip.Address.Country,
 		&hip.Address.State, &hip.Address.City, &hip.Address.Landmark,
+		&hip.Address.Region, &hip.Address.Zone, &hip.Address.Woreda,
+		&hip.Address.Kebele, &hip.Address.NeedsReview,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -262,42 +241,60 @@ func (s *PostgresStore) GetHealthcare_details(healthcare_id string) (*HIPInfo, e
 }
 
 // create client_profile
-func (s *PostgresStore) Create_ClientProfile(client *PatientDetails) error {
+func (s *PostgresStore) Create_ClientProfile(client *PatientDetails, messages ...mq.Message) error {
 	query := `INSERT INTO client_profile (
 		health_id, first_name, middle_name, last_name, sex, healthcare_id, 
 		dob, blood_group, bmi, marriage_status, weight, email, 
 		mobile_number, aadhaar_number, primary_location, sibling, twin, 
-		father_name, mother_name, emergency_number, created_at, updated_at, country, city, state, landmark
+		father_name, mother_name, emergency_number, created_at, updated_at, country, city, state, landmark,
+		region_code, zone_code, woreda_code, kebele_code, address_needs_review,
+		first_name_key, middle_name_key, last_name_key, father_name_key
 	) VALUES (
 		$1, $2, $3, $4, $5, $6, 
 		$7, $8, $9, $10, $11, $12, 
 		$13, $14, $15, $16, $17, 
-		$18, $19, $20, $21, $22, $23, $24, $25, $26
+		$18, $19, $20, $21, $22, $23, $24, $25, $26,
+		NULLIF($27, ''), NULLIF($28, ''), NULLIF($29, ''), NULLIF($30, ''), $31,
+		$32, $33, $34, $35
 	);`
 
-	_, err := s.db.Exec(query, client.HealthID, client.FirstName, client.MiddleName, client.LastName, client.Sex,
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	firstKey, middleKey, lastKey, fatherKey := NameKeys(client)
+	_, err = tx.Exec(query, client.HealthID, client.FirstName, client.MiddleName, client.LastName, client.Sex,
 		client.HealthcareID, client.DOB, client.BloodGroup, client.BMI,
 		client.MarriageStatus, client.Weight, client.Email, client.MobileNumber,
 		client.AadhaarNumber, client.PrimaryLocation, client.Sibling, client.Twin,
 		client.FatherName, client.MotherName, client.EmergencyNumber, client.CreatedAt, client.UpdatedAt,
-		client.Address.Country, client.Address.City, client.Address.State, client.Address.Landmark)
+		client.Address.Country, client.Address.City, client.Address.State, client.Address.Landmark,
+		client.Address.Region, client.Address.Zone, client.Address.Woreda, client.Address.Kebele, client.Address.NeedsReview,
+		firstKey, middleKey, lastKey, fatherKey)
 	if err != nil {
 		return err
 	}
-
-	return nil
+	if err := insertOutbox(tx, messages); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (s *PostgresStore) Get_ClientProfile(health_id string) (*PatientDetails, error) {
-	query := `SELECT health_id, first_name, middle_name, last_name, sex, healthcare_id, 
+// columns of client_profile in the order scanClientProfile expects them
+const clientProfileColumns = `health_id, first_name, COALESCE(middle_name, ''), last_name, sex, healthcare_id, 
 	dob, blood_group, bmi, marriage_status, weight, email, 
 	mobile_number, aadhaar_number, primary_location, sibling, twin, 
-	father_name, mother_name, emergency_number, created_at, updated_at, country, city, state, landmark
-	FROM client_profile
-	WHERE health_id = $1;`
+	father_name, mother_name, emergency_number, created_at, updated_at, country, city, state, landmark,
+	COALESCE(region_code, ''), COALESCE(zone_code, ''), COALESCE(woreda_code, ''), COALESCE(kebele_code, ''),
+	address_needs_review, version`
 
-	row := s.db.QueryRow(query, health_id)
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
+func scanClientProfile(row rowScanner) (*PatientDetails, error) {
 	var client PatientDetails
 	err := row.Scan(
 		&client.HealthID, &client.FirstName, &client.MiddleName, &client.LastName, &client.Sex, &client.HealthcareID,
@@ -305,38 +302,100 @@ func (s *PostgresStore) Get_ClientProfile(health_id string) (*PatientDetails, er
 		&client.MobileNumber, &client.AadhaarNumber, &client.PrimaryLocation, &client.Sibling, &client.Twin,
 		&client.FatherName, &client.MotherName, &client.EmergencyNumber, &client.CreatedAt, &client.UpdatedAt,
 		&client.Address.Country, &client.Address.City, &client.Address.State, &client.Address.Landmark,
+		&client.Address.Region, &client.Address.Zone, &client.Address.Woreda, &client.Address.Kebele,
+		&client.Address.NeedsReview, &client.Version,
 	)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (s *PostgresStore) Get_ClientProfile(health_id string) (*PatientDetails, error) {
+	// a merged health_id keeps working, it resolves to the profile it was merged into
+	query := `SELECT ` + clientProfileColumns + `
+	FROM client_profile
+	WHERE health_id = (SELECT COALESCE(merged_into, health_id) FROM client_profile WHERE health_id = $1);`
 
+	client, err := scanClientProfile(s.db.QueryRow(query, health_id))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("no client found with health ID: %s", health_id)
+			return nil, fmt.Errorf("no client found with health ID %s: %w", health_id, ErrPatientNotFound)
 		}
 		return nil, err
 	}
-	return &client, nil
+	return client, nil
 }
 
-func (s *PostgresStore) UpdateClientProfile(healthID string, updates map[string]interface{}) (*PatientDetails, error) {
-	setClause := []string{}
-	values := []interface{}{}
-	counter := 1
-
-	// Iterate over the updates map to prepare the SET clause
-	for key, value := range updates {
-		if value != "" && value != "N/A" && key != "healthcare_id" && key != "health_id" {
-			setClause = append(setClause, fmt.Sprintf("%s = $%d", key, counter))
-			values = append(values, value)
-			counter++
+// Profiles of this HIP whose address is still legacy free text
+func (s *PostgresStore) GetAddressReviewQueue(healthcare_id string, limit int64) ([]*PatientDetails, error) {
+	query := `SELECT ` + clientProfileColumns + `
+	FROM client_profile
+	WHERE healthcare_id = $1 AND address_needs_review AND merged_into IS NULL
+	ORDER BY created_at
+	LIMIT $2;`
+
+	rows, err := s.db.Query(query, healthcare_id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var clients []*PatientDetails
+	for rows.Next() {
+		client, err := scanClientProfile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		clients = append(clients, client)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return clients, nil
+}
+
+// UpdateClientProfile applies a merge patch and keeps the replaced version in client_profile_versions.
+// ifVersion > 0 makes the update fail with ErrVersionMismatch when the profile moved on in the meantime.
+func (s *PostgresStore) UpdateClientProfile(healthID string, patch *ProfilePatch, ifVersion int, change ProfileChange) (*PatientDetails, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// lock the row so two updates can't both claim the same version
+	previous, err := scanClientProfile(tx.QueryRow(`SELECT `+clientProfileColumns+` FROM client_profile WHERE health_id = $1 FOR UPDATE;`, healthID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no client profile found with health_id %s: %w", healthID, ErrPatientNotFound)
 		}
+		return nil, err
+	}
+	if ifVersion > 0 && previous.Version != ifVersion {
+		return previous, ErrVersionMismatch
 	}
 
-	// If no valid fields to update, return an error
-	if len(setClause) == 0 {
-		return nil, fmt.Errorf("no valid fields to update")
+	_, updates, err := patch.Apply(previous)
+	if err != nil {
+		return nil, err
+	}
+
+	// column names only ever come from the patch allow-list
+	columns := make([]string, 0, len(updates))
+	for column := range updates {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	setClause := []string{}
+	values := []interface{}{}
+	for i, column := range columns {
+		setClause = append(setClause, fmt.Sprintf("%s = $%d", column, i+1))
+		values = append(values, updates[column])
 	}
 
 	// Append the updated_at field to always update the timestamp
-	setClause = append(setClause, fmt.Sprintf("updated_at = NOW()"))
+	setClause = append(setClause, "updated_at = NOW()", "version = version + 1")
 
 	// Add the health_id as the last parameter for the WHERE clause
 	values = append(values, healthID)
@@ -346,28 +405,591 @@ func (s *PostgresStore) UpdateClientProfile(healthID string, updates map[string]
 		UPDATE client_profile
 		SET %s
 		WHERE health_id = $%d
-		RETURNING *;
-	`, strings.Join(setClause, ", "), counter)
+		RETURNING %s;
+	`, strings.Join(setClause, ", "), len(values), clientProfileColumns)
 
 	// Execute the update query
-	row := s.db.QueryRow(query, values...)
-	var updatedClient PatientDetails
-	err := row.Scan(
-		&updatedClient.ID, &updatedClient.HealthID, &updatedClient.FirstName, &updatedClient.MiddleName,
-		&updatedClient.LastName, &updatedClient.Sex, &updatedClient.HealthcareID, &updatedClient.DOB,
-		&updatedClient.BloodGroup, &updatedClient.BMI, &updatedClient.MarriageStatus, &updatedClient.Weight,
-		&updatedClient.Email, &updatedClient.MobileNumber, &updatedClient.AadhaarNumber, &updatedClient.PrimaryLocation,
-		&updatedClient.Sibling, &updatedClient.Twin, &updatedClient.FatherName, &updatedClient.MotherName,
-		&updatedClient.EmergencyNumber, &updatedClient.CreatedAt, &updatedClient.UpdatedAt, &updatedClient.Address.Country,
-		&updatedClient.Address.City, &updatedClient.Address.State, &updatedClient.Address.Landmark)
+	updatedClient, err := scanClientProfile(tx.QueryRow(query, values...))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertProfileVersion(tx, previous, updatedClient, change); err != nil {
+		return nil, err
+	}
+	messages, err := change.messages(previous, updatedClient)
+	if err != nil {
+		return nil, err
+	}
+	if err := insertOutbox(tx, messages); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return updatedClient, nil
+}
+
+// keeps previous as an immutable version, valid from when it was written until now
+func insertProfileVersion(tx *sql.Tx, previous, current *PatientDetails, change ProfileChange) error {
+	snapshot, err := json.Marshal(previous)
+	if err != nil {
+		return fmt.Errorf("failed to encode profile version: %w", err)
+	}
+	// the first version covers the profile since it was created, even if it was edited in place before history existed
+	validFrom := previous.UpdatedAt
+	if previous.Version <= 1 {
+		validFrom = previous.CreatedAt
+	}
+	_, err = tx.Exec(`INSERT INTO client_profile_versions (health_id, version, snapshot, changed_fields, changed_by, reason, valid_from)
+		VALUES ($1, $2, $3, $4, $5, $6, $7);`,
+		previous.HealthID, previous.Version, snapshot, mustJSON(ChangedFields(previous, current)), change.ChangedBy, change.Reason, validFrom)
+	if err != nil {
+		return fmt.Errorf("failed to store profile version: %w", err)
+	}
+	return nil
+}
+
+// Versions of a profile newest first, without the snapshots
+func (s *PostgresStore) ListProfileVersions(health_id string) ([]*ProfileVersion, error) {
+	rows, err := s.db.Query(`SELECT version, changed_by, reason, valid_from, changed_at, changed_fields
+		FROM client_profile_versions WHERE health_id = $1 ORDER BY version DESC;`, health_id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*ProfileVersion
+	for rows.Next() {
+		version, err := scanProfileVersion(rows, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return versions, nil
+}
+
+func scanProfileVersion(row rowScanner, withSnapshot bool) (*ProfileVersion, error) {
+	version := &ProfileVersion{}
+	var changedFields, snapshot []byte
+	dest := []any{&version.Version, &version.ChangedBy, &version.Reason, &version.ValidFrom, &version.ChangedAt, &changedFields}
+	if withSnapshot {
+		dest = append(dest, &snapshot)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(changedFields, &version.ChangedFields); err != nil {
+		return nil, fmt.Errorf("broken changed_fields: %w", err)
+	}
+	if withSnapshot {
+		version.Profile = &PatientDetails{}
+		if err := json.Unmarshal(snapshot, version.Profile); err != nil {
+			return nil, fmt.Errorf("broken profile snapshot: %w", err)
+		}
+	}
+	return version, nil
+}
+
+// GetProfileVersion returns one stored version, the current profile counts as its latest version
+func (s *PostgresStore) GetProfileVersion(health_id string, version int) (*PatientDetails, error) {
+	current, err := s.Get_ClientProfile(health_id)
+	if err != nil {
+		return nil, err
+	}
+	if version == current.Version {
+		return current, nil
+	}
+	stored, err := scanProfileVersion(s.db.QueryRow(`SELECT version, changed_by, reason, valid_from, changed_at, changed_fields, snapshot
+		FROM client_profile_versions WHERE health_id = $1 AND version = $2;`, current.HealthID, version), true)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrVersionNotFound
+		}
+		return nil, err
+	}
+	return stored.Profile, nil
+}
+
+// GetClientProfileAsOf returns the profile as it was at the given time
+func (s *PostgresStore) GetClientProfileAsOf(health_id string, at time.Time) (*PatientDetails, error) {
+	current, err := s.Get_ClientProfile(health_id)
+	if err != nil {
+		return nil, err
+	}
+	if at.Before(current.CreatedAt) {
+		return nil, ErrVersionNotFound
+	}
+	stored, err := scanProfileVersion(s.db.QueryRow(`SELECT version, changed_by, reason, valid_from, changed_at, changed_fields, snapshot
+		FROM client_profile_versions WHERE health_id = $1 AND valid_from <= $2 AND changed_at > $2
+		ORDER BY version DESC LIMIT 1;`, current.HealthID, at), true)
+	if err == sql.ErrNoRows {
+		// no version was replaced after that moment, so it is still the current one
+		return current, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stored.Profile, nil
+}
+
+// Patients of this HIP matching the search, ranked by how close the names are
+func (s *PostgresStore) SearchClientProfiles(healthcare_id string, q *PatientSearch) ([]*PatientMatch, error) {
+	where := []string{"healthcare_id = $1", "merged_into IS NULL"}
+	args := []interface{}{healthcare_id}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if prefixes := searchKeyPrefixes(q); len(prefixes) > 0 {
+		names := []string{}
+		for _, prefix := range prefixes {
+			p := arg(prefix + "%")
+			names = append(names, fmt.Sprintf("first_name_key LIKE %[1]s OR middle_name_key LIKE %[1]s OR last_name_key LIKE %[1]s OR father_name_key LIKE %[1]s", p))
+		}
+		where = append(where, "("+strings.Join(names, " OR ")+")")
+	}
+	if phone := phoneDigits(q.Phone); phone != "" {
+		p := arg("%" + phone)
+		where = append(where, fmt.Sprintf("(regexp_replace(mobile_number, '[^0-9]', '', 'g') LIKE %[1]s OR regexp_replace(emergency_number, '[^0-9]', '', 'g') LIKE %[1]s)", p))
+	}
+	if dob := strings.TrimSpace(q.DOB); dob != "" {
+		where = append(where, "dob = "+arg(dob))
+	}
+	for column, code := range map[string]string{"region_code": q.Region, "zone_code": q.Zone, "woreda_code": q.Woreda} {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			where = append(where, column+" = "+arg(code))
+		}
+	}
+
+	query := `SELECT ` + clientProfileColumns + `
+	FROM client_profile
+	WHERE ` + strings.Join(where, " AND ") + `
+	` + searchOrder(q, arg) + `
+	LIMIT ` + arg(searchCandidateLimit) + `;`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var candidates []*PatientDetails
+	for rows.Next() {
+		client, err := scanClientProfile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		candidates = append(candidates, client)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return RankPatients(q, candidates), nil
+}
+
+// FindDuplicates compares a profile against every live profile that shares an ID, phone, name key or DOB
+// and queues the likely pairs for review
+func (s *PostgresStore) FindDuplicates(patient *PatientDetails) ([]*DuplicateCandidate, error) {
+	firstKey, _, lastKey, fatherKey := NameKeys(patient)
+	firstPrefix := firstKey
+	if len(firstPrefix) > 2 {
+		firstPrefix = firstPrefix[:2]
+	}
+	query := `SELECT ` + clientProfileColumns + `
+	FROM client_profile
+	WHERE health_id <> $1 AND merged_into IS NULL AND (
+		($2 <> '' AND aadhaar_number = $2)
+		OR ($3 <> '' AND RIGHT(regexp_replace(mobile_number, '[^0-9]', '', 'g'), 9) = $3)
+		OR (first_name_key = $4 AND (father_name_key = $5 OR last_name_key = $6))
+		OR (dob = $7 AND first_name_key LIKE $8 || '%')
+	)
+	LIMIT $9;`
+
+	rows, err := s.db.Query(query, patient.HealthID, normalizeID(patient.AadhaarNumber), nationalNumber(patient.MobileNumber),
+		firstKey, fatherKey, lastKey, patient.DOB, firstPrefix, duplicateCandidateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	var candidates []*PatientDetails
+	for rows.Next() {
+		client, err := scanClientProfile(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		candidates = append(candidates, client)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	duplicates := RankDuplicates(patient, candidates)
+	for _, duplicate := range duplicates {
+		fields, _ := json.Marshal(duplicate.Fields)
+		// the pair may already be known from the other side, ON CONFLICT keeps the first one
+		err := s.db.QueryRow(`INSERT INTO duplicate_candidates (health_id, candidate_health_id, score, fields)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING
+			RETURNING id, created_at;`,
+			duplicate.HealthID, duplicate.CandidateHealthID, duplicate.Score, fields).Scan(&duplicate.ID, &duplicate.CreatedAt)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to queue duplicate: %w", err)
+		}
+	}
+	return duplicates, nil
+}
+
+// Pairs waiting for review where this HIP registered at least one of the two patients
+func (s *PostgresStore) GetDuplicateQueue(healthcare_id, status string, limit int64) ([]*DuplicateCandidate, error) {
+	query := `SELECT d.id, d.health_id, d.candidate_health_id, d.score, d.fields, d.status, d.created_at, COALESCE(d.reviewed_by, ''), p.healthcare_id = $2
+	FROM duplicate_candidates d
+	JOIN client_profile p ON p.health_id = d.health_id
+	JOIN client_profile c ON c.health_id = d.candidate_health_id
+	WHERE d.status = $1 AND (p.healthcare_id = $2 OR c.healthcare_id = $2)
+	ORDER BY d.score DESC, d.created_at
+	LIMIT $3;`
+
+	rows, err := s.db.Query(query, status, healthcare_id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	var duplicates []*DuplicateCandidate
+	var owns []bool
+	for rows.Next() {
+		duplicate := &DuplicateCandidate{}
+		var ownsPatient bool
+		var fields []byte
+		err := rows.Scan(&duplicate.ID, &duplicate.HealthID, &duplicate.CandidateHealthID, &duplicate.Score,
+			&fields, &duplicate.Status, &duplicate.CreatedAt, &duplicate.ReviewedBy, &ownsPatient)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if err := json.Unmarshal(fields, &duplicate.Fields); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read the fields of duplicate %d: %w", duplicate.ID, err)
+		}
+		duplicates = append(duplicates, duplicate)
+		owns = append(owns, ownsPatient)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	// only the reviewer's own patient is shown in full, the other one may be another HIP's
+	profile := func(healthID string) (*PatientDetails, error) {
+		return scanClientProfile(s.db.QueryRow(`SELECT `+clientProfileColumns+` FROM client_profile WHERE health_id = $1;`, healthID))
+	}
+	for i, duplicate := range duplicates {
+		if err := reviewDuplicate(duplicate, owns[i], profile); err != nil {
+			return nil, err
+		}
+	}
+	return duplicates, nil
+}
+
+func (s *PostgresStore) DismissDuplicate(healthcare_id string, id int64) error {
+	result, err := s.db.Exec(`UPDATE duplicate_candidates d SET status = 'dismissed', reviewed_by = $1, reviewed_at = NOW()
+		WHERE d.id = $2 AND d.status = 'pending' AND EXISTS (
+			SELECT 1 FROM client_profile p
+			WHERE p.health_id IN (d.health_id, d.candidate_health_id) AND p.healthcare_id = $1
+		);`, healthcare_id, id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrDuplicateNotFound
+	}
+	return nil
+}
+
+// MergeClientProfiles folds merged into surviving: appointments and client_stats counters move over,
+// the merged profile stays (so unmerge is possible) but points at the survivor.
+// Patient records live in mongo and are moved by the caller, see CombinedStore.MergeClientProfiles
+func (s *PostgresStore) MergeClientProfiles(healthcare_id, surviving, merged string) (*PatientMerge, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	owners, err := lockMergePair(tx, surviving, merged)
+	if err != nil {
+		return nil, err
+	}
+	approver, err := mergeApprover(owners, healthcare_id)
+	if err != nil {
+		return nil, err
+	}
+	if approver != "" {
+		return nil, fmt.Errorf("%w: %s registered one of the patients", ErrMergeApprovalRequired, approver)
+	}
+	merge, err := mergeProfiles(tx, healthcare_id, surviving, merged)
+	if err != nil {
+		return nil, err
+	}
+	return merge, tx.Commit()
+}
+
+// RequestMerge asks the HIP that registered the other patient to approve the merge
+func (s *PostgresStore) RequestMerge(healthcare_id, surviving, merged string) (*MergeRequest, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	owners, err := lockMergePair(tx, surviving, merged)
+	if err != nil {
+		return nil, err
+	}
+	request, err := requestMerge(tx, owners, healthcare_id, surviving, merged, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return request, tx.Commit()
+}
+
+func (s *PostgresStore) ListMergeRequests(healthcare_id, status string, limit int64) ([]*MergeRequest, error) {
+	return listMergeRequests(s.db, healthcare_id, status, limit)
+}
+
+// ApproveMergeRequest merges the patients of a request, records are moved by the caller like in MergeClientProfiles
+func (s *PostgresStore) ApproveMergeRequest(healthcare_id string, id int64) (*PatientMerge, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	request, err := decideMergeRequest(tx, healthcare_id, id, "approved", time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	owners, err := lockMergePair(tx, request.SurvivingHealthID, request.MergedHealthID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMergeRequest(owners, request); err != nil {
+		return nil, err
+	}
+	merge, err := mergeProfiles(tx, request.RequestedBy, request.SurvivingHealthID, request.MergedHealthID)
+	if err != nil {
+		return nil, err
+	}
+	if err := setMergeRequestMerge(tx, request, merge.ID); err != nil {
+		return nil, err
+	}
+	return merge, tx.Commit()
+}
+
+func (s *PostgresStore) RejectMergeRequest(healthcare_id string, id int64) (*MergeRequest, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	request, err := decideMergeRequest(tx, healthcare_id, id, "rejected", time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return request, tx.Commit()
+}
+
+// mergeProfiles does the merge of a locked pair in tx
+func mergeProfiles(tx *sql.Tx, healthcare_id, surviving, merged string) (*PatientMerge, error) {
+	moves := MergeMoves{Appointments: []int64{}, Records: []string{}}
+	rows, err := tx.Query(`UPDATE appointments SET health_id = $1, updated_at = NOW() WHERE health_id = $2 RETURNING id;`, surviving, merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move appointments: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		moves.Appointments = append(moves.Appointments, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(`SELECT profile_viewed, profile_updated, records_viewed, records_created FROM client_stats WHERE health_id = $1;`, merged).
+		Scan(&moves.Stats.ProfileViewed, &moves.Stats.ProfileUpdated, &moves.Stats.RecordsViewed, &moves.Stats.RecordsCreated)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to read client_stats: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE client_stats SET profile_viewed = profile_viewed + $2, profile_updated = profile_updated + $3,
+		records_viewed = records_viewed + $4, records_created = records_created + $5 WHERE health_id = $1;`,
+		surviving, moves.Stats.ProfileViewed, moves.Stats.ProfileUpdated, moves.Stats.RecordsViewed, moves.Stats.RecordsCreated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move client_stats: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE client_stats SET profile_viewed = 0, profile_updated = 0, records_viewed = 0, records_created = 0 WHERE health_id = $1;`, merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset client_stats: %w", err)
+	}
 
+	if _, err := tx.Exec(`UPDATE client_profile SET merged_into = $1, updated_at = NOW() WHERE health_id = $2;`, surviving, merged); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE duplicate_candidates SET status = 'merged', reviewed_by = $3, reviewed_at = NOW()
+		WHERE status = 'pending' AND LEAST(health_id, candidate_health_id) = LEAST($1, $2) AND GREATEST(health_id, candidate_health_id) = GREATEST($1, $2);`,
+		surviving, merged, healthcare_id)
+	if err != nil {
+		return nil, err
+	}
+
+	merge := &PatientMerge{SurvivingHealthID: surviving, MergedHealthID: merged, MergedBy: healthcare_id, Moved: moves}
+	movedJSON, _ := json.Marshal(moves)
+	err = tx.QueryRow(`INSERT INTO patient_merges (surviving_health_id, merged_health_id, merged_by, moved)
+		VALUES ($1, $2, $3, $4) RETURNING id, merged_at;`, surviving, merged, healthcare_id, movedJSON).Scan(&merge.ID, &merge.MergedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record merge: %w", err)
+	}
+	return merge, nil
+}
+
+// both profiles must exist and be live, owners maps them to the HIPs that registered them
+func lockMergePair(tx *sql.Tx, surviving, merged string) (map[string]string, error) {
+	if surviving == merged {
+		return nil, fmt.Errorf("%w: cannot merge %s into itself", ErrAlreadyMerged, merged)
+	}
+	rows, err := tx.Query(`SELECT health_id, healthcare_id, COALESCE(merged_into, '') FROM client_profile
+		WHERE health_id IN ($1, $2) FOR UPDATE;`, surviving, merged)
+	if err != nil {
+		return nil, err
+	}
+	return scanMergePair(rows)
+}
+
+func scanMergePair(rows *sql.Rows) (map[string]string, error) {
+	defer rows.Close()
+	owners := map[string]string{}
+	for rows.Next() {
+		var healthID, owner, mergedInto string
+		if err := rows.Scan(&healthID, &owner, &mergedInto); err != nil {
+			return nil, err
+		}
+		if mergedInto != "" {
+			return nil, fmt.Errorf("%w: %s", ErrAlreadyMerged, healthID)
+		}
+		owners[healthID] = owner
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(owners) != 2 {
+		return nil, ErrPatientNotFound
+	}
+	return owners, nil
+}
+
+func (s *PostgresStore) GetMerge(mergeID int64) (*PatientMerge, error) {
+	merge := &PatientMerge{}
+	var unmergedBy sql.NullString
+	var moved []byte
+	err := s.db.QueryRow(`SELECT id, surviving_health_id, merged_health_id, merged_by, merged_at, unmerged_by, unmerged_at, moved
+		FROM patient_merges WHERE id = $1;`, mergeID).Scan(&merge.ID, &merge.SurvivingHealthID, &merge.MergedHealthID,
+		&merge.MergedBy, &merge.MergedAt, &unmergedBy, &merge.UnmergedAt, &moved)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("no client profile found with health_id %s", healthID)
+			return nil, ErrMergeNotFound
 		}
 		return nil, err
 	}
-	return &updatedClient, nil
+	merge.UnmergedBy = unmergedBy.String
+	if err := json.Unmarshal(moved, &merge.Moved); err != nil {
+		return nil, fmt.Errorf("failed to read what merge %d moved: %w", merge.ID, err)
+	}
+	return merge, nil
+}
+
+// SetMergedRecords stores the mongo record ids a merge moved
+func (s *PostgresStore) SetMergedRecords(mergeID int64, records []string) error {
+	_, err := s.db.Exec(`UPDATE patient_merges SET moved = jsonb_set(moved, '{records}', $2::jsonb) WHERE id = $1;`,
+		mergeID, mustJSON(records))
+	return err
+}
+
+// UnmergeClientProfiles puts back exactly what the merge moved, anything created on the survivor since stays there
+func (s *PostgresStore) UnmergeClientProfiles(healthcare_id string, mergeID int64) (*PatientMerge, error) {
+	merge, err := s.GetMerge(mergeID)
+	if err != nil {
+		return nil, err
+	}
+	if merge.UnmergedAt != nil {
+		return nil, ErrMergeNotFound
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the surviving patient's HIP holds both records since the merge, it decides to split them
+	var owned bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM client_profile WHERE health_id = $1 AND healthcare_id = $2)`,
+		merge.SurvivingHealthID, healthcare_id).Scan(&owned)
+	if err != nil {
+		return nil, err
+	}
+	if !owned {
+		return nil, ErrUnmergeForbidden
+	}
+
+	// only unmerge once, even if two admins click at the same time
+	result, err := tx.Exec(`UPDATE patient_merges SET unmerged_by = $2, unmerged_at = NOW() WHERE id = $1 AND unmerged_at IS NULL;`, mergeID, healthcare_id)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, ErrMergeNotFound
+	}
+
+	_, err = tx.Exec(`UPDATE appointments SET health_id = $1, updated_at = NOW() WHERE health_id = $2 AND id = ANY($3);`,
+		merge.MergedHealthID, merge.SurvivingHealthID, pq.Array(merge.Moved.Appointments))
+	if err != nil {
+		return nil, fmt.Errorf("failed to move appointments back: %w", err)
+	}
+	stats := merge.Moved.Stats
+	_, err = tx.Exec(`UPDATE client_stats SET profile_viewed = GREATEST(profile_viewed - $2, 0), profile_updated = GREATEST(profile_updated - $3, 0),
+		records_viewed = GREATEST(records_viewed - $4, 0), records_created = GREATEST(records_created - $5, 0) WHERE health_id = $1;`,
+		merge.SurvivingHealthID, stats.ProfileViewed, stats.ProfileUpdated, stats.RecordsViewed, stats.RecordsCreated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move client_stats back: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE client_stats SET profile_viewed = $2, profile_updated = $3, records_viewed = $4, records_created = $5 WHERE health_id = $1;`,
+		merge.MergedHealthID, stats.ProfileViewed, stats.ProfileUpdated, stats.RecordsViewed, stats.RecordsCreated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore client_stats: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE client_profile SET merged_into = NULL, updated_at = NOW() WHERE health_id = $1;`, merge.MergedHealthID); err != nil {
+		return nil, err
+	}
+	// somebody decided these are two people after all
+	_, err = tx.Exec(`UPDATE duplicate_candidates SET status = 'dismissed', reviewed_by = $3, reviewed_at = NOW()
+		WHERE LEAST(health_id, candidate_health_id) = LEAST($1, $2) AND GREATEST(health_id, candidate_health_id) = GREATEST($1, $2);`,
+		merge.SurvivingHealthID, merge.MergedHealthID, healthcare_id)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetMerge(mergeID)
 }
 
 // Get totalRequest from database
@@ -449,7 +1071,238 @@ func (s *PostgresStore) SetAppointments(healthcare_id, healthID, status string,
 	return rowsAffected, nil
 }
 
+// CheckAppointment makes sure the appointment is the patient's and was booked with the healthcare provider
+func (s *PostgresStore) CheckAppointment(healthcare_id, healthID string, id int64) error {
+	return checkAppointment(s.db, healthcare_id, healthID, id)
+}
+
+// Enqueue writes messages to the outbox on their own, for events that don't come with a change
+func (s *PostgresStore) Enqueue(messages ...mq.Message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertOutbox(tx, messages); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertOutbox writes messages to the outbox and appends them to the event log
+func insertOutbox(tx *sql.Tx, messages []mq.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1);`, eventLogLockID); err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		event, err := parseEvent(msg)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO outbox (routing_key, body) VALUES ($1, $2);`, msg.RoutingKey, string(msg.Body)); err != nil {
+			return fmt.Errorf("failed to write %s message to the outbox: %w", msg.RoutingKey, err)
+		}
+		_, err = tx.Exec(`INSERT INTO event_log (event_id, type, version, occurred_at, body) VALUES ($1, $2, $3, $4, $5);`,
+			event.ID, event.Type, event.Version, event.OccurredAt, string(msg.Body))
+		if err != nil {
+			return fmt.Errorf("failed to append %s event to the log: %w", msg.RoutingKey, err)
+		}
+	}
+	return nil
+}
+
+// RelayOutbox publishes one batch of pending messages and returns how many went out.
+// The advisory lock is held until the transaction ends, another replica skips its turn meanwhile
+func (s *PostgresStore) RelayOutbox(publish func(mq.Message) error) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow(`SELECT pg_try_advisory_xact_lock($1);`, outboxLockID).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+	rows, err := tx.Query(`SELECT id, routing_key, body, attempts, next_attempt_at <= NOW()
+		FROM outbox WHERE published_at IS NULL ORDER BY id LIMIT $1;`, outboxBatch)
+	if err != nil {
+		return 0, err
+	}
+	pending, err := scanOutbox(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	sent, failed := relayBatch(pending, publish)
+	if _, err := tx.Exec(`UPDATE outbox SET published_at = NOW() WHERE id = ANY($1);`, pq.Array(sent)); err != nil {
+		return 0, err
+	}
+	for msg, publishErr := range failed {
+		_, err := tx.Exec(`UPDATE outbox SET attempts = attempts + 1, last_error = $2, next_attempt_at = NOW() + make_interval(secs => $3)
+			WHERE id = $1;`, msg.ID, publishErr.Error(), outboxRetry(msg.Attempts+1).Seconds())
+		if err != nil {
+			return 0, err
+		}
+	}
+	// a failed commit sends the batch again, consumers get messages at least once
+	return len(sent), tx.Commit()
+}
+
+// ProjectEvents applies the next batch of logged events to the counters, the webhook deliveries and the notifications, and returns how many
+func (s *PostgresStore) ProjectEvents() (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow(`SELECT pg_try_advisory_xact_lock($1);`, projectionLockID).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+	n, err := projectAll(tx)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// RebuildProjections recomputes the counters from the whole event log in one transaction.
+// It waits for a running ProjectEvents batch, the server keeps running meanwhile
+func (s *PostgresStore) RebuildProjections() (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1);`, projectionLockID); err != nil {
+		return 0, err
+	}
+	n, err := rebuildCounters(tx)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (s *PostgresStore) EventLogStatus() (*EventLogStatus, error) {
+	return eventLogStatus(s.db)
+}
+
+// StreamEvents publishes the live updates of the next batch of logged events and returns how many events it went through
+func (s *PostgresStore) StreamEvents(publish func(healthcareID string, update rd.LiveUpdate) error) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow(`SELECT pg_try_advisory_xact_lock($1);`, liveLockID).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+	n, err := streamEvents(tx, publish)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (s *PostgresStore) LiveUpdatesSince(healthcare_id string, after int64) ([]rd.LiveUpdate, error) {
+	return liveUpdatesSince(s.db, healthcare_id, after)
+}
+
+// Webhooks, the queries are shared with SQLiteStore (databases/webhooks.go)
+func (s *PostgresStore) CreateWebhook(webhook *Webhook) error {
+	return createWebhook(s.db, webhook)
+}
+
+func (s *PostgresStore) ListWebhooks(healthcare_id string) ([]*Webhook, error) {
+	return listWebhooks(s.db, healthcare_id)
+}
+
+func (s *PostgresStore) GetWebhook(healthcare_id string, id int64) (*Webhook, error) {
+	return getWebhook(s.db, healthcare_id, id)
+}
+
+func (s *PostgresStore) UpdateWebhook(healthcare_id string, id int64, patch *WebhookPatch) (*Webhook, error) {
+	return updateWebhook(s.db, healthcare_id, id, patch)
+}
+
+func (s *PostgresStore) DeleteWebhook(healthcare_id string, id int64) error {
+	return deleteWebhook(s.db, healthcare_id, id)
+}
+
+func (s *PostgresStore) ListWebhookDeliveries(healthcare_id string, webhookID int64, status string, limit int64) ([]*WebhookDelivery, error) {
+	return listWebhookDeliveries(s.db, healthcare_id, webhookID, status, limit)
+}
+
+func (s *PostgresStore) GetWebhookDelivery(healthcare_id string, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	return getWebhookDelivery(s.db, healthcare_id, webhookID, deliveryID)
+}
+
+func (s *PostgresStore) RedeliverWebhook(healthcare_id string, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	return redeliverWebhook(s.db, healthcare_id, webhookID, deliveryID)
+}
+
+// DeliverWebhooks posts a batch of due deliveries, replicas skip the rows another one has claimed
+func (s *PostgresStore) DeliverWebhooks(post func(webhooks.Request) webhooks.Response) (int, error) {
+	return deliverWebhooks(s.db, "FOR UPDATE OF d SKIP LOCKED", post)
+}
+
+// Notifications, the queries are shared with SQLiteStore (databases/notifications.go)
+func (s *PostgresStore) GetNotificationPreferences(healthcare_id, recipientType, recipientID string) (*NotificationPreferences, error) {
+	return getNotificationPreferences(s.db, healthcare_id, recipientType, recipientID)
+}
+
+func (s *PostgresStore) SetNotificationPreferences(healthcare_id string, prefs *NotificationPreferences) error {
+	return setNotificationPreferences(s.db, healthcare_id, prefs)
+}
+
+func (s *PostgresStore) ListNotifications(healthcare_id, status string, limit int64) ([]*Notification, error) {
+	return listNotifications(s.db, healthcare_id, status, limit)
+}
+
+func (s *PostgresStore) GetNotification(healthcare_id string, id int64) (*Notification, error) {
+	return getNotification(s.db, healthcare_id, id)
+}
+
+// SendNotifications sends a batch of due notifications, replicas skip the rows another one has claimed
+func (s *PostgresStore) SendNotifications(channels notify.Channels) (int, error) {
+	return sendNotifications(s.db, "FOR UPDATE SKIP LOCKED", channels)
+}
+
+// RemindAppointments logs the appointment reminders that are due, the unique (appointment, offset)
+// keeps replicas that run it together from logging one twice
+func (s *PostgresStore) RemindAppointments(schedule ReminderSchedule) (int, error) {
+	return remindAppointments(s.db, schedule, time.Now().UTC(), insertOutbox)
+}
+
+// AnswerReminder confirms or cancels the appointment a patient's text message answers
+func (s *PostgresStore) AnswerReminder(from, text string) (*ReminderReply, error) {
+	return answerReminder(s.db, from, text, insertOutbox)
+}
+
 // Utility Functions
+func mustJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
 func checkEmailExists(db *sql.DB, email string) (bool, error) {
 	var exists bool
 	query := "SELECT EXISTS(SELECT 1 FROM HIP_TABLE WHERE email = $1)"
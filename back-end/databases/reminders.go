@@ -0,0 +1,316 @@
+package databases
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"vaibhavyadav-dev/healthcareServer/events"
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+)
+
+// Appointment reminders: the reminder loop of a store looks for appointments that start within
+// one of the schedule's offsets (24h, 2h before) and logs an appointment.reminder.due event for
+// each, the notifications projection tells the patient by email and text message. Only the
+// nearest offset that has passed is sent, a scheduler that was down doesn't send the 24h and the
+// 2h reminder together, and appointment_reminders keeps each (appointment, offset) to one.
+// Appointments that were cancelled, rejected or not available are skipped, and so are those
+// whose time of day can't be read.
+//
+// Every reminder has a reply code. The patient texts back YES or NO with it, the SMS gateway
+// posts the answer to the inbound route and AnswerReminder confirms or cancels the appointment
+// and logs its appointment.status.changed in the same transaction.
+
+const (
+	ReplyConfirmed = "confirmed"
+	ReplyCancelled = "cancelled"
+
+	reminderCodeDigits = 4
+	// the national number, the trunk or country prefix before it differs between gateways
+	phoneKeyDigits = 9
+)
+
+var (
+	ErrReminderNotFound = errors.New("no open reminder matches the reply")
+	ErrInvalidReply     = errors.New("reply is neither a confirmation nor a cancellation")
+)
+
+// the statuses of appointments that are not reminded, lower case
+var reminderSkippedStatuses = []string{"cancelled", "rejected", "not available"}
+
+// the words a reply starts with, in every supported language
+var replyWords = map[string]string{
+	"yes": ReplyConfirmed, "y": ReplyConfirmed, "1": ReplyConfirmed, "confirm": ReplyConfirmed, "ok": ReplyConfirmed,
+	"አዎ": ReplyConfirmed, "eeyyee": ReplyConfirmed,
+	"no": ReplyCancelled, "n": ReplyCancelled, "2": ReplyCancelled, "cancel": ReplyCancelled,
+	"አይ": ReplyCancelled, "lakki": ReplyCancelled,
+}
+
+// appointmentTimeLayouts are the ways appointment_time is written
+var appointmentTimeLayouts = []string{"15:04", "15:04:05", "3:04 PM", "3:04PM", "3:04 pm", "3:04pm"}
+
+// ReminderSchedule is when reminders go out. Offsets are how long before an appointment,
+// Location is the time zone appointment times are in (UTC when nil)
+type ReminderSchedule struct {
+	Offsets  []time.Duration
+	Location *time.Location
+}
+
+// ReminderReply is an answer a patient gave to a reminder, and what it did to the appointment
+type ReminderReply struct {
+	AppointmentID int64  `json:"appointment_id"`
+	HealthcareID  string `json:"healthcare_id"`
+	HealthID      string `json:"health_id"`
+	Reply         string `json:"reply"`
+	Status        string `json:"status"`
+}
+
+// ParseReminderReply reads a text message like "YES 4821" or "no", code is "" when it has none
+func ParseReminderReply(text string) (reply, code string, err error) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	})
+	if len(words) == 0 {
+		return "", "", ErrInvalidReply
+	}
+	reply, ok := replyWords[words[0]]
+	if !ok || len(words) > 2 {
+		return "", "", ErrInvalidReply
+	}
+	if len(words) == 2 {
+		code = words[1]
+		if len(code) != reminderCodeDigits || strings.Trim(code, "0123456789") != "" {
+			return "", "", ErrInvalidReply
+		}
+	}
+	return reply, code, nil
+}
+
+// phoneKey is the last digits of a phone number, +251 911 234 567 and 0911234567 are the same
+func phoneKey(number string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, number)
+	if len(digits) > phoneKeyDigits {
+		digits = digits[len(digits)-phoneKeyDigits:]
+	}
+	return digits
+}
+
+// appointmentStart is when an appointment begins, its date is stored as midnight UTC and its time as text
+func appointmentStart(date time.Time, clock string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range appointmentTimeLayouts {
+		t, err := time.Parse(layout, strings.TrimSpace(clock))
+		if err == nil {
+			date = date.UTC()
+			return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// dueOffset is the nearest offset before start that now has passed
+func dueOffset(offsets []time.Duration, start, now time.Time) (time.Duration, bool) {
+	due, ok := time.Duration(0), false
+	for _, offset := range offsets {
+		if !now.Before(start.Add(-offset)) && (!ok || offset < due) {
+			due, ok = offset, true
+		}
+	}
+	return due, ok
+}
+
+// formatOffset writes an offset the way it is configured, 24h or 90m
+func formatOffset(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+func newReplyCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(10_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", reminderCodeDigits, n.Int64()), nil
+}
+
+type dueReminder struct {
+	appointmentID int64
+	healthcareID  string
+	healthID      string
+	phone         string
+	before        time.Duration
+}
+
+// remindAppointments logs the reminders that are due at now and returns how many, outbox is the
+// store's insertOutbox
+func remindAppointments(db *sql.DB, schedule ReminderSchedule, now time.Time, outbox func(*sql.Tx, []mq.Message) error) (int, error) {
+	if len(schedule.Offsets) == 0 {
+		return 0, nil
+	}
+	loc := schedule.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// dates are midnights, a day either side covers any time of day in any zone
+	rows, err := tx.Query(`SELECT a.id, a.healthcare_id, a.health_id, a.appointment_date, a.appointment_time, c.mobile_number
+		FROM appointments a JOIN client_profile c ON c.health_id = a.health_id
+		WHERE a.appointment_date >= $1 AND a.appointment_date <= $2 AND LOWER(a.status) NOT IN ($3, $4, $5)
+		ORDER BY a.id;`,
+		now.Add(-48*time.Hour), now.Add(slices.Max(schedule.Offsets)+48*time.Hour),
+		reminderSkippedStatuses[0], reminderSkippedStatuses[1], reminderSkippedStatuses[2])
+	if err != nil {
+		return 0, err
+	}
+	var due []dueReminder
+	for rows.Next() {
+		var r dueReminder
+		var date time.Time
+		var clock, mobile string
+		if err := rows.Scan(&r.appointmentID, &r.healthcareID, &r.healthID, &date, &clock, &mobile); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		start, ok := appointmentStart(date, clock, loc)
+		if !ok || !now.Before(start) {
+			continue
+		}
+		if r.before, ok = dueOffset(schedule.Offsets, start, now); ok {
+			r.phone = phoneKey(mobile)
+			due = append(due, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var messages []mq.Message
+	for _, r := range due {
+		code, err := newReplyCode()
+		if err != nil {
+			return 0, err
+		}
+		// another replica may have logged it already, then this one does nothing
+		result, err := tx.Exec(`INSERT INTO appointment_reminders (appointment_id, healthcare_id, health_id, before_minutes,
+			reply_code, phone, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (appointment_id, before_minutes) DO NOTHING;`,
+			r.appointmentID, r.healthcareID, r.healthID, int(r.before/time.Minute), code, r.phone, now)
+		if err != nil {
+			return 0, fmt.Errorf("failed to record reminder of appointment %d: %w", r.appointmentID, err)
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		msg, err := events.Message(events.AppointmentReminderDue{
+			HealthcareID: r.healthcareID, AppointmentID: r.appointmentID, HealthID: r.healthID,
+			Before: formatOffset(r.before), ReplyCode: code,
+		})
+		if err != nil {
+			return 0, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := outbox(tx, messages); err != nil {
+		return 0, err
+	}
+	return len(messages), tx.Commit()
+}
+
+// answerReminder applies a text message from a patient to their latest open reminder, or the one
+// with the code the message has. The appointment's other reminders are closed with it
+func answerReminder(db *sql.DB, from, text string, outbox func(*sql.Tx, []mq.Message) error) (*ReminderReply, error) {
+	reply, code, err := ParseReminderReply(text)
+	if err != nil {
+		return nil, err
+	}
+	phone := phoneKey(from)
+	if phone == "" {
+		return nil, ErrReminderNotFound
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	answer := &ReminderReply{Reply: reply, Status: "Confirmed"}
+	if reply == ReplyCancelled {
+		answer.Status = "Cancelled"
+	}
+	// answers are taken until the day after the appointment
+	err = tx.QueryRow(`SELECT r.appointment_id, r.healthcare_id, r.health_id FROM appointment_reminders r
+		JOIN appointments a ON a.id = r.appointment_id
+		WHERE r.phone = $1 AND r.reply IS NULL AND ($2 = '' OR r.reply_code = $2)
+		AND a.appointment_date >= $3 AND LOWER(a.status) NOT IN ($4, $5, $6)
+		ORDER BY r.id DESC LIMIT 1;`,
+		phone, code, now.Add(-48*time.Hour),
+		reminderSkippedStatuses[0], reminderSkippedStatuses[1], reminderSkippedStatuses[2]).
+		Scan(&answer.AppointmentID, &answer.HealthcareID, &answer.HealthID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrReminderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE appointment_reminders SET reply = $2, replied_at = $3 WHERE appointment_id = $1 AND reply IS NULL;`,
+		answer.AppointmentID, reply, now); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE appointments SET status = $2, updated_at = $3 WHERE id = $1;`,
+		answer.AppointmentID, answer.Status, now); err != nil {
+		return nil, err
+	}
+	msg, err := events.Message(events.AppointmentStatusChanged{
+		HealthcareID: answer.HealthcareID, AppointmentID: answer.AppointmentID, HealthID: answer.HealthID, Status: answer.Status,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := outbox(tx, []mq.Message{msg}); err != nil {
+		return nil, err
+	}
+	return answer, tx.Commit()
+}
+
+// reminders is the reminder loop of a store, it reminds nobody until a schedule is set
+type reminders struct {
+	schedule atomic.Pointer[ReminderSchedule]
+	loop     *outboxRelay
+}
+
+func startReminders(remind func(ReminderSchedule) (int, error)) *reminders {
+	r := &reminders{}
+	r.loop = startOutboxRelay("appointment reminders", func() (int, error) {
+		schedule := r.schedule.Load()
+		if schedule == nil {
+			return 0, nil
+		}
+		return remind(*schedule)
+	})
+	return r
+}
+
+func (r *reminders) enable(schedule ReminderSchedule) {
+	r.schedule.Store(&schedule)
+	r.loop.notify()
+}
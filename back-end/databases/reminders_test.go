@@ -0,0 +1,170 @@
+package databases
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReminderReply(t *testing.T) {
+	cases := []struct {
+		text        string
+		reply, code string
+		err         error
+	}{
+		{"YES 0421", ReplyConfirmed, "0421", nil},
+		{" yes.", ReplyConfirmed, "", nil},
+		{"No, 9999", ReplyCancelled, "9999", nil},
+		{"አዎ 1234", ReplyConfirmed, "1234", nil},
+		{"lakki", ReplyCancelled, "", nil},
+		{"maybe", "", "", ErrInvalidReply},
+		{"yes 12", "", "", ErrInvalidReply},
+		{"yes 1234 please", "", "", ErrInvalidReply},
+		{"", "", "", ErrInvalidReply},
+	}
+	for _, c := range cases {
+		reply, code, err := ParseReminderReply(c.text)
+		if reply != c.reply || code != c.code || !errors.Is(err, c.err) {
+			t.Errorf("%q: got %q %q %v", c.text, reply, code, err)
+		}
+	}
+}
+
+func TestReminderSchedule(t *testing.T) {
+	addis := time.FixedZone("EAT", 3*60*60)
+	start, ok := appointmentStart(time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC), "9:30 AM", addis)
+	if !ok || !start.Equal(time.Date(2026, 10, 21, 6, 30, 0, 0, time.UTC)) {
+		t.Errorf("start %v, %v", start, ok)
+	}
+	if _, ok := appointmentStart(start, "morning", addis); ok {
+		t.Error("want no start for an unreadable time")
+	}
+	offsets := []time.Duration{24 * time.Hour, 2 * time.Hour}
+	for _, c := range []struct {
+		before time.Duration
+		due    time.Duration
+		ok     bool
+	}{
+		{30 * time.Hour, 0, false},
+		{23 * time.Hour, 24 * time.Hour, true},
+		{90 * time.Minute, 2 * time.Hour, true},
+	} {
+		due, ok := dueOffset(offsets, start, start.Add(-c.before))
+		if due != c.due || ok != c.ok {
+			t.Errorf("%v before: due %v, %v", c.before, due, ok)
+		}
+	}
+	if phoneKey("+251 911 234 567") != phoneKey("0911234567") || phoneKey("call me") != "" {
+		t.Error("phone numbers don't match")
+	}
+}
+
+func TestAppointmentReminders(t *testing.T) {
+	store := newTestSQLite(t)
+	hip := &HIPInfo{HealthcareID: "HIP-0001", HealthcareLicense: "LIC-1", HealthcareName: "Adama Hospital", Email: "hip@example.com",
+		Availability: "24x7", TotalFacilities: 5, TotalMBBSDoc: 5, TotalWorker: 5, NoOfBeds: 5, Password: "hash", About: "general hospital"}
+	if _, err := store.SignUpAccount(hip); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create_ClientProfile(testPatient("HID-1", "Almaz")); err != nil {
+		t.Fatal(err)
+	}
+	// AnswerReminder goes by the clock, the appointments are the day after tomorrow
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 2)
+	for _, a := range []struct {
+		id           int64
+		clock        string
+		status       string
+		healthcareID string
+	}{
+		{1, "09:30", "Confirmed", "HIP-0001"},
+		{2, "10:00", "Cancelled", "HIP-0001"},
+		{3, "after lunch", "pending", "HIP-0001"},
+		{4, "11:00", "pending", "HIP-0002"},
+	} {
+		_, err := store.db.Exec(`INSERT INTO appointments (id, health_id, healthcare_id, appointment_date, appointment_time, status, healthcare_name)
+			VALUES ($1, 'HID-1', $2, $3, $4, $5, 'Adama Hospital');`, a.id, a.healthcareID, day, a.clock, a.status)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	schedule := ReminderSchedule{Offsets: []time.Duration{24 * time.Hour, 2 * time.Hour}}
+	remind := func(now time.Time) int {
+		t.Helper()
+		n, err := remindAppointments(store.db, schedule, now, insertSQLiteOutbox)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// 20 hours before the first one, the cancelled and the unreadable ones are skipped
+	now := day.Add(9*time.Hour + 30*time.Minute - 20*time.Hour)
+	if n := remind(now); n != 2 {
+		t.Fatalf("reminded %d", n)
+	}
+	if n := remind(now.Add(time.Minute)); n != 0 {
+		t.Errorf("reminded %d again", n)
+	}
+	// the first is 2h away now, the second one's 2h reminder isn't due yet
+	if n := remind(now.Add(18*time.Hour + 30*time.Minute)); n != 1 {
+		t.Errorf("reminded %d two hours before", n)
+	}
+
+	var code string
+	err := store.db.QueryRow(`SELECT reply_code FROM appointment_reminders WHERE appointment_id = 1 AND before_minutes = 120;`).Scan(&code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ProjectEvents(); err != nil {
+		t.Fatal(err)
+	}
+	sms, err := store.ListNotifications("HIP-0001", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sms) != 4 || sms[0].Type != "appointment.reminder.due" || !strings.Contains(sms[0].Body, "YES "+code) {
+		t.Fatalf("notifications %+v", sms)
+	}
+
+	if _, err := store.AnswerReminder("0911234567", "yes 0000x"); !errors.Is(err, ErrInvalidReply) {
+		t.Errorf("garbled reply: %v", err)
+	}
+	if _, err := store.AnswerReminder("0911999999", "yes "+code); !errors.Is(err, ErrReminderNotFound) {
+		t.Errorf("someone else's reply: %v", err)
+	}
+	reply, err := store.AnswerReminder("+251911234567", "NO "+code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.AppointmentID != 1 || reply.Reply != ReplyCancelled || reply.Status != "Cancelled" {
+		t.Errorf("reply %+v", reply)
+	}
+	var status string
+	var open int
+	if err := store.db.QueryRow(`SELECT status FROM appointments WHERE id = 1;`).Scan(&status); err != nil || status != "Cancelled" {
+		t.Errorf("status %q, %v", status, err)
+	}
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM appointment_reminders WHERE appointment_id = 1 AND reply IS NULL;`).Scan(&open); err != nil || open != 0 {
+		t.Errorf("%d reminders left open, %v", open, err)
+	}
+	var logged int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM event_log WHERE type = 'appointment.status.changed';`).Scan(&logged); err != nil || logged != 1 {
+		t.Errorf("%d status changes logged, %v", logged, err)
+	}
+
+	// without a code the reply is for the latest open reminder, the other HIP's appointment
+	reply, err = store.AnswerReminder("0911234567", "yes")
+	if err != nil || reply.AppointmentID != 4 || reply.Status != "Confirmed" {
+		t.Errorf("reply %+v, %v", reply, err)
+	}
+	if _, err := store.AnswerReminder("0911234567", "yes"); !errors.Is(err, ErrReminderNotFound) {
+		t.Errorf("nothing left to answer: %v", err)
+	}
+	// a cancelled appointment isn't reminded any more
+	schedule.Offsets = append(schedule.Offsets, time.Hour)
+	if n := remind(now.Add(19 * time.Hour)); n != 0 {
+		t.Errorf("reminded %d cancelled", n)
+	}
+}
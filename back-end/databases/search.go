@@ -0,0 +1,178 @@
+package databases
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"vaibhavyadav-dev/healthcareServer/ethiopic"
+)
+
+// Patient search by name, father's name, phone, DOB and location.
+// Postgres narrows the candidates using the phonetic name keys (see ethiopic.Key),
+// the ranking itself happens here so Ge'ez and Latin spellings compare the same way.
+
+// results below this score are noise
+const minSearchScore = 0.75
+
+// how many rows postgres hands over for ranking, the closest first (see searchOrder)
+const searchCandidateLimit = 500
+
+type PatientSearch struct {
+	Name       string `json:"name"`
+	FatherName string `json:"fathername"`
+	Phone      string `json:"phone"`
+	DOB        string `json:"dob"`
+	Region     string `json:"region"`
+	Zone       string `json:"zone"`
+	Woreda     string `json:"woreda"`
+	Limit      int    `json:"limit"`
+}
+
+// Empty is true when nothing that identifies a patient was given, location alone is too broad
+func (q *PatientSearch) Empty() bool {
+	return strings.TrimSpace(q.Name) == "" && strings.TrimSpace(q.FatherName) == "" &&
+		phoneDigits(q.Phone) == "" && strings.TrimSpace(q.DOB) == ""
+}
+
+type PatientMatch struct {
+	Profile   *PatientDetails `json:"client_profile"`
+	Score     float64         `json:"score"`
+	MatchedOn []string        `json:"matched_on"`
+}
+
+// NameKeys returns the phonetic keys stored next to the name columns
+func NameKeys(p *PatientDetails) (first, middle, last, father string) {
+	return ethiopic.Key(p.FirstName), ethiopic.Key(p.MiddleName), ethiopic.Key(p.LastName), ethiopic.Key(p.FatherName)
+}
+
+// name key prefixes postgres should look for, one per word of the searched names
+func searchKeyPrefixes(q *PatientSearch) []string {
+	prefixes := []string{}
+	seen := map[string]bool{}
+	for _, word := range strings.Fields(q.Name + " " + q.FatherName) {
+		key := ethiopic.Key(word)
+		if len(key) > 2 {
+			key = key[:2]
+		}
+		if key != "" && !seen[key] {
+			seen[key] = true
+			prefixes = append(prefixes, key)
+		}
+	}
+	return prefixes
+}
+
+// searchOrder sorts the candidates before the limit cuts them, so the closest ones are the ones
+// ranked: first the profiles with most searched words equal to a name key, dob and location are
+// exact filters already. arg binds a query argument and returns its placeholder.
+func searchOrder(q *PatientSearch, arg func(value interface{}) string) string {
+	exact := []string{}
+	seen := map[string]bool{}
+	for _, word := range strings.Fields(q.Name + " " + q.FatherName) {
+		key := ethiopic.Key(word)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		exact = append(exact, fmt.Sprintf("(CASE WHEN first_name_key = %[1]s OR middle_name_key = %[1]s OR last_name_key = %[1]s OR father_name_key = %[1]s THEN 1 ELSE 0 END)", arg(key)))
+	}
+	if len(exact) == 0 {
+		return "ORDER BY health_id"
+	}
+	return "ORDER BY " + strings.Join(exact, " + ") + " DESC, health_id"
+}
+
+// Ethiopian numbers are written 09.., 9.. or +2519.., the last 9 digits identify the line
+func phoneDigits(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+	if len(digits) > 9 {
+		digits = digits[len(digits)-9:]
+	}
+	return digits
+}
+
+// RankPatients scores every candidate against the query and returns the best ones first
+func RankPatients(q *PatientSearch, candidates []*PatientDetails) []*PatientMatch {
+	matches := []*PatientMatch{}
+	for _, p := range candidates {
+		match := scorePatient(q, p)
+		if match.Score >= minSearchScore {
+			matches = append(matches, match)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+	return matches
+}
+
+func scorePatient(q *PatientSearch, p *PatientDetails) *PatientMatch {
+	match := &PatientMatch{Profile: p, MatchedOn: []string{}}
+	total, criteria := 0.0, 0
+
+	add := func(field string, score float64) {
+		total += score
+		criteria++
+		if score >= 0.85 {
+			match.MatchedOn = append(match.MatchedOn, field)
+		}
+	}
+
+	if strings.TrimSpace(q.Name) != "" {
+		add("name", nameScore(q.Name, p))
+	}
+	if strings.TrimSpace(q.FatherName) != "" {
+		add("fathername", ethiopic.Similarity(q.FatherName, p.FatherName))
+	}
+	if phone := phoneDigits(q.Phone); phone != "" {
+		score := 0.0
+		if phone == phoneDigits(p.MobileNumber) || phone == phoneDigits(p.EmergencyNumber) {
+			score = 1
+		}
+		add("phone", score)
+	}
+	// dob and location are already exact filters in the query
+	if strings.TrimSpace(q.DOB) != "" {
+		match.MatchedOn = append(match.MatchedOn, "dob")
+	}
+
+	if criteria == 0 {
+		match.Score = 1
+		return match
+	}
+	match.Score = total / float64(criteria)
+	return match
+}
+
+// Ethiopian names are given name + father's name + grandfather's name, people type any part of it
+// in either script, so every searched word is matched against the closest part of the stored name
+func nameScore(query string, p *PatientDetails) float64 {
+	parts := []string{p.FirstName, p.MiddleName, p.LastName, p.FatherName}
+	full := strings.Join([]string{p.FirstName, p.MiddleName, p.LastName}, " ")
+
+	words := strings.Fields(ethiopic.Normalize(query))
+	if len(words) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, word := range words {
+		best := 0.0
+		for _, part := range parts {
+			if score := ethiopic.Similarity(word, part); score > best {
+				best = score
+			}
+		}
+		sum += best
+	}
+	return max(sum/float64(len(words)), ethiopic.Similarity(query, full))
+}
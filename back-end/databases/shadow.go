@@ -0,0 +1,205 @@
+package databases
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// While mongo and postgres both hold patients and healthcare accounts, a sampled fraction of reads
+// is repeated against the store that didn't answer and the two results are compared. The caller
+// always gets the primary result straight away, the comparison runs in the background and only
+// ends up in the metrics and the log.
+
+var (
+	shadowReads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shadow_reads_total",
+			Help: "Reads compared against the other store.",
+		},
+		[]string{"entity", "primary"},
+	)
+
+	shadowMismatches = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shadow_read_mismatches_total",
+			Help: "Shadow reads where the two stores returned different data.",
+		},
+		[]string{"entity", "primary"},
+	)
+
+	shadowFieldMismatches = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shadow_read_field_mismatches_total",
+			Help: "Fields that differed between the two stores, per field.",
+		},
+		[]string{"entity", "field"},
+	)
+
+	shadowErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shadow_read_errors_total",
+			Help: "Shadow reads where the other store failed or didn't have the entity.",
+		},
+		[]string{"entity", "store"},
+	)
+)
+
+// at most this many comparisons run at once, reads beyond that are not shadowed
+const maxShadowReads = 16
+
+// fields that legitimately differ between the stores
+var shadowIgnored = map[string]map[string]bool{
+	// postgres and mongo hash with their own salt
+	"healthcare": {"password": true},
+	"patient":    diffIgnored,
+}
+
+// values never written to the log, the field name is enough to find the row
+var shadowRedacted = map[string]bool{
+	"password":      true,
+	"aadhar_number": true,
+}
+
+type shadowReader struct {
+	rate  float64
+	slots chan struct{}
+	// replaced in tests
+	sample func() float64
+}
+
+func newShadowReader(rate float64) *shadowReader {
+	return &shadowReader{
+		rate:   min(max(rate, 0), 1),
+		slots:  make(chan struct{}, maxShadowReads),
+		sample: rand.Float64,
+	}
+}
+
+// EnableShadowReads compares rate (0 to 1) of the patient and healthcare reads with the other store,
+// 0 turns it off again
+func (s *CombinedStore) EnableShadowReads(rate float64) {
+	if rate <= 0 {
+		s.shadow = nil
+		return
+	}
+	s.shadow = newShadowReader(rate)
+}
+
+// compare reads the entity from the other store for a sampled fraction of calls and
+// records any difference with result, it returns without waiting for that
+func (r *shadowReader) compare(entity, primary, key string, result interface{}, read func() (interface{}, error)) {
+	if r == nil || r.sample() >= r.rate {
+		return
+	}
+	select {
+	case r.slots <- struct{}{}:
+	default:
+		return
+	}
+	// taken now, the caller may change result once we return
+	want, err := flattenJSON(result)
+	if err != nil {
+		<-r.slots
+		log.Printf("shadow read %s %s: %v", entity, key, err)
+		return
+	}
+	go func() {
+		defer func() { <-r.slots }()
+		r.check(entity, primary, key, want, read)
+	}()
+}
+
+func (r *shadowReader) check(entity, primary, key string, want map[string]interface{}, read func() (interface{}, error)) {
+	other := shadowStore(primary)
+	shadowReads.WithLabelValues(entity, primary).Inc()
+	result, err := read()
+	if err != nil {
+		shadowErrors.WithLabelValues(entity, other).Inc()
+		log.Printf("shadow read %s %s: %s failed: %v", entity, key, other, err)
+		return
+	}
+	got, err := flattenJSON(result)
+	if err != nil {
+		shadowErrors.WithLabelValues(entity, other).Inc()
+		log.Printf("shadow read %s %s: %v", entity, key, err)
+		return
+	}
+
+	changes := shadowDiff(want, got, shadowIgnored[entity])
+	if len(changes) == 0 {
+		return
+	}
+	shadowMismatches.WithLabelValues(entity, primary).Inc()
+	diffs := make([]string, 0, len(changes))
+	for _, change := range changes {
+		shadowFieldMismatches.WithLabelValues(entity, change.Field).Inc()
+		if shadowRedacted[change.Field] {
+			diffs = append(diffs, change.Field+" differs")
+			continue
+		}
+		diffs = append(diffs, change.Field+": "+primary+"="+shadowValue(change.From)+" "+other+"="+shadowValue(change.To))
+	}
+	log.Printf("shadow read %s %s mismatch: %s", entity, key, strings.Join(diffs, ", "))
+}
+
+// shadowDiff lists the fields that differ, from is the primary value and to the other store's.
+// Timestamps are compared to the millisecond, mongo doesn't keep more.
+func shadowDiff(want, got map[string]interface{}, ignored map[string]bool) []FieldChange {
+	fields := map[string]bool{}
+	for field := range want {
+		fields[field] = true
+	}
+	for field := range got {
+		fields[field] = true
+	}
+	changes := []FieldChange{}
+	for field := range fields {
+		if ignored[field] || shadowEqual(want[field], got[field]) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: field, From: want[field], To: got[field]})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+func shadowEqual(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if !aok || !bok {
+		return false
+	}
+	at, aerr := time.Parse(time.RFC3339Nano, as)
+	bt, berr := time.Parse(time.RFC3339Nano, bs)
+	return aerr == nil && berr == nil && at.Truncate(time.Millisecond).Equal(bt.Truncate(time.Millisecond))
+}
+
+func shadowValue(v interface{}) string {
+	if v == nil {
+		return "<missing>"
+	}
+	if s, ok := v.(string); ok {
+		return `"` + s + `"`
+	}
+	return fmt.Sprint(v)
+}
+
+func shadowStore(primary string) string {
+	if primary == "mongo" {
+		return "postgres"
+	}
+	return "mongo"
+}
@@ -0,0 +1,54 @@
+package databases
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShadowDiff(t *testing.T) {
+	created := time.Date(2024, 3, 1, 8, 30, 0, 123456789, time.UTC)
+	postgres, err := flattenJSON(&PatientDetails{HealthID: "HID1", FirstName: "Abebe", CreatedAt: created, Version: 3, Address: Address{City: "Adama"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// mongo keeps milliseconds and has no version
+	mongo, err := flattenJSON(&PatientDetails{HealthID: "HID1", FirstName: "Abebe", CreatedAt: created.Truncate(time.Millisecond), Address: Address{City: "Nazret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := shadowDiff(postgres, mongo, shadowIgnored["patient"])
+	if len(changes) != 1 || changes[0].Field != "address.city" || changes[0].From != "Adama" || changes[0].To != "Nazret" {
+		t.Errorf("changes = %+v, want only address.city", changes)
+	}
+}
+
+func TestShadowReaderSamples(t *testing.T) {
+	r := newShadowReader(0.25)
+	reads := 0
+	read := func() (interface{}, error) {
+		reads++
+		return &HIPInfo{}, nil
+	}
+	r.sample = func() float64 { return 0.5 }
+	r.compare("healthcare", "mongo", "HIP1", &HIPInfo{}, read)
+
+	done := make(chan struct{})
+	r.sample = func() float64 { return 0.1 }
+	r.compare("healthcare", "mongo", "HIP1", &HIPInfo{}, func() (interface{}, error) {
+		defer close(done)
+		return read()
+	})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sampled read was not shadowed")
+	}
+	if reads != 1 {
+		t.Errorf("reads = %d, want only the sampled one", reads)
+	}
+
+	// disabled shadowing is a nil reader
+	var off *shadowReader
+	off.compare("healthcare", "mongo", "HIP1", &HIPInfo{}, read)
+}
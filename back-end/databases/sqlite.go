@@ -0,0 +1,1152 @@
+package databases
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"modernc.org/sqlite"
+	"vaibhavyadav-dev/healthcareServer/notify"
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+	rd "vaibhavyadav-dev/healthcareServer/redis"
+	"vaibhavyadav-dev/healthcareServer/webhooks"
+)
+
+// SQLiteStore keeps everything postgres and mongo hold in one SQLite file, so the API runs
+// on a laptop without any services. Queries follow the PostgresStore ones, apart from what
+// SQLite spells differently (no FOR UPDATE, no regexp_replace, no arrays). It is meant for
+// development and tests, not for production data.
+
+//go:embed sqlite_schema.sql
+var sqliteSchema string
+
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func init() {
+	// digits(x) keeps only the digits, regexp_replace(x, '[^0-9]', '', 'g') in postgres
+	sqlite.MustRegisterDeterministicScalarFunction("digits", 1, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		value, _ := args[0].(string)
+		return strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, value), nil
+	})
+}
+
+// ConnectToSQLite opens (and creates) the database file, ":memory:" gives a throwaway database
+func ConnectToSQLite(path string) (*SQLiteStore, error) {
+	dsn := "file:" + path + "?_time_format=sqlite&_txlock=immediate&_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// one connection: a :memory: database only exists on the connection that created it,
+	// and SQLite allows a single writer anyway
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// Init creates the tables that don't exist yet
+func (s *SQLiteStore) Init() error {
+	if _, err := s.db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("failed to create sqlite schema: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// timestamps are stored as UTC text, a mix of offsets would not compare in order
+func sqliteNow() time.Time {
+	return time.Now().UTC()
+}
+
+func (s *SQLiteStore) SignUpAccount(hip *HIPInfo, messages ...mq.Message) (int64, error) {
+	var exists bool
+	if err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM HIP_TABLE WHERE email = $1)`, hip.Email).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if exists {
+		return 0, fmt.Errorf("email %s already exists", hip.Email)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO HIP_TABLE (healthcare_id, healthcare_license,
+		healthcare_name, email, availability, total_facilities,
+		total_mbbs_doc, total_worker, no_of_beds, date_of_registration, password, about, country,
+		state, city, landmark, region_code, zone_code, woreda_code, kebele_code, address_needs_review)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NULLIF($17, ''), NULLIF($18, ''), NULLIF($19, ''), NULLIF($20, ''), $21)`,
+		hip.HealthcareID, hip.HealthcareLicense, hip.HealthcareName, hip.Email, hip.Availability, hip.TotalFacilities,
+		hip.TotalMBBSDoc, hip.TotalWorker, hip.NoOfBeds, sqliteNow(), hip.Password, hip.About, hip.Address.Country,
+		hip.Address.State, hip.Address.City, hip.Address.Landmark, hip.Address.Region, hip.Address.Zone, hip.Address.Woreda, hip.Address.Kebele, hip.Address.NeedsReview)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(`INSERT INTO HealthCare_pref (healthcare_id, scheduled_deletion, profile_viewed,
+		profile_updated, account_locked, records_created, records_viewed,
+		totalRequest_count, appointmentFee, isAvailable)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		hip.HealthcareID, "false", 0, 0, "false", 0, 0, loginQuota, 100, "true")
+	if err != nil {
+		return 0, err
+	}
+	if err := insertSQLiteOutbox(tx, messages); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	id, _ := result.LastInsertId()
+	return id, nil
+}
+
+func (s *SQLiteStore) LoginUser(acc *Login) (*HIPInfo, error) {
+	var hip HIPInfo
+	err := s.db.QueryRow(`SELECT healthcare_id, healthcare_license, healthcare_name, email, availability, total_facilities, total_mbbs_doc, total_worker, no_of_beds, date_of_registration, password, country, state, city, landmark
+		FROM HIP_TABLE WHERE healthcare_id = $1`, acc.HealthcareID).Scan(&hip.HealthcareID, &hip.HealthcareLicense, &hip.HealthcareName, &hip.Email, &hip.Availability, &hip.TotalFacilities, &hip.TotalMBBSDoc, &hip.TotalWorker, &hip.NoOfBeds, &hip.DateOfRegistration, &hip.Password, &hip.Address.Country, &hip.Address.State, &hip.Address.City, &hip.Address.Landmark)
+	if err != nil {
+		return nil, fmt.Errorf("error : %w", err)
+	}
+	return &hip, nil
+}
+
+func (s *SQLiteStore) ChangePreferance(healthcareId string, preferance map[string]interface{}, messages ...mq.Message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := map[string]string{
+		"email":              "UPDATE HIP_TABLE SET email = $1 WHERE healthcare_id = $2",
+		"scheduled_deletion": "UPDATE HealthCare_pref SET scheduled_deletion = $1 WHERE healthcare_id = $2",
+		"isAvailable":        "UPDATE HealthCare_pref SET isAvailable = $1 WHERE healthcare_id = $2",
+	}
+	// same order as PostgresStore, email first
+	for _, key := range []string{"email", "scheduled_deletion", "isAvailable"} {
+		value, ok := preferance[key]
+		if !ok || value == "" {
+			continue
+		}
+		if _, err := tx.Exec(statements[key], value, healthcareId); err != nil {
+			return err
+		}
+	}
+	if err := insertSQLiteOutbox(tx, messages); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) GetPreferance(healthcareId string) (*Preferance, error) {
+	preferance := &Preferance{}
+	err := s.db.QueryRow(`SELECT HIP_TABLE.email, HealthCare_pref.isavailable, HealthCare_pref.scheduled_deletion,
+			HealthCare_pref.profile_updated, HealthCare_pref.profile_viewed,
+			HealthCare_pref.records_created, HealthCare_pref.records_viewed
+		FROM HIP_TABLE
+		INNER JOIN HealthCare_pref ON HIP_TABLE.healthcare_id = HealthCare_pref.healthcare_id
+		WHERE HIP_TABLE.healthcare_id = $1;`, healthcareId).Scan(&preferance.Email, &preferance.IsAvailable, &preferance.Scheduled_deletion,
+		&preferance.Profile_updated, &preferance.Profile_viewed, &preferance.Records_created, &preferance.Records_viewed)
+	if err != nil {
+		return nil, err
+	}
+	return preferance, nil
+}
+
+func (s *SQLiteStore) GetHealthcare_details(healthcare_id string) (*HIPInfo, error) {
+	var hip HIPInfo
+	err := s.db.QueryRow(`SELECT
+		healthcare_id, healthcare_license, healthcare_name, email, availability,
+		total_facilities, total_mbbs_doc, total_worker, no_of_beds,
+		date_of_registration, password, about, country, state, city, landmark,
+		COALESCE(region_code, ''), COALESCE(zone_code, ''), COALESCE(woreda_code, ''),
+		COALESCE(kebele_code, ''), address_needs_review
+		FROM HIP_TABLE
+		WHERE healthcare_id = $1;`, healthcare_id).Scan(
+		&hip.HealthcareID, &hip.HealthcareLicense, &hip.HealthcareName, &hip.Email, &hip.Availability,
+		&hip.TotalFacilities, &hip.TotalMBBSDoc, &hip.TotalWorker, &hip.NoOfBeds,
+		&hip.DateOfRegistration, &hip.Password, &hip.About, &hip.Address.Country,
+		&hip.Address.State, &hip.Address.City, &hip.Address.Landmark,
+		&hip.Address.Region, &hip.Address.Zone, &hip.Address.Woreda,
+		&hip.Address.Kebele, &hip.Address.NeedsReview,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no healthcare provider found with ID: %s", healthcare_id)
+		}
+		return nil, err
+	}
+	return &hip, nil
+}
+
+func (s *SQLiteStore) Create_ClientProfile(client *PatientDetails, messages ...mq.Message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	firstKey, middleKey, lastKey, fatherKey := NameKeys(client)
+	_, err = tx.Exec(`INSERT INTO client_profile (
+		health_id, first_name, middle_name, last_name, sex, healthcare_id,
+		dob, blood_group, bmi, marriage_status, weight, email,
+		mobile_number, aadhaar_number, primary_location, sibling, twin,
+		father_name, mother_name, emergency_number, created_at, updated_at, country, city, state, landmark,
+		region_code, zone_code, woreda_code, kebele_code, address_needs_review,
+		first_name_key, middle_name_key, last_name_key, father_name_key
+	) VALUES (
+		$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17,
+		$18, $19, $20, $21, $22, $23, $24, $25, $26,
+		NULLIF($27, ''), NULLIF($28, ''), NULLIF($29, ''), NULLIF($30, ''), $31,
+		$32, $33, $34, $35
+	);`,
+		client.HealthID, client.FirstName, client.MiddleName, client.LastName, client.Sex,
+		client.HealthcareID, client.DOB, client.BloodGroup, client.BMI,
+		client.MarriageStatus, client.Weight, client.Email, client.MobileNumber,
+		client.AadhaarNumber, client.PrimaryLocation, client.Sibling, client.Twin,
+		client.FatherName, client.MotherName, client.EmergencyNumber, client.CreatedAt.UTC(), client.UpdatedAt.UTC(),
+		client.Address.Country, client.Address.City, client.Address.State, client.Address.Landmark,
+		client.Address.Region, client.Address.Zone, client.Address.Woreda, client.Address.Kebele, client.Address.NeedsReview,
+		firstKey, middleKey, lastKey, fatherKey)
+	if err != nil {
+		return err
+	}
+	if err := insertSQLiteOutbox(tx, messages); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Get_ClientProfile(health_id string) (*PatientDetails, error) {
+	// a merged health_id resolves to the profile it was merged into
+	client, err := scanClientProfile(s.db.QueryRow(`SELECT `+clientProfileColumns+`
+		FROM client_profile
+		WHERE health_id = (SELECT COALESCE(merged_into, health_id) FROM client_profile WHERE health_id = $1);`, health_id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no client found with health ID %s: %w", health_id, ErrPatientNotFound)
+		}
+		return nil, err
+	}
+	return client, nil
+}
+
+func (s *SQLiteStore) GetAddressReviewQueue(healthcare_id string, limit int64) ([]*PatientDetails, error) {
+	rows, err := s.db.Query(`SELECT `+clientProfileColumns+`
+		FROM client_profile
+		WHERE healthcare_id = $1 AND address_needs_review AND merged_into IS NULL
+		ORDER BY created_at
+		LIMIT $2;`, healthcare_id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	return scanClientProfiles(rows)
+}
+
+// reads every row and closes rows, so the connection is free for the next query
+func scanClientProfiles(rows *sql.Rows) ([]*PatientDetails, error) {
+	defer rows.Close()
+	var clients []*PatientDetails
+	for rows.Next() {
+		client, err := scanClientProfile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		clients = append(clients, client)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return clients, nil
+}
+
+// UpdateClientProfile works like PostgresStore.UpdateClientProfile, the immediate
+// transaction takes the write lock up front instead of FOR UPDATE
+func (s *SQLiteStore) UpdateClientProfile(healthID string, patch *ProfilePatch, ifVersion int, change ProfileChange) (*PatientDetails, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	previous, err := scanClientProfile(tx.QueryRow(`SELECT `+clientProfileColumns+` FROM client_profile WHERE health_id = $1;`, healthID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no client profile found with health_id %s: %w", healthID, ErrPatientNotFound)
+		}
+		return nil, err
+	}
+	if ifVersion > 0 && previous.Version != ifVersion {
+		return previous, ErrVersionMismatch
+	}
+
+	_, updates, err := patch.Apply(previous)
+	if err != nil {
+		return nil, err
+	}
+
+	// column names only ever come from the patch allow-list
+	columns := make([]string, 0, len(updates))
+	for column := range updates {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	setClause := []string{}
+	values := []interface{}{}
+	for i, column := range columns {
+		setClause = append(setClause, fmt.Sprintf("%s = $%d", column, i+1))
+		values = append(values, updates[column])
+	}
+	values = append(values, sqliteNow(), healthID)
+	setClause = append(setClause, fmt.Sprintf("updated_at = $%d", len(values)-1), "version = version + 1")
+
+	query := fmt.Sprintf(`UPDATE client_profile SET %s WHERE health_id = $%d RETURNING %s;`,
+		strings.Join(setClause, ", "), len(values), clientProfileColumns)
+	updatedClient, err := scanClientProfile(tx.QueryRow(query, values...))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := json.Marshal(previous)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode profile version: %w", err)
+	}
+	validFrom := previous.UpdatedAt
+	if previous.Version <= 1 {
+		validFrom = previous.CreatedAt
+	}
+	_, err = tx.Exec(`INSERT INTO client_profile_versions (health_id, version, snapshot, changed_fields, changed_by, reason, valid_from, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`,
+		previous.HealthID, previous.Version, string(snapshot), mustJSON(ChangedFields(previous, updatedClient)),
+		change.ChangedBy, change.Reason, validFrom.UTC(), updatedClient.UpdatedAt.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to store profile version: %w", err)
+	}
+	messages, err := change.messages(previous, updatedClient)
+	if err != nil {
+		return nil, err
+	}
+	if err := insertSQLiteOutbox(tx, messages); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return updatedClient, nil
+}
+
+func (s *SQLiteStore) ListProfileVersions(health_id string) ([]*ProfileVersion, error) {
+	rows, err := s.db.Query(`SELECT version, changed_by, reason, valid_from, changed_at, changed_fields
+		FROM client_profile_versions WHERE health_id = $1 ORDER BY version DESC;`, health_id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*ProfileVersion
+	for rows.Next() {
+		version, err := scanProfileVersion(rows, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return versions, nil
+}
+
+func (s *SQLiteStore) GetProfileVersion(health_id string, version int) (*PatientDetails, error) {
+	current, err := s.Get_ClientProfile(health_id)
+	if err != nil {
+		return nil, err
+	}
+	if version == current.Version {
+		return current, nil
+	}
+	stored, err := scanProfileVersion(s.db.QueryRow(`SELECT version, changed_by, reason, valid_from, changed_at, changed_fields, snapshot
+		FROM client_profile_versions WHERE health_id = $1 AND version = $2;`, current.HealthID, version), true)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrVersionNotFound
+		}
+		return nil, err
+	}
+	return stored.Profile, nil
+}
+
+func (s *SQLiteStore) GetClientProfileAsOf(health_id string, at time.Time) (*PatientDetails, error) {
+	current, err := s.Get_ClientProfile(health_id)
+	if err != nil {
+		return nil, err
+	}
+	if at.Before(current.CreatedAt) {
+		return nil, ErrVersionNotFound
+	}
+	stored, err := scanProfileVersion(s.db.QueryRow(`SELECT version, changed_by, reason, valid_from, changed_at, changed_fields, snapshot
+		FROM client_profile_versions WHERE health_id = $1 AND valid_from <= $2 AND changed_at > $2
+		ORDER BY version DESC LIMIT 1;`, current.HealthID, at.UTC()), true)
+	if err == sql.ErrNoRows {
+		return current, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stored.Profile, nil
+}
+
+func (s *SQLiteStore) SearchClientProfiles(healthcare_id string, q *PatientSearch) ([]*PatientMatch, error) {
+	where := []string{"healthcare_id = $1", "merged_into IS NULL"}
+	args := []interface{}{healthcare_id}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if prefixes := searchKeyPrefixes(q); len(prefixes) > 0 {
+		names := []string{}
+		for _, prefix := range prefixes {
+			p := arg(prefix + "%")
+			names = append(names, fmt.Sprintf("first_name_key LIKE %[1]s OR middle_name_key LIKE %[1]s OR last_name_key LIKE %[1]s OR father_name_key LIKE %[1]s", p))
+		}
+		where = append(where, "("+strings.Join(names, " OR ")+")")
+	}
+	if phone := phoneDigits(q.Phone); phone != "" {
+		p := arg("%" + phone)
+		where = append(where, fmt.Sprintf("(digits(mobile_number) LIKE %[1]s OR digits(emergency_number) LIKE %[1]s)", p))
+	}
+	if dob := strings.TrimSpace(q.DOB); dob != "" {
+		where = append(where, "dob = "+arg(dob))
+	}
+	for column, code := range map[string]string{"region_code": q.Region, "zone_code": q.Zone, "woreda_code": q.Woreda} {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			where = append(where, column+" = "+arg(code))
+		}
+	}
+
+	rows, err := s.db.Query(`SELECT `+clientProfileColumns+`
+		FROM client_profile
+		WHERE `+strings.Join(where, " AND ")+`
+		`+searchOrder(q, arg)+`
+		LIMIT `+arg(searchCandidateLimit)+`;`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	candidates, err := scanClientProfiles(rows)
+	if err != nil {
+		return nil, err
+	}
+	return RankPatients(q, candidates), nil
+}
+
+func (s *SQLiteStore) FindDuplicates(patient *PatientDetails) ([]*DuplicateCandidate, error) {
+	firstKey, _, lastKey, fatherKey := NameKeys(patient)
+	firstPrefix := firstKey
+	if len(firstPrefix) > 2 {
+		firstPrefix = firstPrefix[:2]
+	}
+	rows, err := s.db.Query(`SELECT `+clientProfileColumns+`
+		FROM client_profile
+		WHERE health_id <> $1 AND merged_into IS NULL AND (
+			($2 <> '' AND aadhaar_number = $2)
+			OR ($3 <> '' AND substr(digits(mobile_number), -9) = $3)
+			OR (first_name_key = $4 AND (father_name_key = $5 OR last_name_key = $6))
+			OR (dob = $7 AND first_name_key LIKE $8 || '%')
+		)
+		LIMIT $9;`, patient.HealthID, normalizeID(patient.AadhaarNumber), nationalNumber(patient.MobileNumber),
+		firstKey, fatherKey, lastKey, patient.DOB, firstPrefix, duplicateCandidateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	candidates, err := scanClientProfiles(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	duplicates := RankDuplicates(patient, candidates)
+	for _, duplicate := range duplicates {
+		fields, _ := json.Marshal(duplicate.Fields)
+		err := s.db.QueryRow(`INSERT INTO duplicate_candidates (health_id, candidate_health_id, score, fields, created_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT DO NOTHING
+			RETURNING id, created_at;`,
+			duplicate.HealthID, duplicate.CandidateHealthID, duplicate.Score, string(fields), sqliteNow()).Scan(&duplicate.ID, &duplicate.CreatedAt)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to queue duplicate: %w", err)
+		}
+	}
+	return duplicates, nil
+}
+
+func (s *SQLiteStore) GetDuplicateQueue(healthcare_id, status string, limit int64) ([]*DuplicateCandidate, error) {
+	rows, err := s.db.Query(`SELECT d.id, d.health_id, d.candidate_health_id, d.score, d.fields, d.status, d.created_at, COALESCE(d.reviewed_by, ''), p.healthcare_id = $2
+		FROM duplicate_candidates d
+		JOIN client_profile p ON p.health_id = d.health_id
+		JOIN client_profile c ON c.health_id = d.candidate_health_id
+		WHERE d.status = $1 AND (p.healthcare_id = $2 OR c.healthcare_id = $2)
+		ORDER BY d.score DESC, d.created_at
+		LIMIT $3;`, status, healthcare_id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	var duplicates []*DuplicateCandidate
+	var owns []bool
+	for rows.Next() {
+		duplicate := &DuplicateCandidate{}
+		var ownsPatient bool
+		var fields string
+		err := rows.Scan(&duplicate.ID, &duplicate.HealthID, &duplicate.CandidateHealthID, &duplicate.Score,
+			&fields, &duplicate.Status, &duplicate.CreatedAt, &duplicate.ReviewedBy, &ownsPatient)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if err := json.Unmarshal([]byte(fields), &duplicate.Fields); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read the fields of duplicate %d: %w", duplicate.ID, err)
+		}
+		duplicates = append(duplicates, duplicate)
+		owns = append(owns, ownsPatient)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	// only the reviewer's own patient is shown in full, the other one may be another HIP's
+	profile := func(healthID string) (*PatientDetails, error) {
+		return scanClientProfile(s.db.QueryRow(`SELECT `+clientProfileColumns+` FROM client_profile WHERE health_id = $1;`, healthID))
+	}
+	for i, duplicate := range duplicates {
+		if err := reviewDuplicate(duplicate, owns[i], profile); err != nil {
+			return nil, err
+		}
+	}
+	return duplicates, nil
+}
+
+func (s *SQLiteStore) DismissDuplicate(healthcare_id string, id int64) error {
+	result, err := s.db.Exec(`UPDATE duplicate_candidates SET status = 'dismissed', reviewed_by = $1, reviewed_at = $3
+		WHERE id = $2 AND status = 'pending' AND EXISTS (
+			SELECT 1 FROM client_profile p
+			WHERE p.health_id IN (duplicate_candidates.health_id, duplicate_candidates.candidate_health_id) AND p.healthcare_id = $1
+		);`, healthcare_id, id, sqliteNow())
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrDuplicateNotFound
+	}
+	return nil
+}
+
+// MergeClientProfiles is PostgresStore.MergeClientProfiles with the patient records moved in
+// the same transaction, they live in this database too
+func (s *SQLiteStore) MergeClientProfiles(healthcare_id, surviving, merged string) (*PatientMerge, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	owners, err := checkMergePair(tx, surviving, merged)
+	if err != nil {
+		return nil, err
+	}
+	approver, err := mergeApprover(owners, healthcare_id)
+	if err != nil {
+		return nil, err
+	}
+	if approver != "" {
+		return nil, fmt.Errorf("%w: %s registered one of the patients", ErrMergeApprovalRequired, approver)
+	}
+	merge, err := mergeSQLiteProfiles(tx, healthcare_id, surviving, merged)
+	if err != nil {
+		return nil, err
+	}
+	return merge, tx.Commit()
+}
+
+func (s *SQLiteStore) RequestMerge(healthcare_id, surviving, merged string) (*MergeRequest, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	owners, err := checkMergePair(tx, surviving, merged)
+	if err != nil {
+		return nil, err
+	}
+	request, err := requestMerge(tx, owners, healthcare_id, surviving, merged, sqliteNow())
+	if err != nil {
+		return nil, err
+	}
+	return request, tx.Commit()
+}
+
+func (s *SQLiteStore) ListMergeRequests(healthcare_id, status string, limit int64) ([]*MergeRequest, error) {
+	return listMergeRequests(s.db, healthcare_id, status, limit)
+}
+
+func (s *SQLiteStore) ApproveMergeRequest(healthcare_id string, id int64) (*PatientMerge, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	request, err := decideMergeRequest(tx, healthcare_id, id, "approved", sqliteNow())
+	if err != nil {
+		return nil, err
+	}
+	owners, err := checkMergePair(tx, request.SurvivingHealthID, request.MergedHealthID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMergeRequest(owners, request); err != nil {
+		return nil, err
+	}
+	merge, err := mergeSQLiteProfiles(tx, request.RequestedBy, request.SurvivingHealthID, request.MergedHealthID)
+	if err != nil {
+		return nil, err
+	}
+	if err := setMergeRequestMerge(tx, request, merge.ID); err != nil {
+		return nil, err
+	}
+	return merge, tx.Commit()
+}
+
+func (s *SQLiteStore) RejectMergeRequest(healthcare_id string, id int64) (*MergeRequest, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	request, err := decideMergeRequest(tx, healthcare_id, id, "rejected", sqliteNow())
+	if err != nil {
+		return nil, err
+	}
+	return request, tx.Commit()
+}
+
+func mergeSQLiteProfiles(tx *sql.Tx, healthcare_id, surviving, merged string) (*PatientMerge, error) {
+	now := sqliteNow()
+	var err error
+
+	moves := MergeMoves{}
+	if moves.Appointments, err = sqliteMove(tx, `UPDATE appointments SET health_id = $1, updated_at = $3 WHERE health_id = $2 RETURNING id;`, surviving, merged, now); err != nil {
+		return nil, fmt.Errorf("failed to move appointments: %w", err)
+	}
+	records, err := tx.Query(`UPDATE patient_records SET health_id = $1 WHERE health_id = $2 RETURNING record_id;`, surviving, merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move patient records: %w", err)
+	}
+	moves.Records = []string{}
+	for records.Next() {
+		var id string
+		if err := records.Scan(&id); err != nil {
+			records.Close()
+			return nil, err
+		}
+		moves.Records = append(moves.Records, id)
+	}
+	records.Close()
+	if err := records.Err(); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(`SELECT profile_viewed, profile_updated, records_viewed, records_created FROM client_stats WHERE health_id = $1;`, merged).
+		Scan(&moves.Stats.ProfileViewed, &moves.Stats.ProfileUpdated, &moves.Stats.RecordsViewed, &moves.Stats.RecordsCreated)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to read client_stats: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE client_stats SET profile_viewed = profile_viewed + $2, profile_updated = profile_updated + $3,
+		records_viewed = records_viewed + $4, records_created = records_created + $5 WHERE health_id = $1;`,
+		surviving, moves.Stats.ProfileViewed, moves.Stats.ProfileUpdated, moves.Stats.RecordsViewed, moves.Stats.RecordsCreated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move client_stats: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE client_stats SET profile_viewed = 0, profile_updated = 0, records_viewed = 0, records_created = 0 WHERE health_id = $1;`, merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset client_stats: %w", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE client_profile SET merged_into = $1, updated_at = $3 WHERE health_id = $2;`, surviving, merged, now); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE duplicate_candidates SET status = 'merged', reviewed_by = $3, reviewed_at = $4
+		WHERE status = 'pending' AND min(health_id, candidate_health_id) = min($1, $2) AND max(health_id, candidate_health_id) = max($1, $2);`,
+		surviving, merged, healthcare_id, now)
+	if err != nil {
+		return nil, err
+	}
+
+	merge := &PatientMerge{SurvivingHealthID: surviving, MergedHealthID: merged, MergedBy: healthcare_id, Moved: moves}
+	err = tx.QueryRow(`INSERT INTO patient_merges (surviving_health_id, merged_health_id, merged_by, merged_at, moved)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, merged_at;`, surviving, merged, healthcare_id, now, mustJSON(moves)).Scan(&merge.ID, &merge.MergedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record merge: %w", err)
+	}
+	return merge, nil
+}
+
+// ids returned by an UPDATE ... RETURNING id
+func sqliteMove(tx *sql.Tx, query string, args ...interface{}) ([]int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// lockMergePair without the row locks, the immediate transaction already holds the database
+func checkMergePair(tx *sql.Tx, surviving, merged string) (map[string]string, error) {
+	if surviving == merged {
+		return nil, fmt.Errorf("%w: cannot merge %s into itself", ErrAlreadyMerged, merged)
+	}
+	rows, err := tx.Query(`SELECT health_id, healthcare_id, COALESCE(merged_into, '') FROM client_profile
+		WHERE health_id IN ($1, $2);`, surviving, merged)
+	if err != nil {
+		return nil, err
+	}
+	return scanMergePair(rows)
+}
+
+func (s *SQLiteStore) GetMerge(mergeID int64) (*PatientMerge, error) {
+	merge := &PatientMerge{}
+	var unmergedBy sql.NullString
+	var moved string
+	err := s.db.QueryRow(`SELECT id, surviving_health_id, merged_health_id, merged_by, merged_at, unmerged_by, unmerged_at, moved
+		FROM patient_merges WHERE id = $1;`, mergeID).Scan(&merge.ID, &merge.SurvivingHealthID, &merge.MergedHealthID,
+		&merge.MergedBy, &merge.MergedAt, &unmergedBy, &merge.UnmergedAt, &moved)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMergeNotFound
+		}
+		return nil, err
+	}
+	merge.UnmergedBy = unmergedBy.String
+	if err := json.Unmarshal([]byte(moved), &merge.Moved); err != nil {
+		return nil, fmt.Errorf("failed to read what merge %d moved: %w", merge.ID, err)
+	}
+	return merge, nil
+}
+
+func (s *SQLiteStore) UnmergeClientProfiles(healthcare_id string, mergeID int64) (*PatientMerge, error) {
+	merge, err := s.GetMerge(mergeID)
+	if err != nil {
+		return nil, err
+	}
+	if merge.UnmergedAt != nil {
+		return nil, ErrMergeNotFound
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the surviving patient's HIP holds both records since the merge, it decides to split them
+	var owned bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM client_profile WHERE health_id = $1 AND healthcare_id = $2)`,
+		merge.SurvivingHealthID, healthcare_id).Scan(&owned)
+	if err != nil {
+		return nil, err
+	}
+	if !owned {
+		return nil, ErrUnmergeForbidden
+	}
+	now := sqliteNow()
+	result, err := tx.Exec(`UPDATE patient_merges SET unmerged_by = $2, unmerged_at = $3 WHERE id = $1 AND unmerged_at IS NULL;`, mergeID, healthcare_id, now)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, ErrMergeNotFound
+	}
+
+	// json_each stands in for = ANY($3)
+	_, err = tx.Exec(`UPDATE appointments SET health_id = $1, updated_at = $4 WHERE health_id = $2 AND id IN (SELECT value FROM json_each($3));`,
+		merge.MergedHealthID, merge.SurvivingHealthID, mustJSON(merge.Moved.Appointments), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move appointments back: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE patient_records SET health_id = $1 WHERE record_id IN (SELECT value FROM json_each($2));`,
+		merge.MergedHealthID, mustJSON(merge.Moved.Records))
+	if err != nil {
+		return nil, fmt.Errorf("failed to move patient records back: %w", err)
+	}
+	stats := merge.Moved.Stats
+	_, err = tx.Exec(`UPDATE client_stats SET profile_viewed = max(profile_viewed - $2, 0), profile_updated = max(profile_updated - $3, 0),
+		records_viewed = max(records_viewed - $4, 0), records_created = max(records_created - $5, 0) WHERE health_id = $1;`,
+		merge.SurvivingHealthID, stats.ProfileViewed, stats.ProfileUpdated, stats.RecordsViewed, stats.RecordsCreated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move client_stats back: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE client_stats SET profile_viewed = $2, profile_updated = $3, records_viewed = $4, records_created = $5 WHERE health_id = $1;`,
+		merge.MergedHealthID, stats.ProfileViewed, stats.ProfileUpdated, stats.RecordsViewed, stats.RecordsCreated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore client_stats: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE client_profile SET merged_into = NULL, updated_at = $2 WHERE health_id = $1;`, merge.MergedHealthID, now); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE duplicate_candidates SET status = 'dismissed', reviewed_by = $3, reviewed_at = $4
+		WHERE min(health_id, candidate_health_id) = min($1, $2) AND max(health_id, candidate_health_id) = max($1, $2);`,
+		merge.SurvivingHealthID, merge.MergedHealthID, healthcare_id, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetMerge(mergeID)
+}
+
+func (s *SQLiteStore) GetTotalRequestCount(healthcare_id string) (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT totalrequest_count FROM HealthCare_pref WHERE healthcare_id = $1;`, healthcare_id).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve totalrequest_count: %w", err)
+	}
+	return count, nil
+}
+
+func (s *SQLiteStore) CreateClient_stats(health_id string) error {
+	_, err := s.db.Exec(`INSERT INTO client_stats (health_id, account_status,
+		available_money, profile_viewed, profile_updated, records_viewed,
+		records_created) VALUES ($1, $2, $3, $4, $5, $6, $7);`, health_id, "Trial", 5000, 0, 0, 0, 0)
+	return err
+}
+
+func (s *SQLiteStore) GetAppointments(healthcare_id string, offset, limit int64) ([]*Appointments, error) {
+	rows, err := s.db.Query(`SELECT id, health_id, status, appointment_date, appointment_time, healthcare_id, department, note, fullname, healthcare_name
+		FROM appointments WHERE healthcare_id = $1`, healthcare_id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var appointments []*Appointments
+	for rows.Next() {
+		var appointment Appointments
+		var date time.Time
+		err := rows.Scan(&appointment.ID, &appointment.HealthID, &appointment.Status, &date,
+			&appointment.AppointmentTime, &appointment.HealthcareID, &appointment.Department,
+			&appointment.Note, &appointment.FullName, &appointment.HealthcareName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		// postgres hands the timestamp back as RFC 3339 text
+		appointment.AppointmentDate = date.Format(time.RFC3339)
+		appointments = append(appointments, &appointment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return appointments, nil
+}
+
+func (s *SQLiteStore) SetAppointments(healthcare_id, healthID, status string, id int64) (int64, error) {
+	result, err := s.db.Exec(`UPDATE appointments SET status = $1, updated_at = $4 WHERE health_id = $2 AND healthcare_id = $3`,
+		status, healthID, healthcare_id, sqliteNow())
+	if err != nil {
+		return 0, fmt.Errorf("failed to update appointments: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch rows affected: %w", err)
+	}
+	return rowsAffected, nil
+}
+
+// BookAppointment adds an appointment, the local store has no service that books them. An ID of 0
+// takes the next one, AppointmentDate is RFC 3339 like GetAppointments returns it
+func (s *SQLiteStore) BookAppointment(a *Appointments) (int64, error) {
+	date, err := time.Parse(time.RFC3339, a.AppointmentDate)
+	if err != nil {
+		return 0, fmt.Errorf("invalid appointment date: %w", err)
+	}
+	var id int64
+	err = s.db.QueryRow(`INSERT INTO appointments (id, health_id, healthcare_id, appointment_date, appointment_time, status,
+		department, note, fullname, healthcare_name, created_at, updated_at)
+		VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11) RETURNING id;`,
+		a.ID, a.HealthID, a.HealthcareID, date.UTC(), a.AppointmentTime, a.Status, a.Department, a.Note, a.FullName,
+		a.HealthcareName, sqliteNow()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to book appointment: %w", err)
+	}
+	return id, nil
+}
+
+func (s *SQLiteStore) CheckAppointment(healthcare_id, healthID string, id int64) error {
+	return checkAppointment(s.db, healthcare_id, healthID, id)
+}
+
+// patient records, kept in mongo by CombinedStore
+
+func (s *SQLiteStore) CreatepatientRecords(healthcare_id string, patientrecords *PatientRecords) (*PatientRecords, error) {
+	record, err := CreatePatientRecords(healthcare_id, patientrecords)
+	if err != nil {
+		return nil, err
+	}
+	// ObjectIDs keep record ids the same shape as in mongo
+	record.ID = primitive.NewObjectID()
+	record.CreatedAt = sqliteNow()
+	_, err = s.db.Exec(`INSERT INTO patient_records (record_id, health_id, issue, createdby_, description, medical_severity, healthcare_name, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`,
+		record.ID.Hex(), record.HealthID, record.Issue, record.Createdby_, record.Description, record.MedicalSeverity, record.HealthcareName, record.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *SQLiteStore) GetPatientRecords(health_id, severity string, list int) (*[]PatientRecords, error) {
+	// mongo treats a limit of 0 as no limit, SQLite spells that -1
+	if list <= 0 {
+		list = -1
+	}
+	rows, err := s.db.Query(`SELECT record_id, health_id, issue, createdby_, description, medical_severity, healthcare_name, created_at
+		FROM patient_records WHERE health_id = $1 AND ($2 = '' OR medical_severity = $2)
+		ORDER BY id LIMIT $3;`, health_id, severity, list)
+	if err != nil {
+		return nil, fmt.Errorf("error in database")
+	}
+	defer rows.Close()
+
+	records := []PatientRecords{}
+	for rows.Next() {
+		var record PatientRecords
+		var id string
+		err := rows.Scan(&id, &record.HealthID, &record.Issue, &record.Createdby_, &record.Description,
+			&record.MedicalSeverity, &record.HealthcareName, &record.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding patient records: %w", err)
+		}
+		record.ID, _ = primitive.ObjectIDFromHex(id)
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error decoding patient records: %w", err)
+	}
+	return &records, nil
+}
+
+// Enqueue works like PostgresStore.Enqueue
+func (s *SQLiteStore) Enqueue(messages ...mq.Message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertSQLiteOutbox(tx, messages); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertSQLiteOutbox writes messages to the outbox and appends them to the event log,
+// writes are serialized already so positions are in commit order
+func insertSQLiteOutbox(tx *sql.Tx, messages []mq.Message) error {
+	now := sqliteNow()
+	for _, msg := range messages {
+		event, err := parseEvent(msg)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO outbox (routing_key, body, created_at, next_attempt_at) VALUES ($1, $2, $3, $3);`,
+			msg.RoutingKey, string(msg.Body), now)
+		if err != nil {
+			return fmt.Errorf("failed to write %s message to the outbox: %w", msg.RoutingKey, err)
+		}
+		_, err = tx.Exec(`INSERT INTO event_log (event_id, type, version, occurred_at, body, recorded_at) VALUES ($1, $2, $3, $4, $5, $6);`,
+			event.ID, event.Type, event.Version, event.OccurredAt.UTC(), string(msg.Body), now)
+		if err != nil {
+			return fmt.Errorf("failed to append %s event to the log: %w", msg.RoutingKey, err)
+		}
+	}
+	return nil
+}
+
+// RelayOutbox works like PostgresStore.RelayOutbox, there is a single writer so no lock is needed
+func (s *SQLiteStore) RelayOutbox(publish func(mq.Message) error) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := sqliteNow()
+	rows, err := tx.Query(`SELECT id, routing_key, body, attempts, next_attempt_at <= $1
+		FROM outbox WHERE published_at IS NULL ORDER BY id LIMIT $2;`, now, outboxBatch)
+	if err != nil {
+		return 0, err
+	}
+	pending, err := scanOutbox(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	sent, failed := relayBatch(pending, publish)
+	for _, id := range sent {
+		if _, err := tx.Exec(`UPDATE outbox SET published_at = $2 WHERE id = $1;`, id, now); err != nil {
+			return 0, err
+		}
+	}
+	for msg, publishErr := range failed {
+		_, err := tx.Exec(`UPDATE outbox SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3 WHERE id = $1;`,
+			msg.ID, publishErr.Error(), now.Add(outboxRetry(msg.Attempts+1)))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(sent), tx.Commit()
+}
+
+// ProjectEvents works like PostgresStore.ProjectEvents
+func (s *SQLiteStore) ProjectEvents() (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	n, err := projectAll(tx)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (s *SQLiteStore) RebuildProjections() (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	n, err := rebuildCounters(tx)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (s *SQLiteStore) EventLogStatus() (*EventLogStatus, error) {
+	return eventLogStatus(s.db)
+}
+
+func (s *SQLiteStore) StreamEvents(publish func(healthcareID string, update rd.LiveUpdate) error) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	n, err := streamEvents(tx, publish)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (s *SQLiteStore) LiveUpdatesSince(healthcare_id string, after int64) ([]rd.LiveUpdate, error) {
+	return liveUpdatesSince(s.db, healthcare_id, after)
+}
+
+func (s *SQLiteStore) CreateWebhook(webhook *Webhook) error {
+	return createWebhook(s.db, webhook)
+}
+
+func (s *SQLiteStore) ListWebhooks(healthcare_id string) ([]*Webhook, error) {
+	return listWebhooks(s.db, healthcare_id)
+}
+
+func (s *SQLiteStore) GetWebhook(healthcare_id string, id int64) (*Webhook, error) {
+	return getWebhook(s.db, healthcare_id, id)
+}
+
+func (s *SQLiteStore) UpdateWebhook(healthcare_id string, id int64, patch *WebhookPatch) (*Webhook, error) {
+	return updateWebhook(s.db, healthcare_id, id, patch)
+}
+
+func (s *SQLiteStore) DeleteWebhook(healthcare_id string, id int64) error {
+	return deleteWebhook(s.db, healthcare_id, id)
+}
+
+func (s *SQLiteStore) ListWebhookDeliveries(healthcare_id string, webhookID int64, status string, limit int64) ([]*WebhookDelivery, error) {
+	return listWebhookDeliveries(s.db, healthcare_id, webhookID, status, limit)
+}
+
+func (s *SQLiteStore) GetWebhookDelivery(healthcare_id string, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	return getWebhookDelivery(s.db, healthcare_id, webhookID, deliveryID)
+}
+
+func (s *SQLiteStore) RedeliverWebhook(healthcare_id string, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	return redeliverWebhook(s.db, healthcare_id, webhookID, deliveryID)
+}
+
+// DeliverWebhooks works like PostgresStore.DeliverWebhooks, a single process claims so no row lock is needed
+func (s *SQLiteStore) DeliverWebhooks(post func(webhooks.Request) webhooks.Response) (int, error) {
+	return deliverWebhooks(s.db, "", post)
+}
+
+func (s *SQLiteStore) GetNotificationPreferences(healthcare_id, recipientType, recipientID string) (*NotificationPreferences, error) {
+	return getNotificationPreferences(s.db, healthcare_id, recipientType, recipientID)
+}
+
+func (s *SQLiteStore) SetNotificationPreferences(healthcare_id string, prefs *NotificationPreferences) error {
+	return setNotificationPreferences(s.db, healthcare_id, prefs)
+}
+
+func (s *SQLiteStore) ListNotifications(healthcare_id, status string, limit int64) ([]*Notification, error) {
+	return listNotifications(s.db, healthcare_id, status, limit)
+}
+
+func (s *SQLiteStore) GetNotification(healthcare_id string, id int64) (*Notification, error) {
+	return getNotification(s.db, healthcare_id, id)
+}
+
+// SendNotifications works like PostgresStore.SendNotifications
+func (s *SQLiteStore) SendNotifications(channels notify.Channels) (int, error) {
+	return sendNotifications(s.db, "", channels)
+}
+
+func (s *SQLiteStore) RemindAppointments(schedule ReminderSchedule) (int, error) {
+	return remindAppointments(s.db, schedule, sqliteNow(), insertSQLiteOutbox)
+}
+
+func (s *SQLiteStore) AnswerReminder(from, text string) (*ReminderReply, error) {
+	return answerReminder(s.db, from, text, insertSQLiteOutbox)
+}
@@ -0,0 +1,372 @@
+-- schema of the local SQLite store, the postgres migrations folded into one file.
+-- Timestamps are written by the store as UTC text so they compare in order.
+
+CREATE TABLE IF NOT EXISTS HIP_TABLE (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	healthcare_id TEXT NOT NULL UNIQUE,
+	healthcare_license TEXT NOT NULL UNIQUE,
+	healthcare_name TEXT NOT NULL UNIQUE,
+	email TEXT NOT NULL UNIQUE,
+	availability TEXT NOT NULL,
+	total_facilities INTEGER NOT NULL,
+	total_mbbs_doc INTEGER NOT NULL,
+	total_worker INTEGER NOT NULL,
+	no_of_beds INTEGER NOT NULL,
+	date_of_registration TIMESTAMP NOT NULL,
+	password TEXT NOT NULL,
+	about TEXT NOT NULL,
+	country TEXT NOT NULL,
+	state TEXT NOT NULL,
+	city TEXT NOT NULL,
+	landmark TEXT NOT NULL,
+	region_code TEXT,
+	zone_code TEXT,
+	woreda_code TEXT,
+	kebele_code TEXT,
+	address_needs_review BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS HealthCare_pref (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	healthcare_id TEXT NOT NULL REFERENCES HIP_TABLE(healthcare_id) ON DELETE CASCADE,
+	scheduled_deletion TEXT,
+	profile_viewed INTEGER,
+	profile_updated INTEGER NOT NULL,
+	account_locked TEXT NOT NULL,
+	records_created INTEGER NOT NULL,
+	records_viewed INTEGER NOT NULL,
+	totalrequest_count INTEGER NOT NULL,
+	appointmentFee INTEGER NOT NULL,
+	isAvailable TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS client_profile (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	health_id TEXT NOT NULL UNIQUE,
+	first_name TEXT NOT NULL,
+	middle_name TEXT,
+	last_name TEXT NOT NULL,
+	sex TEXT NOT NULL,
+	healthcare_id TEXT NOT NULL,
+	dob TEXT NOT NULL,
+	blood_group TEXT NOT NULL,
+	bmi TEXT NOT NULL,
+	marriage_status TEXT NOT NULL,
+	weight TEXT NOT NULL,
+	email TEXT NOT NULL,
+	mobile_number TEXT NOT NULL,
+	aadhaar_number TEXT NOT NULL,
+	primary_location TEXT NOT NULL,
+	sibling TEXT NOT NULL,
+	twin TEXT NOT NULL,
+	father_name TEXT NOT NULL,
+	mother_name TEXT NOT NULL,
+	emergency_number TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	country TEXT NOT NULL,
+	state TEXT NOT NULL,
+	city TEXT NOT NULL,
+	landmark TEXT NOT NULL,
+	region_code TEXT,
+	zone_code TEXT,
+	woreda_code TEXT,
+	kebele_code TEXT,
+	address_needs_review BOOLEAN NOT NULL DEFAULT FALSE,
+	first_name_key TEXT,
+	middle_name_key TEXT,
+	last_name_key TEXT,
+	father_name_key TEXT,
+	merged_into TEXT,
+	version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS client_profile_healthcare_id_idx ON client_profile (healthcare_id);
+CREATE INDEX IF NOT EXISTS client_profile_aadhaar_number_idx ON client_profile (aadhaar_number);
+
+CREATE TABLE IF NOT EXISTS client_stats (
+	health_id TEXT PRIMARY KEY REFERENCES client_profile(health_id) ON DELETE CASCADE,
+	account_status TEXT NOT NULL DEFAULT 'Trial' CHECK (account_status IN ('Trial', 'Testing', 'Beta', 'Premium')),
+	available_money TEXT NOT NULL DEFAULT '5000',
+	profile_viewed INTEGER NOT NULL DEFAULT 0,
+	profile_updated INTEGER NOT NULL DEFAULT 0,
+	records_viewed INTEGER NOT NULL DEFAULT 0,
+	records_created INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS client_profile_versions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	health_id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	snapshot TEXT NOT NULL,
+	changed_fields TEXT NOT NULL DEFAULT '[]',
+	changed_by TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	valid_from TIMESTAMP NOT NULL,
+	changed_at TIMESTAMP NOT NULL,
+	UNIQUE (health_id, version)
+);
+
+CREATE TRIGGER IF NOT EXISTS client_profile_versions_no_update BEFORE UPDATE ON client_profile_versions
+BEGIN
+	SELECT RAISE(ABORT, 'client_profile_versions is append only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS client_profile_versions_no_delete BEFORE DELETE ON client_profile_versions
+BEGIN
+	SELECT RAISE(ABORT, 'client_profile_versions is append only');
+END;
+
+CREATE TABLE IF NOT EXISTS duplicate_candidates (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	health_id TEXT NOT NULL REFERENCES client_profile(health_id) ON DELETE CASCADE,
+	candidate_health_id TEXT NOT NULL REFERENCES client_profile(health_id) ON DELETE CASCADE,
+	score REAL NOT NULL,
+	fields TEXT NOT NULL DEFAULT '{}',
+	status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'merged', 'dismissed')),
+	created_at TIMESTAMP NOT NULL,
+	reviewed_by TEXT,
+	reviewed_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS duplicate_candidates_pair_idx ON duplicate_candidates (min(health_id, candidate_health_id), max(health_id, candidate_health_id));
+
+CREATE TABLE IF NOT EXISTS patient_merges (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	surviving_health_id TEXT NOT NULL REFERENCES client_profile(health_id) ON DELETE CASCADE,
+	merged_health_id TEXT NOT NULL REFERENCES client_profile(health_id) ON DELETE CASCADE,
+	merged_by TEXT NOT NULL,
+	merged_at TIMESTAMP NOT NULL,
+	unmerged_by TEXT,
+	unmerged_at TIMESTAMP,
+	moved TEXT NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE IF NOT EXISTS merge_requests (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	surviving_health_id TEXT NOT NULL REFERENCES client_profile(health_id) ON DELETE CASCADE,
+	merged_health_id TEXT NOT NULL REFERENCES client_profile(health_id) ON DELETE CASCADE,
+	requested_by TEXT NOT NULL,
+	approver TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+	merge_id INTEGER REFERENCES patient_merges(id) ON DELETE SET NULL,
+	created_at TIMESTAMP NOT NULL,
+	decided_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS merge_requests_pending_idx ON merge_requests
+	(min(surviving_health_id, merged_health_id), max(surviving_health_id, merged_health_id)) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS appointments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	health_id TEXT NOT NULL REFERENCES client_profile(health_id) ON DELETE CASCADE,
+	healthcare_id TEXT NOT NULL,
+	appointment_date TIMESTAMP NOT NULL,
+	appointment_time TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'pending',
+	department TEXT NOT NULL DEFAULT '',
+	note TEXT NOT NULL DEFAULT '',
+	fullname TEXT NOT NULL DEFAULT '',
+	healthcare_name TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP,
+	updated_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS appointments_healthcare_id_idx ON appointments (healthcare_id);
+CREATE INDEX IF NOT EXISTS appointments_health_id_idx ON appointments (health_id);
+
+-- patient records live in mongo in the full setup
+CREATE TABLE IF NOT EXISTS patient_records (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	record_id TEXT NOT NULL UNIQUE,
+	health_id TEXT NOT NULL,
+	issue TEXT NOT NULL,
+	createdby_ TEXT NOT NULL,
+	description TEXT NOT NULL,
+	medical_severity TEXT NOT NULL,
+	healthcare_name TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS patient_records_health_id_idx ON patient_records (health_id);
+
+-- queue messages written with the change they announce, see databases/outbox.go
+CREATE TABLE IF NOT EXISTS outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	routing_key TEXT NOT NULL,
+	body TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_error TEXT,
+	published_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id) WHERE published_at IS NULL;
+
+-- every published event, written with its outbox row, see databases/eventlog.go
+CREATE TABLE IF NOT EXISTS event_log (
+	position INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id TEXT NOT NULL UNIQUE,
+	type TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	occurred_at TIMESTAMP NOT NULL,
+	body TEXT NOT NULL,
+	recorded_at TIMESTAMP NOT NULL
+);
+
+CREATE TRIGGER IF NOT EXISTS event_log_no_update BEFORE UPDATE ON event_log
+BEGIN
+	SELECT RAISE(ABORT, 'event_log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS event_log_no_delete BEFORE DELETE ON event_log
+BEGIN
+	SELECT RAISE(ABORT, 'event_log is append-only');
+END;
+
+CREATE TABLE IF NOT EXISTS projection_checkpoints (
+	name TEXT PRIMARY KEY,
+	position INTEGER NOT NULL
+);
+
+-- endpoints HIPs registered for their events, see databases/webhooks.go
+CREATE TABLE IF NOT EXISTS webhooks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	healthcare_id TEXT NOT NULL REFERENCES HIP_TABLE(healthcare_id) ON DELETE CASCADE,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	events TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	active BOOLEAN NOT NULL DEFAULT 1,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_healthcare_id_idx ON webhooks (healthcare_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	position INTEGER NOT NULL REFERENCES event_log(position),
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_status_code INTEGER,
+	last_error TEXT,
+	delivered_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	UNIQUE (webhook_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	delivery_id INTEGER NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+	status_code INTEGER,
+	error TEXT,
+	response TEXT NOT NULL DEFAULT '',
+	duration_ms INTEGER NOT NULL,
+	attempted_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_attempts_delivery_id_idx ON webhook_attempts (delivery_id);
+
+-- how a HIP or a patient wants to be notified, see databases/notifications.go
+CREATE TABLE IF NOT EXISTS notification_preferences (
+	recipient_type TEXT NOT NULL,
+	recipient_id TEXT NOT NULL,
+	language TEXT NOT NULL,
+	email BOOLEAN NOT NULL,
+	sms BOOLEAN NOT NULL,
+	muted TEXT NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (recipient_type, recipient_id)
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	position INTEGER NOT NULL REFERENCES event_log(position),
+	type TEXT NOT NULL,
+	healthcare_id TEXT NOT NULL,
+	recipient_type TEXT NOT NULL,
+	recipient_id TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	address TEXT NOT NULL,
+	language TEXT NOT NULL,
+	subject TEXT NOT NULL DEFAULT '',
+	body TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_error TEXT,
+	sent_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	UNIQUE (position, type, recipient_type, recipient_id, channel)
+);
+
+CREATE INDEX IF NOT EXISTS notifications_healthcare_id_idx ON notifications (healthcare_id, id);
+CREATE INDEX IF NOT EXISTS notifications_due_idx ON notifications (next_attempt_at) WHERE status = 'pending';
+
+-- the events logged before notifications existed are not notified
+INSERT INTO projection_checkpoints (name, position)
+SELECT 'notifications', COALESCE(MAX(position), 0) FROM event_log WHERE true
+ON CONFLICT (name) DO NOTHING;
+
+-- one reminder of an appointment, see databases/reminders.go
+CREATE TABLE IF NOT EXISTS appointment_reminders (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+	healthcare_id TEXT NOT NULL,
+	health_id TEXT NOT NULL,
+	before_minutes INTEGER NOT NULL,
+	reply_code TEXT NOT NULL,
+	phone TEXT NOT NULL,
+	reply TEXT,
+	replied_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	UNIQUE (appointment_id, before_minutes)
+);
+
+CREATE INDEX IF NOT EXISTS appointment_reminders_open_idx ON appointment_reminders (phone, id) WHERE reply IS NULL;
+
+-- the counters before the event log counted them, a rebuild starts from them (databases/eventlog.go).
+-- Taken once, counters_baseline is written last and marks it done
+CREATE TABLE IF NOT EXISTS hip_counter_baselines (
+	healthcare_id TEXT PRIMARY KEY,
+	profile_viewed INTEGER NOT NULL,
+	profile_updated INTEGER NOT NULL,
+	records_created INTEGER NOT NULL,
+	records_viewed INTEGER NOT NULL,
+	totalrequest_count INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS patient_counter_baselines (
+	health_id TEXT PRIMARY KEY,
+	profile_viewed INTEGER NOT NULL,
+	profile_updated INTEGER NOT NULL,
+	records_viewed INTEGER NOT NULL,
+	records_created INTEGER NOT NULL
+);
+
+INSERT INTO projection_checkpoints (name, position)
+SELECT 'counters', COALESCE(MAX(position), 0) FROM event_log
+WHERE NOT EXISTS (SELECT 1 FROM projection_checkpoints WHERE name = 'counters_baseline')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO hip_counter_baselines (healthcare_id, profile_viewed, profile_updated, records_created, records_viewed, totalrequest_count)
+SELECT healthcare_id, COALESCE(profile_viewed, 0), profile_updated, records_created, records_viewed, totalrequest_count
+FROM HealthCare_pref
+WHERE NOT EXISTS (SELECT 1 FROM projection_checkpoints WHERE name = 'counters_baseline')
+ON CONFLICT (healthcare_id) DO NOTHING;
+
+INSERT INTO patient_counter_baselines (health_id, profile_viewed, profile_updated, records_viewed, records_created)
+SELECT health_id, profile_viewed, profile_updated, records_viewed, records_created
+FROM client_stats
+WHERE NOT EXISTS (SELECT 1 FROM projection_checkpoints WHERE name = 'counters_baseline')
+ON CONFLICT (health_id) DO NOTHING;
+
+INSERT INTO projection_checkpoints (name, position)
+SELECT 'counters_baseline', position FROM projection_checkpoints WHERE name = 'counters'
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,322 @@
+package databases
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestSQLite(t *testing.T) *SQLiteStore {
+	t.Helper()
+	store, err := ConnectToSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func testPatient(healthID, first string) *PatientDetails {
+	created := time.Now().UTC().Add(-time.Hour)
+	return &PatientDetails{
+		HealthID: healthID, FirstName: first, MiddleName: "Kebede", LastName: "Tesfaye", Sex: "F",
+		HealthcareID: "HIP-0001", DOB: "1990-05-01", BloodGroup: "O+", BMI: "22", MarriageStatus: "single",
+		Weight: "60", Email: "patient@example.com", MobileNumber: "+251 911 234 567", AadhaarNumber: "N/A",
+		PrimaryLocation: "Adama", Sibling: "1", Twin: "no", FatherName: "Kebede", MotherName: "Almaz",
+		EmergencyNumber: "0911000000", CreatedAt: created, UpdatedAt: created,
+		Address: Address{Country: "Ethiopia", State: "Oromia", City: "Adama", Landmark: "bus station"},
+	}
+}
+
+func TestSQLiteStoreProfiles(t *testing.T) {
+	store := newTestSQLite(t)
+	hip := &HIPInfo{HealthcareID: "HIP-0001", HealthcareLicense: "LIC-1", HealthcareName: "Adama Hospital", Email: "hip@example.com",
+		Availability: "24x7", TotalFacilities: 5, TotalMBBSDoc: 5, TotalWorker: 5, NoOfBeds: 5, Password: "hash", About: "general hospital"}
+	if _, err := store.SignUpAccount(hip); err != nil {
+		t.Fatal(err)
+	}
+	if pref, err := store.GetPreferance("HIP-0001"); err != nil || !pref.IsAvailable || pref.Email != "hip@example.com" {
+		t.Fatalf("preferance = %+v, %v", pref, err)
+	}
+
+	for _, p := range []*PatientDetails{testPatient("HID-1", "Almaz"), testPatient("HID-2", "Almaz")} {
+		if err := store.Create_ClientProfile(p); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CreateClient_stats(p.HealthID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// phone numbers are compared on their digits
+	matches, err := store.SearchClientProfiles("HIP-0001", &PatientSearch{Phone: "0911-234-567"})
+	if err != nil || len(matches) != 2 {
+		t.Fatalf("search by phone = %d matches, %v", len(matches), err)
+	}
+
+	patch, _ := ParseProfilePatch([]byte(`{"fname": "Alemitu"}`))
+	updated, err := store.UpdateClientProfile("HID-1", patch, 1, ProfileChange{ChangedBy: "HIP-0001"})
+	if err != nil || updated.FirstName != "Alemitu" || updated.Version != 2 {
+		t.Fatalf("update = %+v, %v", updated, err)
+	}
+	if _, err := store.UpdateClientProfile("HID-1", patch, 1, ProfileChange{}); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("stale update err = %v, want ErrVersionMismatch", err)
+	}
+	old, err := store.GetClientProfileAsOf("HID-1", updated.UpdatedAt.Add(-time.Second))
+	if err != nil || old.FirstName != "Almaz" {
+		t.Errorf("as of before the update = %+v, %v", old, err)
+	}
+	versions, err := store.ListProfileVersions("HID-1")
+	if err != nil || len(versions) != 1 || versions[0].ChangedFields[0] != "fname" {
+		t.Errorf("versions = %+v, %v", versions, err)
+	}
+}
+
+// a version is valid from its valid_from up to, not including, its changed_at, in whatever zone the time is asked in
+func TestSQLiteStoreProfileAsOfBoundaries(t *testing.T) {
+	store := newTestSQLite(t)
+	if err := store.Create_ClientProfile(testPatient("HID-1", "Almaz")); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"Alemitu", "Aster"} {
+		patch, _ := ParseProfilePatch([]byte(`{"fname": "` + name + `"}`))
+		if _, err := store.UpdateClientProfile("HID-1", patch, i+1, ProfileChange{ChangedBy: "HIP-0001"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, err := store.ListProfileVersions("HID-1")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("versions = %+v, %v", versions, err)
+	}
+	first, second := versions[1], versions[0]
+	if !second.ValidFrom.Equal(first.ChangedAt) {
+		t.Fatalf("version 2 is valid from %s, version 1 changed at %s", second.ValidFrom, first.ChangedAt)
+	}
+	addisAbaba := time.FixedZone("EAT", 3*60*60)
+	cases := []struct {
+		name    string
+		at      time.Time
+		version int
+		fname   string
+	}{
+		{"created", first.ValidFrom, 1, "Almaz"},
+		{"just before the first change", first.ChangedAt.Add(-time.Nanosecond), 1, "Almaz"},
+		{"first change", first.ChangedAt, 2, "Alemitu"},
+		{"first change in local time", first.ChangedAt.In(addisAbaba), 2, "Alemitu"},
+		{"just before the first change in local time", first.ChangedAt.Add(-time.Nanosecond).In(addisAbaba), 1, "Almaz"},
+		{"just before the second change", second.ChangedAt.Add(-time.Nanosecond), 2, "Alemitu"},
+		{"second change", second.ChangedAt, 3, "Aster"},
+	}
+	for _, c := range cases {
+		profile, err := store.GetClientProfileAsOf("HID-1", c.at)
+		if err != nil || profile.Version != c.version || profile.FirstName != c.fname {
+			t.Errorf("%s: as of %s = %+v, %v, want version %d %s", c.name, c.at, profile, err, c.version, c.fname)
+		}
+	}
+	if _, err := store.GetClientProfileAsOf("HID-1", first.ValidFrom.Add(-time.Nanosecond)); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("before the profile was created err = %v, want ErrVersionNotFound", err)
+	}
+}
+
+// the candidates are cut at searchCandidateLimit, the closest names must be kept
+func TestSQLiteStoreSearchPastCandidateLimit(t *testing.T) {
+	store := newTestSQLite(t)
+	for i := 0; i <= searchCandidateLimit; i++ {
+		if err := store.Create_ClientProfile(testPatient(fmt.Sprintf("HID-%04d", i), "Alemayehu")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Create_ClientProfile(testPatient("HID-9999", "Almaz")); err != nil {
+		t.Fatal(err)
+	}
+	matches, err := store.SearchClientProfiles("HIP-0001", &PatientSearch{Name: "Almaz", Limit: 1})
+	if err != nil || len(matches) != 1 || matches[0].Profile.HealthID != "HID-9999" {
+		t.Fatalf("search past the candidate limit = %+v, %v", matches, err)
+	}
+}
+
+func TestSQLiteStoreCheckAppointment(t *testing.T) {
+	store := newTestSQLite(t)
+	for _, healthID := range []string{"HID-1", "HID-2"} {
+		if err := store.Create_ClientProfile(testPatient(healthID, "Almaz")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := store.db.Exec(`INSERT INTO appointments (id, health_id, healthcare_id, appointment_date) VALUES (1, 'HID-1', 'HIP-0001', $1);`,
+		time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CheckAppointment("HIP-0001", "HID-1", 1); err != nil {
+		t.Errorf("the HIP's own appointment: %v", err)
+	}
+	for _, c := range []struct {
+		healthcareID, healthID string
+		id                     int64
+	}{{"HIP-0002", "HID-1", 1}, {"HIP-0001", "HID-2", 1}, {"HIP-0001", "HID-1", 2}} {
+		if err := store.CheckAppointment(c.healthcareID, c.healthID, c.id); !errors.Is(err, ErrAppointmentNotFound) {
+			t.Errorf("CheckAppointment(%s, %s, %d) = %v, want ErrAppointmentNotFound", c.healthcareID, c.healthID, c.id, err)
+		}
+	}
+}
+
+func TestSQLiteStoreMerge(t *testing.T) {
+	store := newTestSQLite(t)
+	for _, p := range []*PatientDetails{testPatient("HID-1", "Almaz"), testPatient("HID-2", "Almaz")} {
+		if err := store.Create_ClientProfile(p); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CreateClient_stats(p.HealthID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	duplicates, err := store.FindDuplicates(testPatient("HID-2", "Almaz"))
+	if err != nil || len(duplicates) != 1 || duplicates[0].ID == 0 {
+		t.Fatalf("duplicates = %+v, %v", duplicates, err)
+	}
+	record, err := store.CreatepatientRecords("HIP-0001", &PatientRecords{Issue: "fever", Description: "high fever",
+		HealthID: "HID-2", MedicalSeverity: "low", HealthcareName: "Adama Hospital"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merge, err := store.MergeClientProfiles("HIP-0001", "HID-1", "HID-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merge.Moved.Records) != 1 || merge.Moved.Records[0] != record.ID.Hex() {
+		t.Errorf("moved records = %v, want %s", merge.Moved.Records, record.ID.Hex())
+	}
+	if p, err := store.Get_ClientProfile("HID-2"); err != nil || p.HealthID != "HID-1" {
+		t.Errorf("merged health_id resolves to %+v, %v", p, err)
+	}
+	if queue, _ := store.GetDuplicateQueue("HIP-0001", "pending", 10); len(queue) != 0 {
+		t.Errorf("pending duplicates after merge = %d", len(queue))
+	}
+
+	if _, err := store.UnmergeClientProfiles("HIP-0001", merge.ID); err != nil {
+		t.Fatal(err)
+	}
+	records, err := store.GetPatientRecords("HID-2", "", 0)
+	if err != nil || len(*records) != 1 {
+		t.Errorf("records after unmerge = %v, %v", records, err)
+	}
+	if _, err := store.UnmergeClientProfiles("HIP-0001", merge.ID); !errors.Is(err, ErrMergeNotFound) {
+		t.Errorf("second unmerge err = %v, want ErrMergeNotFound", err)
+	}
+}
+
+func TestSQLiteStoreDuplicateQueueRedacted(t *testing.T) {
+	store := newTestSQLite(t)
+	other := testPatient("HID-2", "Almaz")
+	other.HealthcareID = "HIP-0002"
+	other.Email = "almaz@example.com"
+	for _, p := range []*PatientDetails{testPatient("HID-1", "Almaz"), other} {
+		if err := store.Create_ClientProfile(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.FindDuplicates(other); err != nil {
+		t.Fatal(err)
+	}
+	for _, hip := range []struct{ healthcareID, own, other string }{
+		{"HIP-0001", "HID-1", "HID-2"},
+		{"HIP-0002", "HID-2", "HID-1"},
+	} {
+		queue, err := store.GetDuplicateQueue(hip.healthcareID, "pending", 10)
+		if err != nil || len(queue) != 1 {
+			t.Fatalf("%s queue = %+v, %v", hip.healthcareID, queue, err)
+		}
+		if queue[0].Patient.HealthID != hip.own || queue[0].Patient.HealthcareID != hip.healthcareID {
+			t.Errorf("%s sees patient %+v in full", hip.healthcareID, queue[0].Patient)
+		}
+		candidate := queue[0].Candidate
+		if candidate.HealthID != hip.other || candidate.Score != queue[0].Score || !slices.Contains(candidate.Reasons, "name") || !slices.Contains(candidate.Reasons, "phone") {
+			t.Errorf("%s sees candidate %+v", hip.healthcareID, candidate)
+		}
+	}
+	// a row that can't be read is an error, not a candidate without reasons
+	if _, err := store.db.Exec(`UPDATE duplicate_candidates SET fields = 'not json';`); err != nil {
+		t.Fatal(err)
+	}
+	if queue, err := store.GetDuplicateQueue("HIP-0001", "pending", 10); err == nil {
+		t.Errorf("queue with unreadable fields = %+v", queue)
+	}
+}
+
+func TestSQLiteStoreMergeRequests(t *testing.T) {
+	store := newTestSQLite(t)
+	other := testPatient("HID-2", "Almaz")
+	other.HealthcareID = "HIP-0002"
+	for _, p := range []*PatientDetails{testPatient("HID-1", "Almaz"), other} {
+		if err := store.Create_ClientProfile(p); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CreateClient_stats(p.HealthID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := store.MergeClientProfiles("HIP-0001", "HID-1", "HID-2"); !errors.Is(err, ErrMergeApprovalRequired) {
+		t.Fatalf("merge of another HIP's patient err = %v, want ErrMergeApprovalRequired", err)
+	}
+	if _, err := store.RequestMerge("HIP-0003", "HID-1", "HID-2"); !errors.Is(err, ErrMergeForbidden) {
+		t.Errorf("request by a third HIP err = %v, want ErrMergeForbidden", err)
+	}
+	request, err := store.RequestMerge("HIP-0001", "HID-1", "HID-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Approver != "HIP-0002" || request.Status != "pending" {
+		t.Errorf("request = %+v", request)
+	}
+	// asking again, either way round, is the same request
+	if again, err := store.RequestMerge("HIP-0002", "HID-2", "HID-1"); err != nil || again.ID != request.ID {
+		t.Errorf("second request = %+v, %v", again, err)
+	}
+	if requests, err := store.ListMergeRequests("HIP-0002", "pending", 10); err != nil || len(requests) != 1 {
+		t.Errorf("pending requests of the approver = %+v, %v", requests, err)
+	}
+
+	if _, err := store.ApproveMergeRequest("HIP-0001", request.ID); !errors.Is(err, ErrMergeRequestNotFound) {
+		t.Errorf("approved by the requester err = %v, want ErrMergeRequestNotFound", err)
+	}
+	merge, err := store.ApproveMergeRequest("HIP-0002", request.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merge.MergedBy != "HIP-0001" || merge.SurvivingHealthID != "HID-1" {
+		t.Errorf("merge = %+v", merge)
+	}
+	if p, err := store.Get_ClientProfile("HID-2"); err != nil || p.HealthID != "HID-1" {
+		t.Errorf("merged health_id resolves to %+v, %v", p, err)
+	}
+	requests, err := store.ListMergeRequests("HIP-0001", "", 10)
+	if err != nil || len(requests) != 1 || requests[0].Status != "approved" || requests[0].MergeID == nil || *requests[0].MergeID != merge.ID {
+		t.Errorf("requests after approval = %+v, %v", requests, err)
+	}
+	if _, err := store.RejectMergeRequest("HIP-0002", request.ID); !errors.Is(err, ErrMergeRequestNotFound) {
+		t.Errorf("rejecting a decided request err = %v, want ErrMergeRequestNotFound", err)
+	}
+
+	// only the HIP of the surviving patient splits them again
+	if _, err := store.UnmergeClientProfiles("HIP-0002", merge.ID); !errors.Is(err, ErrUnmergeForbidden) {
+		t.Errorf("unmerge by the merged patient's HIP err = %v, want ErrUnmergeForbidden", err)
+	}
+	if _, err := store.UnmergeClientProfiles("HIP-0001", merge.ID); err != nil {
+		t.Fatal(err)
+	}
+	request, err = store.RequestMerge("HIP-0002", "HID-2", "HID-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the requester can withdraw it
+	if rejected, err := store.RejectMergeRequest("HIP-0002", request.ID); err != nil || rejected.Status != "rejected" || rejected.DecidedAt == nil {
+		t.Errorf("rejected = %+v, %v", rejected, err)
+	}
+}
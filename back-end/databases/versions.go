@@ -0,0 +1,133 @@
+package databases
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+)
+
+// Every profile update keeps the profile as it was before the change in client_profile_versions,
+// together with who changed it and why. Rows there are never updated or deleted (a trigger enforces it),
+// so the profile can be shown as it was on any date.
+
+var ErrVersionNotFound = errors.New("profile version not found")
+
+// ProfileChange says who is changing a profile and why, it is stored with the version being replaced
+type ProfileChange struct {
+	ChangedBy string
+	Reason    string
+	// Announce builds the events for the update, they go to the outbox with it
+	Announce func(previous, updated *PatientDetails) ([]mq.Message, error)
+}
+
+func (c ProfileChange) messages(previous, updated *PatientDetails) ([]mq.Message, error) {
+	if c.Announce == nil {
+		return nil, nil
+	}
+	return c.Announce(previous, updated)
+}
+
+type ProfileVersion struct {
+	Version       int             `json:"version"`
+	ChangedBy     string          `json:"changed_by"`
+	Reason        string          `json:"reason"`
+	ValidFrom     time.Time       `json:"valid_from"`
+	ChangedAt     time.Time       `json:"changed_at"`
+	ChangedFields []string        `json:"changed_fields"`
+	Profile       *PatientDetails `json:"profile,omitempty"`
+}
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// bookkeeping fields that change on every update and would only add noise to a diff
+var diffIgnored = map[string]bool{
+	"updated_at": true,
+	"version":    true,
+}
+
+// DiffProfiles lists every field that differs between two versions, address fields as address.<name>
+func DiffProfiles(from, to *PatientDetails) ([]FieldChange, error) {
+	a, err := flattenJSON(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := flattenJSON(to)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]bool{}
+	for field := range a {
+		fields[field] = true
+	}
+	for field := range b {
+		fields[field] = true
+	}
+	changes := []FieldChange{}
+	for field := range fields {
+		if diffIgnored[field] || reflect.DeepEqual(a[field], b[field]) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: field, From: a[field], To: b[field]})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes, nil
+}
+
+// ChangedFields is DiffProfiles without the values
+func ChangedFields(from, to *PatientDetails) []string {
+	changes, err := DiffProfiles(from, to)
+	if err != nil {
+		return []string{}
+	}
+	fields := make([]string, 0, len(changes))
+	for _, change := range changes {
+		fields = append(fields, change.Field)
+	}
+	return fields
+}
+
+// JSON field name -> value, nested objects become parent.child
+func flattenJSON(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %T: %w", v, err)
+	}
+	nested := map[string]interface{}{}
+	if err := json.Unmarshal(data, &nested); err != nil {
+		return nil, fmt.Errorf("failed to decode %T: %w", v, err)
+	}
+	flat := map[string]interface{}{}
+	for key, value := range nested {
+		if child, ok := value.(map[string]interface{}); ok {
+			for childKey, childValue := range child {
+				flat[key+"."+childKey] = childValue
+			}
+			continue
+		}
+		flat[key] = value
+	}
+	return flat, nil
+}
+
+// ParseAsOf accepts a date (whole day counts, so the profile at the end of it) or an RFC 3339 timestamp
+func ParseAsOf(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date must be YYYY-MM-DD or RFC 3339: %w", err)
+	}
+	return day.Add(24*time.Hour - time.Nanosecond), nil
+}
@@ -0,0 +1,552 @@
+package databases
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"vaibhavyadav-dev/healthcareServer/events"
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+	"vaibhavyadav-dev/healthcareServer/webhooks"
+)
+
+// A HIP registers webhooks, an endpoint of its own and the event types it wants there as topic
+// patterns (appointment.status.changed, patient.profile.*). The webhooks projection follows the
+// event log like the counters one and turns each event of the HIP into a delivery per matching
+// active webhook, so an event is never delivered twice because a relay round ran again.
+//
+// The delivery loop posts due deliveries (webhooks.Post signs them). A claimed delivery is leased
+// for webhookLease, if the server stops mid-request another round picks it up after that. A failed
+// attempt is retried with backoff (webhookRetry) until webhookMaxAttempts, then the delivery is
+// failed. Every attempt is kept, and the HIP can redeliver any delivery, which starts it over.
+
+const (
+	webhooksProjection = "webhooks"
+	webhookMaxAttempts = 10
+	webhookMaxBackoff  = time.Hour
+	webhookLease       = 2 * webhooks.Timeout
+	// requests in flight at once, one slow receiver doesn't hold back the others
+	webhookConcurrency = 10
+)
+
+var (
+	ErrWebhookNotFound         = errors.New("webhook not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrInvalidWebhookURL       = errors.New("webhook url must be https to a public address")
+)
+
+// EventFilterError is an event filter that matches none of the published event types
+type EventFilterError struct {
+	Filter string
+}
+
+func (e *EventFilterError) Error() string {
+	return fmt.Sprintf("event filter %q matches no event type", e.Filter)
+}
+
+type Webhook struct {
+	ID           int64    `json:"id"`
+	HealthcareID string   `json:"healthcare_id"`
+	URL          string   `json:"url" validate:"required,url"`
+	Events       []string `json:"events" validate:"required,min=1,max=20,dive,required"`
+	Description  string   `json:"description" validate:"max=200"`
+	Active       bool     `json:"active"`
+	// only in the response that created the webhook or rotated it
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookPatch is a change of a webhook, nil fields stay as they are
+type WebhookPatch struct {
+	URL          *string  `json:"url"`
+	Events       []string `json:"events"`
+	Description  *string  `json:"description"`
+	Active       *bool    `json:"active"`
+	RotateSecret bool     `json:"rotate_secret"`
+}
+
+type WebhookDelivery struct {
+	ID        int64  `json:"id"`
+	WebhookID int64  `json:"webhook_id"`
+	EventID   string `json:"event_id"`
+	EventType string `json:"event_type"`
+	// pending, delivered or failed
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// only while pending
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int       `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+
+	// filled for a single delivery
+	Event   map[string]interface{} `json:"event,omitempty"`
+	History []*WebhookAttempt      `json:"history,omitempty"`
+}
+
+// WebhookAttempt is one request made for a delivery, StatusCode is missing when the receiver couldn't be reached
+type WebhookAttempt struct {
+	ID          int64     `json:"id"`
+	StatusCode  *int      `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Response    string    `json:"response,omitempty"`
+	DurationMS  int64     `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// NewWebhook checks what a HIP sent to register a webhook and gives it a secret
+func NewWebhook(healthcareID string, req *Webhook) (*Webhook, error) {
+	webhook := &Webhook{
+		HealthcareID: healthcareID,
+		URL:          strings.TrimSpace(req.URL),
+		Events:       req.Events,
+		Description:  strings.TrimSpace(req.Description),
+		Active:       true,
+	}
+	if err := webhook.check(); err != nil {
+		return nil, err
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = secret
+	return webhook, nil
+}
+
+// Apply changes webhook by patch, the secret is only filled when it was rotated
+func (patch *WebhookPatch) Apply(webhook *Webhook) error {
+	if patch.URL != nil {
+		webhook.URL = strings.TrimSpace(*patch.URL)
+	}
+	if patch.Events != nil {
+		webhook.Events = patch.Events
+	}
+	if patch.Description != nil {
+		webhook.Description = strings.TrimSpace(*patch.Description)
+	}
+	if patch.Active != nil {
+		webhook.Active = *patch.Active
+	}
+	if err := webhook.check(); err != nil {
+		return err
+	}
+	if patch.RotateSecret {
+		secret, err := webhooks.NewSecret()
+		if err != nil {
+			return err
+		}
+		webhook.Secret = secret
+	}
+	return nil
+}
+
+func (w *Webhook) check() error {
+	if err := newValidator().Struct(w); err != nil {
+		return toValidationError(err)
+	}
+	if err := webhooks.CheckURL(w.URL); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidWebhookURL, err)
+	}
+	for _, filter := range w.Events {
+		if !matchesEventType(filter) {
+			return &EventFilterError{Filter: filter}
+		}
+	}
+	return nil
+}
+
+// matchesEventType reports whether filter matches a registered event type, a typo would never deliver anything
+func matchesEventType(filter string) bool {
+	for _, eventType := range events.Types() {
+		if mq.TopicMatch(filter, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookRetry is how long a delivery waits after its nth failed attempt, 10s doubling up to an hour
+func webhookRetry(attempts int) time.Duration {
+	return min(10*time.Second<<min(max(attempts-1, 0), 9), webhookMaxBackoff)
+}
+
+// fanOutWebhooks is the apply of the webhooks projection, one delivery per active webhook of the HIP the event matches
+func fanOutWebhooks(tx *sql.Tx, e loggedEvent) error {
+	var event struct {
+		Data struct {
+			HealthcareID string `json:"healthcare_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(e.Body, &event); err != nil {
+		return err
+	}
+	if event.Data.HealthcareID == "" {
+		return nil
+	}
+	rows, err := tx.Query(`SELECT id, events FROM webhooks WHERE healthcare_id = $1 AND active;`, event.Data.HealthcareID)
+	if err != nil {
+		return err
+	}
+	var matching []int64
+	for rows.Next() {
+		var id int64
+		var filters []byte
+		if err := rows.Scan(&id, &filters); err != nil {
+			rows.Close()
+			return err
+		}
+		if webhookWants(filters, e.Type) {
+			matching = append(matching, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, id := range matching {
+		_, err := tx.Exec(`INSERT INTO webhook_deliveries (webhook_id, position, next_attempt_at, created_at) VALUES ($1, $2, $3, $3)
+			ON CONFLICT (webhook_id, position) DO NOTHING;`, id, e.Position, now)
+		if err != nil {
+			return fmt.Errorf("failed to queue delivery to webhook %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func webhookWants(filters []byte, eventType string) bool {
+	var patterns []string
+	json.Unmarshal(filters, &patterns)
+	for _, pattern := range patterns {
+		if mq.TopicMatch(pattern, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// the webhook columns in the order scanWebhook reads them
+const webhookColumns = `id, healthcare_id, url, events, description, active, created_at, updated_at`
+
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	webhook := &Webhook{}
+	var filters []byte
+	err := row.Scan(&webhook.ID, &webhook.HealthcareID, &webhook.URL, &filters, &webhook.Description,
+		&webhook.Active, &webhook.CreatedAt, &webhook.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return webhook, json.Unmarshal(filters, &webhook.Events)
+}
+
+func createWebhook(db *sql.DB, webhook *Webhook) error {
+	filters, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	err = db.QueryRow(`INSERT INTO webhooks (healthcare_id, url, secret, events, description, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7) RETURNING id;`,
+		webhook.HealthcareID, webhook.URL, webhook.Secret, string(filters), webhook.Description, webhook.Active, now).Scan(&webhook.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	webhook.CreatedAt, webhook.UpdatedAt = now, now
+	return nil
+}
+
+func listWebhooks(db *sql.DB, healthcare_id string) ([]*Webhook, error) {
+	rows, err := db.Query(`SELECT `+webhookColumns+` FROM webhooks WHERE healthcare_id = $1 ORDER BY id;`, healthcare_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func getWebhook(db *sql.DB, healthcare_id string, id int64) (*Webhook, error) {
+	webhook, err := scanWebhook(db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1 AND healthcare_id = $2;`, id, healthcare_id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	return webhook, err
+}
+
+func updateWebhook(db *sql.DB, healthcare_id string, id int64, patch *WebhookPatch) (*Webhook, error) {
+	webhook, err := getWebhook(db, healthcare_id, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := patch.Apply(webhook); err != nil {
+		return nil, err
+	}
+	filters, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return nil, err
+	}
+	webhook.UpdatedAt = time.Now().UTC()
+	result, err := db.Exec(`UPDATE webhooks SET url = $3, events = $4, description = $5, active = $6, updated_at = $7,
+		secret = CASE WHEN $8 = '' THEN secret ELSE $8 END
+		WHERE id = $1 AND healthcare_id = $2;`,
+		id, healthcare_id, webhook.URL, string(filters), webhook.Description, webhook.Active, webhook.UpdatedAt, webhook.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, ErrWebhookNotFound
+	}
+	return webhook, nil
+}
+
+// deleteWebhook removes a webhook with its deliveries and their attempts
+func deleteWebhook(db *sql.DB, healthcare_id string, id int64) error {
+	result, err := db.Exec(`DELETE FROM webhooks WHERE id = $1 AND healthcare_id = $2;`, id, healthcare_id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+const deliveryColumns = `d.id, d.webhook_id, e.event_id, e.type, d.status, d.attempts, d.next_attempt_at,
+	d.last_status_code, d.last_error, d.delivered_at, d.created_at`
+
+// scanDelivery reads deliveryColumns, followed by extra when the query selected more
+func scanDelivery(row rowScanner, extra ...any) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	var nextAttemptAt, deliveredAt sql.NullTime
+	var lastStatusCode sql.NullInt64
+	var lastError sql.NullString
+	dest := []any{&delivery.ID, &delivery.WebhookID, &delivery.EventID, &delivery.EventType, &delivery.Status, &delivery.Attempts,
+		&nextAttemptAt, &lastStatusCode, &lastError, &deliveredAt, &delivery.CreatedAt}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	if delivery.Status == "pending" && nextAttemptAt.Valid {
+		delivery.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if lastStatusCode.Valid {
+		code := int(lastStatusCode.Int64)
+		delivery.LastStatusCode = &code
+	}
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+	delivery.LastError = lastError.String
+	return delivery, nil
+}
+
+// listWebhookDeliveries is the newest deliveries of a webhook, status "" for all of them
+func listWebhookDeliveries(db *sql.DB, healthcare_id string, webhookID int64, status string, limit int64) ([]*WebhookDelivery, error) {
+	if _, err := getWebhook(db, healthcare_id, webhookID); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT `+deliveryColumns+`
+		FROM webhook_deliveries d JOIN event_log e ON e.position = d.position
+		WHERE d.webhook_id = $1 AND ($2 = '' OR d.status = $2)
+		ORDER BY d.id DESC LIMIT $3;`, webhookID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// getWebhookDelivery is a delivery with the event it carries and every attempt made for it
+func getWebhookDelivery(db *sql.DB, healthcare_id string, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	var body []byte
+	row := db.QueryRow(`SELECT `+deliveryColumns+`, e.body
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		JOIN event_log e ON e.position = d.position
+		WHERE d.id = $1 AND d.webhook_id = $2 AND w.healthcare_id = $3;`, deliveryID, webhookID, healthcare_id)
+	delivery, err := scanDelivery(row, &body)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &delivery.Event); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT id, status_code, error, response, duration_ms, attempted_at
+		FROM webhook_attempts WHERE delivery_id = $1 ORDER BY id;`, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	delivery.History = []*WebhookAttempt{}
+	for rows.Next() {
+		attempt := &WebhookAttempt{}
+		var statusCode sql.NullInt64
+		var attemptErr sql.NullString
+		if err := rows.Scan(&attempt.ID, &statusCode, &attemptErr, &attempt.Response, &attempt.DurationMS, &attempt.AttemptedAt); err != nil {
+			return nil, err
+		}
+		if statusCode.Valid {
+			code := int(statusCode.Int64)
+			attempt.StatusCode = &code
+		}
+		attempt.Error = attemptErr.String
+		delivery.History = append(delivery.History, attempt)
+	}
+	return delivery, rows.Err()
+}
+
+// redeliverWebhook puts a delivery back in the queue with all its attempts ahead of it, whatever its status
+func redeliverWebhook(db *sql.DB, healthcare_id string, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	result, err := db.Exec(`UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = $4
+		WHERE id = $1 AND webhook_id = $2 AND webhook_id IN (SELECT id FROM webhooks WHERE healthcare_id = $3);`,
+		deliveryID, webhookID, healthcare_id, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	return getWebhookDelivery(db, healthcare_id, webhookID, deliveryID)
+}
+
+type claimedDelivery struct {
+	id       int64
+	attempts int
+	request  webhooks.Request
+}
+
+// claimWebhookDeliveries leases a batch of due deliveries, lock is what keeps two replicas from claiming the same rows
+func claimWebhookDeliveries(db *sql.DB, lock string) ([]*claimedDelivery, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	rows, err := tx.Query(`SELECT d.id, d.attempts, w.url, w.secret, e.type, e.body
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		JOIN event_log e ON e.position = d.position
+		WHERE d.status = 'pending' AND w.active AND d.next_attempt_at <= $1
+		ORDER BY d.next_attempt_at, d.id LIMIT $2 `+lock+`;`, now, outboxBatch)
+	if err != nil {
+		return nil, err
+	}
+	var claimed []*claimedDelivery
+	for rows.Next() {
+		c := &claimedDelivery{}
+		if err := rows.Scan(&c.id, &c.attempts, &c.request.URL, &c.request.Secret, &c.request.EventType, &c.request.Body); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		c.request.DeliveryID = c.id
+		claimed = append(claimed, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, c := range claimed {
+		if _, err := tx.Exec(`UPDATE webhook_deliveries SET next_attempt_at = $2 WHERE id = $1;`, c.id, now.Add(webhookLease)); err != nil {
+			return nil, err
+		}
+	}
+	return claimed, tx.Commit()
+}
+
+// deliverWebhooks posts a claimed batch and records how each went, it returns how many were attempted
+func deliverWebhooks(db *sql.DB, lock string, post func(webhooks.Request) webhooks.Response) (int, error) {
+	claimed, err := claimWebhookDeliveries(db, lock)
+	if err != nil || len(claimed) == 0 {
+		return 0, err
+	}
+	responses := make([]webhooks.Response, len(claimed))
+	slots := make(chan struct{}, webhookConcurrency)
+	var wg sync.WaitGroup
+	for i, c := range claimed {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			responses[i] = post(c.request)
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	for i, c := range claimed {
+		if err := recordWebhookAttempt(db, c, responses[i]); err != nil {
+			errs = append(errs, fmt.Errorf("delivery %d: %w", c.id, err))
+		}
+	}
+	return len(claimed), errors.Join(errs...)
+}
+
+func recordWebhookAttempt(db *sql.DB, c *claimedDelivery, res webhooks.Response) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	var statusCode sql.NullInt64
+	if res.StatusCode != 0 {
+		statusCode = sql.NullInt64{Int64: int64(res.StatusCode), Valid: true}
+	}
+	var attemptErr sql.NullString
+	if res.Err != nil {
+		attemptErr = sql.NullString{String: res.Err.Error(), Valid: true}
+	}
+	_, err = tx.Exec(`INSERT INTO webhook_attempts (delivery_id, status_code, error, response, duration_ms, attempted_at)
+		VALUES ($1, $2, $3, $4, $5, $6);`, c.id, statusCode, attemptErr, res.Body, res.Duration.Milliseconds(), now)
+	if err != nil {
+		return err
+	}
+
+	attempts := c.attempts + 1
+	switch {
+	case res.OK():
+		_, err = tx.Exec(`UPDATE webhook_deliveries SET status = 'delivered', attempts = $2, last_status_code = $3, last_error = NULL,
+			delivered_at = $4 WHERE id = $1;`, c.id, attempts, statusCode, now)
+	case attempts >= webhookMaxAttempts:
+		_, err = tx.Exec(`UPDATE webhook_deliveries SET status = 'failed', attempts = $2, last_status_code = $3, last_error = $4
+			WHERE id = $1;`, c.id, attempts, statusCode, attemptErr)
+	default:
+		_, err = tx.Exec(`UPDATE webhook_deliveries SET attempts = $2, last_status_code = $3, last_error = $4, next_attempt_at = $5
+			WHERE id = $1;`, c.id, attempts, statusCode, attemptErr, now.Add(webhookRetry(attempts)))
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
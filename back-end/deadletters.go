@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+)
+
+const deadLettersUsage = `usage: dead-letters <command>
+  list [-limit n] [queue...]    dead-lettered messages of the consumed queues, or only the ones given
+  show <queue> <id>             one message with its last error and body
+  replay <queue> <id...>|-all   send messages back to their queue, attempts start over
+  discard <queue> <id...>|-all  drop messages for good`
+
+// runDeadLetters handles the dead-letters subcommand, only rabbitmq is needed for it
+func runDeadLetters(rabbitMqURL string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(deadLettersUsage)
+	}
+	rabbit, err := mq.Connect2rabbitmq(rabbitMqURL)
+	if err != nil {
+		return err
+	}
+	defer rabbit.Close()
+
+	switch args[0] {
+	case "list":
+		flags := flag.NewFlagSet("dead-letters list", flag.ContinueOnError)
+		limit := flags.Int("limit", 50, "maximum number of messages to list per queue, 0 for all")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		queues := flags.Args()
+		if len(queues) == 0 {
+			queues = mq.ConsumedQueues()
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "QUEUE\tID\tEVENT\tATTEMPTS\tFAILED\tERROR")
+		for _, queue := range queues {
+			letters, err := rabbit.DeadLetters(queue, *limit)
+			if err != nil {
+				return err
+			}
+			for _, l := range letters {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", l.Queue, l.ID, l.RoutingKey, l.Attempts, l.FailedAt, l.Error)
+			}
+		}
+		return w.Flush()
+	case "show":
+		if len(args) != 3 {
+			return fmt.Errorf(deadLettersUsage)
+		}
+		letters, err := rabbit.DeadLetters(args[1], 0)
+		if err != nil {
+			return err
+		}
+		for _, l := range letters {
+			if l.ID == args[2] {
+				fmt.Printf("queue:     %s\nid:        %s\nevent:     %s\nattempts:  %d\nfailed at: %s\nerror:     %s\n\n%s\n",
+					l.Queue, l.ID, l.RoutingKey, l.Attempts, l.FailedAt, l.Error, l.Body)
+				return nil
+			}
+		}
+		return fmt.Errorf("no dead-lettered message %s in %s", args[2], args[1])
+	case "replay", "discard":
+		queue, ids, err := deadLetterSelection(args)
+		if err != nil {
+			return err
+		}
+		act := rabbit.ReplayDeadLetters
+		if args[0] == "discard" {
+			act = rabbit.DiscardDeadLetters
+		}
+		n, err := act(queue, ids...)
+		fmt.Printf("%s %d of %s\n", args[0], n, mq.DeadQueue(queue))
+		if err == nil && len(ids) > 0 && n < len(ids) {
+			return fmt.Errorf("%d of the given ids were not found", len(ids)-n)
+		}
+		return err
+	default:
+		return fmt.Errorf(deadLettersUsage)
+	}
+}
+
+// deadLetterSelection is the queue and ids of replay and discard, no ids with -all.
+// Without either nothing is selected, so a forgotten id doesn't touch the whole queue
+func deadLetterSelection(args []string) (string, []string, error) {
+	if len(args) < 3 {
+		return "", nil, fmt.Errorf(deadLettersUsage)
+	}
+	if args[2] == "-all" {
+		if len(args) > 3 {
+			return "", nil, fmt.Errorf("-all takes no ids")
+		}
+		return args[1], nil, nil
+	}
+	return args[1], args[2:], nil
+}
@@ -0,0 +1,233 @@
+package ethiopic
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Names reach us both in Ge'ez script (ተስፋዬ) and in any of several Latin spellings
+// (Tesfaye, Tesfaie, Tesfay). Everything here works towards comparing them:
+//   Normalize     folds homophone fidel (ሐ/ኀ/ኸ -> ሀ, ሠ -> ሰ, ዐ -> አ, ፀ -> ጸ) and punctuation
+//   Transliterate turns fidel into plain lowercase Latin
+//   Key           phonetic skeleton that most spelling variants share, cheap enough to index
+//   Similarity    0..1 score used to rank search results
+
+const (
+	fidelStart = 0x1200
+	fidelEnd   = 0x135A
+)
+
+// consonant of every fidel row (8 code points each, starting at U+1200), "" for the vowel carriers አ and ዐ
+var rows = []string{
+	"h", "l", "h", "m", "s", "r", "s", "sh", // ሀ ለ ሐ መ ሠ ረ ሰ ሸ
+	"q", "qw", "q", "qw", "b", "v", "t", "ch", // ቀ ቈ ቐ ቘ በ ቨ ተ ቸ
+	"h", "hw", "n", "ny", "", "k", "kw", "h", // ኀ ኈ ነ ኘ አ ከ ኰ ኸ
+	"hw", "w", "", "z", "zh", "y", "d", "d", // ዀ ወ ዐ ዘ ዠ የ ደ ዸ
+	"j", "g", "gw", "g", "t", "ch", "p", "ts", // ጀ ገ ጐ ጘ ጠ ጨ ጰ ጸ
+	"ts", "f", "p", // ፀ ፈ ፐ
+}
+
+// vowel of each order: ä u i a e ɨ o wa, the sixth order is usually written without a vowel
+var orders = []string{"e", "u", "i", "a", "e", "", "o", "wa"}
+
+// vowel carriers have no consonant so the first and sixth order need an explicit vowel
+var carrierOrders = []string{"a", "u", "i", "a", "e", "e", "o", "wa"}
+
+// rows that sound the same in Amharic, folded onto the row we keep
+var homophones = map[rune]rune{
+	0x1210: 0x1200, // ሐ -> ሀ
+	0x1280: 0x1200, // ኀ -> ሀ
+	0x12B8: 0x1200, // ኸ -> ሀ
+	0x1220: 0x1230, // ሠ -> ሰ
+	0x12D0: 0x12A0, // ዐ -> አ
+	0x1340: 0x1338, // ፀ -> ጸ
+}
+
+// Normalize prepares a name for comparison: NFC, lower case, homophones folded,
+// Ethiopic punctuation turned into spaces and whitespace collapsed
+func Normalize(s string) string {
+	s = norm.NFC.String(s)
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 0x1361 && r <= 0x1368: // ፡ ። ፣ ፤ ፥ ፦ ፧ ፨
+			b.WriteRune(' ')
+		case r >= fidelStart && r < fidelEnd:
+			order := (r - fidelStart) % 8
+			if row, ok := homophones[r-order]; ok {
+				r = row + order
+			}
+			b.WriteRune(r)
+		case unicode.IsPunct(r):
+			b.WriteRune(' ')
+		default:
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// Transliterate turns fidel into Latin letters, anything that isn't fidel is left alone.
+// This is meant for matching, not for display, so it follows no particular standard.
+func Transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < fidelStart || r >= fidelEnd {
+			b.WriteRune(r)
+			continue
+		}
+		index := int(r - fidelStart)
+		row, order := index/8, index%8
+		if row >= len(rows) {
+			continue
+		}
+		if rows[row] == "" {
+			b.WriteString(carrierOrders[order])
+			continue
+		}
+		b.WriteString(rows[row])
+		b.WriteString(orders[order])
+	}
+	return b.String()
+}
+
+// Latin is Normalize followed by Transliterate, the form names are compared in
+func Latin(s string) string {
+	return Transliterate(Normalize(s))
+}
+
+// spelling variants that mean the same sound, applied in order
+var folds = strings.NewReplacer(
+	"sh", "x",
+	"ch", "c",
+	"ph", "p",
+	"th", "t",
+	"kh", "h",
+	"gh", "g",
+	"dh", "d",
+	"ts", "s",
+	"tz", "s",
+	"ck", "k",
+	"q", "k",
+	"v", "b",
+)
+
+// Key is the consonant skeleton of a name: Tesfaye, Tesfaie, ተስፋዬ -> tsf, Mohammed, Muhamed -> mhmd.
+// A leading vowel is kept as "a" so Eshetu and Ishetu still share a key, y and w count as vowels
+// everywhere except at the start.
+func Key(s string) string {
+	latin := Latin(s)
+	letters := make([]rune, 0, len(latin))
+	for _, r := range latin {
+		if r >= 'a' && r <= 'z' {
+			letters = append(letters, r)
+		}
+	}
+	if len(letters) == 0 {
+		return ""
+	}
+	folded := folds.Replace(string(letters))
+
+	var b strings.Builder
+	var last rune
+	for i, r := range folded {
+		if i == 0 && (r == 'y' || r == 'w') {
+			b.WriteRune(r)
+			last = r
+			continue
+		}
+		if isVowel(r) {
+			if i == 0 {
+				b.WriteRune('a')
+			}
+			last = 0
+			continue
+		}
+		// doubled consonants (gemination) are spelled either way
+		if r == last {
+			continue
+		}
+		b.WriteRune(r)
+		last = r
+	}
+	return b.String()
+}
+
+func isVowel(r rune) bool {
+	switch r {
+	case 'a', 'e', 'i', 'o', 'u', 'y', 'w':
+		return true
+	}
+	return false
+}
+
+// Similarity scores two names between 0 and 1 regardless of the script either is written in
+func Similarity(a, b string) float64 {
+	la, lb := Latin(a), Latin(b)
+	if la == "" || lb == "" {
+		return 0
+	}
+	if la == lb {
+		return 1
+	}
+	spelling := JaroWinkler(la, lb)
+	ka, kb := Key(a), Key(b)
+	if ka != "" && ka == kb {
+		// same sounds, only the spelling differs
+		return 0.85 + 0.15*spelling
+	}
+	return 0.6*spelling + 0.4*JaroWinkler(ka, kb)
+}
+
+// JaroWinkler is the usual string similarity, favouring strings that share a prefix
+func JaroWinkler(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+	window := max(len(ra), len(rb))/2 - 1
+	if window < 0 {
+		window = 0
+	}
+	matchedA := make([]bool, len(ra))
+	matchedB := make([]bool, len(rb))
+	matches := 0
+	for i := range ra {
+		lo, hi := max(0, i-window), min(len(rb), i+window+1)
+		for j := lo; j < hi; j++ {
+			if matchedB[j] || ra[i] != rb[j] {
+				continue
+			}
+			matchedA[i], matchedB[j] = true, true
+			matches++
+			break
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+	transpositions := 0
+	j := 0
+	for i := range ra {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if ra[i] != rb[j] {
+			transpositions++
+		}
+		j++
+	}
+	m := float64(matches)
+	jaro := (m/float64(len(ra)) + m/float64(len(rb)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(ra), len(rb)) && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
@@ -0,0 +1,53 @@
+package ethiopic
+
+import "testing"
+
+func TestKeyAcrossScripts(t *testing.T) {
+	same := [][2]string{
+		{"ተስፋዬ", "Tesfaye"},
+		{"Tesfaie", "Tesfay"},
+		{"ኃይሌ", "Haile"},
+		{"ሐይሌ", "Hayle"},
+		{"ግርማ", "Girma"},
+		{"ፀጋዬ", "Tsegaye"},
+		{"ከበደ", "Kebbede"},
+		{"እሸቱ", "Ishetu"},
+		{"ዮሐንስ", "Yohannes"},
+		{"Mohammed", "Muhamed"},
+	}
+	for _, pair := range same {
+		if Key(pair[0]) != Key(pair[1]) {
+			t.Errorf("Key(%q) = %q, Key(%q) = %q, want equal", pair[0], Key(pair[0]), pair[1], Key(pair[1]))
+		}
+		if score := Similarity(pair[0], pair[1]); score < 0.85 {
+			t.Errorf("Similarity(%q, %q) = %.3f, want >= 0.85", pair[0], pair[1], score)
+		}
+	}
+}
+
+func TestSimilarityRanksDifferentNamesLow(t *testing.T) {
+	different := [][2]string{
+		{"Abebe", "Kebede"},
+		{"አበበ", "ግርማ"},
+		{"Tesfaye", "Mulugeta"},
+	}
+	for _, pair := range different {
+		if score := Similarity(pair[0], pair[1]); score >= 0.75 {
+			t.Errorf("Similarity(%q, %q) = %.3f, want < 0.75", pair[0], pair[1], score)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"  Abebe   Kebede ": "abebe kebede",
+		"ሐጎስ፡ገብሩ":           "ሀጎስ ገብሩ",
+		"ሠላም":               "ሰላም",
+		"ዐለሙ":               "አለሙ",
+	}
+	for in, want := range cases {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
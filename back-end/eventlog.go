@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	db "vaibhavyadav-dev/healthcareServer/databases"
+)
+
+const eventsUsage = `usage: events <command>
+  status   events in the log and how far the counters projection has applied them
+  replay   apply the events after the projection's checkpoint, what the server does every second
+  rebuild  reset the counters and replay the whole log`
+
+// the event log of either store
+type eventLog interface {
+	EventLogStatus() (*db.EventLogStatus, error)
+	ProjectEvents() (int, error)
+	RebuildProjections() (int, error)
+}
+
+// runEvents handles the events subcommand, against the local SQLite file with STORE=local and postgres otherwise
+func runEvents(postgresConn string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf(eventsUsage)
+	}
+	store, err := openEventLog(postgresConn)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+	case "replay":
+		total := 0
+		for {
+			n, err := store.ProjectEvents()
+			total += n
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+		}
+		fmt.Printf("replayed %d events\n", total)
+	case "rebuild":
+		n, err := store.RebuildProjections()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rebuilt the counters from %d events\n", n)
+	default:
+		return fmt.Errorf(eventsUsage)
+	}
+
+	status, err := store.EventLogStatus()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d events in the log, newest at %d, counters at %d (%d behind)\n",
+		status.Events, status.Head, status.Checkpoint, status.Head-status.Checkpoint)
+	return nil
+}
+
+func openEventLog(postgresConn string) (eventLog, error) {
+	if os.Getenv("STORE") == "local" {
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "healthcare.db"
+		}
+		sqlite, err := db.ConnectToSQLite(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open sqlite: %w", err)
+		}
+		return sqlite, sqlite.Init()
+	}
+	postgres, err := db.ConnectToPostgreSQL(postgresConn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+	// the log tables are part of the schema
+	migrator, err := postgres.Migrator()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrator.Up(); err != nil {
+		return nil, err
+	}
+	return postgres, nil
+}
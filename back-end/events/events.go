@@ -0,0 +1,88 @@
+// Package events holds the domain events the server publishes. Each payload is a typed
+// struct, sent inside an Envelope that carries its type, schema version, a unique ID and
+// when it happened. The type is also the routing key on the topic exchange (rabbitmq.Exchange),
+// so consumers bind to what they need, e.g. patient.profile.* or #.
+//
+// A payload change that breaks consumers (a field removed, renamed or retyped) gets a new
+// version, adding a field does not. The JSON Schema of every registered type is served at
+// /events/schemas, consumers can validate against it.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+
+	"github.com/google/uuid"
+)
+
+// Payload is the data of one event type
+type Payload interface {
+	// EventType is the routing key, <aggregate>.<entity>.<what happened>
+	EventType() string
+	// EventVersion is the version of the payload's schema
+	EventVersion() int
+}
+
+// Envelope is the message body of every event
+type Envelope struct {
+	ID         string    `json:"id" validate:"required"`
+	Type       string    `json:"type" validate:"required"`
+	Version    int       `json:"version" validate:"required,min=1"`
+	OccurredAt time.Time `json:"occurred_at" validate:"required"`
+	Data       Payload   `json:"data" validate:"required"`
+}
+
+// New wraps data in an envelope with a fresh ID
+func New(data Payload) Envelope {
+	return Envelope{
+		ID:         uuid.NewString(),
+		Type:       data.EventType(),
+		Version:    data.EventVersion(),
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	}
+}
+
+// Message encodes data as a new event for the outbox
+func Message(data Payload) (mq.Message, error) {
+	if _, ok := registry[data.EventType()]; !ok {
+		return mq.Message{}, fmt.Errorf("event type %s is not registered", data.EventType())
+	}
+	body, err := json.Marshal(New(data))
+	if err != nil {
+		return mq.Message{}, err
+	}
+	return mq.Message{RoutingKey: data.EventType(), Body: body}, nil
+}
+
+// every event type the server publishes, by routing key
+var registry = map[string]Payload{}
+
+func register(payloads ...Payload) {
+	for _, p := range payloads {
+		if _, ok := registry[p.EventType()]; ok {
+			panic("event type registered twice: " + p.EventType())
+		}
+		registry[p.EventType()] = p
+	}
+}
+
+// Types lists the registered event types, sorted
+func Types() []string {
+	types := make([]string, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Lookup returns the zero value of a registered type's payload
+func Lookup(eventType string) (Payload, bool) {
+	p, ok := registry[eventType]
+	return p, ok
+}
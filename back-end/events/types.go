@@ -0,0 +1,127 @@
+package events
+
+import "time"
+
+func init() {
+	register(
+		HIPAccountCreated{}, HIPLoggedIn{}, HIPDeletionScheduled{},
+		PatientProfileCreated{}, PatientProfileViewed{}, PatientProfileUpdated{},
+		PatientRecordCreated{}, PatientRecordsViewed{},
+		AppointmentStatusChanged{}, AppointmentReminderDue{},
+	)
+}
+
+// HIP is the healthcare provider an event happened at, or that acted
+type HIP struct {
+	HealthcareID   string `json:"healthcare_id" validate:"required"`
+	HealthcareName string `json:"healthcare_name" validate:"required"`
+}
+
+// HIPAccountCreated is a new registration, the welcome mail goes to Email
+type HIPAccountCreated struct {
+	HIP
+	Email     string `json:"email" validate:"required,email"`
+	IPAddress string `json:"ip_address"`
+}
+
+func (HIPAccountCreated) EventType() string { return "hip.account.created" }
+func (HIPAccountCreated) EventVersion() int { return 1 }
+
+// HIPLoggedIn is sent for every login, so the HIP can spot one that wasn't theirs
+type HIPLoggedIn struct {
+	HIP
+	Email     string `json:"email" validate:"required,email"`
+	IPAddress string `json:"ip_address"`
+}
+
+func (HIPLoggedIn) EventType() string { return "hip.account.logged_in" }
+func (HIPLoggedIn) EventVersion() int { return 1 }
+
+type HIPDeletionScheduled struct {
+	HIP
+	Email string `json:"email" validate:"required,email"`
+}
+
+func (HIPDeletionScheduled) EventType() string { return "hip.account.deletion_scheduled" }
+func (HIPDeletionScheduled) EventVersion() int { return 1 }
+
+// Patient is whose profile or records an event is about
+type Patient struct {
+	HealthID string `json:"health_id" validate:"required"`
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+type PatientProfileCreated struct {
+	HIP
+	Patient Patient `json:"patient" validate:"required"`
+}
+
+func (PatientProfileCreated) EventType() string { return "patient.profile.created" }
+func (PatientProfileCreated) EventVersion() int { return 1 }
+
+type PatientProfileViewed struct {
+	HIP
+	Patient Patient `json:"patient" validate:"required"`
+}
+
+func (PatientProfileViewed) EventType() string { return "patient.profile.viewed" }
+func (PatientProfileViewed) EventVersion() int { return 1 }
+
+// PatientProfileUpdated carries the version the update produced and the fields it changed
+type PatientProfileUpdated struct {
+	HIP
+	Patient       Patient  `json:"patient" validate:"required"`
+	Version       int      `json:"version" validate:"required,min=1"`
+	ChangedFields []string `json:"changed_fields"`
+}
+
+func (PatientProfileUpdated) EventType() string { return "patient.profile.updated" }
+func (PatientProfileUpdated) EventVersion() int { return 1 }
+
+// PatientRecordCreated carries the whole record, the records consumer stores it from here
+type PatientRecordCreated struct {
+	HIP
+	HealthID        string    `json:"health_id" validate:"required"`
+	Issue           string    `json:"issue" validate:"required"`
+	Description     string    `json:"description" validate:"required"`
+	MedicalSeverity string    `json:"medical_severity" validate:"required,oneof=High Low Severe Normal"`
+	CreatedAt       time.Time `json:"created_at" validate:"required"`
+}
+
+func (PatientRecordCreated) EventType() string { return "patient.record.created" }
+func (PatientRecordCreated) EventVersion() int { return 1 }
+
+type PatientRecordsViewed struct {
+	HIP
+	HealthID string `json:"health_id" validate:"required"`
+	// empty when every severity was listed
+	Severity string `json:"severity,omitempty"`
+}
+
+func (PatientRecordsViewed) EventType() string { return "patient.records.viewed" }
+func (PatientRecordsViewed) EventVersion() int { return 1 }
+
+// AppointmentStatusChanged is a status update the appointments consumer applies
+type AppointmentStatusChanged struct {
+	HealthcareID  string `json:"healthcare_id" validate:"required"`
+	AppointmentID int64  `json:"appointment_id" validate:"required"`
+	HealthID      string `json:"health_id" validate:"required"`
+	Status        string `json:"status" validate:"required"`
+}
+
+func (AppointmentStatusChanged) EventType() string { return "appointment.status.changed" }
+func (AppointmentStatusChanged) EventVersion() int { return 1 }
+
+// AppointmentReminderDue is a reminder the scheduler sends Before the appointment. The patient
+// answers it by text message with ReplyCode, which confirms or cancels the appointment
+type AppointmentReminderDue struct {
+	HealthcareID  string `json:"healthcare_id" validate:"required"`
+	AppointmentID int64  `json:"appointment_id" validate:"required"`
+	HealthID      string `json:"health_id" validate:"required"`
+	Before        string `json:"before" validate:"required"`
+	ReplyCode     string `json:"reply_code" validate:"required"`
+}
+
+func (AppointmentReminderDue) EventType() string { return "appointment.reminder.due" }
+func (AppointmentReminderDue) EventVersion() int { return 1 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+
+	"vaibhavyadav-dev/healthcareServer/events"
+	"vaibhavyadav-dev/healthcareServer/i18n"
+
+	"github.com/gorilla/mux"
+)
+
+// The schema registry serves a JSON Schema per published event type, built from the
+// payload structs like the OpenAPI document is, so a consumer can validate what it reads
+// off the exchange against what the server says it sends.
+
+const eventSchemasPath = "/events/schemas"
+
+type eventSchemaEntry struct {
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+	// where the schema of the type is served
+	Schema string `json:"schema"`
+}
+
+func (s *APIServer) GetEventSchemas(w http.ResponseWriter, r *http.Request) error {
+	entries := []eventSchemaEntry{}
+	for _, eventType := range events.Types() {
+		payload, _ := events.Lookup(eventType)
+		entries = append(entries, eventSchemaEntry{
+			Type:    eventType,
+			Version: payload.EventVersion(),
+			Schema:  eventSchemasPath + "/" + eventType,
+		})
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{"event_types": entries, "fetched": len(entries)})
+}
+
+func (s *APIServer) GetEventSchema(w http.ResponseWriter, r *http.Request) error {
+	eventType := mux.Vars(r)["type"]
+	payload, ok := events.Lookup(eventType)
+	if !ok {
+		return newProblem(http.StatusNotFound, i18n.EventTypeNotFound, eventType)
+	}
+	return writeJSON(w, http.StatusOK, eventSchema(payload))
+}
+
+// eventSchema is the envelope with payload as its data, type and version are fixed to the payload's
+func eventSchema(payload events.Payload) map[string]interface{} {
+	b := &specBuilder{schemas: map[string]interface{}{}}
+	schema := b.structSchema(reflect.TypeOf(events.Envelope{}))
+	properties := schema["properties"].(map[string]interface{})
+	properties["type"].(map[string]interface{})["const"] = payload.EventType()
+	properties["version"].(map[string]interface{})["const"] = payload.EventVersion()
+	properties["data"] = b.schema(reflect.TypeOf(payload))
+
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["$id"] = eventSchemasPath + "/" + payload.EventType()
+	schema["title"] = payload.EventType()
+	return schema
+}
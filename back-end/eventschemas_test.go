@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"vaibhavyadav-dev/healthcareServer/events"
+	"vaibhavyadav-dev/healthcareServer/i18n"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSchema struct {
+	ID         string                `json:"$id"`
+	Required   []string              `json:"required"`
+	Const      interface{}           `json:"const"`
+	Properties map[string]testSchema `json:"properties"`
+}
+
+func getEventSchema(t *testing.T, api *testAPI, eventType string) testSchema {
+	t.Helper()
+	res := api.do("GET", "/events/schemas/"+eventType, "", map[string]string{"Authorization": ""})
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	var schema testSchema
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &schema))
+	return schema
+}
+
+// assertRequired checks every field the schema requires is in value, nested objects included
+func assertRequired(t *testing.T, schema testSchema, value map[string]interface{}, path string) {
+	t.Helper()
+	for _, name := range schema.Required {
+		field, ok := value[name]
+		if !assert.True(t, ok, "%s%s is required but missing", path, name) {
+			continue
+		}
+		if nested, ok := field.(map[string]interface{}); ok {
+			assertRequired(t, schema.Properties[name], nested, path+name+".")
+		}
+	}
+}
+
+func TestEventSchemas(t *testing.T) {
+	api := newTestAPI(t)
+
+	res := api.do("GET", "/events/schemas", "", map[string]string{"Authorization": ""})
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	var index struct {
+		EventTypes []eventSchemaEntry `json:"event_types"`
+		Fetched    int                `json:"fetched"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &index))
+	assert.Equal(t, len(events.Types()), index.Fetched)
+
+	for _, entry := range index.EventTypes {
+		schema := getEventSchema(t, api, entry.Type)
+		assert.Equal(t, entry.Schema, schema.ID)
+		assert.Equal(t, entry.Type, schema.Properties["type"].Const)
+		assert.Equal(t, float64(entry.Version), schema.Properties["version"].Const)
+		assert.Subset(t, schema.Required, []string{"id", "type", "version", "occurred_at", "data"}, entry.Type)
+		assert.NotEmpty(t, schema.Properties["data"].Required, "%s has no required data", entry.Type)
+	}
+
+	schema := getEventSchema(t, api, "patient.record.created")
+	assert.Contains(t, schema.Properties["data"].Required, "healthcare_id", "embedded HIP fields are flattened")
+
+	runCases(t, []handlerCase{
+		{name: "unknown type", method: "GET", path: "/events/schemas/patient.profile.deleted", header: map[string]string{"Authorization": ""},
+			status: http.StatusNotFound, code: i18n.EventTypeNotFound},
+		{name: "wrong method", method: "POST", path: "/events/schemas", header: map[string]string{"Authorization": ""},
+			status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+	})
+}
+
+// the events the handlers publish carry what their schema requires
+func TestPublishedEventsMatchSchemas(t *testing.T) {
+	api := newTestAPI(t)
+	record := `{"issue": "fever", "description": "high fever for two days", "health_id": "{health_id}", "medical_severity": "High"}`
+	res := api.do("POST", v1+"/client/records/create", record, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	res = api.do("GET", v1+"/client/profile/get?healthID={health_id}", "", nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	for _, eventType := range []string{"patient.record.created", "patient.profile.viewed"} {
+		event := queued(t, api, "logs")
+		require.Equal(t, eventType, event["type"])
+		assertRequired(t, getEventSchema(t, api, eventType), event, "")
+	}
+}
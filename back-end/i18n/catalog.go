@@ -0,0 +1,208 @@
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Every user facing message has a stable code, clients should branch on the code
+// and only show the text. Translations live in locales/<lang>.json
+
+type Lang string
+
+const (
+	English Lang = "en"
+	Amharic Lang = "am"
+	Oromo   Lang = "om"
+)
+
+type Code string
+
+const (
+	InternalError            Code = "internal_error"
+	MethodNotAllowed         Code = "method_not_allowed"
+	RouteNotFound            Code = "route_not_found"
+	InvalidPathParam         Code = "invalid_path_param"
+	InvalidRequestBody       Code = "invalid_request_body"
+	ValidationFailed         Code = "validation_failed"
+	InvalidAddress           Code = "invalid_address"
+	QueryParamMissing        Code = "query_param_missing"
+	InvalidQueryParam        Code = "invalid_query_param"
+	AuthHeaderInvalid        Code = "auth_header_invalid"
+	InvalidToken             Code = "invalid_token"
+	TokenMissingClaim        Code = "token_missing_claim"
+	RequestBlocked           Code = "request_blocked"
+	RateLimited              Code = "rate_limited"
+	RateLimitedSuspended     Code = "rate_limited_suspended"
+	QuotaExhausted           Code = "quota_exhausted"
+	HIPCreated               Code = "hip_created"
+	HIPAlreadyExists         Code = "hip_already_exists"
+	HIPNotFound              Code = "hip_not_found"
+	PasswordMismatch         Code = "password_mismatch"
+	NoFieldsToUpdate         Code = "no_fields_to_update"
+	PreferencesUpdated       Code = "preferences_updated"
+	AccountDeletionScheduled Code = "account_deletion_scheduled"
+	InvalidAppointmentStatus Code = "invalid_appointment_status"
+	AppointmentUpdateQueued  Code = "appointment_update_queued"
+	AppointmentNotFound      Code = "appointment_not_found"
+	HealthIDMissing          Code = "health_id_missing"
+	PatientCreated           Code = "patient_created"
+	PatientAlreadyExists     Code = "patient_already_exists"
+	PatientNotFound          Code = "patient_not_found"
+	InvalidMedicalSeverity   Code = "invalid_medical_severity"
+	RecordQueued             Code = "record_queued"
+	SearchCriteriaMissing    Code = "search_criteria_missing"
+	DuplicateNotFound        Code = "duplicate_not_found"
+	DuplicateDismissed       Code = "duplicate_dismissed"
+	PatientsMerged           Code = "patients_merged"
+	PatientsUnmerged         Code = "patients_unmerged"
+	PatientAlreadyMerged     Code = "patient_already_merged"
+	MergeNotFound            Code = "merge_not_found"
+	MergeForbidden           Code = "merge_forbidden"
+	UnmergeForbidden         Code = "unmerge_forbidden"
+	VersionNotFound          Code = "version_not_found"
+	PreconditionRequired     Code = "precondition_required"
+	ProfileChanged           Code = "profile_changed"
+	NothingToUpdate          Code = "nothing_to_update"
+	UnsupportedMediaType     Code = "unsupported_media_type"
+	InvalidIdempotencyKey    Code = "invalid_idempotency_key"
+	IdempotencyKeyReused     Code = "idempotency_key_reused"
+	IdempotencyKeyInProgress Code = "idempotency_key_in_progress"
+	EventTypeNotFound        Code = "event_type_not_found"
+	WebhookNotFound          Code = "webhook_not_found"
+	WebhookDeliveryNotFound  Code = "webhook_delivery_not_found"
+	InvalidWebhookURL        Code = "invalid_webhook_url"
+	InvalidEventFilter       Code = "invalid_event_filter"
+	WebhookCreated           Code = "webhook_created"
+	WebhookUpdated           Code = "webhook_updated"
+	WebhookDeleted           Code = "webhook_deleted"
+	WebhookRedeliveryQueued  Code = "webhook_redelivery_queued"
+	InvalidLastEventID       Code = "invalid_last_event_id"
+	StreamReset              Code = "stream_reset"
+	NotificationNotFound     Code = "notification_not_found"
+	NotificationPrefsSaved   Code = "notification_preferences_saved"
+	ReminderReplyAccepted    Code = "reminder_reply_accepted"
+	ReminderNotFound         Code = "reminder_not_found"
+	InvalidReminderReply     Code = "invalid_reminder_reply"
+	MergeRequested           Code = "merge_requested"
+	MergeRequestNotFound     Code = "merge_request_not_found"
+	MergeRequestRejected     Code = "merge_request_rejected"
+
+	// per field validation messages, first argument is always the field name
+	FieldRequired Code = "field_required"
+	FieldMin      Code = "field_min"
+	FieldMax      Code = "field_max"
+	FieldMinValue Code = "field_min_value"
+	FieldMaxValue Code = "field_max_value"
+	FieldEmail    Code = "field_email"
+	FieldOneOf    Code = "field_oneof"
+	FieldInvalid  Code = "field_invalid"
+	FieldType     Code = "field_type"
+	FieldReadOnly Code = "field_readonly"
+	FieldUnknown  Code = "field_unknown"
+)
+
+// first one is the fallback when nothing in Accept-Language matches
+var supported = []Lang{English, Amharic, Oromo}
+
+//go:embed locales/*.json
+var locales embed.FS
+
+var (
+	catalog = map[Lang]map[Code]string{}
+	matcher language.Matcher
+)
+
+func init() {
+	tags := make([]language.Tag, 0, len(supported))
+	for _, lang := range supported {
+		data, err := locales.ReadFile(path.Join("locales", string(lang)+".json"))
+		if err != nil {
+			panic(fmt.Sprintf("missing locale %s: %s", lang, err))
+		}
+		messages := map[Code]string{}
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("broken locale %s: %s", lang, err))
+		}
+		catalog[lang] = messages
+		tags = append(tags, language.Make(string(lang)))
+	}
+	matcher = language.NewMatcher(tags)
+}
+
+// Negotiate picks the best supported language for an Accept-Language header
+func Negotiate(acceptLanguage string) Lang {
+	if strings.TrimSpace(acceptLanguage) == "" {
+		return English
+	}
+	_, index, confidence := matcher.Match(parseAcceptLanguage(acceptLanguage)...)
+	if confidence == language.No {
+		return English
+	}
+	return supported[index]
+}
+
+func parseAcceptLanguage(header string) []language.Tag {
+	tags, _, err := language.ParseAcceptLanguage(header)
+	if err != nil {
+		return nil
+	}
+	return tags
+}
+
+// T returns the message for code in lang, falling back to English and then the code itself
+func T(lang Lang, code Code, args ...any) string {
+	text, ok := catalog[lang][code]
+	if !ok {
+		text, ok = catalog[English][code]
+	}
+	if !ok {
+		return string(code)
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// Codes lists every code known for lang, used to check that translations are complete
+func Codes(lang Lang) []Code {
+	codes := make([]Code, 0, len(catalog[lang]))
+	for code := range catalog[lang] {
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+func Supported() []Lang {
+	return append([]Lang(nil), supported...)
+}
+
+type contextKey string
+
+const contextKeyLang = contextKey("lang")
+
+// Middleware negotiates the language once per request and announces it in Content-Language
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := Negotiate(r.Header.Get("Accept-Language"))
+		w.Header().Set("Content-Language", string(lang))
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyLang, lang)))
+	})
+}
+
+// FromRequest returns the language picked by Middleware, negotiating again if it didn't run
+func FromRequest(r *http.Request) Lang {
+	if lang, ok := r.Context().Value(contextKeyLang).(Lang); ok {
+		return lang
+	}
+	return Negotiate(r.Header.Get("Accept-Language"))
+}
@@ -0,0 +1,31 @@
+package i18n
+
+import "testing"
+
+func TestCatalogComplete(t *testing.T) {
+	for _, lang := range Supported() {
+		for _, code := range Codes(English) {
+			if _, ok := catalog[lang][code]; !ok {
+				t.Errorf("%s: missing translation for %s", lang, code)
+			}
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]Lang{
+		"":                    English,
+		"am":                  Amharic,
+		"am-ET,en;q=0.8":      Amharic,
+		"om-ET":               Oromo,
+		"fr-FR,om;q=0.5":      Oromo,
+		"fr":                  English,
+		"en-US,am;q=0.9":      English,
+		"not a language tag!": English,
+	}
+	for header, want := range cases {
+		if got := Negotiate(header); got != want {
+			t.Errorf("Negotiate(%q) = %s, want %s", header, got, want)
+		}
+	}
+}
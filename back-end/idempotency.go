@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"vaibhavyadav-dev/healthcareServer/i18n"
+	rd "vaibhavyadav-dev/healthcareServer/redis"
+)
+
+// A POST, PATCH or DELETE sent with an Idempotency-Key runs once. A retry with the same key
+// and the same request gets the first response again (with Idempotent-Replayed: true) for
+// idempotencyTTL, the same key with a different method, path, query or body is a 422 and a
+// retry while the first one is still running a 409. Keys are per HIP, register shares one
+// space. 5xx responses are not kept, the retry runs the request again.
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+var (
+	// how long a finished response is replayed
+	idempotencyTTL = 24 * time.Hour
+	// how long a claim holds the key, a request that dies midway frees it after this
+	idempotencyLockTTL = time.Minute
+)
+
+// idempotent wraps the state-changing routes, requests without a key pass straight through
+func (s *APIServer) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			writeProblem(w, r, newProblem(http.StatusBadRequest, i18n.InvalidIdempotencyKey, maxIdempotencyKeyLength))
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeProblem(w, r, newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		storeKey := idempotencyStoreKey(r, key)
+		fingerprint := requestFingerprint(r, body)
+		held, err := s.store.ClaimIdempotencyKey(storeKey, &rd.IdempotentRequest{Fingerprint: fingerprint}, idempotencyLockTTL)
+		if err != nil {
+			writeProblem(w, r, internalError(err))
+			return
+		}
+		if held != nil {
+			switch {
+			case held.Fingerprint != fingerprint:
+				writeProblem(w, r, newProblem(http.StatusUnprocessableEntity, i18n.IdempotencyKeyReused))
+			case held.Status == 0:
+				w.Header().Set("Retry-After", "1")
+				writeProblem(w, r, newProblem(http.StatusConflict, i18n.IdempotencyKeyInProgress))
+			default:
+				replay(w, held)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			if err := s.store.ReleaseIdempotencyKey(storeKey); err != nil {
+				log.Printf("request %s: failed to release idempotency key: %v", requestID(r), err)
+			}
+			return
+		}
+		done := &rd.IdempotentRequest{Fingerprint: fingerprint, Status: rec.status, Header: rec.header, Body: rec.body.Bytes()}
+		if err := s.store.SaveIdempotentResponse(storeKey, done, idempotencyTTL); err != nil {
+			// the response is already on its way, a retry after the claim expires runs again
+			log.Printf("request %s: failed to save idempotent response: %v", requestID(r), err)
+		}
+	}
+}
+
+// idempotencyStoreKey scopes the key to the HIP behind the token, so two HIPs can't collide
+func idempotencyStoreKey(r *http.Request, key string) string {
+	owner, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		owner = "public"
+	}
+	return "hip:idempotency:" + owner + ":" + key
+}
+
+// requestFingerprint tells a retry from a different request sent with the same key
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{r.Method, r.URL.Path, r.URL.RawQuery} {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replay writes a kept response, the request ID stays the retry's own
+func replay(w http.ResponseWriter, held *rd.IdempotentRequest) {
+	for name, values := range held.Header {
+		if name == http.CanonicalHeaderKey(requestIDHeader) {
+			continue
+		}
+		w.Header()[name] = values
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(held.Status)
+	w.Write(held.Body)
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for _, c := range key {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// responseRecorder passes the response through and keeps a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	header map[string][]string
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
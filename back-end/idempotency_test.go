@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"vaibhavyadav-dev/healthcareServer/i18n"
+	rd "vaibhavyadav-dev/healthcareServer/redis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const idempotentRecord = `{"issue": "fever", "description": "high fever for two days", "health_id": "{health_id}", "medical_severity": "High"}`
+
+func withKey(key string) map[string]string {
+	return map[string]string{idempotencyKeyHeader: key}
+}
+
+func TestIdempotentRetryReplays(t *testing.T) {
+	api := newTestAPI(t)
+	create := `{"fname": "Almaz", "middlename": "Kebede", "lname": "Tesfaye", "sex": "F", "dob": "1990-05-01", "bloodgrp": "O+",
+		"bmi": "22", "marriage_status": "single", "weight": "60", "email": "almaz@example.com", "mobilenumber": "0911234567",
+		"aadhar_number": "N/A", "primary_location": "Addis Ababa", "sibling": "1", "twin": "no", "fathername": "Kebede",
+		"mothername": "Almaz", "emergencynumber": "0911000000",
+		"address": {"region": "ET-AA", "zone": "ET-AA-01", "woreda": "ET-AA-01-01", "landmark": "near the bus station"}}`
+
+	first := api.do("POST", v1+"/client/profile/create", create, withKey("create-almaz"))
+	require.Equal(t, http.StatusCreated, first.Code, first.Body.String())
+	assert.Empty(t, first.Header().Get(idempotentReplayedHeader))
+
+	retry := api.do("POST", v1+"/client/profile/create", create, withKey("create-almaz"))
+	require.Equal(t, http.StatusCreated, retry.Code, retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get(idempotentReplayedHeader))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+	assert.NotEqual(t, first.Header().Get(requestIDHeader), retry.Header().Get(requestIDHeader))
+
+	// a second registration would be flagged against the first one as well
+	duplicates, err := api.store.LocalStore.GetDuplicateQueue(api.hip.HealthcareID, "pending", 10)
+	require.NoError(t, err)
+	assert.Len(t, duplicates, 1)
+}
+
+func TestIdempotentRecordQueuedOnce(t *testing.T) {
+	api := newTestAPI(t)
+	for i := 0; i < 3; i++ {
+		rec := api.do("POST", v1+"/client/records/create", idempotentRecord, withKey("record-1"))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+	assert.Equal(t, 1, api.store.count("Enqueue"))
+
+	// the same key on v2 is a different request
+	rec := api.do("POST", v2Prefix+"/patients/{health_id}/records", idempotentRecord, withKey("record-1"))
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+
+	// without a key every request runs
+	api.do("POST", v1+"/client/records/create", idempotentRecord, nil)
+	api.do("POST", v1+"/client/records/create", idempotentRecord, nil)
+	assert.Equal(t, 3, api.store.count("Enqueue"))
+}
+
+func TestIdempotencyKeyRejected(t *testing.T) {
+	runCases(t, []handlerCase{
+		{name: "key reused for another body", method: "POST", path: v1 + "/client/records/create",
+			body: strings.Replace(idempotentRecord, "fever", "cough", 1), header: withKey("record-1"),
+			setup: func(t *testing.T, api *testAPI) {
+				rec := api.do("POST", v1+"/client/records/create", idempotentRecord, withKey("record-1"))
+				require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			},
+			status: http.StatusUnprocessableEntity, code: i18n.IdempotencyKeyReused,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, 1, api.store.count("Enqueue"))
+			}},
+		{name: "first request still running", method: "POST", path: v1 + "/client/records/create",
+			body: idempotentRecord, header: withKey("record-1"),
+			setup: func(t *testing.T, api *testAPI) {
+				req := httptest.NewRequest("POST", v1+"/client/records/create", nil)
+				claim := &rd.IdempotentRequest{Fingerprint: requestFingerprint(req, []byte(api.expand(idempotentRecord)))}
+				held, err := api.store.ClaimIdempotencyKey("hip:idempotency:"+api.hip.HealthcareID+":record-1", claim, idempotencyLockTTL)
+				require.NoError(t, err)
+				require.Nil(t, held)
+			},
+			status: http.StatusConflict, code: i18n.IdempotencyKeyInProgress,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+				assert.Equal(t, 0, api.store.count("Enqueue"))
+			}},
+		{name: "key too long", method: "POST", path: v1 + "/client/records/create",
+			body: idempotentRecord, header: withKey(strings.Repeat("k", maxIdempotencyKeyLength+1)),
+			status: http.StatusBadRequest, code: i18n.InvalidIdempotencyKey},
+		{name: "other HIP's key", method: "POST", path: v1 + "/client/records/create",
+			body: idempotentRecord, header: withKey("record-1"),
+			setup: func(t *testing.T, api *testAPI) {
+				claim := &rd.IdempotentRequest{Fingerprint: "something else", Status: http.StatusOK}
+				_, err := api.store.ClaimIdempotencyKey("hip:idempotency:HCID0000000000000002:record-1", claim, idempotencyTTL)
+				require.NoError(t, err)
+			},
+			status: http.StatusOK, code: i18n.RecordQueued},
+	})
+}
+
+// a failed request keeps nothing, its retry runs again
+func TestIdempotentServerErrorNotKept(t *testing.T) {
+	api := newTestAPI(t)
+	api.store.failWith("Enqueue", errStoreDown)
+	rec := api.do("POST", v1+"/client/records/create", idempotentRecord, withKey("record-1"))
+	require.Equal(t, http.StatusInternalServerError, rec.Code, rec.Body.String())
+
+	api.store.failWith("Enqueue", nil)
+	rec = api.do("POST", v1+"/client/records/create", idempotentRecord, withKey("record-1"))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Empty(t, rec.Header().Get(idempotentReplayedHeader))
+	assert.Equal(t, 2, api.store.count("Enqueue"))
+}
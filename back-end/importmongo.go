@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	db "vaibhavyadav-dev/healthcareServer/databases"
+)
+
+const importMongoUsage = `usage: import-mongo <command>
+  run [-batch n] [-retry-rejected] [collection...]  import healthcare_info, patient_details and appointments, or only the ones given
+  status                                            imported, skipped, conflicting and rejected documents per collection
+  issues [-limit n] [collection]                    conflicts and rejects with their reason`
+
+// runImportMongo handles the import-mongo subcommand, it copies the legacy mongo data into postgres
+func runImportMongo(postgresConn, mongoURI string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(importMongoUsage)
+	}
+	postgres, err := db.ConnectToPostgreSQL(postgresConn)
+	if err != nil {
+		return fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+	// the import tables are part of the schema
+	migrator, err := postgres.Migrator()
+	if err != nil {
+		return err
+	}
+	if _, err := migrator.Up(); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "run":
+		flags := flag.NewFlagSet("import-mongo run", flag.ContinueOnError)
+		batch := flags.Int("batch", 500, "documents per transaction")
+		retryRejected := flags.Bool("retry-rejected", false, "read documents rejected earlier again from mongo")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *batch < 1 {
+			return fmt.Errorf("-batch must be at least 1")
+		}
+		mongo, err := db.ConnectToMongoDB(mongoURI, "db", nil)
+		if err != nil {
+			return fmt.Errorf("failed to connect to mongo: %w", err)
+		}
+		importer := db.NewMongoImporter(mongo, postgres)
+		importer.BatchSize = *batch
+		stats, err := importer.Run(context.Background(), flags.Args(), *retryRejected)
+		printImportStats(stats)
+		return err
+	case "status":
+		stats, err := db.NewMongoImporter(nil, postgres).Status()
+		if err != nil {
+			return err
+		}
+		printImportStats(stats)
+		return nil
+	case "issues":
+		flags := flag.NewFlagSet("import-mongo issues", flag.ContinueOnError)
+		limit := flags.Int("limit", 100, "maximum number of issues to list")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		issues, err := db.NewMongoImporter(nil, postgres).Issues(flags.Arg(0), *limit)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "COLLECTION\tMONGO ID\tKIND\tREASON")
+		for _, issue := range issues {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", issue.Collection, issue.MongoID, issue.Kind, issue.Reason)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf(importMongoUsage)
+	}
+}
+
+func printImportStats(stats []*db.ImportStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tIMPORTED\tSKIPPED\tCONFLICTS\tREJECTED\tFINISHED")
+	for _, s := range stats {
+		finished := "no"
+		if s.FinishedAt != nil {
+			finished = s.FinishedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", s.Collection, s.Imported, s.Skipped, s.Conflicts, s.Rejected, finished)
+	}
+	w.Flush()
+}
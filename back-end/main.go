@@ -3,8 +3,10 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
 	"time"
 	db "vaibhavyadav-dev/healthcareServer/databases"
+	"vaibhavyadav-dev/healthcareServer/webhooks"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -27,6 +29,84 @@ func main() {
 	psqlInfo := os.Getenv("POSTGRES")
 	mongoURI := os.Getenv("MONGOURL") 
 
+	// ./fs migrate status|up|down|to <version> manages the postgres schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(psqlInfo, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// ./fs import-mongo run|status|issues copies the legacy mongo collections into postgres and exits
+	if len(os.Args) > 1 && os.Args[1] == "import-mongo" {
+		if err := runImportMongo(psqlInfo, mongoURI, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// ./fs events status|replay|rebuild recomputes the counters from the event log and exits
+	if len(os.Args) > 1 && os.Args[1] == "events" {
+		if err := runEvents(psqlInfo, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// ./fs dead-letters list|show|replay|discard inspects the messages the consumers gave up on and exits
+	if len(os.Args) > 1 && os.Args[1] == "dead-letters" {
+		if err := runDeadLetters(rabbitMqURL, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// region/zone/woreda/kebele dataset is bundled in the binary,
+	// ADMIN_AREAS_FILE points to a newer copy without rebuilding
+	if areasFile := os.Getenv("ADMIN_AREAS_FILE"); areasFile != "" {
+		areas, err := db.LoadAdminAreas(areasFile)
+		if err != nil {
+			log.Fatal("Failed to load admin areas:", err)
+		}
+		db.UseAdminAreas(areas)
+	}
+	// WEBHOOKS_ALLOW_PRIVATE=true lets webhooks post to localhost and private networks, for development only
+	if allow := os.Getenv("WEBHOOKS_ALLOW_PRIVATE"); allow != "" {
+		private, err := strconv.ParseBool(allow)
+		if err != nil {
+			log.Fatal("WEBHOOKS_ALLOW_PRIVATE must be true or false:", err)
+		}
+		webhooks.AllowPrivate(private)
+	}
+
+	PORT := os.Getenv("PORT")
+
+	// SMTP_* and SMS_GATEWAY_* configure the notification channels, see notificationChannels
+	channels, err := notificationChannels()
+	if err != nil {
+		log.Fatal("Failed to configure notifications:", err)
+	}
+	// APPOINTMENT_REMINDERS and APPOINTMENT_TIMEZONE set when reminders go out, see reminderSchedule
+	schedule, err := reminderSchedule()
+	if err != nil {
+		log.Fatal("Failed to configure appointment reminders:", err)
+	}
+
+	// STORE=local runs on a single SQLite file (SQLITE_PATH, healthcare.db by default)
+	// with an in-process queue and in-memory rate limiter and cache, no services needed
+	if os.Getenv("STORE") == "local" {
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "healthcare.db"
+		}
+		store, err := db.Localstore(path, 30, 20*time.Second)
+		if err != nil {
+			log.Fatal("Failed to initialize store:", err)
+		}
+		log.Printf("Using the local store in %s", path)
+		store.EnableNotifications(channels)
+		store.EnableReminders(schedule)
+		NewAPIServer(PORT, store).Run()
+		return
+	}
+
 	// first one is redis url, second one is limit, and third one is time.Second
 	// limit -> 10
 	// window -> per 5 second
@@ -35,7 +115,16 @@ func main() {
 	if err != nil {
 		log.Fatal("Failed to initialize store:", err)
 	}
-	PORT := os.Getenv("PORT")
+	// SHADOW_READ_RATE=0.05 compares 5% of patient and healthcare reads with the other store
+	if rate := os.Getenv("SHADOW_READ_RATE"); rate != "" {
+		sample, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			log.Fatal("SHADOW_READ_RATE must be a number between 0 and 1:", err)
+		}
+		store.EnableShadowReads(sample)
+	}
+	store.EnableNotifications(channels)
+	store.EnableReminders(schedule)
 	server := NewAPIServer(PORT, store)
 	server.Run()
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	db "vaibhavyadav-dev/healthcareServer/databases"
+)
+
+const migrateUsage = `usage: migrate <command>
+  status        list migrations and whether they are applied
+  up            apply every pending migration
+  down          roll back the newest applied migration
+  to <version>  migrate up or down to exactly that version (0 rolls back everything)`
+
+// runMigrate handles the migrate subcommand, only postgres is needed for it
+func runMigrate(postgresConn string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+	postgres, err := db.ConnectToPostgreSQL(postgresConn)
+	if err != nil {
+		return fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+	migrator, err := postgres.Migrator()
+	if err != nil {
+		return err
+	}
+
+	var done []db.Migration
+	switch args[0] {
+	case "status":
+		return printMigrationStatus(migrator)
+	case "up":
+		done, err = migrator.Up()
+	case "down":
+		done, err = migrator.Down()
+	case "to":
+		if len(args) < 2 {
+			return fmt.Errorf(migrateUsage)
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("version must be a number: %w", convErr)
+		}
+		done, err = migrator.To(version)
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+	for _, migration := range done {
+		fmt.Printf("%s %04d_%s\n", args[0], migration.Version, migration.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		fmt.Println("nothing to do")
+	}
+	return nil
+}
+
+func printMigrationStatus(migrator *db.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.Format(time.RFC3339)
+		}
+		if status.Unknown {
+			applied += " (not in this binary)"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	mod "vaibhavyadav-dev/healthcareServer/databases"
+	"vaibhavyadav-dev/healthcareServer/i18n"
+	"vaibhavyadav-dev/healthcareServer/notify"
+
+	"github.com/gorilla/mux"
+)
+
+// The store tells HIPs about their account and patients about their appointments by email and
+// text message (see databases/notifications.go). A HIP sees what was sent about it and its
+// patients and how it went, and sets in which language and on which channels it and each patient
+// are told. Only v2 serves notifications.
+
+func (s *APIServer) ListNotifications(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status != "" && status != "pending" && status != "sent" && status != "failed" {
+		return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "status")
+	}
+	limit := 20
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 100 {
+			return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "limit")
+		}
+	}
+	notifications, err := s.store.ListNotifications(healthcareID, status, int64(limit))
+	if err != nil {
+		return internalError(err)
+	}
+	if notifications == nil {
+		notifications = []*mod.Notification{}
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"notifications": notifications,
+		"fetched":       len(notifications),
+	})
+}
+
+func (s *APIServer) GetNotification(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	id, _, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	notification, err := s.store.GetNotification(healthcareID, id)
+	if err != nil {
+		return notificationProblem(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{"notification": notification})
+}
+
+// GetNotificationPreferences are the HIP's own preferences, or a patient's under /patients/{healthID}
+func (s *APIServer) GetNotificationPreferences(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, recipientType, recipientID, err := notificationRecipient(r)
+	if err != nil {
+		return err
+	}
+	prefs, err := s.store.GetNotificationPreferences(healthcareID, recipientType, recipientID)
+	if err != nil {
+		return notificationProblem(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{"preferences": prefs})
+}
+
+// SetNotificationPreferences replaces the preferences, a channel left out is turned off
+func (s *APIServer) SetNotificationPreferences(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, recipientType, recipientID, err := notificationRecipient(r)
+	if err != nil {
+		return err
+	}
+	prefs := mod.NotificationPreferences{}
+	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+		return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err)
+	}
+	prefs.RecipientType, prefs.RecipientID = recipientType, recipientID
+	if err := s.store.SetNotificationPreferences(healthcareID, &prefs); err != nil {
+		return notificationProblem(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        i18n.NotificationPrefsSaved,
+		"message":     msg(r, i18n.NotificationPrefsSaved),
+		"preferences": prefs,
+	})
+}
+
+// notificationRecipient is the patient of the path, or the HIP behind the token. The store only
+// lets the HIP reach patients it registered or has an appointment with
+func notificationRecipient(r *http.Request) (healthcareID, recipientType, recipientID string, err error) {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return "", "", "", missingClaim("healthcareID")
+	}
+	if healthID, ok := mux.Vars(r)["healthID"]; ok {
+		return healthcareID, mod.RecipientPatient, healthID, nil
+	}
+	return healthcareID, mod.RecipientHIP, healthcareID, nil
+}
+
+func notificationProblem(err error) *Problem {
+	var filterErr *mod.EventFilterError
+	switch {
+	case errors.Is(err, mod.ErrNotificationNotFound):
+		return newProblem(http.StatusNotFound, i18n.NotificationNotFound).withCause(err)
+	case errors.Is(err, mod.ErrPatientNotFound):
+		return newProblem(http.StatusNotFound, i18n.PatientNotFound).withCause(err)
+	case errors.As(err, &filterErr):
+		return newProblem(http.StatusUnprocessableEntity, i18n.InvalidEventFilter, filterErr.Filter).withCause(err)
+	}
+	var verr *mod.ValidationError
+	if errors.As(err, &verr) {
+		return validationProblem(err)
+	}
+	return internalError(err)
+}
+
+// notificationChannels are the channels the environment configures:
+//
+//	SMTP_HOST, SMTP_PORT (465 by default), SMTP_EMAIL, SMTP_PASSWORD, SMTP_FROM   email
+//	SMS_GATEWAY_URL, SMS_GATEWAY_TOKEN                                          text messages
+//	NOTIFY_LOG_FILE                                                             where the channels left
+//	                                                                            unconfigured write instead, - for stdout
+//
+// A notification on a channel that is neither configured nor logged fails, its status says so
+func notificationChannels() (notify.Channels, error) {
+	channels := notify.Channels{}
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := 465
+		if portStr := os.Getenv("SMTP_PORT"); portStr != "" {
+			var err error
+			if port, err = strconv.Atoi(portStr); err != nil {
+				return nil, fmt.Errorf("SMTP_PORT must be a port number: %w", err)
+			}
+		}
+		channels[notify.Email] = &notify.SMTP{Host: host, Port: port, Username: os.Getenv("SMTP_EMAIL"),
+			Password: os.Getenv("SMTP_PASSWORD"), From: os.Getenv("SMTP_FROM")}
+	}
+	if url := os.Getenv("SMS_GATEWAY_URL"); url != "" {
+		channels[notify.SMS] = notify.SMSChannel{Gateway: notify.HTTPGateway{URL: url, Token: os.Getenv("SMS_GATEWAY_TOKEN")}}
+	}
+	logFile := os.Getenv("NOTIFY_LOG_FILE")
+	if logFile == "" {
+		return channels, nil
+	}
+	out := os.Stdout
+	if logFile != "-" {
+		var err error
+		if out, err = os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+			return nil, err
+		}
+	}
+	for _, channel := range []string{notify.Email, notify.SMS} {
+		if _, ok := channels[channel]; !ok {
+			channels[channel] = notify.NewLog(out, channel)
+		}
+	}
+	return channels, nil
+}
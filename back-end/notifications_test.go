@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mod "vaibhavyadav-dev/healthcareServer/databases"
+	"vaibhavyadav-dev/healthcareServer/i18n"
+	"vaibhavyadav-dev/healthcareServer/notify"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// outbox is a notification channel that hands what it is given to the test
+type outbox chan notify.Message
+
+func (o outbox) Send(msg notify.Message) error {
+	o <- msg
+	return nil
+}
+
+func (o outbox) next(t *testing.T) notify.Message {
+	t.Helper()
+	select {
+	case msg := <-o:
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatal("nothing was sent")
+		return notify.Message{}
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	runCases(t, []handlerCase{
+		{name: "defaults", method: "GET", path: v2 + "/healthcare/notification-preferences", status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				prefs := body["preferences"].(map[string]interface{})
+				assert.Equal(t, "en", prefs["language"])
+				assert.Equal(t, true, prefs["email"])
+				assert.Equal(t, "hip", prefs["recipient_type"])
+				assert.Equal(t, api.hip.HealthcareID, prefs["recipient_id"])
+			}},
+		{name: "patient saved", method: "PUT", path: v2 + "/patients/{health_id}/notification-preferences",
+			body: `{"language": "om", "sms": true, "muted": ["hip.#"]}`, status: http.StatusOK, code: i18n.NotificationPrefsSaved,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				res := api.do("GET", v2+"/patients/{health_id}/notification-preferences", "", nil)
+				require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+				var got struct {
+					Preferences mod.NotificationPreferences `json:"preferences"`
+				}
+				require.NoError(t, json.Unmarshal(res.Body.Bytes(), &got))
+				assert.Equal(t, "om", got.Preferences.Language)
+				assert.False(t, got.Preferences.Email)
+				assert.True(t, got.Preferences.SMS)
+				assert.Equal(t, []string{"hip.#"}, got.Preferences.Muted)
+				assert.NotNil(t, got.Preferences.UpdatedAt)
+			}},
+		{name: "unknown patient", method: "GET", path: v2 + "/patients/HID-NOBODY/notification-preferences",
+			status: http.StatusNotFound, code: i18n.PatientNotFound},
+		{name: "another HIP's patient", method: "GET", path: v2 + "/patients/{other_health_id}/notification-preferences",
+			setup:  func(t *testing.T, api *testAPI) { api.vars["other_health_id"] = api.addPatient(t, "HCID0000000000000002").HealthID },
+			status: http.StatusNotFound, code: i18n.PatientNotFound},
+		{name: "another HIP's patient not saved", method: "PUT", path: v2 + "/patients/{other_health_id}/notification-preferences",
+			body:   `{"language": "om", "sms": true}`,
+			setup:  func(t *testing.T, api *testAPI) { api.vars["other_health_id"] = api.addPatient(t, "HCID0000000000000002").HealthID },
+			status: http.StatusNotFound, code: i18n.PatientNotFound},
+		{name: "another HIP's patient with an appointment here", method: "GET", path: v2 + "/patients/{other_health_id}/notification-preferences",
+			setup: func(t *testing.T, api *testAPI) {
+				api.vars["other_health_id"] = api.addPatient(t, "HCID0000000000000002").HealthID
+				api.bookAppointment(t, 2, api.hip.HealthcareID, api.vars["other_health_id"])
+			},
+			status: http.StatusOK},
+		{name: "unsupported language", method: "PUT", path: v2 + "/healthcare/notification-preferences", body: `{"language": "fr", "email": true}`,
+			status: http.StatusUnprocessableEntity, code: i18n.ValidationFailed},
+		{name: "muting nothing", method: "PUT", path: v2 + "/healthcare/notification-preferences", body: `{"language": "en", "muted": ["hip.account.deleted"]}`,
+			status: http.StatusUnprocessableEntity, code: i18n.InvalidEventFilter},
+		{name: "not json", method: "PUT", path: v2 + "/healthcare/notification-preferences", body: `language=en`,
+			status: http.StatusBadRequest, code: i18n.InvalidRequestBody},
+
+		{name: "bad status", method: "GET", path: v2 + "/notifications?status=delivered",
+			status: http.StatusBadRequest, code: i18n.InvalidQueryParam},
+		{name: "limit too high", method: "GET", path: v2 + "/notifications?limit=500",
+			status: http.StatusBadRequest, code: i18n.InvalidQueryParam},
+		{name: "unknown notification", method: "GET", path: v2 + "/notifications/99",
+			status: http.StatusNotFound, code: i18n.NotificationNotFound},
+		{name: "list unavailable", method: "GET", path: v2 + "/notifications",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("ListNotifications", errors.New("db down")) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+	})
+}
+
+func TestNotificationDelivery(t *testing.T) {
+	api := newTestAPI(t)
+	email, sms := make(outbox, 10), make(outbox, 10)
+	api.store.EnableNotifications(notify.Channels{notify.Email: email, notify.SMS: sms})
+
+	// the patient only wants text messages, in Amharic
+	res := api.do("PUT", v2+"/patients/{health_id}/notification-preferences", `{"language": "am", "sms": true}`, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	res = api.do("PATCH", v2+"/appointments/7", `{"health_id": "{health_id}", "status": "Confirmed"}`, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	text := sms.next(t)
+	assert.Equal(t, "0911234567", text.To)
+	assert.Contains(t, text.Body, "Confirmed")
+	assert.Contains(t, text.Body, "ቀጠሮ")
+
+	// the HIP is told about its deletion by email
+	res = api.do("DELETE", v2+"/healthcare", "", nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	mail := email.next(t)
+	assert.Equal(t, api.hip.Email, mail.To)
+	assert.Contains(t, mail.Subject, "scheduled for deletion")
+	select {
+	case extra := <-email:
+		t.Errorf("the patient got email %+v", extra)
+	default:
+	}
+
+	var sent []*mod.Notification
+	require.Eventually(t, func() bool {
+		res := api.do("GET", v2+"/notifications?status=sent", "", nil)
+		var body struct {
+			Notifications []*mod.Notification `json:"notifications"`
+		}
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+		sent = body.Notifications
+		return len(sent) == 2
+	}, 3*time.Second, 20*time.Millisecond)
+	assert.Equal(t, "hip.account.deletion_scheduled", sent[0].Type)
+	assert.Equal(t, "appointment.status.changed", sent[1].Type)
+	assert.Equal(t, "sms", sent[1].Channel)
+	assert.NotNil(t, sent[1].SentAt)
+
+	api.vars["notification_id"] = jsonNumber(sent[1].ID)
+	res = api.do("GET", v2+"/notifications/{notification_id}", "", nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	assert.Contains(t, res.Body.String(), `"status":"sent"`)
+}
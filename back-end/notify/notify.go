@@ -0,0 +1,77 @@
+// Package notify sends the notifications the server writes to HIPs and patients. A Channel
+// delivers a rendered Message to one address: SMTP for email, an SMSGateway for text messages,
+// and Log, which only writes what would have been sent, for development and tests.
+//
+// What a notification says comes from templates/<lang>.json, one template per notification
+// type (an event type, or hip.account.locked) in every language the API speaks. Render falls
+// back to English when a language has no template of its own.
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// the channels a notification can go out on
+const (
+	Email = "email"
+	SMS   = "sms"
+)
+
+var ErrNoChannel = errors.New("no channel is configured for the notification")
+
+// Message is one notification for one address, Subject is empty for a text message
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Channel delivers messages, an error means the message wasn't accepted and may be tried again
+type Channel interface {
+	Send(Message) error
+}
+
+// Channels are the configured channels by name (Email, SMS)
+type Channels map[string]Channel
+
+// Send sends msg on the channel named channel
+func (c Channels) Send(channel string, msg Message) error {
+	ch, ok := c[channel]
+	if !ok || ch == nil {
+		return ErrNoChannel
+	}
+	return ch.Send(msg)
+}
+
+// Log writes every message as a line of JSON instead of sending it
+type Log struct {
+	Channel string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewLog(w io.Writer, channel string) *Log {
+	return &Log{Channel: channel, w: w}
+}
+
+func (l *Log) Send(msg Message) error {
+	line, err := json.Marshal(map[string]interface{}{
+		"channel": l.Channel,
+		"to":      msg.To,
+		"subject": msg.Subject,
+		"body":    msg.Body,
+		"sent_at": time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,188 @@
+package notify
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"vaibhavyadav-dev/healthcareServer/i18n"
+)
+
+// smtpStub is a mail server that accepts everything on a local port and keeps the mails
+type smtpStub struct {
+	ln    net.Listener
+	mails chan string
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	stub := &smtpStub{ln: ln, mails: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go stub.serve(conn)
+		}
+	}()
+	return stub
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 stub ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " x")[0])
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 stub")
+		case "DATA":
+			reply("354 go ahead")
+			var mail strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				mail.WriteString(line)
+			}
+			s.mails <- mail.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *smtpStub) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestSMTP(t *testing.T) {
+	stub := newSMTPStub(t)
+	mailer := &SMTP{Host: "127.0.0.1", Port: stub.port(), From: "noreply@healthcare.example"}
+	err := mailer.Send(Message{To: "hip@example.com", Subject: "ቀጠሮ ተረጋግጧል", Body: "Your appointment is Confirmed."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail := <-stub.mails
+	header, body, _ := strings.Cut(mail, "\r\n\r\n")
+	for _, want := range []string{"From: noreply@healthcare.example", "To: hip@example.com", "Subject: =?utf-8?q?", "charset=utf-8"} {
+		if !strings.Contains(header, want) {
+			t.Errorf("header has no %q:\n%s", want, header)
+		}
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "Your appointment is Confirmed.\r\n" && string(decoded) != "Your appointment is Confirmed." {
+		t.Errorf("body %q", decoded)
+	}
+
+	// nothing listens, the message is tried again later
+	stub.ln.Close()
+	if err := mailer.Send(Message{To: "hip@example.com", Body: "again"}); err == nil {
+		t.Error("sent to a closed server")
+	}
+}
+
+func TestHTTPGateway(t *testing.T) {
+	var got map[string]string
+	var auth string
+	status := http.StatusAccepted
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+		io.WriteString(w, "quota exceeded")
+	}))
+	defer gateway.Close()
+
+	channel := SMSChannel{Gateway: HTTPGateway{URL: gateway.URL, Token: "secret"}}
+	if err := channel.Send(Message{To: "+251911000000", Subject: "ignored", Body: "see you at 9:00"}); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer secret" || got["to"] != "+251911000000" || got["text"] != "see you at 9:00" {
+		t.Errorf("gateway got %v with %q", got, auth)
+	}
+	status = http.StatusTooManyRequests
+	if err := channel.Send(Message{To: "+251911000000", Body: "again"}); err == nil || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Errorf("refused message: %v", err)
+	}
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	channels := Channels{Email: NewLog(&buf, Email)}
+	if err := channels.Send(Email, Message{To: "a@example.com", Subject: "hi", Body: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	var line map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["channel"] != Email || line["to"] != "a@example.com" || line["body"] != "hello" {
+		t.Errorf("logged %s", buf.String())
+	}
+	if err := channels.Send(SMS, Message{To: "+251911000000", Body: "hello"}); err != ErrNoChannel {
+		t.Errorf("unconfigured channel: %v", err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	data := map[string]string{"healthcare_name": "Black Lion", "name": "Abebe", "status": "Confirmed",
+		"appointment_date": "2026-10-20", "appointment_time": "09:00", "remaining": "4"}
+	for _, lang := range i18n.Supported() {
+		for _, kind := range Types() {
+			if !Has(lang, kind) {
+				t.Errorf("%s has no %s template", lang, kind)
+				continue
+			}
+			for _, channel := range []string{Email, SMS} {
+				msg, err := Render(kind, lang, channel, "to", data)
+				if err != nil {
+					t.Fatalf("%s %s %s: %v", lang, kind, channel, err)
+				}
+				if msg.Body == "" || (channel == Email) == (msg.Subject == "") {
+					t.Errorf("%s %s %s: %+v", lang, kind, channel, msg)
+				}
+			}
+		}
+	}
+	msg, err := Render("appointment.status.changed", i18n.English, SMS, "+251911000000", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Body != "Black Lion: your appointment on 2026-10-20 at 09:00 is Confirmed." {
+		t.Errorf("sms %q", msg.Body)
+	}
+	if _, err := Render("patient.profile.viewed", i18n.English, Email, "to", data); err == nil {
+		t.Error("rendered a type without a template")
+	}
+}
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// SMSGateway is a provider that sends text messages to phone numbers
+type SMSGateway interface {
+	SendSMS(to, text string) error
+}
+
+// SMSChannel is the channel of an SMSGateway, a text message has no subject
+type SMSChannel struct {
+	Gateway SMSGateway
+}
+
+func (s SMSChannel) Send(msg Message) error {
+	return s.Gateway.SendSMS(msg.To, msg.Body)
+}
+
+// HTTPGateway posts {"to": ..., "text": ...} to URL with the Token as a bearer token,
+// most providers take that or sit behind a small relay that does
+type HTTPGateway struct {
+	URL   string
+	Token string
+}
+
+var gatewayClient = &http.Client{Timeout: 10 * time.Second}
+
+func (g HTTPGateway) SendSMS(to, text string) error {
+	body, err := json.Marshal(map[string]string{"to": to, "text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", g.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.Token)
+	}
+	res, err := gatewayClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		reply, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+		return fmt.Errorf("sms gateway answered %d: %s", res.StatusCode, bytes.TrimSpace(reply))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"text/template"
+
+	"vaibhavyadav-dev/healthcareServer/i18n"
+)
+
+// Template is what a notification of one type says in one language. SMS is the shorter
+// text of the sms channel, the body is used when a template has none
+type Template struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	SMS     string `json:"sms"`
+}
+
+type parsed struct {
+	subject, body, sms *template.Template
+}
+
+//go:embed templates/*.json
+var files embed.FS
+
+var templates = map[i18n.Lang]map[string]*parsed{}
+
+func init() {
+	for _, lang := range i18n.Supported() {
+		data, err := files.ReadFile(path.Join("templates", string(lang)+".json"))
+		if err != nil {
+			panic(fmt.Sprintf("missing notification templates %s: %s", lang, err))
+		}
+		raw := map[string]Template{}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			panic(fmt.Sprintf("broken notification templates %s: %s", lang, err))
+		}
+		templates[lang] = map[string]*parsed{}
+		for kind, t := range raw {
+			if t.SMS == "" {
+				t.SMS = t.Body
+			}
+			name := string(lang) + "/" + kind
+			templates[lang][kind] = &parsed{
+				subject: template.Must(template.New(name + "/subject").Option("missingkey=zero").Parse(t.Subject)),
+				body:    template.Must(template.New(name + "/body").Option("missingkey=zero").Parse(t.Body)),
+				sms:     template.Must(template.New(name + "/sms").Option("missingkey=zero").Parse(t.SMS)),
+			}
+		}
+	}
+}
+
+// Types lists the notification types there are templates for
+func Types() []string {
+	kinds := make([]string, 0, len(templates[i18n.English]))
+	for kind := range templates[i18n.English] {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Has reports whether lang has its own template for kind, English is used for the ones it hasn't
+func Has(lang i18n.Lang, kind string) bool {
+	_, ok := templates[lang][kind]
+	return ok
+}
+
+// Render fills the template of kind for channel in lang with data, to is who it goes to
+func Render(kind string, lang i18n.Lang, channel, to string, data map[string]string) (Message, error) {
+	t, ok := templates[lang][kind]
+	if !ok {
+		t, ok = templates[i18n.English][kind]
+	}
+	if !ok {
+		return Message{}, fmt.Errorf("no notification template for %s", kind)
+	}
+	msg := Message{To: to}
+	body := t.body
+	if channel == SMS {
+		body = t.sms
+	} else {
+		subject, err := execute(t.subject, data)
+		if err != nil {
+			return Message{}, err
+		}
+		msg.Subject = subject
+	}
+	text, err := execute(body, data)
+	if err != nil {
+		return Message{}, err
+	}
+	msg.Body = text
+	return msg, nil
+}
+
+func execute(t *template.Template, data map[string]string) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
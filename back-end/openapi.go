@@ -0,0 +1,782 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mod "vaibhavyadav-dev/healthcareServer/databases"
+	"vaibhavyadav-dev/healthcareServer/i18n"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The OpenAPI document is put together from apiOperations and the model structs, field names,
+// types and limits come from their json and validate tags so the spec moves with the models.
+// Every route in routes() needs an entry here (v2 ones in v2Operations), TestOpenAPICoversRoutes fails otherwise.
+
+type apiOperation struct {
+	Method      string
+	Path        string
+	OperationID string
+	Summary     string
+	Tag         string
+	Auth        bool
+	Idempotent  bool
+	PathParams  []apiParam
+	Query       []apiParam
+	Headers     []apiParam
+	// zero value of the request body type, or a schema
+	Body        interface{}
+	ContentType string
+	Status      int
+	// zero value of the response type, or a schema
+	Response        interface{}
+	ResponseHeaders []string
+	Errors          []int
+}
+
+type apiParam struct {
+	Name        string
+	Description string
+	Required    bool
+	Type        string
+}
+
+// response bodies that are map literals in the handlers
+type statusResponse struct {
+	Code    i18n.Code `json:"code"`
+	Message string    `json:"message,omitempty"`
+	Status  string    `json:"status,omitempty"`
+}
+
+type signUpResponse struct {
+	Code              i18n.Code `json:"code"`
+	Status            string    `json:"status"`
+	HealthcareDetails struct {
+		HealthcareID      string `json:"healthcare_id"`
+		HealthcareLicense string `json:"healthcare_license"`
+		Name              string `json:"name"`
+		Email             string `json:"email"`
+	} `json:"Healthcare_details"`
+}
+
+type loginResponse struct {
+	ExpiresIn      string `json:"Expires In"`
+	Token          string `json:"token"`
+	HealthcareID   string `json:"healthcare_id"`
+	HealthcareName string `json:"healthcare_name"`
+}
+
+type preferanceResponse struct {
+	Preferance mod.Preferance `json:"preferance"`
+	// only when served from the cache
+	RefreshIn float64 `json:"refreshIn(seconds),omitempty"`
+}
+
+// served from the database under "healthcare", from the cache under "preferance"
+type healthcareDetailsResponse struct {
+	Healthcare *mod.HIPInfo `json:"healthcare,omitempty"`
+	Preferance *mod.HIPInfo `json:"preferance,omitempty"`
+	RefreshIn  float64      `json:"refreshIn(seconds),omitempty"`
+}
+
+type preferanceChangeResponse struct {
+	Code        i18n.Code              `json:"code"`
+	Status      string                 `json:"status"`
+	Preferances map[string]interface{} `json:"preferances"`
+}
+
+type appointmentsResponse struct {
+	Appointments []mod.Appointments `json:"appointments"`
+	Fetched      int                `json:"fetched"`
+}
+
+type appointmentQueuedResponse struct {
+	Code         i18n.Code             `json:"code"`
+	Status       string                `json:"status"`
+	Message      string                `json:"message"`
+	Appointments mod.UpdateAppointment `json:"appointments"`
+}
+
+type patientCreatedResponse struct {
+	Code               i18n.Code `json:"code"`
+	Message            string    `json:"message"`
+	Status             string    `json:"status"`
+	Email              string    `json:"email"`
+	HealthID           string    `json:"health_id"`
+	Fullname           string    `json:"fullname"`
+	PossibleDuplicates []struct {
+		ID                int64   `json:"id"`
+		CandidateHealthID string  `json:"candidate_health_id"`
+		Score             float64 `json:"score"`
+	} `json:"possible_duplicates"`
+}
+
+type patientRecordsResponse struct {
+	PatientRecords []mod.PatientRecords `json:"patient_records"`
+	Severity       string               `json:"severity"`
+}
+
+type profileVersionsResponse struct {
+	HealthID       string               `json:"health_id"`
+	CurrentVersion int                  `json:"current_version"`
+	Versions       []mod.ProfileVersion `json:"versions"`
+	Fetched        int                  `json:"fetched"`
+}
+
+type profileDiffResponse struct {
+	HealthID string            `json:"health_id"`
+	From     int               `json:"from"`
+	To       int               `json:"to"`
+	Changes  []mod.FieldChange `json:"changes"`
+}
+
+type profileAsOfResponse struct {
+	AsOf          time.Time          `json:"as_of"`
+	ClientProfile mod.PatientDetails `json:"client_profile"`
+}
+
+type mergeResponse struct {
+	Code    i18n.Code        `json:"code"`
+	Message string           `json:"message"`
+	Merge   mod.PatientMerge `json:"merge"`
+}
+
+type mergeRequestResponse struct {
+	Code         i18n.Code        `json:"code"`
+	Message      string           `json:"message"`
+	MergeRequest mod.MergeRequest `json:"merge_request"`
+}
+
+type webhookResponse struct {
+	Code    i18n.Code   `json:"code"`
+	Message string      `json:"message"`
+	Webhook mod.Webhook `json:"webhook"`
+}
+
+type notificationPreferencesResponse struct {
+	Code        i18n.Code                   `json:"code"`
+	Message     string                      `json:"message"`
+	Preferences mod.NotificationPreferences `json:"preferences"`
+}
+
+type reminderReplyResponse struct {
+	Code    i18n.Code         `json:"code"`
+	Message string            `json:"message"`
+	Reply   mod.ReminderReply `json:"reply"`
+}
+
+type redeliveryResponse struct {
+	Code     i18n.Code           `json:"code"`
+	Message  string              `json:"message"`
+	Delivery mod.WebhookDelivery `json:"delivery"`
+}
+
+var (
+	healthIDQuery = apiParam{Name: "healthID", Description: "health ID of the patient", Required: true}
+	cacheQuery    = apiParam{Name: "cache", Description: "false reads past the cache, defaults to true"}
+	limitQuery    = apiParam{Name: "limit", Type: "integer"}
+
+	changeReasonHeader = apiParam{Name: "X-Change-Reason", Description: "kept with the replaced version"}
+
+	webhookIDParam      = apiParam{Name: "id", Type: "integer", Description: "id of the webhook", Required: true}
+	deliveryIDParam     = apiParam{Name: "delivery_id", Type: "integer", Description: "id of the delivery", Required: true}
+	mergeRequestIDParam = apiParam{Name: "id", Type: "integer", Description: "id of the merge request", Required: true}
+
+	idempotencyKeyParam = apiParam{Name: idempotencyKeyHeader,
+		Description: "a retry with the same key and request gets the first response again, see idempotency.go"}
+)
+
+var apiOperations = []apiOperation{
+	{Method: "GET", Path: "/metrics", OperationID: "getMetrics", Summary: "Prometheus metrics", Tag: "monitoring",
+		ContentType: "text/plain", Status: http.StatusOK, Response: map[string]interface{}{"type": "string"}},
+	{Method: "GET", Path: "/openapi.json", OperationID: "getOpenAPI", Summary: "This document", Tag: "monitoring",
+		Status: http.StatusOK, Response: map[string]interface{}{"type": "object"}, Errors: []int{405}},
+	{Method: "GET", Path: "/events/schemas", OperationID: "listEventSchemas", Summary: "Event types published to the exchange", Tag: "events",
+		Status: http.StatusOK, Response: listOf("event_types", eventSchemaEntry{}), Errors: []int{405}},
+	{Method: "GET", Path: "/events/schemas/{type}", OperationID: "getEventSchema", Summary: "JSON Schema of an event type, envelope included", Tag: "events",
+		PathParams: []apiParam{{Name: "type", Description: "event type, the routing key", Required: true}},
+		Status:     http.StatusOK, Response: map[string]interface{}{"type": "object"}, Errors: []int{404, 405}},
+
+	{Method: "POST", Path: "/api/v1/healthcare/auth/register", OperationID: "signUp", Summary: "Register a healthcare provider", Tag: "auth",
+		Idempotent: true, Body: mod.HIPInfo{}, Status: http.StatusCreated, Response: signUpResponse{}, Errors: []int{400, 405, 409, 422, 500}},
+	{Method: "POST", Path: "/api/v1/healthcare/auth/login", OperationID: "login", Summary: "Log in, the token is valid for 5 days", Tag: "auth",
+		Body: mod.Login{}, Status: http.StatusOK, Response: loginResponse{}, Errors: []int{400, 401, 405, 429, 500}},
+
+	{Method: "GET", Path: "/api/v1/healthcare/address/regions", OperationID: "listRegions", Summary: "Regions", Tag: "address",
+		Status: http.StatusOK, Response: listOf("regions", mod.AdminArea{}), Errors: []int{405}},
+	{Method: "GET", Path: "/api/v1/healthcare/address/zones", OperationID: "listZones", Summary: "Zones of a region", Tag: "address",
+		Query: []apiParam{{Name: "region", Description: "region code e.g. ET-AM", Required: true}}, Status: http.StatusOK,
+		Response: listOf("zones", mod.AdminArea{}), Errors: []int{400, 404, 405}},
+	{Method: "GET", Path: "/api/v1/healthcare/address/woredas", OperationID: "listWoredas", Summary: "Woredas of a zone", Tag: "address",
+		Query: []apiParam{{Name: "zone", Description: "zone code e.g. ET-AM-01", Required: true}}, Status: http.StatusOK,
+		Response: listOf("woredas", mod.AdminArea{}), Errors: []int{400, 404, 405}},
+	{Method: "GET", Path: "/api/v1/healthcare/address/kebeles", OperationID: "listKebeles", Summary: "Kebeles of a woreda", Tag: "address",
+		Query: []apiParam{{Name: "woreda", Description: "woreda code e.g. ET-AM-01-01", Required: true}}, Status: http.StatusOK,
+		Response: listOf("kebeles", mod.AdminArea{}), Errors: []int{400, 404, 405}},
+	{Method: "GET", Path: "/api/v1/healthcare/address/review", OperationID: "listAddressReview", Summary: "Patients whose free-text address has to be re-entered", Tag: "address",
+		Auth: true, Query: []apiParam{limitQuery}, Status: http.StatusOK, Response: listOf("client_profiles", mod.PatientDetails{}), Errors: []int{400, 405}},
+
+	{Method: "GET", Path: "/api/v1/healthcare/preferance/get", OperationID: "getPreferance", Summary: "Preferences and counters of the logged in provider", Tag: "healthcare",
+		Auth: true, Query: []apiParam{cacheQuery}, Status: http.StatusOK, Response: preferanceResponse{}, Errors: []int{405}},
+	{Method: "PATCH", Path: "/api/v1/healthcare/preferance/change", OperationID: "changePreferance", Summary: "Change email, availability or scheduled deletion", Tag: "healthcare",
+		Auth: true, Body: mod.ChangePreferance{}, Status: http.StatusOK, Response: preferanceChangeResponse{}, Errors: []int{400, 405, 422}},
+	{Method: "DELETE", Path: "/api/v1/healthcare/delete/account", OperationID: "deleteAccount", Summary: "Schedule the account for deletion", Tag: "healthcare",
+		Auth: true, Status: http.StatusOK, Response: statusResponse{}, Errors: []int{405}},
+	{Method: "GET", Path: "/api/v1/healthcare/details", OperationID: "getHealthcareDetails", Summary: "Profile of the logged in provider", Tag: "healthcare",
+		Auth: true, Query: []apiParam{cacheQuery}, Status: http.StatusOK, Response: healthcareDetailsResponse{}, Errors: []int{404, 405}},
+
+	{Method: "GET", Path: "/api/v1/healthcare/appointments/get", OperationID: "listAppointments", Summary: "Appointments booked with the provider", Tag: "appointments",
+		Auth: true, Query: []apiParam{limitQuery}, Status: http.StatusOK, Response: appointmentsResponse{}, Errors: []int{400, 405}},
+	{Method: "POST", Path: "/api/v1/healthcare/appointments/set", OperationID: "setAppointment", Summary: "Queue a status change of an appointment", Tag: "appointments",
+		Auth: true, Body: mod.UpdateAppointment{}, Status: http.StatusOK, Response: appointmentQueuedResponse{}, Errors: []int{400, 404, 405, 422}},
+
+	{Method: "POST", Path: "/api/v1/healthcare/client/records/create", OperationID: "createRecord", Summary: "Queue a medical record", Tag: "records",
+		Auth: true, Body: mod.PatientRecords{}, Status: http.StatusOK, Response: statusResponse{}, Errors: []int{400, 405, 422}},
+	{Method: "GET", Path: "/api/v1/healthcare/client/records/fetch", OperationID: "listRecords", Summary: "Medical records of a patient", Tag: "records",
+		Auth: true, Query: []apiParam{healthIDQuery, {Name: "list", Type: "integer", Description: "how many, defaults to 5"},
+			{Name: "severity", Description: "only records of this severity"}},
+		Status: http.StatusOK, Response: patientRecordsResponse{}, Errors: []int{400, 405}},
+
+	{Method: "POST", Path: "/api/v1/healthcare/client/profile/create", OperationID: "createPatient", Summary: "Register a patient", Tag: "patients",
+		Auth: true, Body: mod.PatientDetails{}, Status: http.StatusCreated, Response: patientCreatedResponse{}, Errors: []int{400, 405, 409, 422}},
+	{Method: "GET", Path: "/api/v1/healthcare/client/profile/get", OperationID: "getPatient", Summary: "Profile of a patient", Tag: "patients",
+		Auth: true, Query: []apiParam{healthIDQuery}, Status: http.StatusOK, Response: wrapped("client_profile", mod.PatientDetails{}),
+		ResponseHeaders: []string{"ETag"}, Errors: []int{400, 404, 405}},
+	{Method: "PATCH", Path: "/api/v1/healthcare/client/profile/update", OperationID: "updatePatient", Summary: "Change a profile with a JSON merge patch", Tag: "patients",
+		Auth: true, Query: []apiParam{healthIDQuery},
+		Headers:     []apiParam{{Name: "If-Match", Description: "ETag of the version being changed, * or left out for any version"}, changeReasonHeader},
+		Body:        map[string]interface{}{"type": "object", "description": "fields of the patient profile to change, null clears one, address takes region/zone/woreda/kebele/landmark"},
+		ContentType: "application/merge-patch+json", Status: http.StatusAccepted, Response: wrapped("updated_details", mod.PatientDetails{}),
+		ResponseHeaders: []string{"ETag"}, Errors: []int{400, 404, 405, 412, 415, 422}},
+	{Method: "GET", Path: "/api/v1/healthcare/client/profile/search", OperationID: "searchPatients", Summary: "Search the provider's patients", Tag: "patients",
+		Auth: true, Query: []apiParam{{Name: "name"}, {Name: "fathername"}, {Name: "phone"}, {Name: "dob"}, {Name: "region"}, {Name: "zone"}, {Name: "woreda"},
+			{Name: "limit", Type: "integer", Description: "1 to 50, defaults to 10"}},
+		Status: http.StatusOK, Response: listOf("matches", mod.PatientMatch{}), Errors: []int{400, 405}},
+
+	{Method: "GET", Path: "/api/v1/healthcare/client/profile/versions", OperationID: "listPatientVersions", Summary: "Earlier versions of a profile", Tag: "history",
+		Auth: true, Query: []apiParam{healthIDQuery}, Status: http.StatusOK, Response: profileVersionsResponse{}, Errors: []int{400, 404, 405}},
+	{Method: "GET", Path: "/api/v1/healthcare/client/profile/diff", OperationID: "diffPatientVersions", Summary: "Field changes between two versions", Tag: "history",
+		Auth: true, Query: []apiParam{healthIDQuery, {Name: "from", Type: "integer", Required: true}, {Name: "to", Type: "integer", Description: "defaults to the current version"}},
+		Status: http.StatusOK, Response: profileDiffResponse{}, Errors: []int{400, 404, 405}},
+	{Method: "GET", Path: "/api/v1/healthcare/client/profile/asof", OperationID: "getPatientAsOf", Summary: "A profile as it was at a time", Tag: "history",
+		Auth: true, Query: []apiParam{healthIDQuery, {Name: "date", Description: "YYYY-MM-DD (end of that day) or RFC 3339", Required: true}},
+		Status: http.StatusOK, Response: profileAsOfResponse{}, Errors: []int{400, 404, 405}},
+
+	{Method: "GET", Path: "/api/v1/healthcare/client/duplicates", OperationID: "listDuplicates", Summary: "Possible duplicate registrations", Tag: "duplicates",
+		Auth: true, Query: []apiParam{{Name: "status", Description: "pending, merged or dismissed"}, limitQuery},
+		Status: http.StatusOK, Response: listOf("duplicates", mod.DuplicateCandidate{}), Errors: []int{400, 405}},
+	{Method: "POST", Path: "/api/v1/healthcare/client/duplicates/dismiss", OperationID: "dismissDuplicate", Summary: "Mark a pair as two different patients", Tag: "duplicates",
+		Auth: true, Body: struct {
+			ID int64 `json:"id" validate:"required"`
+		}{}, Status: http.StatusOK, Response: struct {
+			statusResponse
+			ID int64 `json:"id"`
+		}{}, Errors: []int{400, 404, 405}},
+	{Method: "POST", Path: "/api/v1/healthcare/client/merge", OperationID: "mergePatients", Summary: "Fold one registration into another, a 202 with a merge request when another provider registered it", Tag: "duplicates",
+		Auth: true, Body: struct {
+			SurvivingHealthID string `json:"surviving_health_id" validate:"required"`
+			MergedHealthID    string `json:"merged_health_id" validate:"required"`
+		}{}, Status: http.StatusOK, Response: mergeResponse{}, Errors: []int{400, 403, 404, 405, 409}},
+	{Method: "POST", Path: "/api/v1/healthcare/client/unmerge", OperationID: "unmergePatients", Summary: "Undo a merge", Tag: "duplicates",
+		Auth: true, Body: struct {
+			MergeID int64 `json:"merge_id" validate:"required"`
+		}{}, Status: http.StatusOK, Response: mergeResponse{}, Errors: []int{400, 403, 404, 405, 409}},
+
+	// merge requests are v2 only
+	{Method: "GET", Path: "/api/v2/merge-requests", OperationID: "listMergeRequests", Summary: "Merge requests the provider made or has to decide", Tag: "duplicates",
+		Auth: true, Query: []apiParam{{Name: "status", Description: "pending, approved or rejected"}, limitQuery},
+		Status: http.StatusOK, Response: listOf("merge_requests", mod.MergeRequest{}), Errors: []int{400, 405}},
+	{Method: "POST", Path: "/api/v2/merge-requests/{id}/approve", OperationID: "approveMergeRequest", Summary: "Approve a merge request, the patients are merged", Tag: "duplicates",
+		Auth: true, PathParams: []apiParam{mergeRequestIDParam}, Status: http.StatusOK, Response: mergeResponse{}, Errors: []int{400, 403, 404, 405, 409}},
+	{Method: "POST", Path: "/api/v2/merge-requests/{id}/reject", OperationID: "rejectMergeRequest", Summary: "Reject or withdraw a merge request", Tag: "duplicates",
+		Auth: true, PathParams: []apiParam{mergeRequestIDParam}, Status: http.StatusOK, Response: mergeRequestResponse{}, Errors: []int{400, 404, 405}},
+
+	// webhooks are v2 only
+	{Method: "GET", Path: "/api/v2/webhooks", OperationID: "listWebhooks", Summary: "Webhooks of the provider", Tag: "webhooks",
+		Auth: true, Status: http.StatusOK, Response: listOf("webhooks", mod.Webhook{}), Errors: []int{405}},
+	{Method: "POST", Path: "/api/v2/webhooks", OperationID: "createWebhook", Summary: "Register an endpoint for events, the secret is only in this response", Tag: "webhooks",
+		Auth: true, Body: struct {
+			URL         string   `json:"url" validate:"required"`
+			Events      []string `json:"events" validate:"required"`
+			Description string   `json:"description"`
+		}{}, Status: http.StatusCreated, Response: webhookResponse{}, Errors: []int{400, 405, 422}},
+	{Method: "GET", Path: "/api/v2/webhooks/{id}", OperationID: "getWebhook", Summary: "A webhook", Tag: "webhooks",
+		Auth: true, PathParams: []apiParam{webhookIDParam}, Status: http.StatusOK, Response: wrapped("webhook", mod.Webhook{}), Errors: []int{400, 404, 405}},
+	{Method: "PATCH", Path: "/api/v2/webhooks/{id}", OperationID: "updateWebhook", Summary: "Change, pause or rotate the secret of a webhook", Tag: "webhooks",
+		Auth: true, PathParams: []apiParam{webhookIDParam}, Body: mod.WebhookPatch{}, Status: http.StatusOK, Response: webhookResponse{},
+		Errors: []int{400, 404, 405, 422}},
+	{Method: "DELETE", Path: "/api/v2/webhooks/{id}", OperationID: "deleteWebhook", Summary: "Remove a webhook and its deliveries", Tag: "webhooks",
+		Auth: true, PathParams: []apiParam{webhookIDParam}, Status: http.StatusOK, Response: struct {
+			statusResponse
+			ID int64 `json:"id"`
+		}{}, Errors: []int{400, 404, 405}},
+	{Method: "GET", Path: "/api/v2/webhooks/{id}/deliveries", OperationID: "listWebhookDeliveries", Summary: "Newest deliveries of a webhook", Tag: "webhooks",
+		Auth: true, PathParams: []apiParam{webhookIDParam},
+		Query:  []apiParam{{Name: "status", Description: "pending, delivered or failed"}, {Name: "limit", Type: "integer", Description: "1 to 100, defaults to 20"}},
+		Status: http.StatusOK, Response: listOf("deliveries", mod.WebhookDelivery{}), Errors: []int{400, 404, 405}},
+	{Method: "GET", Path: "/api/v2/webhooks/{id}/deliveries/{delivery_id}", OperationID: "getWebhookDelivery", Summary: "A delivery with its event and every attempt", Tag: "webhooks",
+		Auth: true, PathParams: []apiParam{webhookIDParam, deliveryIDParam}, Status: http.StatusOK, Response: wrapped("delivery", mod.WebhookDelivery{}),
+		Errors: []int{400, 404, 405}},
+	{Method: "POST", Path: "/api/v2/webhooks/{id}/deliveries/{delivery_id}/redeliver", OperationID: "redeliverWebhook", Summary: "Send a delivery again", Tag: "webhooks",
+		Auth: true, PathParams: []apiParam{webhookIDParam, deliveryIDParam}, Status: http.StatusAccepted, Response: redeliveryResponse{},
+		Errors: []int{400, 404, 405}},
+
+	// notifications are v2 only
+	{Method: "GET", Path: "/api/v2/notifications", OperationID: "listNotifications", Summary: "Newest notifications about the provider and its patients", Tag: "notifications",
+		Auth:   true,
+		Query:  []apiParam{{Name: "status", Description: "pending, sent or failed"}, {Name: "limit", Type: "integer", Description: "1 to 100, defaults to 20"}},
+		Status: http.StatusOK, Response: listOf("notifications", mod.Notification{}), Errors: []int{400, 405}},
+	{Method: "GET", Path: "/api/v2/notifications/{id}", OperationID: "getNotification", Summary: "A notification and how sending it went", Tag: "notifications",
+		Auth: true, PathParams: []apiParam{{Name: "id", Type: "integer", Description: "id of the notification", Required: true}},
+		Status: http.StatusOK, Response: wrapped("notification", mod.Notification{}), Errors: []int{400, 404, 405}},
+	{Method: "GET", Path: "/api/v2/healthcare/notification-preferences", OperationID: "getNotificationPreferences", Summary: "How the provider is notified", Tag: "notifications",
+		Auth: true, Status: http.StatusOK, Response: wrapped("preferences", mod.NotificationPreferences{}), Errors: []int{405}},
+	{Method: "PUT", Path: "/api/v2/healthcare/notification-preferences", OperationID: "setNotificationPreferences", Summary: "Replace how the provider is notified", Tag: "notifications",
+		Auth: true, Body: mod.NotificationPreferences{}, Status: http.StatusOK, Response: notificationPreferencesResponse{}, Errors: []int{400, 405, 422}},
+	{Method: "GET", Path: "/api/v2/patients/{healthID}/notification-preferences", OperationID: "getPatientNotificationPreferences", Summary: "How a patient is notified", Tag: "notifications",
+		Auth: true, PathParams: []apiParam{healthIDQuery}, Status: http.StatusOK, Response: wrapped("preferences", mod.NotificationPreferences{}), Errors: []int{404, 405}},
+	{Method: "PUT", Path: "/api/v2/patients/{healthID}/notification-preferences", OperationID: "setPatientNotificationPreferences", Summary: "Replace how a patient is notified", Tag: "notifications",
+		Auth: true, PathParams: []apiParam{healthIDQuery}, Body: mod.NotificationPreferences{}, Status: http.StatusOK, Response: notificationPreferencesResponse{},
+		Errors: []int{400, 404, 405, 422}},
+	// the SMS gateway posts the patients' answers to reminders, it has no JWT
+	{Method: "POST", Path: "/api/v2/sms/inbound", OperationID: "receiveSMS", Summary: "A patient's text message confirming or cancelling an appointment", Tag: "notifications",
+		Headers: []apiParam{{Name: "Authorization", Description: "Bearer and the SMS_INBOUND_TOKEN the server was started with", Required: true}},
+		Body:    inboundSMS{}, Status: http.StatusOK, Response: reminderReplyResponse{}, Errors: []int{400, 401, 404, 405, 422, 500}},
+
+	{Method: "GET", Path: "/api/v2/stream", OperationID: "streamUpdates", Summary: "Appointment changes, new records and quota warnings as server-sent events", Tag: "stream",
+		Auth: true, Query: []apiParam{{Name: "access_token", Description: "the bearer token, for clients that can't set Authorization"}},
+		Headers:     []apiParam{{Name: "Last-Event-ID", Type: "integer", Description: "id of the last event seen, the missed ones are sent first"}},
+		ContentType: "text/event-stream", Status: http.StatusOK,
+		Response: map[string]interface{}{"type": "string", "description": "events appointment.status.changed, patient.record.created, hip.quota.low and reset, " +
+			"each with its id and the event envelope as data"},
+		Errors: []int{400, 405}},
+}
+
+// v2Operations are the v1 operations on the resource paths of routesV2. A path variable
+// replaces the query parameter of the same name, or the body when that only carried the id
+var v2Operations = []struct {
+	from, method, path string
+	noBody             bool
+	// replace the v1 headers and errors when set
+	headers []apiParam
+	errors  []int
+}{
+	{from: "signUp", method: "POST", path: "/auth/register"},
+	{from: "login", method: "POST", path: "/auth/login"},
+	{from: "listRegions", method: "GET", path: "/regions"},
+	{from: "listZones", method: "GET", path: "/regions/{region}/zones"},
+	{from: "listWoredas", method: "GET", path: "/zones/{zone}/woredas"},
+	{from: "listKebeles", method: "GET", path: "/woredas/{woreda}/kebeles"},
+	{from: "listAddressReview", method: "GET", path: "/address-review"},
+	{from: "getHealthcareDetails", method: "GET", path: "/healthcare"},
+	{from: "deleteAccount", method: "DELETE", path: "/healthcare"},
+	{from: "getPreferance", method: "GET", path: "/healthcare/preferences"},
+	{from: "changePreferance", method: "PATCH", path: "/healthcare/preferences"},
+	{from: "listAppointments", method: "GET", path: "/appointments"},
+	{from: "setAppointment", method: "PATCH", path: "/appointments/{id:[0-9]+}"},
+	{from: "searchPatients", method: "GET", path: "/patients"},
+	{from: "createPatient", method: "POST", path: "/patients"},
+	{from: "getPatient", method: "GET", path: "/patients/{healthID}"},
+	{from: "updatePatient", method: "PATCH", path: "/patients/{healthID}",
+		headers: []apiParam{{Name: "If-Match", Description: "ETag of the version being changed, or *", Required: true}, changeReasonHeader},
+		errors:  []int{400, 404, 405, 412, 415, 422, 428}},
+	{from: "listRecords", method: "GET", path: "/patients/{healthID}/records"},
+	{from: "createRecord", method: "POST", path: "/patients/{healthID}/records"},
+	{from: "listPatientVersions", method: "GET", path: "/patients/{healthID}/versions"},
+	{from: "diffPatientVersions", method: "GET", path: "/patients/{healthID}/diff"},
+	{from: "getPatientAsOf", method: "GET", path: "/patients/{healthID}/asof"},
+	{from: "listDuplicates", method: "GET", path: "/duplicates"},
+	{from: "dismissDuplicate", method: "POST", path: "/duplicates/{id:[0-9]+}/dismiss", noBody: true},
+	{from: "mergePatients", method: "POST", path: "/merges"},
+	{from: "unmergePatients", method: "POST", path: "/merges/{id:[0-9]+}/unmerge", noBody: true},
+}
+
+// pathVariable is {name} or {name:pattern} in a mux path template
+var pathVariable = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// openAPIPath drops the patterns of a mux template, /merges/{id:[0-9]+} is /merges/{id} in the spec
+func openAPIPath(template string) string {
+	return pathVariable.ReplaceAllString(template, "{$1}")
+}
+
+// allOperations is apiOperations followed by their v2 copies, the operationIds get a V2 suffix
+func allOperations() []apiOperation {
+	byID := map[string]apiOperation{}
+	for _, op := range apiOperations {
+		byID[op.OperationID] = op
+	}
+	operations := append([]apiOperation{}, apiOperations...)
+	for _, v2 := range v2Operations {
+		v1 := byID[v2.from]
+		op := v1
+		op.Method, op.Path, op.OperationID = v2.method, v2Prefix+openAPIPath(v2.path), v2.from+"V2"
+		op.Query = nil
+		inPath := map[string]bool{}
+		for _, match := range pathVariable.FindAllStringSubmatch(v2.path, -1) {
+			param := apiParam{Name: match[1], Required: true}
+			if match[2] == ":[0-9]+" {
+				param.Type = "integer"
+			}
+			for _, p := range v1.Query {
+				if p.Name == param.Name {
+					param.Description = p.Description
+				}
+			}
+			op.PathParams = append(op.PathParams, param)
+			inPath[param.Name] = true
+		}
+		for _, p := range v1.Query {
+			if !inPath[p.Name] {
+				op.Query = append(op.Query, p)
+			}
+		}
+		if v2.noBody {
+			op.Body = nil
+		}
+		if v2.headers != nil {
+			op.Headers = v2.headers
+		}
+		if v2.errors != nil {
+			op.Errors = v2.errors
+		}
+		operations = append(operations, op)
+	}
+	return operations
+}
+
+// listOf is the {"<key>": [...], "fetched": n} shape the list endpoints answer with
+func listOf(key string, item interface{}) *inlineSchema {
+	return &inlineSchema{build: func(b *specBuilder) map[string]interface{} {
+		return map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				key:       map[string]interface{}{"type": "array", "items": b.schema(reflect.TypeOf(item))},
+				"fetched": map[string]interface{}{"type": "integer"},
+			},
+			"required": []string{key, "fetched"},
+		}
+	}}
+}
+
+func wrapped(key string, value interface{}) *inlineSchema {
+	return &inlineSchema{build: func(b *specBuilder) map[string]interface{} {
+		return map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{key: b.schema(reflect.TypeOf(value))},
+			"required":   []string{key},
+		}
+	}}
+}
+
+// a schema that needs the builder for the types inside it
+type inlineSchema struct {
+	build func(b *specBuilder) map[string]interface{}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  map[string]interface{}
+)
+
+func openAPIDocument() map[string]interface{} {
+	openAPIOnce.Do(func() { openAPIDoc = buildOpenAPI(allOperations()) })
+	return openAPIDoc
+}
+
+func (s *APIServer) GetOpenAPI(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, http.StatusOK, openAPIDocument())
+}
+
+type specBuilder struct {
+	schemas map[string]interface{}
+}
+
+func buildOpenAPI(operations []apiOperation) map[string]interface{} {
+	b := &specBuilder{schemas: map[string]interface{}{}}
+	b.schemas["Problem"] = b.structSchema(reflect.TypeOf(Problem{}))
+	b.schemas["Problem"].(map[string]interface{})["description"] = "RFC 7807 problem details, see problem.go for the codes and their statuses"
+	errorSchema := map[string]interface{}{"$ref": "#/components/schemas/Problem"}
+	codes := i18n.Codes(i18n.English)
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	b.schemas["Code"] = map[string]interface{}{"type": "string", "enum": codes, "description": "stable message code, branch on this and show the message"}
+
+	paths := map[string]interface{}{}
+	for _, op := range operations {
+		item, ok := paths[op.Path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = b.operation(op, errorSchema)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":       "Ethio HealthCare Server",
+			"version":     "1.0.0",
+			"description": "Messages are translated by Accept-Language (en, am, om), the code next to each one never is.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+func (b *specBuilder) operation(op apiOperation, errorSchema map[string]interface{}) map[string]interface{} {
+	operation := map[string]interface{}{
+		"operationId": op.OperationID,
+		"summary":     op.Summary,
+		"tags":        []string{op.Tag},
+	}
+	params := []interface{}{}
+	for _, p := range op.PathParams {
+		params = append(params, parameter("path", p))
+	}
+	for _, p := range op.Query {
+		params = append(params, parameter("query", p))
+	}
+	for _, p := range op.Headers {
+		params = append(params, parameter("header", p))
+	}
+	// private() takes an Idempotency-Key on every write, Idempotent marks the public ones
+	idempotent := op.Idempotent || (op.Auth && op.Method != "GET")
+	if idempotent {
+		params = append(params, parameter("header", idempotencyKeyParam))
+	}
+	if len(params) > 0 {
+		operation["parameters"] = params
+	}
+	contentType := op.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	if op.Body != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{contentType: map[string]interface{}{"schema": b.value(op.Body)}},
+		}
+		contentType = "application/json"
+	}
+
+	success := map[string]interface{}{
+		"description": http.StatusText(op.Status),
+		"content":     map[string]interface{}{contentType: map[string]interface{}{"schema": b.value(op.Response)}},
+	}
+	responseHeaders := op.ResponseHeaders
+	if idempotent {
+		responseHeaders = append(append([]string{}, responseHeaders...), idempotentReplayedHeader)
+	}
+	if strings.HasPrefix(op.Path, v1Prefix+"/") {
+		// deprecatedV1 marks every v1 response
+		operation["deprecated"] = true
+		responseHeaders = append(append([]string{}, responseHeaders...), "Deprecation", "Sunset", "Link")
+	}
+	if len(responseHeaders) > 0 {
+		headers := map[string]interface{}{}
+		for _, name := range responseHeaders {
+			headers[name] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+		}
+		success["headers"] = headers
+	}
+	responses := map[string]interface{}{strconv.Itoa(op.Status): success}
+
+	errors := op.Errors
+	if idempotent {
+		// bad key, retry while the first one runs, key reused for another request
+		errors = append(append([]int{}, errors...), http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity)
+	}
+	if op.Auth {
+		// withJWTAuth and RateLimiter
+		errors = append(errors, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusInternalServerError)
+		operation["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+	}
+	for _, status := range errors {
+		responses[strconv.Itoa(status)] = map[string]interface{}{
+			"description": http.StatusText(status),
+			"headers":     map[string]interface{}{requestIDHeader: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}},
+			"content":     map[string]interface{}{"application/problem+json": map[string]interface{}{"schema": errorSchema}},
+		}
+	}
+	operation["responses"] = responses
+	return operation
+}
+
+func parameter(in string, p apiParam) map[string]interface{} {
+	typ := p.Type
+	if typ == "" {
+		typ = "string"
+	}
+	param := map[string]interface{}{
+		"name":     p.Name,
+		"in":       in,
+		"required": p.Required,
+		"schema":   map[string]interface{}{"type": typ},
+	}
+	if p.Description != "" {
+		param["description"] = p.Description
+	}
+	return param
+}
+
+// value is the schema of a zero value, a ready schema or an inlineSchema
+func (b *specBuilder) value(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v
+	case *inlineSchema:
+		return v.build(b)
+	}
+	return b.schema(reflect.TypeOf(v))
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	codeType     = reflect.TypeOf(i18n.Code(""))
+	modelsPath   = reflect.TypeOf(mod.PatientDetails{}).PkgPath()
+)
+
+// schema of a Go type, the named models of the databases package become shared components
+func (b *specBuilder) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case objectIDType:
+		return map[string]interface{}{"type": "string"}
+	case codeType:
+		return map[string]interface{}{"$ref": "#/components/schemas/Code"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Interface:
+		// any JSON value
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if t.Name() == "" || t.PkgPath() != modelsPath {
+			return b.structSchema(t)
+		}
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := b.schemas[t.Name()]; !ok {
+			// placeholder first, a model can refer to itself
+			b.schemas[t.Name()] = map[string]interface{}{}
+			b.schemas[t.Name()] = b.structSchema(t)
+		}
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+func (b *specBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	b.addFields(t, properties, &required)
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (b *specBuilder) addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		// embedded structs without a json name are flattened like encoding/json does
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			b.addFields(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := b.schema(field.Type)
+		if isRequired := applyValidateTag(schema, field.Type, field.Tag.Get("validate")); isRequired {
+			*required = append(*required, name)
+		}
+		properties[name] = schema
+	}
+}
+
+// applyValidateTag turns the validator rules into JSON schema keywords, it reports whether the field is required
+func applyValidateTag(schema map[string]interface{}, t reflect.Type, tag string) bool {
+	if tag == "" {
+		return false
+	}
+	// a $ref can't have siblings that mean something in every tool, leave referenced models alone
+	if _, ok := schema["$ref"]; ok {
+		return strings.Contains(","+tag+",", ",required,")
+	}
+	isRequired := false
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			isRequired = true
+		case "email":
+			schema["format"] = "email"
+		case "oneof":
+			schema["enum"] = oneofValues(param)
+		case "min", "max", "gte", "lte":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+			schema[boundKeyword(t, name == "min" || name == "gte")] = n
+		}
+	}
+	return isRequired
+}
+
+func boundKeyword(t reflect.Type, lower bool) string {
+	switch t.Kind() {
+	case reflect.String:
+		if lower {
+			return "minLength"
+		}
+		return "maxLength"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if lower {
+			return "minItems"
+		}
+		return "maxItems"
+	}
+	if lower {
+		return "minimum"
+	}
+	return "maximum"
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestOpenAPICoversRoutes fails when a route is registered without an apiOperations entry
+// or an entry is left behind after its route is removed
+func TestOpenAPICoversRoutes(t *testing.T) {
+	paths := openAPIDocument()["paths"].(map[string]interface{})
+
+	registered := map[string]bool{}
+	err := NewAPIServer(":0", nil).routes().Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		// the /api/v1/healthcare and /api/v2 prefixes only hold subrouters
+		path, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		path = openAPIPath(path)
+		registered[path] = true
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s is routed but has no entry in apiOperations", path)
+			return nil
+		}
+		// routes without .Methods() answer every method, the spec has to list at least one
+		methods, err := route.GetMethods()
+		if err != nil {
+			if len(item) == 0 {
+				t.Errorf("%s has no operations in the spec", path)
+			}
+			return nil
+		}
+		for _, method := range methods {
+			if _, ok := item[strings.ToLower(method)]; !ok {
+				t.Errorf("%s %s is routed but not in the spec", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path := range paths {
+		if !registered[path] {
+			t.Errorf("%s is in the spec but not routed", path)
+		}
+	}
+}
+
+func TestOpenAPIOperations(t *testing.T) {
+	seen := map[string]bool{}
+	for _, op := range allOperations() {
+		if op.OperationID == "" || seen[op.OperationID] {
+			t.Errorf("%s %s: operationId %q is empty or used twice", op.Method, op.Path, op.OperationID)
+		}
+		seen[op.OperationID] = true
+		if http.StatusText(op.Status) == "" || op.Response == nil {
+			t.Errorf("%s %s has no success response", op.Method, op.Path)
+		}
+	}
+}
+
+// every $ref points at a component that exists
+func TestOpenAPIReferences(t *testing.T) {
+	doc := openAPIDocument()
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	var refs []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				refs = append(refs, ref)
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+
+	if len(refs) == 0 {
+		t.Fatal("no $ref in the document, models are not shared components")
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		name, ok := strings.CutPrefix(ref, "#/components/schemas/")
+		if _, found := schemas[name]; !ok || !found {
+			t.Errorf("%s does not resolve", ref)
+		}
+	}
+}
+
+func TestGetOpenAPI(t *testing.T) {
+	api := newTestAPI(t)
+
+	res := api.do("GET", "/openapi.json", "", map[string]string{"Authorization": ""})
+	if res.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", res.Code, res.Body)
+	}
+	var doc struct {
+		OpenAPI    string `json:"openapi"`
+		Components struct {
+			Schemas map[string]struct {
+				Required   []string `json:"required"`
+				Properties map[string]struct {
+					Type      string   `json:"type"`
+					Format    string   `json:"format"`
+					MaxLength int      `json:"maxLength"`
+					Enum      []string `json:"enum"`
+				} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("openapi = %q", doc.OpenAPI)
+	}
+
+	// the limits come from the validate tags of the model
+	patient, ok := doc.Components.Schemas["PatientDetails"]
+	if !ok {
+		t.Fatal("PatientDetails is not a component")
+	}
+	if !contains(patient.Required, "fname") {
+		t.Errorf("fname not required, required = %v", patient.Required)
+	}
+	if got := patient.Properties["fname"].MaxLength; got != 60 {
+		t.Errorf("fname maxLength = %d, want 60", got)
+	}
+	if got := patient.Properties["email"].Format; got != "email" {
+		t.Errorf("email format = %q", got)
+	}
+	if got := doc.Components.Schemas["HIPInfo"].Properties["date_of_registration"].Format; got != "date-time" {
+		t.Errorf("HIPInfo.date_of_registration format = %q", got)
+	}
+	// a quoted oneof value keeps its space
+	statuses := doc.Components.Schemas["UpdateAppointment"].Properties["status"].Enum
+	if want := []string{"Pending", "Confirmed", "Rejected", "Not Available", "Cancelled"}; !slices.Equal(statuses, want) {
+		t.Errorf("UpdateAppointment.status enum = %q, want %q", statuses, want)
+	}
+
+	res = api.do("POST", "/openapi.json", "", map[string]string{"Authorization": ""})
+	if res.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d", res.Code)
+	}
+}
+
+// v2 ids are path parameters, every v1 operation is deprecated
+func TestOpenAPIVersions(t *testing.T) {
+	paths := openAPIDocument()["paths"].(map[string]interface{})
+
+	unmerge := paths["/api/v2/merges/{id}/unmerge"].(map[string]interface{})["post"].(map[string]interface{})
+	var inPath []map[string]interface{}
+	for _, p := range unmerge["parameters"].([]interface{}) {
+		if p.(map[string]interface{})["in"] == "path" {
+			inPath = append(inPath, p.(map[string]interface{}))
+		}
+	}
+	if len(inPath) != 1 {
+		t.Fatalf("path parameters = %v", inPath)
+	}
+	id := inPath[0]
+	if id["in"] != "path" || id["name"] != "id" || id["required"] != true {
+		t.Errorf("id parameter = %v", id)
+	}
+	if _, ok := unmerge["requestBody"]; ok {
+		t.Error("v2 unmerge still takes the id in a body")
+	}
+
+	getPatient := paths["/api/v2/patients/{healthID}"].(map[string]interface{})["get"].(map[string]interface{})
+	for _, p := range getPatient["parameters"].([]interface{}) {
+		if p.(map[string]interface{})["in"] == "query" {
+			t.Errorf("healthID is still a query parameter: %v", p)
+		}
+	}
+	if _, ok := getPatient["deprecated"]; ok {
+		t.Error("v2 operation is deprecated")
+	}
+
+	for path, item := range paths {
+		if !strings.HasPrefix(path, v1Prefix+"/") {
+			continue
+		}
+		for method, op := range item.(map[string]interface{}) {
+			if op.(map[string]interface{})["deprecated"] != true {
+				t.Errorf("%s %s is not deprecated", method, path)
+			}
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	mod "vaibhavyadav-dev/healthcareServer/databases"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The Postman collection doubles as a contract test: its requests run in order against the real
+// router, the variables its test scripts capture are carried to the next request, and every
+// response has to come back with the status and the shape of the example saved next to it.
+
+const postmanCollectionFile = "Healthcare.postman_collection.json"
+
+type postmanCollection struct {
+	Item     []postmanItem `json:"item"`
+	Variable []postmanPair `json:"variable"`
+}
+
+type postmanItem struct {
+	Name string `json:"name"`
+	// folders only have items
+	Item     []postmanItem     `json:"item"`
+	Request  *postmanRequest   `json:"request"`
+	Response []postmanResponse `json:"response"`
+	Event    []struct {
+		Listen string `json:"listen"`
+		Script struct {
+			Exec []string `json:"exec"`
+		} `json:"script"`
+	} `json:"event"`
+}
+
+type postmanPair struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Disabled bool   `json:"disabled"`
+}
+
+type postmanRequest struct {
+	Method string        `json:"method"`
+	Header []postmanPair `json:"header"`
+	Body   *struct {
+		Mode string `json:"mode"`
+		Raw  string `json:"raw"`
+	} `json:"body"`
+	// a plain string or an object with raw, only raw is used
+	URL  json.RawMessage `json:"url"`
+	Auth *struct {
+		Type string `json:"type"`
+		// {"token": ...} in collection v2.0, [{"key": "token", "value": ...}] in v2.1
+		Bearer json.RawMessage `json:"bearer"`
+	} `json:"auth"`
+}
+
+type postmanResponse struct {
+	Name string `json:"name"`
+	Code int    `json:"code"`
+	Body string `json:"body"`
+}
+
+// requests that need state the collection itself cannot create
+var postmanFixtures = map[string]func(t *testing.T, store *memStore, vars map[string]string){
+	// the queue is only filled when the same person is registered twice
+	"Get Duplicate Queue": func(t *testing.T, store *memStore, vars map[string]string) {
+		patient, err := store.LocalStore.Get_ClientProfile(vars["health_id"])
+		require.NoError(t, err)
+		again := *patient
+		again.HealthID = patient.HealthID + "-2"
+		require.NoError(t, store.LocalStore.Create_ClientProfile(&again))
+		require.NoError(t, store.LocalStore.CreateClient_stats(again.HealthID))
+		_, err = store.LocalStore.FindDuplicates(&again)
+		require.NoError(t, err)
+	},
+	// the collection cannot book one, app_id stays 1
+	"Assign Appointment": func(t *testing.T, store *memStore, vars map[string]string) {
+		patient, err := store.LocalStore.Get_ClientProfile(vars["health_id"])
+		require.NoError(t, err)
+		id, err := strconv.ParseInt(vars["app_id"], 10, 64)
+		require.NoError(t, err)
+		_, err = store.LocalStore.BookAppointment(&mod.Appointments{
+			ID: id, HealthcareID: patient.HealthcareID, HealthID: patient.HealthID, AppointmentDate: "2026-10-21T00:00:00Z",
+			AppointmentTime: "09:30", FullName: "Almaz Tesfaye", Status: "Pending", HealthcareName: "Adama Hospital",
+		})
+		require.NoError(t, err)
+	},
+}
+
+func TestPostmanCollection(t *testing.T) {
+	raw, err := os.ReadFile(postmanCollectionFile)
+	require.NoError(t, err)
+	collection := postmanCollection{}
+	require.NoError(t, json.Unmarshal(raw, &collection))
+
+	store := newMemStore(t)
+	server := httptest.NewServer(NewAPIServer(":0", store).Handler())
+	defer server.Close()
+
+	run := &postmanRun{server: server, store: store, vars: map[string]string{"Addr": server.URL}}
+	for _, variable := range collection.Variable {
+		if !variable.Disabled {
+			run.vars[variable.Key] = variable.Value
+		}
+	}
+	items := flattenPostman(collection.Item)
+	require.NotEmpty(t, items, "collection has no requests")
+	for _, item := range items {
+		t.Run(item.Name, func(t *testing.T) { run.item(t, item) })
+	}
+}
+
+type postmanRun struct {
+	server  *httptest.Server
+	store   *memStore
+	vars    map[string]string
+	dynamic int
+}
+
+func flattenPostman(items []postmanItem) []postmanItem {
+	flat := []postmanItem{}
+	for _, item := range items {
+		if item.Request == nil {
+			flat = append(flat, flattenPostman(item.Item)...)
+			continue
+		}
+		flat = append(flat, item)
+	}
+	return flat
+}
+
+func (run *postmanRun) item(t *testing.T, item postmanItem) {
+	if len(item.Response) == 0 {
+		t.Fatalf("no example response saved for %q", item.Name)
+	}
+	example := item.Response[0]
+	if fixture, ok := postmanFixtures[item.Name]; ok {
+		fixture(t, run.store, run.vars)
+	}
+
+	req := run.request(t, item.Request)
+	res, err := run.server.Client().Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	if res.StatusCode != example.Code {
+		t.Fatalf("%s %s: status %d, the collection documents %d\n%s", req.Method, req.URL.Path, res.StatusCode, example.Code, body)
+	}
+	var got interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("response is not json: %s\n%s", err, body)
+	}
+	if strings.TrimSpace(example.Body) != "" {
+		var want interface{}
+		require.NoError(t, json.Unmarshal([]byte(example.Body), &want), "example %q is not json", example.Name)
+		for _, problem := range shapeDiff("$", want, got) {
+			t.Errorf("%s %s: %s", req.Method, req.URL.Path, problem)
+		}
+	}
+	run.capture(t, item, res, got)
+}
+
+func (run *postmanRun) request(t *testing.T, request *postmanRequest) *http.Request {
+	t.Helper()
+	rawURL := ""
+	if err := json.Unmarshal(request.URL, &rawURL); err != nil {
+		parsed := struct {
+			Raw string `json:"raw"`
+		}{}
+		require.NoError(t, json.Unmarshal(request.URL, &parsed))
+		rawURL = parsed.Raw
+	}
+	// a request pinned to a host would never reach the server under test
+	if !strings.HasPrefix(rawURL, "{{Addr}}") {
+		t.Fatalf("url %q does not start with {{Addr}}", rawURL)
+	}
+
+	body := ""
+	if request.Body != nil && request.Body.Mode == "raw" {
+		body = run.expand(t, stripJSONComments(request.Body.Raw))
+	}
+	// spaces are left as they are in the collection, Postman encodes them when sending
+	req, err := http.NewRequest(request.Method, strings.ReplaceAll(run.expand(t, rawURL), " ", "%20"), strings.NewReader(body))
+	require.NoError(t, err)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, header := range request.Header {
+		if !header.Disabled {
+			req.Header.Set(header.Key, run.expand(t, header.Value))
+		}
+	}
+	if request.Auth != nil && request.Auth.Type == "bearer" {
+		req.Header.Set("Authorization", "Bearer "+run.expand(t, bearerToken(t, request.Auth.Bearer)))
+	}
+	return req
+}
+
+func bearerToken(t *testing.T, raw json.RawMessage) string {
+	t.Helper()
+	v20 := struct {
+		Token string `json:"token"`
+	}{}
+	if err := json.Unmarshal(raw, &v20); err == nil {
+		return v20.Token
+	}
+	v21 := []postmanPair{}
+	require.NoError(t, json.Unmarshal(raw, &v21))
+	for _, pair := range v21 {
+		if pair.Key == "token" {
+			return pair.Value
+		}
+	}
+	return ""
+}
+
+// Postman drops // comment lines from JSON bodies before sending them
+func stripJSONComments(body string) string {
+	lines := strings.Split(body, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "//") {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+var postmanVariable = regexp.MustCompile(`{{\s*([$\w]+)\s*}}`)
+
+func (run *postmanRun) expand(t *testing.T, s string) string {
+	t.Helper()
+	return postmanVariable.ReplaceAllStringFunc(s, func(match string) string {
+		name := postmanVariable.FindStringSubmatch(match)[1]
+		if strings.HasPrefix(name, "$") {
+			return run.dynamicValue(t, name)
+		}
+		value, ok := run.vars[name]
+		if !ok {
+			t.Fatalf("variable %s is never set", name)
+		}
+		return value
+	})
+}
+
+// stand-ins for Postman's dynamic variables, valid for every field they are used in
+func (run *postmanRun) dynamicValue(t *testing.T, name string) string {
+	t.Helper()
+	run.dynamic++
+	n := run.dynamic
+	names := []string{"Abebe", "Almaz", "Dawit", "Hana", "Tigist", "Yonas"}
+	switch name {
+	case "$randomFullName":
+		return names[n%len(names)] + " " + names[(n+1)%len(names)]
+	case "$randomFirstName", "$randomLastName":
+		return names[n%len(names)]
+	case "$randomUserName":
+		return strings.ToLower(names[n%len(names)]) + strconv.Itoa(n)
+	case "$randomEmail":
+		return fmt.Sprintf("user%d@example.com", n)
+	case "$randomPhoneNumber":
+		return fmt.Sprintf("091-123-%04d", n)
+	case "$randomAlphaNumeric":
+		return string(rune('a' + n%26))
+	case "$randomBoolean":
+		return strconv.FormatBool(n%2 == 0)
+	case "$randomDateRecent":
+		return time.Now().AddDate(0, 0, -n).Format("2006-01-02")
+	case "$isoTimestamp":
+		return time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	case "$randomJobDescriptor":
+		return "Senior"
+	case "$randomCatchPhraseNoun":
+		return "fever"
+	case "$randomStreetName", "$randomStreetAddress":
+		return fmt.Sprintf("Churchill Road %d", n)
+	case "$randomCity":
+		return "Adama"
+	case "$randomCountry":
+		return "Ethiopia"
+	case "$randomLocale":
+		return "am"
+	}
+	t.Fatalf("dynamic variable %s has no stand-in", name)
+	return ""
+}
+
+var (
+	scriptVar = regexp.MustCompile(`^(?:var|let|const)\s+(\w+)\s*=\s*(jsonData[\w.\[\]]*)\s*;?$`)
+	scriptSet = regexp.MustCompile(`^pm\.(?:collectionVariables|globals|environment)\.set\(\s*"(\w+)"\s*,\s*(.+?)\s*\);?$`)
+	scriptHdr = regexp.MustCompile(`^pm\.response\.headers\.get\(\s*"([\w-]+)"\s*\)$`)
+)
+
+// capture does what the test scripts do with the response, only the
+// `var x = jsonData.path` and `pm.collectionVariables.set("name", ...)` forms are understood
+func (run *postmanRun) capture(t *testing.T, item postmanItem, res *http.Response, body interface{}) {
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return
+	}
+	for _, event := range item.Event {
+		if event.Listen != "test" {
+			continue
+		}
+		locals := map[string]string{}
+		for _, line := range event.Script.Exec {
+			line = strings.TrimSpace(line)
+			if m := scriptVar.FindStringSubmatch(line); m != nil {
+				locals[m[1]] = m[2]
+				continue
+			}
+			m := scriptSet.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			expr := m[2]
+			if path, ok := locals[expr]; ok {
+				expr = path
+			}
+			if h := scriptHdr.FindStringSubmatch(expr); h != nil {
+				run.vars[m[1]] = res.Header.Get(h[1])
+				continue
+			}
+			value, ok := jsonPath(body, expr)
+			if !ok {
+				t.Logf("%s: could not evaluate %s for %s", item.Name, m[2], m[1])
+				continue
+			}
+			run.vars[m[1]] = value
+		}
+	}
+}
+
+var jsonPathToken = regexp.MustCompile(`\.(\w+)|\[(\d+)\]`)
+
+// jsonPath reads jsonData.a.b[0].c, the value as it would be put in a template
+func jsonPath(body interface{}, expr string) (string, bool) {
+	if !strings.HasPrefix(expr, "jsonData") {
+		return "", false
+	}
+	rest := strings.TrimPrefix(expr, "jsonData")
+	current := body
+	for _, token := range jsonPathToken.FindAllStringSubmatch(rest, -1) {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			if token[1] == "" {
+				return "", false
+			}
+			current = node[token[1]]
+		case []interface{}:
+			index, err := strconv.Atoi(token[2])
+			if err != nil || index >= len(node) {
+				return "", false
+			}
+			current = node[index]
+		default:
+			return "", false
+		}
+	}
+	switch value := current.(type) {
+	case string:
+		return value, true
+	case nil:
+		return "", false
+	}
+	raw, _ := json.Marshal(current)
+	return string(raw), true
+}
+
+// shapeDiff lists where got does not have the shape of the example: every member the example
+// shows has to be there with the same JSON type, arrays are compared by their first element
+func shapeDiff(path string, want, got interface{}) []string {
+	if want == nil {
+		return nil
+	}
+	if jsonKind(want) != jsonKind(got) {
+		return []string{fmt.Sprintf("%s is %s, the example has %s", path, jsonKind(got), jsonKind(want))}
+	}
+	problems := []string{}
+	switch want := want.(type) {
+	case map[string]interface{}:
+		got := got.(map[string]interface{})
+		keys := make([]string, 0, len(want))
+		for key := range want {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value, ok := got[key]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s is missing", path, key))
+				continue
+			}
+			problems = append(problems, shapeDiff(path+"."+key, want[key], value)...)
+		}
+	case []interface{}:
+		got := got.([]interface{})
+		if len(want) > 0 && len(got) > 0 {
+			problems = append(problems, shapeDiff(path+"[0]", want[0], got[0])...)
+		}
+	}
+	return problems
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return "null"
+}
+
+func TestShapeDiff(t *testing.T) {
+	var want, got interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"fetched": 1, "matches": [{"score": 0.9, "client_profile": {"fname": "Abebe"}}], "note": null}`), &want))
+	require.NoError(t, json.Unmarshal([]byte(`{"fetched": "1", "matches": [{"score": 0.5, "client_profile": {}}], "extra": true}`), &got))
+	require.Equal(t, []string{
+		"$.fetched is a string, the example has a number",
+		"$.matches[0].client_profile.fname is missing",
+		"$.note is missing",
+	}, shapeDiff("$", want, got))
+
+	// an empty array can not be checked further, a null one is drift
+	require.NoError(t, json.Unmarshal([]byte(`{"matches": []}`), &got))
+	require.Empty(t, shapeDiff("$", map[string]interface{}{"matches": []interface{}{"x"}}, got))
+	require.NoError(t, json.Unmarshal([]byte(`{"matches": null}`), &got))
+	require.Equal(t, []string{"$.matches is null, the example has an array"}, shapeDiff("$", map[string]interface{}{"matches": []interface{}{}}, got))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"vaibhavyadav-dev/healthcareServer/i18n"
+)
+
+// Every error response is an RFC 7807 problem details body (application/problem+json).
+// Handlers return a *Problem and makeHTTPHandlerFunc writes it, any other error becomes an
+// internal_error. The text of the underlying error never reaches the client, it is logged
+// with the request ID the client got in X-Request-ID and in the body.
+//
+// code is stable per failure kind, clients branch on it and show detail. The statuses:
+//
+//	400 invalid_request_body, query_param_missing, invalid_query_param, invalid_path_param, health_id_missing,
+//	    search_criteria_missing, no_fields_to_update, nothing_to_update, invalid_idempotency_key,
+//	    invalid_last_event_id
+//	401 auth_header_invalid, invalid_token, token_missing_claim, hip_not_found (login), password_mismatch
+//	403 merge_forbidden
+//	404 hip_not_found, patient_not_found, version_not_found, duplicate_not_found, merge_not_found, merge_request_not_found,
+//	    invalid_address (listing the areas under an unknown one), event_type_not_found, webhook_not_found,
+//	    webhook_delivery_not_found, notification_not_found, reminder_not_found, route_not_found
+//	405 method_not_allowed, Allow lists the methods routed for the path
+//	409 hip_already_exists, patient_already_exists, patient_already_merged, idempotency_key_in_progress
+//	412 profile_changed
+//	415 unsupported_media_type
+//	422 validation_failed, invalid_address, invalid_appointment_status, invalid_medical_severity,
+//	    idempotency_key_reused, invalid_webhook_url, invalid_event_filter, invalid_reminder_reply
+//	428 precondition_required
+//	429 rate_limited, rate_limited_suspended, quota_exhausted
+//	500 internal_error
+
+const requestIDHeader = "X-Request-ID"
+
+const contextKeyRequestID = contextKey("requestID")
+
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// the message for Code in the caller's language
+	Detail    string         `json:"detail"`
+	Instance  string         `json:"instance,omitempty"`
+	Code      i18n.Code      `json:"code"`
+	RequestID string         `json:"request_id,omitempty"`
+	Errors    []FieldProblem `json:"errors,omitempty"`
+
+	args  []any
+	cause error
+}
+
+// FieldProblem is one field that failed validation
+type FieldProblem struct {
+	Field   string    `json:"field"`
+	Code    i18n.Code `json:"code"`
+	Message string    `json:"message"`
+
+	args []any
+}
+
+func newProblem(status int, code i18n.Code, args ...any) *Problem {
+	return &Problem{Type: "about:blank", Status: status, Code: code, args: args}
+}
+
+// withCause keeps err for the log, it is not sent
+func (p *Problem) withCause(err error) *Problem {
+	p.cause = err
+	return p
+}
+
+func (p *Problem) Error() string {
+	if p.cause != nil {
+		return fmt.Sprintf("%d %s: %v", p.Status, p.Code, p.cause)
+	}
+	return fmt.Sprintf("%d %s", p.Status, p.Code)
+}
+
+func (p *Problem) Unwrap() error {
+	return p.cause
+}
+
+func internalError(err error) *Problem {
+	return newProblem(http.StatusInternalServerError, i18n.InternalError).withCause(err)
+}
+
+func missingClaim(claim string) *Problem {
+	return newProblem(http.StatusUnauthorized, i18n.TokenMissingClaim, claim)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed string) *Problem {
+	w.Header().Set("Allow", allowed)
+	return newProblem(http.StatusMethodNotAllowed, i18n.MethodNotAllowed, r.Method)
+}
+
+// writeProblem sends err as a problem, translated for r, and logs what the client doesn't see
+func writeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	var p *Problem
+	if !errors.As(err, &p) {
+		p = internalError(err)
+	}
+	body := *p
+	body.Title = http.StatusText(p.Status)
+	body.Detail = msg(r, p.Code, p.args...)
+	body.Instance = r.URL.Path
+	body.RequestID = requestID(r)
+	body.Errors = make([]FieldProblem, len(p.Errors))
+	for i, field := range p.Errors {
+		field.Message = msg(r, field.Code, field.args...)
+		body.Errors[i] = field
+	}
+
+	if p.cause != nil || p.Status >= http.StatusInternalServerError {
+		log.Printf("request %s: %s %s: %v", body.RequestID, r.Method, r.URL.Path, p)
+	}
+
+	w.Header().Set("content-type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// withRequestID gives every request an ID, the caller's X-Request-ID if it sent a sane one
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyRequestID, id)))
+	})
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(contextKeyRequestID).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// the ID ends up in logs, only short printable ASCII is taken over
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
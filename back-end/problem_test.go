@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"vaibhavyadav-dev/healthcareServer/i18n"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the store's error text stays in the log, next to the request ID the client got
+func TestProblemHidesInternalErrors(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	api := newTestAPI(t)
+	api.store.failWith("GetPreferance", errStoreDown)
+	rec := api.do("GET", v1+"/preferance/get?cache=false", "", map[string]string{"X-Request-ID": "req-42"})
+
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.NotContains(t, rec.Body.String(), errStoreDown.Error())
+	assert.Equal(t, "req-42", rec.Header().Get("X-Request-ID"))
+
+	problem := Problem{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+	assert.Equal(t, i18n.InternalError, problem.Code)
+	assert.Equal(t, "req-42", problem.RequestID)
+	assert.Equal(t, "/api/v1/healthcare/preferance/get", problem.Instance)
+
+	assert.Contains(t, logs.String(), "request req-42")
+	assert.Contains(t, logs.String(), errStoreDown.Error())
+}
+
+func TestProblemTranslated(t *testing.T) {
+	api := newTestAPI(t)
+	rec := api.do("GET", v1+"/client/profile/get", "", map[string]string{"Accept-Language": "am"})
+
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	problem := Problem{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+	assert.Equal(t, i18n.HealthIDMissing, problem.Code)
+	assert.Equal(t, i18n.T(i18n.Amharic, i18n.HealthIDMissing), problem.Detail)
+	// the title is the status, never translated
+	assert.Equal(t, "Bad Request", problem.Title)
+}
+
+func TestProblemFieldErrors(t *testing.T) {
+	api := newTestAPI(t)
+	rec := api.do("PATCH", v1+"/preferance/change", `{"email": "not-an-email"}`, nil)
+
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	problem := Problem{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+	assert.Equal(t, i18n.ValidationFailed, problem.Code)
+	require.Len(t, problem.Errors, 1)
+	assert.Equal(t, "email", problem.Errors[0].Field)
+	assert.Equal(t, i18n.FieldEmail, problem.Errors[0].Code)
+	assert.Equal(t, i18n.T(i18n.English, i18n.FieldEmail, "email"), problem.Errors[0].Message)
+}
+
+func TestMethodNotAllowedSetsAllow(t *testing.T) {
+	api := newTestAPI(t)
+	rec := api.do("DELETE", v1+"/client/profile/get", "", nil)
+
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET", rec.Header().Get("Allow"))
+}
+
+func TestRequestID(t *testing.T) {
+	api := newTestAPI(t)
+
+	generated := api.do("GET", v1+"/address/regions", "", nil).Header().Get("X-Request-ID")
+	assert.Len(t, generated, 24)
+
+	// anything that could forge a log line is replaced
+	rec := api.do("GET", v1+"/address/regions", "", map[string]string{"X-Request-ID": "a b\nrequest c"})
+	assert.NotContains(t, rec.Header().Get("X-Request-ID"), " ")
+	rec = api.do("GET", v1+"/address/regions", "", map[string]string{"X-Request-ID": strings.Repeat("x", 65)})
+	assert.Len(t, rec.Header().Get("X-Request-ID"), 24)
+}
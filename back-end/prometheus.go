@@ -108,3 +108,8 @@ func (rw *ResponseWriter) Write(b []byte) (int, error) {
 	rw.size += size
 	return size, err
 }
+
+// Unwrap lets http.ResponseController flush a stream through the wrapper
+func (rw *ResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -1,11 +1,31 @@
 package rabbitmq
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	amqp "github.com/rabbitmq/amqp091-go"
 	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// Rabbitmq publishes persistent messages to the events exchange and waits for the broker to
+// confirm each one. Publishes share a small pool of channels in confirm mode. When the
+// connection drops it is dialled again in the background with backoff, publishes fail with
+// ErrUnavailable meanwhile and the outbox relay keeps the messages until it is back.
+
+const (
+	channelPoolSize = 4
+	// how long a publish waits for the broker's ack
+	confirmTimeout = 5 * time.Second
+	minBackoff     = time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+var ErrUnavailable = errors.New("rabbitmq is unreachable")
+
 func failOnError(err error, msg string) {
 	if err != nil {
 		log.Fatalf("%s: %s", msg, err)
@@ -13,30 +33,212 @@ func failOnError(err error, msg string) {
 }
 
 type Rabbitmq struct {
-	conn *amqp.Connection
-	ch   *amqp.Channel
+	url string
+
+	mu sync.Mutex
+	// both nil while reconnecting
+	conn   *amqp.Connection
+	pool   chan *amqp.Channel
+	closed bool
 }
 
 func Connect2rabbitmq(URL string) (*Rabbitmq, error) {
-	conn, err := amqp.Dial(URL)
+	r := &Rabbitmq{url: URL}
+	if err := r.connect(); err != nil {
+		return nil, fmt.Errorf("failed to connect RabbitMQ server: %w", err)
+	}
+	log.Printf("Successfully Connected to RabbitMq server... :)")
+	return r, nil
+}
+
+// connect dials, opens the channel pool and declares the exchange and queues
+func (r *Rabbitmq) connect() error {
+	conn, err := amqp.Dial(r.url)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	pool := make(chan *amqp.Channel, channelPoolSize)
+	for i := 0; i < channelPoolSize; i++ {
+		ch, err := openChannel(conn)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		pool <- ch
+	}
+
+	ch := <-pool
+	if err := declare(ch); err != nil {
+		conn.Close()
+		return err
+	}
+	pool <- ch
+
+	r.mu.Lock()
+	r.conn, r.pool = conn, pool
+	r.mu.Unlock()
+	go r.watch(conn.NotifyClose(make(chan *amqp.Error, 1)))
+	return nil
+}
+
+// declare sets up the exchange, the bound queues and their retry and dead-letter queues. Queues that already exist as
+// non-durable have to be deleted once (rabbitmqctl delete_queue <name>)
+func declare(ch *amqp.Channel) error {
+	err := ch.ExchangeDeclare(
+		Exchange, // name
+		"topic",  // type
+		true,     // durable
+		false,    // auto-deleted
+		false,    // internal
+		false,    // no-wait
+		nil,      // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", Exchange, err)
 	}
-	// defer conn.Close()
+	for queue, keys := range bindings {
+		_, err := ch.QueueDeclare(
+			queue, // queue name
+			true,  // durable
+			false, // delete when unused
+			false, // exclusive
+			false, // no-wait
+			nil,   // arguments
+		)
+		if err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", queue, err)
+		}
+		for _, key := range keys {
+			if err := ch.QueueBind(queue, key, Exchange, false, nil); err != nil {
+				return fmt.Errorf("failed to bind queue %s to %s: %w", queue, key, err)
+			}
+		}
+	}
+	return declareRetries(ch)
+}
+
+// openChannel puts a new channel in confirm mode, unroutable messages are logged
+func openChannel(conn *amqp.Connection) (*amqp.Channel, error) {
 	ch, err := conn.Channel()
 	if err != nil {
 		return nil, err
 	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+	go func() {
+		for ret := range returns {
+			log.Printf("[x] message for %s returned: %s", ret.RoutingKey, ret.ReplyText)
+		}
+	}()
+	return ch, nil
+}
 
-	// Ping to check server connection
-	if err := ch.ExchangeDeclarePassive("amq.direct", "direct", true, false, false, false, nil); err != nil {
-		return nil, fmt.Errorf("failed to connect RabbitMQ server: %w", err)
+// watch reconnects when the connection closes on its own, Close ends it
+func (r *Rabbitmq) watch(closed chan *amqp.Error) {
+	reason := <-closed
+	if reason == nil {
+		return
 	}
+	log.Printf("[x] RabbitMQ connection lost: %v, reconnecting", reason)
+	r.mu.Lock()
+	r.conn, r.pool = nil, nil
+	r.mu.Unlock()
 
-	log.Printf("Successfully Connected to RabbitMq server... :)")
-	return &Rabbitmq{
-		conn: conn,
-		ch:   ch,
-	}, nil
+	backoff := minBackoff
+	for {
+		time.Sleep(backoff)
+		r.mu.Lock()
+		closedByUs := r.closed
+		r.mu.Unlock()
+		if closedByUs {
+			return
+		}
+		if err := r.connect(); err != nil {
+			log.Printf("[x] RabbitMQ reconnect failed, next try in %s: %v", backoff, err)
+			backoff = min(2*backoff, maxBackoff)
+			continue
+		}
+		log.Printf("Reconnected to RabbitMq server")
+		return
+	}
 }
 
+// Publish sends msg and waits for the confirm. While the broker is away it fails with
+// ErrUnavailable and the caller (the outbox relay) keeps msg
+func (r *Rabbitmq) Publish(msg Message) error {
+	conn, pool := r.current()
+	if pool == nil {
+		return ErrUnavailable
+	}
+	err := send(conn, pool, msg)
+	if errors.Is(err, amqp.ErrClosed) {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
+
+// send publishes an event on a pooled channel
+func send(conn *amqp.Connection, pool chan *amqp.Channel, msg Message) error {
+	return sendTo(conn, pool, Exchange, msg.RoutingKey, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Type:         msg.RoutingKey,
+		Body:         msg.Body,
+	})
+}
+
+// sendTo publishes and waits for the confirm, a channel the broker closed is replaced
+func sendTo(conn *amqp.Connection, pool chan *amqp.Channel, exchange, key string, publishing amqp.Publishing) error {
+	ch := <-pool
+	if ch.IsClosed() {
+		fresh, err := openChannel(conn)
+		if err != nil {
+			pool <- ch
+			return err
+		}
+		ch = fresh
+	}
+	defer func() { pool <- ch }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), confirmTimeout)
+	defer cancel()
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx,
+		exchange, // exchange
+		key,      // routing key
+		true,     // mandatory
+		false,    // immediate
+		publishing)
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("no confirm for %s message within %s: %w", key, confirmTimeout, err)
+	}
+	if !acked {
+		return fmt.Errorf("broker rejected %s message", key)
+	}
+	return nil
+}
+
+// current is the live connection and its channel pool, both nil while reconnecting
+func (r *Rabbitmq) current() (*amqp.Connection, chan *amqp.Channel) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conn, r.pool
+}
+
+// Close stops reconnecting
+func (r *Rabbitmq) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	conn := r.conn
+	r.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
@@ -0,0 +1,58 @@
+package rabbitmq
+
+import (
+	"errors"
+	"testing"
+)
+
+// without a connection, as while reconnecting, publishing fails and the relay keeps the message
+func TestPublishDuringOutage(t *testing.T) {
+	r := &Rabbitmq{}
+	if err := r.Publish(Message{RoutingKey: "patient.record.created", Body: []byte(`{}`)}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Publish without a connection: %v, want ErrUnavailable", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTopicMatch(t *testing.T) {
+	cases := []struct {
+		binding, key string
+		match        bool
+	}{
+		{"#", "patient.profile.viewed", true},
+		{"patient.profile.*", "patient.profile.viewed", true},
+		{"patient.*", "patient.profile.viewed", false},
+		{"patient.#", "patient.profile.viewed", true},
+		{"#.viewed", "patient.records.viewed", true},
+		{"patient.record.created", "patient.records.viewed", false},
+		{"*.account.#", "hip.account", true},
+	}
+	for _, c := range cases {
+		if got := TopicMatch(c.binding, c.key); got != c.match {
+			t.Errorf("TopicMatch(%q, %q) = %v", c.binding, c.key, got)
+		}
+	}
+}
+
+// the in-process queue routes like the exchange
+func TestMemoryRoutesByBinding(t *testing.T) {
+	m := NewMemory()
+	if err := m.Publish(Message{RoutingKey: "patient.record.created", Body: []byte(`{"n":1}`)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, queue := range []string{"logs", "patient_records"} {
+		select {
+		case body := <-m.Consume(queue):
+			if string(body) != `{"n":1}` {
+				t.Errorf("%s got %s", queue, body)
+			}
+		default:
+			t.Errorf("nothing routed to %s", queue)
+		}
+	}
+	if n := len(m.Consume("appointment_update")); n != 0 {
+		t.Errorf("appointment_update got %d messages", n)
+	}
+}
@@ -0,0 +1,123 @@
+package rabbitmq
+
+import (
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Dead-lettered messages are read with basic.get and left unacked, closing the channel
+// puts back every one that wasn't replayed or discarded, in its place.
+
+// DeadLetter is a message that ran out of attempts or was poison
+type DeadLetter struct {
+	ID         string
+	Queue      string
+	RoutingKey string
+	Attempts   int
+	Error      string
+	FailedAt   string
+	Body       []byte
+}
+
+func deadLetter(queue string, d amqp.Delivery) DeadLetter {
+	letter := DeadLetter{ID: d.MessageId, Queue: queue, RoutingKey: d.Type, Attempts: attempts(d.Headers), Body: d.Body}
+	if key, ok := d.Headers[routingKeyHeader].(string); ok {
+		letter.RoutingKey = key
+	}
+	letter.Error, _ = d.Headers[errorHeader].(string)
+	letter.FailedAt, _ = d.Headers[failedAtHeader].(string)
+	return letter
+}
+
+// DeadLetters lists up to limit (0 for all) dead-lettered messages of queue, oldest first, they stay where they are
+func (r *Rabbitmq) DeadLetters(queue string, limit int) ([]DeadLetter, error) {
+	letters := []DeadLetter{}
+	err := r.eachDeadLetter(queue, func(d amqp.Delivery) (bool, error) {
+		letters = append(letters, deadLetter(queue, d))
+		return limit <= 0 || len(letters) < limit, nil
+	})
+	return letters, err
+}
+
+// ReplayDeadLetters sends the dead-lettered messages with the given IDs, or all of them without IDs,
+// back to queue with their attempts reset. It returns how many went back
+func (r *Rabbitmq) ReplayDeadLetters(queue string, ids ...string) (int, error) {
+	conn, pool := r.current()
+	if pool == nil {
+		return 0, ErrUnavailable
+	}
+	replayed := 0
+	err := r.eachDeadLetter(queue, func(d amqp.Delivery) (bool, error) {
+		if !selected(d.MessageId, ids) {
+			return true, nil
+		}
+		publishing := amqp.Publishing{
+			ContentType:  d.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    d.MessageId,
+			Type:         d.Type,
+			// only the event type is kept, the consumer starts over at attempt 1
+			Headers: amqp.Table{routingKeyHeader: deadLetter(queue, d).RoutingKey},
+			Body:    d.Body,
+		}
+		if err := sendTo(conn, pool, "", queue, publishing); err != nil {
+			return false, err
+		}
+		replayed++
+		return true, d.Ack(false)
+	})
+	return replayed, err
+}
+
+// DiscardDeadLetters drops the dead-lettered messages with the given IDs, or all of them without IDs
+func (r *Rabbitmq) DiscardDeadLetters(queue string, ids ...string) (int, error) {
+	discarded := 0
+	err := r.eachDeadLetter(queue, func(d amqp.Delivery) (bool, error) {
+		if !selected(d.MessageId, ids) {
+			return true, nil
+		}
+		discarded++
+		return true, d.Ack(false)
+	})
+	return discarded, err
+}
+
+func selected(id string, ids []string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, want := range ids {
+		if id == want {
+			return true
+		}
+	}
+	return false
+}
+
+// eachDeadLetter gets the messages of queue's dead-letter queue one by one until it is empty
+// or visit says to stop, the ones visit didn't ack go back when the channel closes
+func (r *Rabbitmq) eachDeadLetter(queue string, visit func(amqp.Delivery) (bool, error)) error {
+	if _, ok := retryPolicies[queue]; !ok {
+		return fmt.Errorf("queue %s has no dead-letter queue, one of %v", queue, ConsumedQueues())
+	}
+	conn, _ := r.current()
+	if conn == nil {
+		return ErrUnavailable
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	for {
+		d, ok, err := ch.Get(DeadQueue(queue), false)
+		if err != nil || !ok {
+			return err
+		}
+		more, err := visit(d)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package rabbitmq
+
+import (
+	"log"
+	"sync"
+)
+
+// Memory stands in for RabbitMQ when the server runs without it. Messages are routed to an
+// in-process buffer per bound queue, with the same names, bindings and bodies the broker uses.
+// Nothing survives a restart, and when a buffer is full the oldest message is dropped.
+
+const memoryQueueSize = 1000
+
+type Memory struct {
+	mu     sync.Mutex
+	queues map[string]chan []byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{queues: map[string]chan []byte{}}
+}
+
+// Consume returns the queue's messages, for in-process consumers and tests
+func (m *Memory) Consume(queue string) <-chan []byte {
+	return m.queue(queue)
+}
+
+func (m *Memory) queue(name string) chan []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q, ok := m.queues[name]
+	if !ok {
+		q = make(chan []byte, memoryQueueSize)
+		m.queues[name] = q
+	}
+	return q
+}
+
+// Publish is the outbox relay's publish, msg goes to every queue bound to its routing key
+func (m *Memory) Publish(msg Message) error {
+	for queue, keys := range bindings {
+		for _, key := range keys {
+			if TopicMatch(key, msg.RoutingKey) {
+				m.deliver(queue, msg.Body)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Memory) deliver(name string, body []byte) {
+	q := m.queue(name)
+	for {
+		select {
+		case q <- body:
+			return
+		default:
+		}
+		// nobody is consuming, make room
+		select {
+		case <-q:
+			log.Printf("[x] queue %s is full, dropped its oldest message", name)
+		default:
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// A consumed queue retries a message its handler failed on through delay queues, one per
+// backoff step: the message waits there for the step's TTL and expires back into the queue.
+// After MaxAttempts, or at once for a poison message, it goes to the dead-letter exchange,
+// into <queue>.dead, with the reason in its headers. The dead-letters subcommand lists,
+// replays and discards them from there.
+//
+// This server declares the queues and reads the dead letters, the consumers run elsewhere
+// and hand a failed message to Retry, which sends it on with the headers below.
+
+const DeadLetterExchange = "healthcare.dead"
+
+// ErrPoison marks a message no attempt can handle, wrap it and Retry dead-letters the message at once
+var ErrPoison = errors.New("poison message")
+
+// headers Retry sets when it sends a message on
+const (
+	attemptsHeader = "x-attempts"
+	errorHeader    = "x-last-error"
+	failedAtHeader = "x-failed-at"
+	// the routing key the event was published with, a retried message has the delay queue's
+	routingKeyHeader = "x-routing-key"
+)
+
+type RetryPolicy struct {
+	// deliveries before the message is dead-lettered, the first one included
+	MaxAttempts int
+	// wait before the first retry, doubled for every next one up to MaxDelay
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// queue name -> retry policy of the consumed queues
+var retryPolicies = map[string]RetryPolicy{
+	"patient_records":    {MaxAttempts: 5, Delay: 10 * time.Second, MaxDelay: 10 * time.Minute},
+	"appointment_update": {MaxAttempts: 8, Delay: 5 * time.Second, MaxDelay: 10 * time.Minute},
+}
+
+// ConsumedQueues lists the queues that have a retry policy and a dead-letter queue
+func ConsumedQueues() []string {
+	queues := make([]string, 0, len(retryPolicies))
+	for queue := range retryPolicies {
+		queues = append(queues, queue)
+	}
+	sort.Strings(queues)
+	return queues
+}
+
+// delay is the wait before retry n, the first retry is 1
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := p.Delay
+	for i := 1; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxDelay)
+}
+
+// delays lists the distinct waits the policy uses, one delay queue each
+func (p RetryPolicy) delays() []time.Duration {
+	var delays []time.Duration
+	for n := 1; n < p.MaxAttempts; n++ {
+		if d := p.delay(n); len(delays) == 0 || delays[len(delays)-1] != d {
+			delays = append(delays, d)
+		}
+	}
+	return delays
+}
+
+func DelayQueue(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", queue, delay)
+}
+
+func DeadQueue(queue string) string {
+	return queue + ".dead"
+}
+
+// declareRetries sets up the delay queues and the dead-letter queue of every consumed queue
+func declareRetries(ch *amqp.Channel) error {
+	err := ch.ExchangeDeclare(DeadLetterExchange, "direct", true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", DeadLetterExchange, err)
+	}
+	for queue, policy := range retryPolicies {
+		for _, delay := range policy.delays() {
+			name := DelayQueue(queue, delay)
+			_, err := ch.QueueDeclare(name, true, false, false, false, amqp.Table{
+				"x-message-ttl": delay.Milliseconds(),
+				// expired messages go back to the queue through the default exchange
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": queue,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to declare queue %s: %w", name, err)
+			}
+		}
+		if _, err := ch.QueueDeclare(DeadQueue(queue), true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", DeadQueue(queue), err)
+		}
+		if err := ch.QueueBind(DeadQueue(queue), queue, DeadLetterExchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind queue %s: %w", DeadQueue(queue), err)
+		}
+	}
+	return nil
+}
+
+// Retry sends d, which the handler of queue failed on with cause, to its next delay queue
+// or, after the last attempt or for ErrPoison, to the dead-letter exchange, and acks it.
+// When the send fails d is left unacked for the caller to nack
+func (r *Rabbitmq) Retry(queue string, d amqp.Delivery, cause error) error {
+	exchange, key, publishing, err := retryRoute(queue, d, cause, time.Now())
+	if err != nil {
+		return err
+	}
+	conn, pool := r.current()
+	if pool == nil {
+		return ErrUnavailable
+	}
+	if err := sendTo(conn, pool, exchange, key, publishing); err != nil {
+		return err
+	}
+	return d.Ack(false)
+}
+
+// retryRoute is where Retry sends d and what with
+func retryRoute(queue string, d amqp.Delivery, cause error, now time.Time) (exchange, key string, publishing amqp.Publishing, err error) {
+	policy, ok := retryPolicies[queue]
+	if !ok {
+		return "", "", publishing, fmt.Errorf("queue %s has no retry policy", queue)
+	}
+	routingKey, ok := d.Headers[routingKeyHeader].(string)
+	if !ok {
+		routingKey = d.RoutingKey
+	}
+	// this delivery was attempt n
+	n := attempts(d.Headers) + 1
+	publishing = amqp.Publishing{
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    d.MessageId,
+		Type:         d.Type,
+		Headers: amqp.Table{
+			attemptsHeader:   int32(n),
+			errorHeader:      cause.Error(),
+			failedAtHeader:   now.UTC().Format(time.RFC3339),
+			routingKeyHeader: routingKey,
+		},
+		Body: d.Body,
+	}
+	if n >= policy.MaxAttempts || errors.Is(cause, ErrPoison) {
+		return DeadLetterExchange, queue, publishing, nil
+	}
+	return "", DelayQueue(queue, policy.delay(n)), publishing, nil
+}
+
+// attempts reads the attempt count off a message, 0 when it was never retried
+func attempts(headers amqp.Table) int {
+	switch n := headers[attemptsHeader].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 6, Delay: 10 * time.Second, MaxDelay: time.Minute}
+	if got := fmt.Sprint(policy.delays()); got != "[10s 20s 40s 1m0s]" {
+		t.Errorf("delay queues %s", got)
+	}
+	for retry, want := range map[int]time.Duration{1: 10 * time.Second, 3: 40 * time.Second, 5: time.Minute} {
+		if delay := policy.delay(retry); delay != want {
+			t.Errorf("retry %d: delay %s, want %s", retry, delay, want)
+		}
+	}
+	if got := DelayQueue("patient_records", 10*time.Second); got != "patient_records.retry.10s" {
+		t.Errorf("delay queue %s", got)
+	}
+}
+
+func TestRetryRoute(t *testing.T) {
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	first := amqp.Delivery{MessageId: "7c1f", RoutingKey: "appointment.updated", Type: "appointment.updated", Body: []byte(`{}`)}
+	exchange, key, publishing, err := retryRoute("appointment_update", first, errors.New("timeout"), now)
+	if err != nil || exchange != "" || key != "appointment_update.retry.5s" {
+		t.Fatalf("first failure went to %q %q: %v", exchange, key, err)
+	}
+	if publishing.MessageId != "7c1f" || string(publishing.Body) != `{}` || publishing.DeliveryMode != amqp.Persistent {
+		t.Errorf("publishing %+v", publishing)
+	}
+	if attempts(publishing.Headers) != 1 || publishing.Headers[errorHeader] != "timeout" ||
+		publishing.Headers[failedAtHeader] != "2026-10-19T08:00:00Z" || publishing.Headers[routingKeyHeader] != "appointment.updated" {
+		t.Errorf("headers %v", publishing.Headers)
+	}
+
+	// back from the delay queue, the event type comes from the header
+	third := amqp.Delivery{RoutingKey: "appointment_update", Type: "appointment.updated",
+		Headers: amqp.Table{attemptsHeader: int32(2), routingKeyHeader: "appointment.updated"}}
+	_, key, publishing, _ = retryRoute("appointment_update", third, errors.New("timeout"), now)
+	if key != "appointment_update.retry.20s" || attempts(publishing.Headers) != 3 || publishing.Headers[routingKeyHeader] != "appointment.updated" {
+		t.Errorf("third failure went to %q with %v", key, publishing.Headers)
+	}
+
+	last := amqp.Delivery{Headers: amqp.Table{attemptsHeader: int32(4), routingKeyHeader: "patient.record.created"}}
+	if exchange, key, _, _ := retryRoute("patient_records", last, errors.New("timeout"), now); exchange != DeadLetterExchange || key != "patient_records" {
+		t.Errorf("last attempt went to %q %q", exchange, key)
+	}
+	poison := fmt.Errorf("%w: unexpected end of JSON input", ErrPoison)
+	exchange, key, publishing, _ = retryRoute("patient_records", amqp.Delivery{RoutingKey: "patient.record.created"}, poison, now)
+	if exchange != DeadLetterExchange || key != "patient_records" || publishing.Headers[errorHeader] != "poison message: unexpected end of JSON input" {
+		t.Errorf("poison message went to %q %q with %v", exchange, key, publishing.Headers)
+	}
+	if _, _, _, err := retryRoute("outbox", first, errors.New("timeout"), now); err == nil {
+		t.Error("a queue without a retry policy was retried")
+	}
+}
+
+func TestRetryUnavailable(t *testing.T) {
+	r := &Rabbitmq{}
+	if err := r.Retry("patient_records", amqp.Delivery{}, errors.New("timeout")); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("retry without a connection: %v", err)
+	}
+}
+
+func TestDeadLetterHeaders(t *testing.T) {
+	// as Retry sends it on after its second failure, from a delay queue
+	d := amqp.Delivery{MessageId: "7c1f", RoutingKey: "patient_records", Type: "patient.record.created", Body: []byte(`{`),
+		Headers: amqp.Table{attemptsHeader: int32(2), errorHeader: "poison message: unexpected end of JSON input",
+			failedAtHeader: "2026-10-19T08:00:00Z", routingKeyHeader: "patient.record.created"}}
+	letter := deadLetter("patient_records", d)
+	if letter.ID != "7c1f" || letter.Attempts != 2 || letter.RoutingKey != "patient.record.created" || letter.FailedAt == "" {
+		t.Errorf("dead letter %+v", letter)
+	}
+	if letter.Error != "poison message: unexpected end of JSON input" {
+		t.Errorf("error %q", letter.Error)
+	}
+	// without headers it was never retried
+	if letter := deadLetter("patient_records", amqp.Delivery{Type: "patient.record.created"}); letter.Attempts != 0 || letter.RoutingKey != "patient.record.created" {
+		t.Errorf("dead letter without headers %+v", letter)
+	}
+}
@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// IdempotentRequest is what an Idempotency-Key holds: the fingerprint of the first
+// request sent with it and, once that one finished, its response. Status stays 0
+// while the first request is still running.
+type IdempotentRequest struct {
+	Fingerprint string              `json:"fingerprint"`
+	Status      int                 `json:"status,omitempty"`
+	Header      map[string][]string `json:"header,omitempty"`
+	Body        []byte              `json:"body,omitempty"`
+}
+
+// ClaimIdempotencyKey stores req under key for ttl unless the key is taken (SETNX).
+// It returns nil when req got the key, otherwise what the key already holds.
+func (r *Redisconn) ClaimIdempotencyKey(key string, req *IdempotentRequest, ttl time.Duration) (*IdempotentRequest, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		claimed, err := r.conn.SetNX(r.ctx, key, data, ttl).Result()
+		if err != nil || claimed {
+			return nil, err
+		}
+		held, err := r.conn.Get(r.ctx, key).Bytes()
+		if err == redis.Nil {
+			// expired between the two calls, try to claim it again
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		existing := &IdempotentRequest{}
+		if err := json.Unmarshal(held, existing); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+}
+
+// SaveIdempotentResponse replaces the claim with the finished request, kept for ttl
+func (r *Redisconn) SaveIdempotentResponse(key string, req *IdempotentRequest, ttl time.Duration) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return r.conn.Set(r.ctx, key, data, ttl).Err()
+}
+
+// ReleaseIdempotencyKey drops the key so the next request with it runs again
+func (r *Redisconn) ReleaseIdempotencyKey(key string) error {
+	return r.conn.Del(r.ctx, key).Err()
+}
@@ -0,0 +1,165 @@
+package redis
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Live updates are what a HIP's open streams show as it happens, published to the channel
+// live:<healthcare_id>. A replica keeps one subscription and adds a HIP's channel while it has
+// a stream open for it, so an update reaches the streams on every replica whichever one published it.
+
+const liveChannelPrefix = "live:"
+
+// updates a subscriber may fall behind by, past it its channel is closed and the stream
+// resumes from the event log
+const liveBuffer = 64
+
+// LiveUpdate is one event for a HIP's streams
+type LiveUpdate struct {
+	// position in the event log, a stream resumes after it
+	ID   int64           `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// liveHub fans the updates of a HIP out to the subscribers in this process
+type liveHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan LiveUpdate]struct{}
+	// called with mu held when a HIP gets its first subscriber and loses its last, nil in Memory
+	watch   func(healthcareID string) error
+	unwatch func(healthcareID string)
+}
+
+func newLiveHub() *liveHub {
+	return &liveHub{subs: map[string]map[chan LiveUpdate]struct{}{}}
+}
+
+// subscribe returns the updates of healthcareID and the func that ends the subscription
+func (h *liveHub) subscribe(healthcareID string) (<-chan LiveUpdate, func(), error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs, ok := h.subs[healthcareID]
+	if !ok {
+		if h.watch != nil {
+			if err := h.watch(healthcareID); err != nil {
+				return nil, nil, err
+			}
+		}
+		subs = map[chan LiveUpdate]struct{}{}
+		h.subs[healthcareID] = subs
+	}
+	ch := make(chan LiveUpdate, liveBuffer)
+	subs[ch] = struct{}{}
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.drop(healthcareID, ch)
+		})
+	}
+	return ch, cancel, nil
+}
+
+// drop closes ch unless fanOut did already, mu is held
+func (h *liveHub) drop(healthcareID string, ch chan LiveUpdate) {
+	subs := h.subs[healthcareID]
+	if _, ok := subs[ch]; !ok {
+		return
+	}
+	delete(subs, ch)
+	close(ch)
+	if len(subs) == 0 {
+		delete(h.subs, healthcareID)
+		if h.unwatch != nil {
+			h.unwatch(healthcareID)
+		}
+	}
+}
+
+// fanOut hands update to every subscriber of healthcareID, one that fell behind is dropped
+func (h *liveHub) fanOut(healthcareID string, update LiveUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[healthcareID] {
+		select {
+		case ch <- update:
+		default:
+			h.drop(healthcareID, ch)
+		}
+	}
+}
+
+// closeAll ends every subscription
+func (h *liveHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for healthcareID, subs := range h.subs {
+		for ch := range subs {
+			h.drop(healthcareID, ch)
+		}
+	}
+}
+
+// PublishLive sends update to the streams of healthcareID on every replica
+func (r *Redisconn) PublishLive(healthcareID string, update LiveUpdate) error {
+	data, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	return r.conn.Publish(r.ctx, liveChannelPrefix+healthcareID, data).Err()
+}
+
+// SubscribeLive returns the updates for healthcareID's streams until cancel is called.
+// The channel is also closed when the subscriber falls behind
+func (r *Redisconn) SubscribeLive(healthcareID string) (<-chan LiveUpdate, func(), error) {
+	r.liveOnce.Do(r.startLive)
+	return r.live.subscribe(healthcareID)
+}
+
+// startLive opens the replica's subscription, go-redis resubscribes its channels after a reconnect
+func (r *Redisconn) startLive() {
+	r.pubsub = r.conn.Subscribe(r.ctx)
+	r.live.watch = func(healthcareID string) error {
+		return r.pubsub.Subscribe(r.ctx, liveChannelPrefix+healthcareID)
+	}
+	r.live.unwatch = func(healthcareID string) {
+		if err := r.pubsub.Unsubscribe(r.ctx, liveChannelPrefix+healthcareID); err != nil {
+			log.Printf("[x] failed to unsubscribe from live updates of %s: %v", healthcareID, err)
+		}
+	}
+	go func() {
+		for msg := range r.pubsub.Channel() {
+			update := LiveUpdate{}
+			if err := json.Unmarshal([]byte(msg.Payload), &update); err != nil {
+				log.Printf("[x] live update on %s is not json: %v", msg.Channel, err)
+				continue
+			}
+			r.live.fanOut(strings.TrimPrefix(msg.Channel, liveChannelPrefix), update)
+		}
+	}()
+}
+
+func (m *Memory) PublishLive(healthcareID string, update LiveUpdate) error {
+	m.live.fanOut(healthcareID, update)
+	return nil
+}
+
+func (m *Memory) SubscribeLive(healthcareID string) (<-chan LiveUpdate, func(), error) {
+	return m.live.subscribe(healthcareID)
+}
+
+// closeLive ends the replica's subscription and closes the channels of its streams
+func (r *Redisconn) closeLive() error {
+	// waits for a startLive that is running, and keeps a later one from starting
+	r.liveOnce.Do(func() {})
+	r.live.closeAll()
+	if r.pubsub == nil {
+		return nil
+	}
+	return r.pubsub.Close()
+}
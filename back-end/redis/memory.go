@@ -0,0 +1,179 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Memory does what Redisconn does for the rate limiter and the cache, inside the process.
+// It keeps the same keys, expiries and return values (a miss is redis.Nil) so handlers
+// can't tell the difference. Only for a single server, nothing is shared or persisted.
+
+type memoryEntry struct {
+	value   string
+	count   int64
+	expires time.Time
+}
+
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	now     func() time.Time
+
+	limit       int64
+	window      time.Duration
+	lastchecked time.Time
+
+	live *liveHub
+}
+
+func NewMemory(limit int64, window time.Duration) *Memory {
+	return &Memory{
+		entries: map[string]*memoryEntry{},
+		now:     time.Now,
+		limit:   limit,
+		window:  window,
+		live:    newLiveHub(),
+	}
+}
+
+// entry returns the live entry for key, expired ones are removed on the way
+func (m *Memory) entry(key string) *memoryEntry {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if !e.expires.IsZero() && !m.now().Before(e.expires) {
+		delete(m.entries, key)
+		return nil
+	}
+	return e
+}
+
+// incr is INCRBY, a new key starts at 0 without expiry
+func (m *Memory) incr(key string, by int64) *memoryEntry {
+	e := m.entry(key)
+	if e == nil {
+		e = &memoryEntry{}
+		m.entries[key] = e
+	}
+	e.count += by
+	return e
+}
+
+func (m *Memory) IsAllowed(healthcare_id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	window := m.incr(fmt.Sprintf("hip:rate_limit:%s", healthcare_id), 1)
+	// total requests per session, past 300 blocked until the quota is reset
+	if m.incr(fmt.Sprintf("hip:total_count:%s", healthcare_id), 1).count > 300 {
+		return false, nil
+	}
+	if window.count == 1 {
+		window.expires = m.now().Add(m.window)
+	}
+	if window.count > m.limit {
+		window.expires = m.now().Add(5 * time.Minute)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *Memory) IsAllowed_leaky_bucket(healthcare_id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := fmt.Sprintf("hip:leaky_bucket:%s", healthcare_id)
+	now := m.now()
+	allowedOutflow := int64(now.Sub(m.lastchecked).Seconds() * 50) // 50 req second
+	m.lastchecked = now
+
+	bucket := m.incr(key, -allowedOutflow)
+	if bucket.count >= m.limit {
+		return false, nil
+	}
+	count := bucket.count
+	bucket.count++
+	if count == 0 {
+		bucket.expires = now.Add(time.Minute)
+	}
+	return true, nil
+}
+
+func (m *Memory) Set(key string, value interface{}) error {
+	reqData, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to serialize data1")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = &memoryEntry{value: string(reqData), expires: m.now().Add(time.Hour)}
+	return nil
+}
+
+func (m *Memory) Get(key string) (interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entry(key)
+	if e == nil {
+		return nil, redis.Nil
+	}
+	ttl := time.Duration(-1)
+	if !e.expires.IsZero() {
+		ttl = e.expires.Sub(m.now())
+	}
+	// same shape as Redisconn.Get
+	return struct {
+		Value string        `json:"value"`
+		TTL   time.Duration `json:"ttl"`
+	}{
+		Value: e.value,
+		TTL:   ttl,
+	}, nil
+}
+
+func (m *Memory) ClaimIdempotencyKey(key string, req *IdempotentRequest, ttl time.Duration) (*IdempotentRequest, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.entry(key); e != nil {
+		existing := &IdempotentRequest{}
+		if err := json.Unmarshal([]byte(e.value), existing); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+	m.entries[key] = &memoryEntry{value: string(data), expires: m.now().Add(ttl)}
+	return nil, nil
+}
+
+func (m *Memory) SaveIdempotentResponse(key string, req *IdempotentRequest, ttl time.Duration) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = &memoryEntry{value: string(data), expires: m.now().Add(ttl)}
+	return nil
+}
+
+func (m *Memory) ReleaseIdempotencyKey(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *Memory) Close() error {
+	m.live.closeAll()
+	return nil
+}
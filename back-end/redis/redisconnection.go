@@ -3,6 +3,7 @@ package redis
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -17,6 +18,11 @@ type Redisconn struct {
 	window      time.Duration
 	lastchecked time.Time
 	refill      time.Duration
+
+	// live updates, the subscription is opened with the first stream (live.go)
+	live     *liveHub
+	liveOnce sync.Once
+	pubsub   *redis.PubSub
 }
 
 func Connect2Redis(addr string, limit int64, window time.Duration) (*Redisconn, error) {
@@ -35,5 +41,6 @@ func Connect2Redis(addr string, limit int64, window time.Duration) (*Redisconn,
 		conn:   client,
 		limit:  limit,
 		window: window,
+		live:   newLiveHub(),
 	}, nil
 }
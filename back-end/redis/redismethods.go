@@ -42,5 +42,8 @@ func (r *Redisconn) Get(key string) (interface{}, error) {
 }
 
 func (r *Redisconn) Close() error {
+	if err := r.closeLive(); err != nil {
+		return err
+	}
 	return r.conn.Close()
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	// appointment times are in APPOINTMENT_TIMEZONE, containers often have no zone database
+	_ "time/tzdata"
+
+	mod "vaibhavyadav-dev/healthcareServer/databases"
+	"vaibhavyadav-dev/healthcareServer/i18n"
+)
+
+// The store reminds patients of their appointments by email and text message (see
+// databases/reminders.go). A patient answers the text message with YES or NO and the reply code,
+// the SMS gateway posts the answer here and the appointment is confirmed or cancelled. The
+// gateway is not a HIP, it authenticates with SMS_INBOUND_TOKEN instead of a JWT.
+
+// inboundSMS is what the gateway posts, as JSON or as a form
+type inboundSMS struct {
+	From string `json:"from"`
+	Text string `json:"text"`
+}
+
+// ReceiveSMS applies a patient's answer to a reminder
+func (s *APIServer) ReceiveSMS(w http.ResponseWriter, r *http.Request) error {
+	sms := inboundSMS{}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		if err := r.ParseForm(); err != nil {
+			return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err)
+		}
+		sms.From, sms.Text = r.PostForm.Get("from"), r.PostForm.Get("text")
+	} else if err := json.NewDecoder(r.Body).Decode(&sms); err != nil {
+		return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err)
+	}
+	if strings.TrimSpace(sms.From) == "" {
+		p := newProblem(http.StatusUnprocessableEntity, i18n.ValidationFailed)
+		p.Errors = []FieldProblem{{Field: "from", Code: i18n.FieldRequired, args: []any{"from"}}}
+		return p
+	}
+	reply, err := s.store.AnswerReminder(sms.From, sms.Text)
+	switch {
+	case errors.Is(err, mod.ErrInvalidReply):
+		return newProblem(http.StatusUnprocessableEntity, i18n.InvalidReminderReply).withCause(err)
+	case errors.Is(err, mod.ErrReminderNotFound):
+		return newProblem(http.StatusNotFound, i18n.ReminderNotFound).withCause(err)
+	case err != nil:
+		return internalError(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"code":    i18n.ReminderReplyAccepted,
+		"message": msg(r, i18n.ReminderReplyAccepted),
+		"reply":   reply,
+	})
+}
+
+// withSMSGatewayAuth lets through the requests that carry SMS_INBOUND_TOKEN as their bearer token,
+// none while it is unset
+func withSMSGatewayAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			writeProblem(w, r, newProblem(http.StatusUnauthorized, i18n.AuthHeaderInvalid))
+			return
+		}
+		want := os.Getenv("SMS_INBOUND_TOKEN")
+		if want == "" || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(want)) != 1 {
+			writeProblem(w, r, newProblem(http.StatusUnauthorized, i18n.InvalidToken))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// reminderSchedule is the schedule the environment configures:
+//
+//	APPOINTMENT_REMINDERS   how long before an appointment reminders go out, 24h,2h by default, off for none
+//	APPOINTMENT_TIMEZONE    the zone appointment times are in, Africa/Addis_Ababa by default
+func reminderSchedule() (mod.ReminderSchedule, error) {
+	schedule := mod.ReminderSchedule{}
+	zone := os.Getenv("APPOINTMENT_TIMEZONE")
+	if zone == "" {
+		zone = "Africa/Addis_Ababa"
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return schedule, fmt.Errorf("APPOINTMENT_TIMEZONE must be a time zone: %w", err)
+	}
+	schedule.Location = loc
+
+	offsets := os.Getenv("APPOINTMENT_REMINDERS")
+	if offsets == "" {
+		offsets = "24h,2h"
+	}
+	if offsets == "off" {
+		return schedule, nil
+	}
+	for _, field := range strings.Split(offsets, ",") {
+		offset, err := time.ParseDuration(strings.TrimSpace(field))
+		if err != nil || offset < time.Minute {
+			return schedule, fmt.Errorf("APPOINTMENT_REMINDERS must list durations of a minute or more, like 24h,2h: %q", field)
+		}
+		schedule.Offsets = append(schedule.Offsets, offset)
+	}
+	return schedule, nil
+}
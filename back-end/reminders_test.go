@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"vaibhavyadav-dev/healthcareServer/i18n"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiveSMS(t *testing.T) {
+	gateway := map[string]string{"Authorization": "Bearer gateway-secret"}
+	withToken := func(t *testing.T, api *testAPI) { t.Setenv("SMS_INBOUND_TOKEN", "gateway-secret") }
+	runCases(t, []handlerCase{
+		{name: "no reminder to answer", method: "POST", path: v2 + "/sms/inbound", body: `{"from": "+251911234567", "text": "YES 1234"}`,
+			header: gateway, setup: withToken, status: http.StatusNotFound, code: i18n.ReminderNotFound},
+		{name: "neither yes nor no", method: "POST", path: v2 + "/sms/inbound", body: `{"from": "0911234567", "text": "who is this?"}`,
+			header: gateway, setup: withToken, status: http.StatusUnprocessableEntity, code: i18n.InvalidReminderReply},
+		{name: "posted as a form", method: "POST", path: v2 + "/sms/inbound", body: `from=0911234567&text=lakki`,
+			header: map[string]string{"Authorization": "Bearer gateway-secret", "Content-Type": "application/x-www-form-urlencoded"},
+			setup:  withToken, status: http.StatusNotFound, code: i18n.ReminderNotFound,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, 1, api.store.count("AnswerReminder"))
+			}},
+		{name: "token in the query", method: "POST", path: v2 + "/sms/inbound?access_token=gateway-secret", body: `{"from": "0911234567", "text": "no"}`,
+			header: map[string]string{"Authorization": ""}, setup: withToken, status: http.StatusNotFound, code: i18n.ReminderNotFound},
+		{name: "sender missing", method: "POST", path: v2 + "/sms/inbound", body: `{"text": "yes"}`,
+			header: gateway, setup: withToken, status: http.StatusUnprocessableEntity, code: i18n.ValidationFailed},
+		{name: "not json", method: "POST", path: v2 + "/sms/inbound", body: `yes`,
+			header: gateway, setup: withToken, status: http.StatusBadRequest, code: i18n.InvalidRequestBody},
+		{name: "a HIP's token", method: "POST", path: v2 + "/sms/inbound", body: `{"from": "0911234567", "text": "yes"}`,
+			setup: withToken, status: http.StatusUnauthorized, code: i18n.InvalidToken,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Zero(t, api.store.count("AnswerReminder"))
+			}},
+		{name: "gateway not configured", method: "POST", path: v2 + "/sms/inbound", body: `{"from": "0911234567", "text": "yes"}`,
+			header: gateway, setup: func(t *testing.T, api *testAPI) { t.Setenv("SMS_INBOUND_TOKEN", "") },
+			status: http.StatusUnauthorized, code: i18n.InvalidToken},
+		{name: "store unavailable", method: "POST", path: v2 + "/sms/inbound", body: `{"from": "0911234567", "text": "yes"}`,
+			header: gateway, setup: func(t *testing.T, api *testAPI) {
+				withToken(t, api)
+				api.store.failWith("AnswerReminder", errors.New("db down"))
+			},
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+	})
+}
+
+func TestReminderSchedule(t *testing.T) {
+	t.Setenv("APPOINTMENT_REMINDERS", "")
+	t.Setenv("APPOINTMENT_TIMEZONE", "")
+	schedule, err := reminderSchedule()
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{24 * time.Hour, 2 * time.Hour}, schedule.Offsets)
+	assert.Equal(t, "Africa/Addis_Ababa", schedule.Location.String())
+
+	t.Setenv("APPOINTMENT_REMINDERS", "48h, 90m")
+	schedule, err = reminderSchedule()
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{48 * time.Hour, 90 * time.Minute}, schedule.Offsets)
+
+	t.Setenv("APPOINTMENT_REMINDERS", "off")
+	schedule, err = reminderSchedule()
+	require.NoError(t, err)
+	assert.Empty(t, schedule.Offsets)
+
+	for _, bad := range []string{"tomorrow", "24h,-2h", "10s"} {
+		t.Setenv("APPOINTMENT_REMINDERS", bad)
+		_, err := reminderSchedule()
+		assert.Error(t, err, bad)
+	}
+	t.Setenv("APPOINTMENT_REMINDERS", "")
+	t.Setenv("APPOINTMENT_TIMEZONE", "Mars/Olympus")
+	_, err = reminderSchedule()
+	assert.Error(t, err)
+}
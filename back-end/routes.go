@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"vaibhavyadav-dev/healthcareServer/i18n"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/cors"
+
+	// for monitoring
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	v1Prefix = "/api/v1/healthcare"
+	v2Prefix = "/api/v2"
+)
+
+// v1 keeps working until v1Sunset, every v1 response says so and points at v2
+var (
+	v1Deprecated = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	v1Sunset     = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// Handler is every route behind CORS, what Run serves and what the tests call
+func (s *APIServer) Handler() http.Handler {
+	c := cors.New(cors.Options{
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "Accept-Language", "X-Change-Reason", "If-Match", "Last-Event-ID", idempotencyKeyHeader, requestIDHeader},
+		ExposedHeaders:   []string{"ETag", "Allow", "Deprecation", "Sunset", "Link", "Retry-After", idempotentReplayedHeader, requestIDHeader},
+		AllowCredentials: true,
+	})
+
+	// Wrap the router with CORS handler
+	return c.Handler(s.routes())
+}
+
+// routes registers every endpoint, each one needs an entry in apiOperations (openapi.go)
+func (s *APIServer) routes() *mux.Router {
+	router := mux.NewRouter()
+	// Add Prometheus middleware to all routes
+	router.Use(PrometheusMiddleware)
+	// X-Request-ID ties an error response to its log line
+	router.Use(withRequestID)
+	// picks am / om / en from Accept-Language for every message below
+	router.Use(i18n.Middleware)
+
+	// mux skips the middlewares above when no route matched, so the fallback wraps them itself.
+	// Inside a subrouter mux reports a wrong method as not found, both go through unrouted
+	router.NotFoundHandler = withMiddlewares(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, unrouted(w, r, router))
+	}))
+	router.MethodNotAllowedHandler = router.NotFoundHandler
+
+	router.Path("/metrics").Methods("GET").Handler(promhttp.Handler())
+	router.HandleFunc("/openapi.json", makeHTTPHandlerFunc(s.GetOpenAPI)).Methods("GET")
+	// what the events on the exchange look like, for consumers
+	router.HandleFunc(eventSchemasPath, makeHTTPHandlerFunc(s.GetEventSchemas)).Methods("GET")
+	router.HandleFunc(eventSchemasPath+"/{type}", makeHTTPHandlerFunc(s.GetEventSchema)).Methods("GET")
+
+	s.routesV1(router.PathPrefix(v1Prefix).Subrouter())
+	s.routesV2(router.PathPrefix(v2Prefix).Subrouter())
+	return router
+}
+
+// private is a handler behind the JWT and the HIP's rate limit, its writes take an Idempotency-Key
+func (s *APIServer) private(f apiFunc) http.HandlerFunc {
+	return withJWTAuth(s.RateLimiter(s.idempotent(makeHTTPHandlerFunc(f))))
+}
+
+// routesV1 is the original action style API, the resource comes from the query or the body
+func (s *APIServer) routesV1(v1 *mux.Router) {
+	v1.Use(deprecatedV1)
+
+	v1.HandleFunc("/auth/register", s.idempotent(makeHTTPHandlerFunc(s.SignUp))).Methods("POST")
+	v1.HandleFunc("/auth/login", makeHTTPHandlerFunc(s.LoginUser)).Methods("POST")
+
+	// address reference data is public, register page needs it before login
+	v1.HandleFunc("/address/regions", makeHTTPHandlerFunc(s.GetRegions)).Methods("GET")
+	v1.HandleFunc("/address/zones", makeHTTPHandlerFunc(s.GetZones)).Methods("GET")
+	v1.HandleFunc("/address/woredas", makeHTTPHandlerFunc(s.GetWoredas)).Methods("GET")
+	v1.HandleFunc("/address/kebeles", makeHTTPHandlerFunc(s.GetKebeles)).Methods("GET")
+	v1.HandleFunc("/address/review", s.private(s.GetAddressReviewQueue)).Methods("GET")
+
+	// this one will serve from postgres
+	v1.HandleFunc("/preferance/get", s.private(s.GetPreferance)).Methods("GET")
+	v1.HandleFunc("/preferance/change", s.private(s.Update_Preferance)).Methods("PATCH")
+	v1.HandleFunc("/delete/account", s.private(s.DeleteAccount)).Methods("DELETE")
+
+	// this is will server from mongodb
+	v1.HandleFunc("/appointments/get", s.private(s.GetAppointments)).Methods("GET")
+	v1.HandleFunc("/appointments/set", s.private(s.SetAppointments)).Methods("POST")
+	v1.HandleFunc("/details", s.private(s.GetHealthcare_details)).Methods("GET")
+
+	v1.HandleFunc("/client/records/create", s.private(s.CreatepatientRecords)).Methods("POST")
+	v1.HandleFunc("/client/records/fetch", s.private(s.GetPatientRecords)).Methods("GET")
+
+	v1.HandleFunc("/client/profile/create", s.private(s.Create_ClientProfile)).Methods("POST")
+	v1.HandleFunc("/client/profile/get", s.private(s.Get_clientProfile)).Methods("GET")
+	v1.HandleFunc("/client/profile/update", s.private(s.UpdateClientProfile)).Methods("PATCH")
+	v1.HandleFunc("/client/profile/search", s.private(s.SearchClientProfiles)).Methods("GET")
+
+	// profile history
+	v1.HandleFunc("/client/profile/versions", s.private(s.ListProfileVersions)).Methods("GET")
+	v1.HandleFunc("/client/profile/diff", s.private(s.DiffProfileVersions)).Methods("GET")
+	v1.HandleFunc("/client/profile/asof", s.private(s.GetClientProfileAsOf)).Methods("GET")
+
+	// duplicate review and merges
+	v1.HandleFunc("/client/duplicates", s.private(s.GetDuplicateQueue)).Methods("GET")
+	v1.HandleFunc("/client/duplicates/dismiss", s.private(s.DismissDuplicate)).Methods("POST")
+	v1.HandleFunc("/client/merge", s.private(s.MergeClientProfiles)).Methods("POST")
+	v1.HandleFunc("/client/unmerge", s.private(s.UnmergeClientProfiles)).Methods("POST")
+}
+
+// routesV2 serves the same handlers on resource paths, ids that v1 takes from
+// the query or the body are path variables (see pathOrQuery and pathID)
+func (s *APIServer) routesV2(v2 *mux.Router) {
+	v2.HandleFunc("/auth/register", s.idempotent(makeHTTPHandlerFunc(s.SignUp))).Methods("POST")
+	v2.HandleFunc("/auth/login", makeHTTPHandlerFunc(s.LoginUser)).Methods("POST")
+
+	v2.HandleFunc("/regions", makeHTTPHandlerFunc(s.GetRegions)).Methods("GET")
+	v2.HandleFunc("/regions/{region}/zones", makeHTTPHandlerFunc(s.GetZones)).Methods("GET")
+	v2.HandleFunc("/zones/{zone}/woredas", makeHTTPHandlerFunc(s.GetWoredas)).Methods("GET")
+	v2.HandleFunc("/woredas/{woreda}/kebeles", makeHTTPHandlerFunc(s.GetKebeles)).Methods("GET")
+	v2.HandleFunc("/address-review", s.private(s.GetAddressReviewQueue)).Methods("GET")
+
+	// the HIP behind the token
+	v2.HandleFunc("/healthcare", s.private(s.GetHealthcare_details)).Methods("GET")
+	v2.HandleFunc("/healthcare", s.private(s.DeleteAccount)).Methods("DELETE")
+	v2.HandleFunc("/healthcare/preferences", s.private(s.GetPreferance)).Methods("GET")
+	v2.HandleFunc("/healthcare/preferences", s.private(s.Update_Preferance)).Methods("PATCH")
+
+	v2.HandleFunc("/appointments", s.private(s.GetAppointments)).Methods("GET")
+	v2.HandleFunc("/appointments/{id:[0-9]+}", s.private(s.SetAppointments)).Methods("PATCH")
+
+	v2.HandleFunc("/patients", s.private(s.SearchClientProfiles)).Methods("GET")
+	v2.HandleFunc("/patients", s.private(s.Create_ClientProfile)).Methods("POST")
+	v2.HandleFunc("/patients/{healthID}", s.private(s.Get_clientProfile)).Methods("GET")
+	v2.HandleFunc("/patients/{healthID}", s.private(s.UpdateClientProfile)).Methods("PATCH")
+	v2.HandleFunc("/patients/{healthID}/records", s.private(s.GetPatientRecords)).Methods("GET")
+	v2.HandleFunc("/patients/{healthID}/records", s.private(s.CreatepatientRecords)).Methods("POST")
+	v2.HandleFunc("/patients/{healthID}/versions", s.private(s.ListProfileVersions)).Methods("GET")
+	v2.HandleFunc("/patients/{healthID}/diff", s.private(s.DiffProfileVersions)).Methods("GET")
+	v2.HandleFunc("/patients/{healthID}/asof", s.private(s.GetClientProfileAsOf)).Methods("GET")
+
+	v2.HandleFunc("/duplicates", s.private(s.GetDuplicateQueue)).Methods("GET")
+	v2.HandleFunc("/duplicates/{id:[0-9]+}/dismiss", s.private(s.DismissDuplicate)).Methods("POST")
+	v2.HandleFunc("/merges", s.private(s.MergeClientProfiles)).Methods("POST")
+	v2.HandleFunc("/merges/{id:[0-9]+}/unmerge", s.private(s.UnmergeClientProfiles)).Methods("POST")
+	// merge requests have no v1 route, v1 merges answer with a merge request too
+	v2.HandleFunc("/merge-requests", s.private(s.ListMergeRequests)).Methods("GET")
+	v2.HandleFunc("/merge-requests/{id:[0-9]+}/approve", s.private(s.ApproveMergeRequest)).Methods("POST")
+	v2.HandleFunc("/merge-requests/{id:[0-9]+}/reject", s.private(s.RejectMergeRequest)).Methods("POST")
+
+	// webhooks have no v1 route, see webhooks.go
+	v2.HandleFunc("/webhooks", s.private(s.ListWebhooks)).Methods("GET")
+	v2.HandleFunc("/webhooks", s.private(s.CreateWebhook)).Methods("POST")
+	v2.HandleFunc("/webhooks/{id:[0-9]+}", s.private(s.GetWebhook)).Methods("GET")
+	v2.HandleFunc("/webhooks/{id:[0-9]+}", s.private(s.UpdateWebhook)).Methods("PATCH")
+	v2.HandleFunc("/webhooks/{id:[0-9]+}", s.private(s.DeleteWebhook)).Methods("DELETE")
+	v2.HandleFunc("/webhooks/{id:[0-9]+}/deliveries", s.private(s.ListWebhookDeliveries)).Methods("GET")
+	v2.HandleFunc("/webhooks/{id:[0-9]+}/deliveries/{delivery_id:[0-9]+}", s.private(s.GetWebhookDelivery)).Methods("GET")
+	v2.HandleFunc("/webhooks/{id:[0-9]+}/deliveries/{delivery_id:[0-9]+}/redeliver", s.private(s.RedeliverWebhook)).Methods("POST")
+
+	// notifications have no v1 route, see notifications.go
+	v2.HandleFunc("/notifications", s.private(s.ListNotifications)).Methods("GET")
+	v2.HandleFunc("/notifications/{id:[0-9]+}", s.private(s.GetNotification)).Methods("GET")
+	v2.HandleFunc("/healthcare/notification-preferences", s.private(s.GetNotificationPreferences)).Methods("GET")
+	v2.HandleFunc("/healthcare/notification-preferences", s.private(s.SetNotificationPreferences)).Methods("PUT")
+	v2.HandleFunc("/patients/{healthID}/notification-preferences", s.private(s.GetNotificationPreferences)).Methods("GET")
+	v2.HandleFunc("/patients/{healthID}/notification-preferences", s.private(s.SetNotificationPreferences)).Methods("PUT")
+	// answers to appointment reminders from the SMS gateway, see reminders.go
+	v2.HandleFunc("/sms/inbound", tokenFromQuery(withSMSGatewayAuth(makeHTTPHandlerFunc(s.ReceiveSMS)))).Methods("POST")
+
+	// server-sent events for the dashboard, see stream.go
+	v2.HandleFunc("/stream", tokenFromQuery(s.private(s.StreamUpdates))).Methods("GET")
+}
+
+// deprecatedV1 marks v1 responses (RFC 9745 Deprecation, RFC 8594 Sunset)
+func deprecatedV1(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", v1Deprecated.Unix()))
+		w.Header().Set("Sunset", v1Sunset.Format(http.TimeFormat))
+		w.Header().Set("Link", "<"+v2Prefix+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withMiddlewares(h http.Handler) http.Handler {
+	return PrometheusMiddleware(withRequestID(i18n.Middleware(h)))
+}
+
+// unrouted is a 405 when another method is routed for the path, a 404 otherwise
+func unrouted(w http.ResponseWriter, r *http.Request, router *mux.Router) *Problem {
+	if allowed := allowedMethods(router, r); allowed != "" {
+		return methodNotAllowed(w, r, allowed)
+	}
+	return newProblem(http.StatusNotFound, i18n.RouteNotFound, r.URL.Path)
+}
+
+// allowedMethods lists the methods some route takes for r's path, for the Allow header of a 405
+func allowedMethods(router *mux.Router, r *http.Request) string {
+	var allowed []string
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		for _, method := range methods {
+			try := r.Clone(r.Context())
+			try.Method = method
+			if route.Match(try, &mux.RouteMatch{}) && !contains(allowed, method) {
+				allowed = append(allowed, method)
+			}
+		}
+		return nil
+	})
+	return strings.Join(allowed, ", ")
+}
+
+// pathOrQuery reads a v2 path variable, v1 sends the same value as a query parameter
+func pathOrQuery(r *http.Request, name string) string {
+	if value, ok := mux.Vars(r)[name]; ok {
+		return value
+	}
+	return r.URL.Query().Get(name)
+}
+
+// pathID is the numeric {name} of a v2 path, ok is false on v1 routes where the id is in the body
+func pathID(r *http.Request, name string) (id int64, ok bool, err error) {
+	value, ok := mux.Vars(r)[name]
+	if !ok {
+		return 0, false, nil
+	}
+	id, err = strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, true, newProblem(http.StatusBadRequest, i18n.InvalidPathParam, name)
+	}
+	return id, true, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"vaibhavyadav-dev/healthcareServer/i18n"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const v2 = "/api/v2"
+
+// the v2 routes reach the v1 handlers with the ids taken from the path
+func TestV2Routes(t *testing.T) {
+	duplicate := func(t *testing.T, api *testAPI) {
+		other := api.addPatient(t, api.hip.HealthcareID)
+		duplicates, err := api.store.LocalStore.FindDuplicates(other)
+		require.NoError(t, err)
+		require.Len(t, duplicates, 1)
+		api.vars["other_health_id"] = other.HealthID
+		api.vars["duplicate_id"] = jsonNumber(duplicates[0].ID)
+	}
+	runCases(t, []handlerCase{
+		{name: "zones of a region", method: "GET", path: v2 + "/regions/ET-AA/zones", header: map[string]string{"Authorization": ""}, status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.NotEmpty(t, body["zones"])
+			}},
+		{name: "woredas of unknown zone", method: "GET", path: v2 + "/zones/ET-XX-01/woredas", status: http.StatusNotFound, code: i18n.InvalidAddress},
+		{name: "healthcare", method: "GET", path: v2 + "/healthcare", status: http.StatusOK},
+		{name: "preferences", method: "PATCH", path: v2 + "/healthcare/preferences", body: `{"isAvailable": false}`,
+			status: http.StatusOK, code: i18n.PreferencesUpdated},
+
+		{name: "patient", method: "GET", path: v2 + "/patients/{health_id}", status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				profile := body["client_profile"].(map[string]interface{})
+				assert.Equal(t, api.vars["health_id"], profile["health_id"])
+				assert.NotEmpty(t, rec.Header().Get("ETag"))
+			}},
+		{name: "unknown patient", method: "GET", path: v2 + "/patients/HID-nobody", status: http.StatusNotFound, code: i18n.PatientNotFound},
+		{name: "patient updated", method: "PATCH", path: v2 + "/patients/{health_id}", body: `{"weight": "62"}`,
+			header: map[string]string{"If-Match": "*", "Content-Type": "application/merge-patch+json"}, status: http.StatusAccepted,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, "62", body["updated_details"].(map[string]interface{})["weight"])
+			}},
+		{name: "patients searched", method: "GET", path: v2 + "/patients?name=Almaz", status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, float64(1), body["fetched"])
+			}},
+		{name: "versions", method: "GET", path: v2 + "/patients/{health_id}/versions", status: http.StatusOK},
+
+		{name: "record for the patient in the path", method: "POST", path: v2 + "/patients/{health_id}/records",
+			body:   `{"issue": "fever", "description": "high fever for two days", "medical_severity": "High"}`,
+			status: http.StatusOK, code: i18n.RecordQueued,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				queuedRecord := queued(t, api, "patient_records")["data"].(map[string]interface{})
+				assert.Equal(t, api.vars["health_id"], queuedRecord["health_id"])
+			}},
+		{name: "records", method: "GET", path: v2 + "/patients/{health_id}/records", status: http.StatusOK},
+
+		{name: "appointment in the path", method: "PATCH", path: v2 + "/appointments/7", body: `{"health_id": "{health_id}", "status": "Confirmed"}`,
+			status: http.StatusOK, code: i18n.AppointmentUpdateQueued,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				update := queued(t, api, "appointment_update")["data"].(map[string]interface{})
+				assert.Equal(t, float64(7), update["appointment_id"])
+			}},
+		{name: "appointment id out of range", method: "PATCH", path: v2 + "/appointments/99999999999999999999",
+			body: `{"health_id": "{health_id}", "status": "Confirmed"}`, status: http.StatusBadRequest, code: i18n.InvalidPathParam},
+		{name: "appointment id is not a number", method: "PATCH", path: v2 + "/appointments/seven", status: http.StatusNotFound, code: i18n.RouteNotFound},
+
+		{name: "duplicate dismissed", method: "POST", path: v2 + "/duplicates/{duplicate_id}/dismiss", setup: duplicate,
+			status: http.StatusOK, code: i18n.DuplicateDismissed},
+		{name: "unknown merge", method: "POST", path: v2 + "/merges/99/unmerge", status: http.StatusNotFound, code: i18n.MergeNotFound},
+
+		{name: "unknown route", method: "GET", path: v2 + "/clinics", status: http.StatusNotFound, code: i18n.RouteNotFound},
+		{name: "wrong method", method: "DELETE", path: v2 + "/patients/{health_id}", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, "GET, PATCH", rec.Header().Get("Allow"))
+			}},
+	})
+}
+
+func TestV1Deprecated(t *testing.T) {
+	api := newTestAPI(t)
+
+	rec := api.do("GET", v1+"/address/regions", "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "@1792368000", rec.Header().Get("Deprecation"))
+	sunset, err := http.ParseTime(rec.Header().Get("Sunset"))
+	require.NoError(t, err)
+	assert.True(t, sunset.Equal(v1Sunset))
+	assert.True(t, sunset.After(time.Unix(1792368000, 0)))
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Link"), "</api/v2>"))
+
+	rec = api.do("GET", v2+"/regions", "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+	assert.Empty(t, rec.Header().Get("Sunset"))
+}
@@ -1,205 +1,852 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	mod "vaibhavyadav-dev/healthcareServer/databases"
+	"vaibhavyadav-dev/healthcareServer/i18n"
+
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	v1           = "/api/v1/healthcare"
+	testPassword = "secret123"
 )
 
-type Address struct {
-	Country  string `json:"country"`
-	Landmark string `json:"landmark"`
-	City     string `json:"city"`
-	State    string `json:"state"`
+var errStoreDown = errors.New("store is down")
+
+// testAPI is one HIP with one patient behind the real router
+type testAPI struct {
+	store   *memStore
+	handler http.Handler
+	hip     *mod.HIPInfo
+	token   string
+	// substituted for {name} in case paths, bodies and headers
+	vars map[string]string
 }
 
-type RegisterRequest struct {
-	Name            string  `json:"name"`
-	Availability    string  `json:"availability"`
-	TotalFacilities int     `json:"total_facilities"`
-	TotalMbbsDoc    int     `json:"total_mbbs_doc"`
-	TotalWorker     int     `json:"total_worker"`
-	NoOfBeds        int     `json:"no_of_beds"`
-	Email           string  `json:"email"`
-	AppointmentFee  int     `json:"appointment_fee"`
-	About           string  `json:"about"`
-	Password        string  `json:"password"`
-	Address         Address `json:"address"`
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	store := newMemStore(t)
+	api := &testAPI{store: store, handler: NewAPIServer(":0", store).Handler(), vars: map[string]string{}}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+	api.hip = &mod.HIPInfo{
+		HealthcareID: "HCID0000000000000001", HealthcareLicense: "LIC0000000000000001", HealthcareName: "Adama Hospital",
+		Email: "hip@adama.example", Availability: "24x7", TotalFacilities: 5, TotalMBBSDoc: 5, TotalWorker: 5, NoOfBeds: 5,
+		About: "general hospital", Password: string(hash), Address: testAddress(),
+	}
+	_, err = store.LocalStore.SignUpAccount(api.hip)
+	require.NoError(t, err)
+	api.token, err = createJWT(api.hip)
+	require.NoError(t, err)
+
+	api.vars["healthcare_id"] = api.hip.HealthcareID
+	api.vars["health_id"] = api.addPatient(t, api.hip.HealthcareID).HealthID
+	// the patient's appointments with the HIP
+	api.bookAppointment(t, 1, api.hip.HealthcareID, api.vars["health_id"])
+	api.bookAppointment(t, 7, api.hip.HealthcareID, api.vars["health_id"])
+	return api
 }
 
-type HealthcareDetails struct {
-	HealthcareID      string `json:"healthcare_id"`
-	HealthcareLicense string `json:"healthcare_license"`
+func testAddress() mod.Address {
+	return mod.Address{Region: "ET-AA", Zone: "ET-AA-01", Woreda: "ET-AA-01-01", Landmark: "near the bus station"}
 }
 
-type RegisterResponse struct {
-	Status            string            `json:"status"`
-	HealthcareDetails HealthcareDetails `json:"healthcare_details"`
+// addPatient registers a patient straight in the store, with the same details every time
+func (api *testAPI) addPatient(t *testing.T, healthcareID string) *mod.PatientDetails {
+	t.Helper()
+	patient, err := mod.Create_clientProfile(healthcareID, &mod.PatientDetails{
+		FirstName: "Almaz", MiddleName: "Kebede", LastName: "Tesfaye", Sex: "F", DOB: "1990-05-01", BloodGroup: "O+",
+		BMI: "22", MarriageStatus: "single", Weight: "60", Email: "almaz@example.com", MobileNumber: "0911234567",
+		AadhaarNumber: "N/A", PrimaryLocation: "Addis Ababa", Sibling: "1", Twin: "no", FatherName: "Kebede",
+		MotherName: "Almaz", EmergencyNumber: "0911000000", Address: testAddress(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, api.store.LocalStore.Create_ClientProfile(patient))
+	require.NoError(t, api.store.LocalStore.CreateClient_stats(patient.HealthID))
+	return patient
 }
 
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
-	var req RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+// bookAppointment adds appointment id of the patient with the HIP, two days from now
+func (api *testAPI) bookAppointment(t *testing.T, id int64, healthcareID, healthID string) {
+	t.Helper()
+	_, err := api.store.LocalStore.BookAppointment(&mod.Appointments{
+		ID: id, HealthcareID: healthcareID, HealthID: healthID, AppointmentDate: time.Now().UTC().AddDate(0, 0, 2).Format(time.RFC3339),
+		AppointmentTime: "09:30", Department: "Cardiology", FullName: "Almaz Tesfaye", Status: "Pending", HealthcareName: "Adama Hospital",
+	})
+	require.NoError(t, err)
+}
 
-	response := RegisterResponse{
-		Status: "Successfully Created",
-		HealthcareDetails: HealthcareDetails{
-			HealthcareID:      "HCID12345",
-			HealthcareLicense: "HCID123",
-		},
+func (api *testAPI) expand(s string) string {
+	for name, value := range api.vars {
+		s = strings.ReplaceAll(s, "{"+name+"}", value)
 	}
+	return s
+}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(response)
-}
-
-func TestRegisterEndpoint(t *testing.T) {
-	tests := []struct {
-		name             string
-		request          RegisterRequest
-		expectedStatus   int
-		validateResponse func(*testing.T, []byte)
-	}{
-		{
-			name: "Successful Registration",
-			request: RegisterRequest{
-				Name:            "Test Hospital",
-				Availability:    "Yes",
-				TotalFacilities: 200,
-				TotalMbbsDoc:    58,
-				TotalWorker:     400,
-				NoOfBeds:        200,
-				Email:           "test@hospital.com",
-				AppointmentFee:  300,
-				About:           "Test Hospital Description",
-				Password:        "11",
-				Address: Address{
-					Country:  "India",
-					Landmark: "Test Landmark",
-					City:     "Test City",
-					State:    "Test State",
-				},
-			},
-			expectedStatus: http.StatusCreated,
-			validateResponse: func(t *testing.T, body []byte) {
-				var response RegisterResponse
-				err := json.Unmarshal(body, &response)
-				fmt.Println("RESPONSE***********", response)
-				assert.NoError(t, err)
-				assert.Equal(t, "Successfully Created", response.Status)
-				assert.NotEmpty(t, response.HealthcareDetails.HealthcareID)
-				assert.NotEmpty(t, response.HealthcareDetails.HealthcareLicense)
-			},
-		},
+// do sends the request with the HIP's token unless header sets Authorization itself
+func (api *testAPI) do(method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, api.expand(path), strings.NewReader(api.expand(body)))
+	req.Header.Set("Content-Type", "application/json")
+	if _, ok := header["Authorization"]; !ok {
+		req.Header.Set("Authorization", "Bearer "+api.token)
 	}
+	for name, value := range header {
+		req.Header.Set(name, api.expand(value))
+	}
+	rec := httptest.NewRecorder()
+	api.handler.ServeHTTP(rec, req)
+	return rec
+}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			jsonBody, _ := json.Marshal(tt.request)
-			req := httptest.NewRequest("POST", "http://localhost:3000/api/v1/healthcareauth/register", bytes.NewBuffer(jsonBody))
-			req.Header.Set("Content-Type", "application/json")
+type handlerCase struct {
+	name   string
+	method string
+	path   string
+	body   string
+	header map[string]string
+	setup  func(t *testing.T, api *testAPI)
+	status int
+	// expected "code" of the response, not checked when empty
+	code  i18n.Code
+	check func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{})
+}
 
-			w := httptest.NewRecorder()
-			RegisterHandler(w, req) // Call the handler function
+// runCases gives every case its own store, nothing one case does is seen by another
+func runCases(t *testing.T, cases []handlerCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			if tc.setup != nil {
+				tc.setup(t, api)
+			}
+			rec := api.do(tc.method, tc.path, tc.body, tc.header)
+			require.Equal(t, tc.status, rec.Code, rec.Body.String())
 
-			assert.Equal(t, tt.expectedStatus, w.Code)
-			if tt.validateResponse != nil {
-				tt.validateResponse(t, w.Body.Bytes())
+			// a 204 never carries a body to the client
+			body := map[string]interface{}{}
+			if rec.Code != http.StatusNoContent && rec.Body.Len() > 0 {
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body), rec.Body.String())
+			}
+			if tc.code != "" {
+				assert.Equal(t, string(tc.code), body["code"], rec.Body.String())
+			}
+			if rec.Code >= http.StatusBadRequest {
+				assertProblem(t, rec, body)
+			}
+			if tc.check != nil {
+				tc.check(t, api, rec, body)
 			}
 		})
 	}
 }
 
-type LoginRequest struct {
-	HealthcareID      string `json:"healthcare_id"`
-	HealthcareLicense string `json:"healthcare_license"`
-	Password          string `json:"password"`
+// assertProblem checks an error response is a problem details body tied to its request ID
+func assertProblem(t *testing.T, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+	t.Helper()
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, float64(rec.Code), body["status"], rec.Body.String())
+	assert.Equal(t, http.StatusText(rec.Code), body["title"])
+	assert.NotEmpty(t, body["type"])
+	assert.NotEmpty(t, body["detail"])
+	assert.NotEmpty(t, body["code"])
+	assert.NotEmpty(t, body["request_id"])
+	assert.Equal(t, rec.Header().Get("X-Request-ID"), body["request_id"])
 }
 
-type LoginResponse struct {
-	Token          string `json:"token"`
-	ExpiresIn      string `json:"Expires In"`
-	HealthcareId   string `json:"healthcare_id"`
-	HealthcareName string `json:"healthcare_name"`
+// queued returns the next message on an in-process queue, decoded. Messages
+// reach the queue through the outbox relay, so it waits a little for them
+func queued(t *testing.T, api *testAPI, queue string) map[string]interface{} {
+	t.Helper()
+	select {
+	case raw := <-api.store.Queue().Consume(queue):
+		message := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(raw, &message))
+		return message
+	case <-time.After(2 * time.Second):
+		t.Fatalf("nothing published to %s", queue)
+		return nil
+	}
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+func signToken(t *testing.T, key string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+	require.NoError(t, err)
+	return "Bearer " + token
+}
+
+func TestAuthentication(t *testing.T) {
+	runCases(t, []handlerCase{
+		{name: "valid token", method: "GET", path: v1 + "/details", status: http.StatusOK},
+		{name: "no authorization header", method: "GET", path: v1 + "/details", header: map[string]string{"Authorization": ""},
+			status: http.StatusUnauthorized, code: i18n.AuthHeaderInvalid},
+		{name: "not a bearer token", method: "GET", path: v1 + "/details", header: map[string]string{"Authorization": "Token abc"},
+			status: http.StatusUnauthorized, code: i18n.AuthHeaderInvalid},
+		{name: "malformed token", method: "GET", path: v1 + "/details", header: map[string]string{"Authorization": "Bearer abc.def.ghi"},
+			status: http.StatusUnauthorized, code: i18n.InvalidToken},
+		{name: "signed with another key", method: "GET", path: v1 + "/details",
+			setup: func(t *testing.T, api *testAPI) {
+				api.vars["token"] = signToken(t, "not-the-key", jwt.MapClaims{"healthcareID": "HCID1", "healthcare_email": "a@b.co", "healthcare_name": "Adama Hospital"})
+			},
+			header: map[string]string{"Authorization": "{token}"}, status: http.StatusUnauthorized, code: i18n.InvalidToken},
+		{name: "token without healthcare_name", method: "GET", path: v1 + "/details",
+			setup: func(t *testing.T, api *testAPI) {
+				api.vars["token"] = signToken(t, "PASSWORD", jwt.MapClaims{"healthcareID": "HCID1", "healthcare_email": "a@b.co"})
+			},
+			header: map[string]string{"Authorization": "{token}"}, status: http.StatusUnauthorized, code: i18n.TokenMissingClaim},
+		{name: "address data is public", method: "GET", path: v1 + "/address/regions", header: map[string]string{"Authorization": ""},
+			status: http.StatusOK},
+	})
+}
+
+func TestSignUp(t *testing.T) {
+	register := `{"name": "Hawassa Clinic", "email": "clinic@hawassa.example", "availability": "weekdays",
+		"total_facilities": 6, "total_mbbs_doc": 6, "total_worker": 6, "no_of_beds": 6, "about": "maternity clinic",
+		"password": "pass123", "address": {"region": "ET-AA", "zone": "ET-AA-01", "woreda": "ET-AA-01-01", "landmark": "main road"}}`
+	runCases(t, []handlerCase{
+		{name: "created", method: "POST", path: v1 + "/auth/register", body: register, status: http.StatusCreated, code: i18n.HIPCreated,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				details := body["Healthcare_details"].(map[string]interface{})
+				hip, err := api.store.LoginUser(&mod.Login{HealthcareID: details["healthcare_id"].(string)})
+				require.NoError(t, err)
+				assert.Equal(t, "clinic@hawassa.example", hip.Email)
+				assert.Equal(t, "hip.account.created", queued(t, api, "logs")["type"])
+			}},
+		{name: "invalid address", method: "POST", path: v1 + "/auth/register",
+			body:   strings.Replace(register, `"zone": "ET-AA-01"`, `"zone": "ET-OR-01"`, 1),
+			status: http.StatusUnprocessableEntity, code: i18n.InvalidAddress},
+		{name: "legacy free-text address", method: "POST", path: v1 + "/auth/register",
+			body: strings.Replace(register, `"region": "ET-AA", "zone": "ET-AA-01", "woreda": "ET-AA-01-01"`,
+				`"country": "Ethiopia", "state": "Sidama", "city": "Hawassa"`, 1),
+			status: http.StatusCreated, code: i18n.HIPCreated,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				details := body["Healthcare_details"].(map[string]interface{})
+				hip, err := api.store.LocalStore.GetHealthcare_details_postgres(details["healthcare_id"].(string))
+				require.NoError(t, err)
+				assert.True(t, hip.Address.NeedsReview)
+				assert.Equal(t, "Hawassa", hip.Address.City)
+			}},
+		{name: "email already registered", method: "POST", path: v1 + "/auth/register",
+			body:   strings.Replace(register, "clinic@hawassa.example", "hip@adama.example", 1),
+			status: http.StatusConflict, code: i18n.HIPAlreadyExists},
+		{name: "body is not json", method: "POST", path: v1 + "/auth/register", body: "{", status: http.StatusBadRequest, code: i18n.InvalidRequestBody},
+		{name: "wrong method", method: "GET", path: v1 + "/auth/register", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+	})
+}
 
-	if req.HealthcareID == "HCID123" && req.HealthcareLicense == "HCID123" && req.Password == "11" {
-		response := LoginResponse{
-			Token:     "tokengoeshere",
-			ExpiresIn: "5d",
+func TestLogin(t *testing.T) {
+	login := `{"healthcare_id": "{healthcare_id}", "healthcare_license": "LIC0000000000000001", "password": "` + testPassword + `"}`
+	runCases(t, []handlerCase{
+		{name: "logged in", method: "POST", path: v1 + "/auth/login", body: login, status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, "5d", body["Expires In"])
+				token, err := validateJWT(body["token"].(string))
+				require.NoError(t, err)
+				assert.Equal(t, api.hip.HealthcareID, token.Claims.(jwt.MapClaims)["healthcareID"])
+				assert.Equal(t, "hip.account.logged_in", queued(t, api, "logs")["type"])
+			}},
+		{name: "wrong password", method: "POST", path: v1 + "/auth/login", body: strings.Replace(login, testPassword, "wrong", 1),
+			status: http.StatusUnauthorized, code: i18n.PasswordMismatch},
+		{name: "unknown healthcare", method: "POST", path: v1 + "/auth/login", body: strings.Replace(login, "{healthcare_id}", "HCID-nobody", 1),
+			status: http.StatusUnauthorized, code: i18n.HIPNotFound},
+		{name: "session quota used up", method: "POST", path: v1 + "/auth/login", body: login,
+			setup:  func(t *testing.T, api *testAPI) { api.store.denyFixedWindow = true },
+			status: http.StatusTooManyRequests, code: i18n.QuotaExhausted},
+		{name: "limiter unavailable", method: "POST", path: v1 + "/auth/login", body: login,
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("IsAllowed", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "request count unavailable", method: "POST", path: v1 + "/auth/login", body: login,
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("GetTotalRequestCount", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "body is not json", method: "POST", path: v1 + "/auth/login", body: "[", status: http.StatusBadRequest, code: i18n.InvalidRequestBody},
+		{name: "wrong method", method: "GET", path: v1 + "/auth/login", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	runCases(t, []handlerCase{
+		{name: "allowed", method: "GET", path: v1 + "/preferance/get", status: http.StatusOK},
+		{name: "fixed window exceeded", method: "GET", path: v1 + "/preferance/get",
+			setup:  func(t *testing.T, api *testAPI) { api.store.denyFixedWindow = true },
+			status: http.StatusTooManyRequests, code: i18n.RateLimited,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Zero(t, api.store.count("GetPreferance"), "blocked request reached the handler")
+			}},
+		{name: "leaky bucket full", method: "GET", path: v1 + "/preferance/get",
+			setup:  func(t *testing.T, api *testAPI) { api.store.denyLeakyBucket = true },
+			status: http.StatusTooManyRequests, code: i18n.RateLimitedSuspended},
+		{name: "fixed window unavailable", method: "GET", path: v1 + "/preferance/get",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("IsAllowed", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "leaky bucket unavailable", method: "GET", path: v1 + "/preferance/get",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("IsAllowed_leaky_bucket", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+	})
+
+	t.Run("window of 30 requests", func(t *testing.T) {
+		api := newTestAPI(t)
+		for i := 0; i < 30; i++ {
+			require.Equal(t, http.StatusOK, api.do("GET", v1+"/preferance/get", "", nil).Code, "request %d", i+1)
 		}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(response)
-	} else {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		assert.Equal(t, http.StatusTooManyRequests, api.do("GET", v1+"/preferance/get", "", nil).Code)
+	})
+}
+
+func TestGetPreferance(t *testing.T) {
+	prime := func(t *testing.T, api *testAPI) {
+		require.Equal(t, http.StatusOK, api.do("GET", v1+"/preferance/get", "", nil).Code)
+	}
+	runCases(t, []handlerCase{
+		{name: "cache miss reads the store and fills the cache", method: "GET", path: v1 + "/preferance/get", status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, 1, api.store.count("GetPreferance"))
+				assert.Equal(t, 1, api.store.count("Set"))
+				assert.NotContains(t, body, "refreshIn(seconds)")
+				assert.Equal(t, "hip@adama.example", body["preferance"].(map[string]interface{})["email"])
+				_, err := api.store.LocalStore.Get("hip:pref:" + api.hip.HealthcareID)
+				assert.NoError(t, err)
+			}},
+		{name: "cache hit does not touch the store", method: "GET", path: v1 + "/preferance/get", setup: prime, status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, 1, api.store.count("GetPreferance"))
+				assert.Equal(t, 1, api.store.count("Set"))
+				assert.Greater(t, body["refreshIn(seconds)"], float64(0))
+				assert.Equal(t, "hip@adama.example", body["preferance"].(map[string]interface{})["email"])
+			}},
+		{name: "cache=false skips the cache", method: "GET", path: v1 + "/preferance/get?cache=false", setup: prime, status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, 2, api.store.count("GetPreferance"))
+				assert.Equal(t, 1, api.store.count("Get"))
+			}},
+		{name: "cache unavailable", method: "GET", path: v1 + "/preferance/get",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("Get", errStoreDown) },
+			status: http.StatusInternalServerError},
+		{name: "store unavailable", method: "GET", path: v1 + "/preferance/get",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("GetPreferance", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "cache write fails", method: "GET", path: v1 + "/preferance/get",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("Set", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "wrong method", method: "POST", path: v1 + "/preferance/get", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+	})
+}
+
+func TestGetHealthcareDetails(t *testing.T) {
+	prime := func(t *testing.T, api *testAPI) {
+		require.Equal(t, http.StatusOK, api.do("GET", v1+"/details", "", nil).Code)
 	}
+	runCases(t, []handlerCase{
+		{name: "cache miss reads the store and fills the cache", method: "GET", path: v1 + "/details", status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, 1, api.store.count("GetHealthcare_details_postgres"))
+				assert.Equal(t, 1, api.store.count("Set"))
+				assert.Equal(t, "Adama Hospital", body["healthcare"].(map[string]interface{})["name"])
+			}},
+		{name: "cache hit does not touch the store", method: "GET", path: v1 + "/details", setup: prime, status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, 1, api.store.count("GetHealthcare_details_postgres"))
+				assert.Greater(t, body["refreshIn(seconds)"], float64(0))
+				// cached details have always been sent under "preferance"
+				assert.Equal(t, "Adama Hospital", body["preferance"].(map[string]interface{})["name"])
+			}},
+		{name: "cache=false skips the cache", method: "GET", path: v1 + "/details?cache=false", setup: prime, status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, 2, api.store.count("GetHealthcare_details_postgres"))
+				assert.Contains(t, body, "healthcare")
+			}},
+		{name: "cache unavailable", method: "GET", path: v1 + "/details",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("Get", errStoreDown) },
+			status: http.StatusInternalServerError},
+		{name: "healthcare not found", method: "GET", path: v1 + "/details",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("GetHealthcare_details_postgres", errStoreDown) },
+			status: http.StatusNotFound, code: i18n.HIPNotFound},
+		{name: "cache write fails", method: "GET", path: v1 + "/details",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("Set", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "wrong method", method: "PUT", path: v1 + "/details", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+	})
 }
 
-func TestLoginEndpoint(t *testing.T) {
-	tests := []struct {
-		name             string
-		request          LoginRequest
-		expectedStatus   int
-		validateResponse func(*testing.T, []byte)
-	}{
-		{
-			name: "Successful Login",
-			request: LoginRequest{
-				HealthcareID:      "HCID123",
-				HealthcareLicense: "LICENSE123",
-				Password:          "11",
+func TestUpdatePreferance(t *testing.T) {
+	runCases(t, []handlerCase{
+		{name: "updated", method: "PATCH", path: v1 + "/preferance/change", body: `{"email": "desk@adama.example", "isAvailable": false}`,
+			status: http.StatusOK, code: i18n.PreferencesUpdated,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				pref, err := api.store.LocalStore.GetPreferance(api.hip.HealthcareID)
+				require.NoError(t, err)
+				assert.Equal(t, "desk@adama.example", pref.Email)
+				assert.False(t, pref.IsAvailable)
+			}},
+		{name: "invalid email", method: "PATCH", path: v1 + "/preferance/change", body: `{"email": "desk"}`,
+			status: http.StatusUnprocessableEntity, code: i18n.ValidationFailed,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				field := body["errors"].([]interface{})[0].(map[string]interface{})
+				assert.Equal(t, "email", field["field"])
+				assert.Equal(t, string(i18n.FieldEmail), field["code"])
+			}},
+		{name: "only unknown fields", method: "PATCH", path: v1 + "/preferance/change", body: `{"appointmentFee": 10}`,
+			status: http.StatusBadRequest, code: i18n.NoFieldsToUpdate},
+		{name: "body is not json", method: "PATCH", path: v1 + "/preferance/change", body: "{", status: http.StatusBadRequest, code: i18n.InvalidRequestBody},
+		{name: "store unavailable", method: "PATCH", path: v1 + "/preferance/change", body: `{"isAvailable": true}`,
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("ChangePreferance", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "wrong method", method: "POST", path: v1 + "/preferance/change", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+	})
+}
+
+func TestDeleteAccount(t *testing.T) {
+	runCases(t, []handlerCase{
+		{name: "deletion scheduled", method: "DELETE", path: v1 + "/delete/account", status: http.StatusOK, code: i18n.AccountDeletionScheduled,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				pref, err := api.store.LocalStore.GetPreferance(api.hip.HealthcareID)
+				require.NoError(t, err)
+				assert.True(t, pref.Scheduled_deletion)
+				assert.Equal(t, "hip.account.deletion_scheduled", queued(t, api, "logs")["type"])
+			}},
+		{name: "store unavailable", method: "DELETE", path: v1 + "/delete/account",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("ChangePreferance", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "wrong method", method: "POST", path: v1 + "/delete/account", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+	})
+}
+
+func TestAppointments(t *testing.T) {
+	set := `{"id": 1, "health_id": "{health_id}", "status": "Confirmed"}`
+	runCases(t, []handlerCase{
+		{name: "booked with the HIP", method: "GET", path: v1 + "/appointments/get", status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Len(t, body["appointments"], 2)
+				assert.Equal(t, float64(2), body["fetched"])
+			}},
+		{name: "limit is not a number", method: "GET", path: v1 + "/appointments/get?limit=ten", status: http.StatusBadRequest, code: i18n.InvalidQueryParam},
+		{name: "store unavailable", method: "GET", path: v1 + "/appointments/get",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("GetAppointments_postgres", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "get with wrong method", method: "POST", path: v1 + "/appointments/get", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+
+		{name: "status update queued", method: "POST", path: v1 + "/appointments/set", body: set, status: http.StatusOK, code: i18n.AppointmentUpdateQueued,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				update := queued(t, api, "appointment_update")["data"].(map[string]interface{})
+				assert.Equal(t, "Confirmed", update["status"])
+				assert.Equal(t, api.hip.HealthcareID, update["healthcare_id"])
+			}},
+		{name: "another HIP in the body", method: "POST", path: v1 + "/appointments/set",
+			body:   `{"id": 1, "health_id": "{health_id}", "healthcare_id": "HCID0000000000000002", "status": "Confirmed"}`,
+			status: http.StatusOK, code: i18n.AppointmentUpdateQueued,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				update := queued(t, api, "appointment_update")["data"].(map[string]interface{})
+				assert.Equal(t, api.hip.HealthcareID, update["healthcare_id"])
+			}},
+		{name: "another HIP's appointment", method: "POST", path: v1 + "/appointments/set", body: strings.Replace(set, `"id": 1`, `"id": 2`, 1),
+			setup:  func(t *testing.T, api *testAPI) { api.bookAppointment(t, 2, "HCID0000000000000002", api.vars["health_id"]) },
+			status: http.StatusNotFound, code: i18n.AppointmentNotFound,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Zero(t, api.store.count("Enqueue"))
+			}},
+		{name: "another patient's appointment", method: "POST", path: v1 + "/appointments/set", body: strings.Replace(set, `"id": 1`, `"id": 2`, 1),
+			setup: func(t *testing.T, api *testAPI) {
+				api.bookAppointment(t, 2, api.hip.HealthcareID, api.addPatient(t, api.hip.HealthcareID).HealthID)
 			},
-			expectedStatus: http.StatusOK,
-			validateResponse: func(t *testing.T, body []byte) {
-				var response LoginResponse
-				err := json.Unmarshal(body, &response)
-				assert.NoError(t, err)
-				assert.NotEmpty(t, response.Token)
-				assert.Equal(t, "5d", response.ExpiresIn)
+			status: http.StatusNotFound, code: i18n.AppointmentNotFound},
+		{name: "no such appointment", method: "POST", path: v1 + "/appointments/set", body: strings.Replace(set, `"id": 1`, `"id": 99`, 1),
+			status: http.StatusNotFound, code: i18n.AppointmentNotFound},
+		{name: "appointment lookup unavailable", method: "POST", path: v1 + "/appointments/set", body: set,
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("CheckAppointment", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "cancelled", method: "PATCH", path: v2 + "/appointments/7", body: `{"health_id": "{health_id}", "status": "Cancelled"}`,
+			status: http.StatusOK, code: i18n.AppointmentUpdateQueued,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, "Cancelled", queued(t, api, "appointment_update")["data"].(map[string]interface{})["status"])
+			}},
+		{name: "not available", method: "POST", path: v1 + "/appointments/set", body: strings.Replace(set, "Confirmed", "Not Available", 1),
+			status: http.StatusOK, code: i18n.AppointmentUpdateQueued},
+		{name: "unknown status", method: "POST", path: v1 + "/appointments/set", body: strings.Replace(set, "Confirmed", "Done", 1),
+			status: http.StatusUnprocessableEntity, code: i18n.InvalidAppointmentStatus},
+		{name: "health_id too short", method: "POST", path: v1 + "/appointments/set", body: strings.Replace(set, "{health_id}", "HID1", 1),
+			status: http.StatusUnprocessableEntity, code: i18n.ValidationFailed},
+		{name: "appointment id missing", method: "POST", path: v1 + "/appointments/set", body: strings.Replace(set, `"id": 1, `, "", 1),
+			status: http.StatusUnprocessableEntity, code: i18n.ValidationFailed},
+		{name: "queue unavailable", method: "POST", path: v1 + "/appointments/set", body: set,
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("Enqueue", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "set with wrong method", method: "GET", path: v1 + "/appointments/set", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+	})
+}
+
+func TestClientProfile(t *testing.T) {
+	create := `{"fname": "Almaz", "middlename": "Kebede", "lname": "Tesfaye", "sex": "F", "dob": "1990-05-01", "bloodgrp": "O+",
+		"bmi": "22", "marriage_status": "single", "weight": "60", "email": "almaz@example.com", "mobilenumber": "0911234567",
+		"aadhar_number": "N/A", "primary_location": "Addis Ababa", "sibling": "1", "twin": "no", "fathername": "Kebede",
+		"mothername": "Almaz", "emergencynumber": "0911000000",
+		"address": {"region": "ET-AA", "zone": "ET-AA-01", "woreda": "ET-AA-01-01", "landmark": "near the bus station"}}`
+	runCases(t, []handlerCase{
+		{name: "created with its likely duplicate", method: "POST", path: v1 + "/client/profile/create", body: create,
+			status: http.StatusCreated, code: i18n.PatientCreated,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				created, err := api.store.LocalStore.Get_ClientProfile(body["health_id"].(string))
+				require.NoError(t, err)
+				assert.Equal(t, api.hip.HealthcareID, created.HealthcareID)
+				duplicates := body["possible_duplicates"].([]interface{})
+				require.Len(t, duplicates, 1)
+				assert.Equal(t, api.vars["health_id"], duplicates[0].(map[string]interface{})["candidate_health_id"])
+			}},
+		{name: "missing first name", method: "POST", path: v1 + "/client/profile/create", body: strings.Replace(create, `"fname": "Almaz", `, "", 1),
+			status: http.StatusUnprocessableEntity, code: i18n.ValidationFailed,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				field := body["errors"].([]interface{})[0].(map[string]interface{})
+				assert.Equal(t, "fname", field["field"])
+				assert.Equal(t, string(i18n.FieldRequired), field["code"])
+			}},
+		{name: "woreda outside the zone", method: "POST", path: v1 + "/client/profile/create", body: strings.Replace(create, "ET-AA-01-01", "ET-AA-02-01", 1),
+			status: http.StatusUnprocessableEntity, code: i18n.InvalidAddress},
+		{name: "legacy free-text address", method: "POST", path: v1 + "/client/profile/create",
+			body: strings.Replace(create, `"region": "ET-AA", "zone": "ET-AA-01", "woreda": "ET-AA-01-01"`,
+				`"country": "Ethiopia", "state": "Oromia", "city": "Adama"`, 1),
+			status: http.StatusCreated, code: i18n.PatientCreated,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				queue, err := api.store.LocalStore.GetAddressReviewQueue(api.hip.HealthcareID, 10)
+				require.NoError(t, err)
+				require.Len(t, queue, 1)
+				assert.Equal(t, body["health_id"], queue[0].HealthID)
+				assert.Equal(t, "Adama", queue[0].Address.City)
+			}},
+		{name: "body is not json", method: "POST", path: v1 + "/client/profile/create", body: "{", status: http.StatusBadRequest, code: i18n.InvalidRequestBody},
+		{name: "stats cannot be created", method: "POST", path: v1 + "/client/profile/create", body: create,
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("CreateClient_stats", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "failed duplicate check does not fail registration", method: "POST", path: v1 + "/client/profile/create", body: create,
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("FindDuplicates", errStoreDown) },
+			status: http.StatusCreated, code: i18n.PatientCreated,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Empty(t, body["possible_duplicates"])
+			}},
+		{name: "create with wrong method", method: "GET", path: v1 + "/client/profile/create", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+
+		{name: "fetched with its etag", method: "GET", path: v1 + "/client/profile/get?healthID={health_id}", status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, `"v1"`, rec.Header().Get("ETag"))
+				assert.Equal(t, "Almaz", body["client_profile"].(map[string]interface{})["fname"])
+				assert.Equal(t, "patient.profile.viewed", queued(t, api, "logs")["type"])
+			}},
+		{name: "healthID missing", method: "GET", path: v1 + "/client/profile/get", status: http.StatusBadRequest, code: i18n.HealthIDMissing},
+		{name: "unknown patient", method: "GET", path: v1 + "/client/profile/get?healthID=HID-nobody", status: http.StatusNotFound, code: i18n.PatientNotFound},
+		{name: "view cannot be logged", method: "GET", path: v1 + "/client/profile/get?healthID={health_id}",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("Enqueue", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "get with wrong method", method: "POST", path: v1 + "/client/profile/get?healthID={health_id}", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+	})
+}
+
+func TestUpdateClientProfile(t *testing.T) {
+	update := v1 + "/client/profile/update?healthID={health_id}"
+	ifMatch := func(etag string) map[string]string {
+		return map[string]string{"If-Match": etag, "Content-Type": "application/merge-patch+json"}
+	}
+	runCases(t, []handlerCase{
+		{name: "updated", method: "PATCH", path: update, body: `{"fname": "Alemitu"}`, header: ifMatch(`"v1"`),
+			status: http.StatusAccepted,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, `"v2"`, rec.Header().Get("ETag"))
+				assert.Equal(t, "Alemitu", body["updated_details"].(map[string]interface{})["fname"])
+			}},
+		{name: "any version", method: "PATCH", path: update, body: `{"fname": "Alemitu"}`, header: ifMatch("*"), status: http.StatusAccepted},
+		// v1 clients predate If-Match
+		{name: "If-Match missing on v1", method: "PATCH", path: update, body: `{"fname": "Alemitu"}`, status: http.StatusAccepted,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, `"v2"`, rec.Header().Get("ETag"))
+			}},
+		{name: "If-Match missing on v2", method: "PATCH", path: v2 + "/patients/{health_id}", body: `{"fname": "Alemitu"}`,
+			status: http.StatusPreconditionRequired, code: i18n.PreconditionRequired},
+		{name: "If-Match on v2", method: "PATCH", path: v2 + "/patients/{health_id}", body: `{"fname": "Alemitu"}`, header: ifMatch(`"v1"`),
+			status: http.StatusAccepted},
+		{name: "store failure", method: "PATCH", path: update, body: `{"fname": "Alemitu"}`, header: ifMatch(`"v1"`),
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("Update_clientProfile", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "stale version", method: "PATCH", path: update, body: `{"fname": "Alemitu"}`, header: ifMatch(`"v7"`),
+			status: http.StatusPreconditionFailed, code: i18n.ProfileChanged},
+		{name: "malformed If-Match", method: "PATCH", path: update, body: `{"fname": "Alemitu"}`, header: ifMatch("v1"),
+			status: http.StatusPreconditionFailed, code: i18n.ProfileChanged},
+		{name: "not a merge patch", method: "PATCH", path: update, body: `{"fname": "Alemitu"}`,
+			header: map[string]string{"If-Match": `"v1"`, "Content-Type": "text/plain"},
+			status: http.StatusUnsupportedMediaType, code: i18n.UnsupportedMediaType},
+		{name: "empty patch", method: "PATCH", path: update, body: `{}`, header: ifMatch(`"v1"`),
+			status: http.StatusBadRequest, code: i18n.NothingToUpdate},
+		{name: "read-only field", method: "PATCH", path: update, body: `{"health_id": "HID-other"}`, header: ifMatch(`"v1"`),
+			status: http.StatusUnprocessableEntity, code: i18n.ValidationFailed,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				field := body["errors"].([]interface{})[0].(map[string]interface{})
+				assert.Equal(t, string(i18n.FieldReadOnly), field["code"])
+			}},
+		{name: "body is not json", method: "PATCH", path: update, body: "{", header: ifMatch(`"v1"`),
+			status: http.StatusBadRequest, code: i18n.InvalidRequestBody},
+		{name: "unknown patient", method: "PATCH", path: v1 + "/client/profile/update?healthID=HID-nobody", body: `{"fname": "Alemitu"}`,
+			header: ifMatch("*"), status: http.StatusNotFound, code: i18n.PatientNotFound},
+		{name: "healthID missing", method: "PATCH", path: v1 + "/client/profile/update", body: `{"fname": "Alemitu"}`, header: ifMatch(`"v1"`),
+			status: http.StatusBadRequest, code: i18n.HealthIDMissing},
+		{name: "wrong method", method: "POST", path: update, body: `{"fname": "Alemitu"}`, header: ifMatch(`"v1"`),
+			status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+	})
+}
+
+func TestProfileHistory(t *testing.T) {
+	updated := func(t *testing.T, api *testAPI) {
+		rec := api.do("PATCH", v1+"/client/profile/update?healthID={health_id}", `{"fname": "Alemitu"}`, map[string]string{"If-Match": `"v1"`})
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	runCases(t, []handlerCase{
+		{name: "versions", method: "GET", path: v1 + "/client/profile/versions?healthID={health_id}", setup: updated, status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, float64(2), body["current_version"])
+				assert.Equal(t, float64(1), body["fetched"])
+			}},
+		{name: "versions of unknown patient", method: "GET", path: v1 + "/client/profile/versions?healthID=HID-nobody",
+			status: http.StatusNotFound, code: i18n.PatientNotFound},
+		{name: "versions without healthID", method: "GET", path: v1 + "/client/profile/versions", status: http.StatusBadRequest, code: i18n.HealthIDMissing},
+
+		{name: "diff against current", method: "GET", path: v1 + "/client/profile/diff?healthID={health_id}&from=1", setup: updated, status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, float64(2), body["to"])
+				change := body["changes"].([]interface{})[0].(map[string]interface{})
+				assert.Equal(t, "fname", change["field"])
+			}},
+		{name: "diff without from", method: "GET", path: v1 + "/client/profile/diff?healthID={health_id}",
+			status: http.StatusBadRequest, code: i18n.InvalidQueryParam},
+		{name: "diff to unknown version", method: "GET", path: v1 + "/client/profile/diff?healthID={health_id}&from=1&to=9",
+			status: http.StatusNotFound, code: i18n.VersionNotFound},
+
+		{name: "as of now", method: "GET", path: v1 + "/client/profile/asof?healthID={health_id}&date=" + time.Now().UTC().Format("2006-01-02"),
+			setup: updated, status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, "Alemitu", body["client_profile"].(map[string]interface{})["fname"])
+			}},
+		{name: "as of before registration", method: "GET", path: v1 + "/client/profile/asof?healthID={health_id}&date=2000-01-01",
+			status: http.StatusNotFound},
+		{name: "as of an invalid date", method: "GET", path: v1 + "/client/profile/asof?healthID={health_id}&date=yesterday",
+			status: http.StatusBadRequest, code: i18n.InvalidQueryParam},
+	})
+}
+
+func TestPatientRecords(t *testing.T) {
+	record := `{"issue": "fever", "description": "high fever for two days", "health_id": "{health_id}", "medical_severity": "High"}`
+	runCases(t, []handlerCase{
+		{name: "record queued", method: "POST", path: v1 + "/client/records/create", body: record, status: http.StatusOK, code: i18n.RecordQueued,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				queuedRecord := queued(t, api, "patient_records")["data"].(map[string]interface{})
+				assert.Equal(t, api.hip.HealthcareID, queuedRecord["healthcare_id"])
+				assert.Equal(t, "Adama Hospital", queuedRecord["healthcare_name"])
+				assert.Equal(t, "patient.record.created", queued(t, api, "logs")["type"])
+			}},
+		{name: "unknown severity", method: "POST", path: v1 + "/client/records/create", body: strings.Replace(record, "High", "Mild", 1),
+			status: http.StatusUnprocessableEntity, code: i18n.InvalidMedicalSeverity},
+		{name: "issue too short", method: "POST", path: v1 + "/client/records/create", body: strings.Replace(record, "fever", "f", 1),
+			status: http.StatusUnprocessableEntity, code: i18n.ValidationFailed},
+		{name: "body is not json", method: "POST", path: v1 + "/client/records/create", body: "{",
+			status: http.StatusBadRequest, code: i18n.InvalidRequestBody},
+		{name: "outbox unavailable", method: "POST", path: v1 + "/client/records/create", body: record,
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("Enqueue", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "create with wrong method", method: "GET", path: v1 + "/client/records/create", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+
+		{name: "fetched", method: "GET", path: v1 + "/client/records/fetch?healthID={health_id}", status: http.StatusOK,
+			setup: func(t *testing.T, api *testAPI) {
+				_, err := api.store.CreatepatientRecords(api.hip.HealthcareID, &mod.PatientRecords{Issue: "fever", Description: "high fever",
+					HealthID: api.vars["health_id"], MedicalSeverity: "High", HealthcareName: "Adama Hospital"})
+				require.NoError(t, err)
 			},
-		},
-		{
-			name: "Failed Login - Invalid Credentials",
-			request: LoginRequest{
-				HealthcareID:      "INVALID_ID",
-				HealthcareLicense: "INVALID_LICENSE",
-				Password:          "wrong_password",
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, "N/A", body["severity"])
+				assert.Len(t, body["patient_records"], 1)
+				assert.Equal(t, "patient.records.viewed", queued(t, api, "logs")["type"])
+			}},
+		{name: "healthID missing", method: "GET", path: v1 + "/client/records/fetch", status: http.StatusBadRequest, code: i18n.HealthIDMissing},
+		{name: "list is not a number", method: "GET", path: v1 + "/client/records/fetch?healthID={health_id}&list=all",
+			status: http.StatusBadRequest, code: i18n.InvalidQueryParam},
+		{name: "store unavailable", method: "GET", path: v1 + "/client/records/fetch?healthID={health_id}",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("GetPatientRecords", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+	})
+}
+
+func TestSearchClientProfiles(t *testing.T) {
+	runCases(t, []handlerCase{
+		{name: "by phone", method: "GET", path: v1 + "/client/profile/search?phone=091-123-4567", status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, float64(1), body["fetched"])
+			}},
+		{name: "nothing matches", method: "GET", path: v1 + "/client/profile/search?name=Dawit", status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, []interface{}{}, body["matches"])
+			}},
+		{name: "no criteria", method: "GET", path: v1 + "/client/profile/search", status: http.StatusBadRequest, code: i18n.SearchCriteriaMissing},
+		{name: "limit too large", method: "GET", path: v1 + "/client/profile/search?name=Almaz&limit=51", status: http.StatusBadRequest, code: i18n.InvalidQueryParam},
+		{name: "store unavailable", method: "GET", path: v1 + "/client/profile/search?name=Almaz",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("SearchClientProfiles", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "wrong method", method: "POST", path: v1 + "/client/profile/search?name=Almaz", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+	})
+}
+
+func TestDuplicatesAndMerges(t *testing.T) {
+	// a second registration of the same person, queued as a duplicate of the first
+	duplicate := func(t *testing.T, api *testAPI) {
+		other := api.addPatient(t, api.hip.HealthcareID)
+		duplicates, err := api.store.LocalStore.FindDuplicates(other)
+		require.NoError(t, err)
+		require.Len(t, duplicates, 1)
+		api.vars["other_health_id"] = other.HealthID
+		api.vars["duplicate_id"] = jsonNumber(duplicates[0].ID)
+	}
+	merged := func(t *testing.T, api *testAPI) {
+		duplicate(t, api)
+		merge, err := api.store.MergeClientProfiles(api.hip.HealthcareID, api.vars["health_id"], api.vars["other_health_id"])
+		require.NoError(t, err)
+		api.vars["merge_id"] = jsonNumber(merge.ID)
+	}
+	// another HIP asks this one to merge its patient into this HIP's
+	const otherHIP = "HCID0000000000000002"
+	requested := func(t *testing.T, api *testAPI) {
+		api.vars["other_health_id"] = api.addPatient(t, otherHIP).HealthID
+		request, err := api.store.RequestMerge(otherHIP, api.vars["other_health_id"], api.vars["health_id"])
+		require.NoError(t, err)
+		api.vars["merge_request_id"] = jsonNumber(request.ID)
+	}
+	merge := `{"surviving_health_id": "{health_id}", "merged_health_id": "{other_health_id}"}`
+	runCases(t, []handlerCase{
+		{name: "pending queue", method: "GET", path: v1 + "/client/duplicates", setup: duplicate, status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, float64(1), body["fetched"])
+				candidate := body["duplicates"].([]interface{})[0].(map[string]interface{})["candidate"].(map[string]interface{})
+				assert.Len(t, candidate, 3, "the candidate has more than health_id, score and reasons")
+				assert.Equal(t, api.vars["health_id"], candidate["health_id"])
+			}},
+		{name: "queue with unknown status", method: "GET", path: v1 + "/client/duplicates?status=open", status: http.StatusBadRequest, code: i18n.InvalidQueryParam},
+		{name: "queue unavailable", method: "GET", path: v1 + "/client/duplicates",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("GetDuplicateQueue", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+
+		{name: "dismissed", method: "POST", path: v1 + "/client/duplicates/dismiss", body: `{"id": {duplicate_id}}`, setup: duplicate,
+			status: http.StatusOK, code: i18n.DuplicateDismissed},
+		{name: "dismiss unknown duplicate", method: "POST", path: v1 + "/client/duplicates/dismiss", body: `{"id": 99}`,
+			status: http.StatusNotFound, code: i18n.DuplicateNotFound},
+		{name: "dismiss without id", method: "POST", path: v1 + "/client/duplicates/dismiss", body: `{}`,
+			status: http.StatusBadRequest, code: i18n.InvalidRequestBody},
+
+		{name: "merged", method: "POST", path: v1 + "/client/merge", body: merge, setup: duplicate, status: http.StatusOK, code: i18n.PatientsMerged,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				profile, err := api.store.Get_ClientProfile(api.vars["other_health_id"])
+				require.NoError(t, err)
+				assert.Equal(t, api.vars["health_id"], profile.HealthID)
+			}},
+		{name: "merged twice", method: "POST", path: v1 + "/client/merge", body: merge, setup: merged,
+			status: http.StatusConflict, code: i18n.PatientAlreadyMerged},
+		{name: "merge unknown patient", method: "POST", path: v1 + "/client/merge", body: merge,
+			setup:  func(t *testing.T, api *testAPI) { api.vars["other_health_id"] = "HID-nobody" },
+			status: http.StatusNotFound, code: i18n.PatientNotFound},
+		{name: "merge patients of another HIP", method: "POST", path: v1 + "/client/merge", body: merge,
+			setup: func(t *testing.T, api *testAPI) {
+				api.vars["health_id"] = api.addPatient(t, "HCID0000000000000002").HealthID
+				api.vars["other_health_id"] = api.addPatient(t, "HCID0000000000000002").HealthID
 			},
-			expectedStatus: http.StatusUnauthorized,
-			validateResponse: func(t *testing.T, body []byte) {
-				assert.Empty(t, body) // Expecting no body on unauthorized
+			status: http.StatusForbidden, code: i18n.MergeForbidden},
+		{name: "merge without both ids", method: "POST", path: v1 + "/client/merge", body: `{"surviving_health_id": "{health_id}"}`,
+			status: http.StatusBadRequest, code: i18n.InvalidRequestBody},
+		{name: "merge with a patient of another HIP is a merge request", method: "POST", path: v1 + "/client/merge", body: merge,
+			setup:  func(t *testing.T, api *testAPI) { api.vars["other_health_id"] = api.addPatient(t, otherHIP).HealthID },
+			status: http.StatusAccepted, code: i18n.MergeRequested,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				request := body["merge_request"].(map[string]interface{})
+				assert.Equal(t, otherHIP, request["approver"])
+				assert.Equal(t, "pending", request["status"])
+				_, err := api.store.Get_ClientProfile(api.vars["other_health_id"])
+				require.NoError(t, err, "merged before the approval")
+			}},
+
+		{name: "merge requests", method: "GET", path: v2 + "/merge-requests?status=pending", setup: requested, status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, float64(1), body["fetched"])
+			}},
+		{name: "merge requests with unknown status", method: "GET", path: v2 + "/merge-requests?status=open", status: http.StatusBadRequest, code: i18n.InvalidQueryParam},
+		{name: "merge request approved", method: "POST", path: v2 + "/merge-requests/{merge_request_id}/approve", setup: requested,
+			status: http.StatusOK, code: i18n.PatientsMerged,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, otherHIP, body["merge"].(map[string]interface{})["merged_by"])
+				profile, err := api.store.Get_ClientProfile(api.vars["health_id"])
+				require.NoError(t, err)
+				assert.Equal(t, api.vars["other_health_id"], profile.HealthID)
+			}},
+		{name: "merge request approved by its requester", method: "POST", path: v2 + "/merge-requests/{merge_request_id}/approve",
+			setup: func(t *testing.T, api *testAPI) {
+				api.vars["other_health_id"] = api.addPatient(t, otherHIP).HealthID
+				request, err := api.store.RequestMerge(api.hip.HealthcareID, api.vars["health_id"], api.vars["other_health_id"])
+				require.NoError(t, err)
+				api.vars["merge_request_id"] = jsonNumber(request.ID)
 			},
-		},
-	}
+			status: http.StatusNotFound, code: i18n.MergeRequestNotFound},
+		{name: "merge request rejected", method: "POST", path: v2 + "/merge-requests/{merge_request_id}/reject", setup: requested,
+			status: http.StatusOK, code: i18n.MergeRequestRejected,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, "rejected", body["merge_request"].(map[string]interface{})["status"])
+			}},
+		{name: "unknown merge request", method: "POST", path: v2 + "/merge-requests/99/reject", status: http.StatusNotFound, code: i18n.MergeRequestNotFound},
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			jsonBody, _ := json.Marshal(tt.request)
-			req := httptest.NewRequest("POST", "http://localhost:3000/api/v1/healthcareauth/login", bytes.NewBuffer(jsonBody))
-			req.Header.Set("Content-Type", "application/json")
+		{name: "unmerged", method: "POST", path: v1 + "/client/unmerge", body: `{"merge_id": {merge_id}}`, setup: merged,
+			status: http.StatusOK, code: i18n.PatientsUnmerged},
+		{name: "unmerge by the merged patient's HIP", method: "POST", path: v1 + "/client/unmerge", body: `{"merge_id": {merge_id}}`,
+			setup: func(t *testing.T, api *testAPI) {
+				api.vars["other_health_id"] = api.addPatient(t, otherHIP).HealthID
+				request, err := api.store.RequestMerge(otherHIP, api.vars["other_health_id"], api.vars["health_id"])
+				require.NoError(t, err)
+				merge, err := api.store.ApproveMergeRequest(api.hip.HealthcareID, request.ID)
+				require.NoError(t, err)
+				api.vars["merge_id"] = jsonNumber(merge.ID)
+			},
+			status: http.StatusForbidden, code: i18n.UnmergeForbidden},
+		{name: "unmerge unknown merge", method: "POST", path: v1 + "/client/unmerge", body: `{"merge_id": 99}`,
+			status: http.StatusNotFound, code: i18n.MergeNotFound},
+		{name: "unmerge with wrong method", method: "GET", path: v1 + "/client/unmerge", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
+	})
+}
 
-			w := httptest.NewRecorder()
-			LoginHandler(w, req)
+func TestAddressHierarchy(t *testing.T) {
+	runCases(t, []handlerCase{
+		{name: "regions", method: "GET", path: v1 + "/address/regions", status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Greater(t, body["fetched"], float64(0))
+			}},
+		{name: "zones of a region", method: "GET", path: v1 + "/address/zones?region=et-aa", status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.NotEmpty(t, body["zones"])
+			}},
+		{name: "zones without region", method: "GET", path: v1 + "/address/zones", status: http.StatusBadRequest, code: i18n.QueryParamMissing},
+		{name: "woredas of unknown zone", method: "GET", path: v1 + "/address/woredas?zone=ET-XX-01", status: http.StatusNotFound, code: i18n.InvalidAddress},
+		{name: "regions with wrong method", method: "POST", path: v1 + "/address/regions", status: http.StatusMethodNotAllowed, code: i18n.MethodNotAllowed},
 
-			assert.Equal(t, tt.expectedStatus, w.Code)
-			if tt.validateResponse != nil {
-				tt.validateResponse(t, w.Body.Bytes())
-			}
-		})
-	}
+		{name: "review queue", method: "GET", path: v1 + "/address/review", status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, []interface{}{}, body["client_profiles"])
+			}},
+		{name: "review queue with invalid limit", method: "GET", path: v1 + "/address/review?limit=0", status: http.StatusBadRequest, code: i18n.InvalidQueryParam},
+		{name: "review queue unavailable", method: "GET", path: v1 + "/address/review",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("GetAddressReviewQueue", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+	})
+}
+
+func jsonNumber(n int64) string {
+	raw, _ := json.Marshal(n)
+	return string(raw)
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	mod "vaibhavyadav-dev/healthcareServer/databases"
+	mq "vaibhavyadav-dev/healthcareServer/rabbitmq"
+	rd "vaibhavyadav-dev/healthcareServer/redis"
+)
+
+// memStore is the Store the handler tests run against, the SQLite local store on ":memory:"
+// with call counts and injectable failures on top so the error paths can be reached
+type memStore struct {
+	*mod.LocalStore
+
+	mu    sync.Mutex
+	calls map[string]int
+	fail  map[string]error
+	// rate limiter answers, both allow by default
+	denyFixedWindow bool
+	denyLeakyBucket bool
+}
+
+func newMemStore(t *testing.T) *memStore {
+	t.Helper()
+	local, err := mod.Localstore(":memory:", 30, 20*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { local.Close() })
+	return &memStore{
+		LocalStore: local,
+		calls:      map[string]int{},
+		fail:       map[string]error{},
+	}
+}
+
+// call records that method was used and returns the failure injected for it
+func (s *memStore) call(method string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[method]++
+	return s.fail[method]
+}
+
+func (s *memStore) count(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+func (s *memStore) failWith(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail[method] = err
+}
+
+func (s *memStore) ChangePreferance(id string, pref map[string]interface{}, messages ...mq.Message) error {
+	if err := s.call("ChangePreferance"); err != nil {
+		return err
+	}
+	return s.LocalStore.ChangePreferance(id, pref, messages...)
+}
+
+func (s *memStore) GetPreferance(id string) (*mod.Preferance, error) {
+	if err := s.call("GetPreferance"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.GetPreferance(id)
+}
+
+func (s *memStore) GetTotalRequestCount(healthcare_id string) (int, error) {
+	if err := s.call("GetTotalRequestCount"); err != nil {
+		return 0, err
+	}
+	return s.LocalStore.GetTotalRequestCount(healthcare_id)
+}
+
+func (s *memStore) CreateClient_stats(health_id string) error {
+	if err := s.call("CreateClient_stats"); err != nil {
+		return err
+	}
+	return s.LocalStore.CreateClient_stats(health_id)
+}
+
+func (s *memStore) GetAppointments_postgres(health_id string, offset, limit int64) ([]*mod.Appointments, error) {
+	if err := s.call("GetAppointments_postgres"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.GetAppointments_postgres(health_id, offset, limit)
+}
+
+func (s *memStore) CheckAppointment(healthcare_id, health_id string, id int64) error {
+	if err := s.call("CheckAppointment"); err != nil {
+		return err
+	}
+	return s.LocalStore.CheckAppointment(healthcare_id, health_id, id)
+}
+
+func (s *memStore) GetHealthcare_details_postgres(healthcare_id string) (*mod.HIPInfo, error) {
+	if err := s.call("GetHealthcare_details_postgres"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.GetHealthcare_details_postgres(healthcare_id)
+}
+
+func (s *memStore) Update_clientProfile(health_id string, patch *mod.ProfilePatch, ifVersion int, change mod.ProfileChange) (*mod.PatientDetails, error) {
+	if err := s.call("Update_clientProfile"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.Update_clientProfile(health_id, patch, ifVersion, change)
+}
+
+func (s *memStore) SearchClientProfiles(healthcare_id string, q *mod.PatientSearch) ([]*mod.PatientMatch, error) {
+	if err := s.call("SearchClientProfiles"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.SearchClientProfiles(healthcare_id, q)
+}
+
+func (s *memStore) FindDuplicates(patient *mod.PatientDetails) ([]*mod.DuplicateCandidate, error) {
+	if err := s.call("FindDuplicates"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.FindDuplicates(patient)
+}
+
+func (s *memStore) GetDuplicateQueue(healthcare_id, status string, limit int64) ([]*mod.DuplicateCandidate, error) {
+	if err := s.call("GetDuplicateQueue"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.GetDuplicateQueue(healthcare_id, status, limit)
+}
+
+func (s *memStore) GetAddressReviewQueue(healthcare_id string, limit int64) ([]*mod.PatientDetails, error) {
+	if err := s.call("GetAddressReviewQueue"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.GetAddressReviewQueue(healthcare_id, limit)
+}
+
+func (s *memStore) GetPatientRecords(healthID, severity string, limit int) (*[]mod.PatientRecords, error) {
+	if err := s.call("GetPatientRecords"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.GetPatientRecords(healthID, severity, limit)
+}
+
+func (s *memStore) Enqueue(messages ...mq.Message) error {
+	if err := s.call("Enqueue"); err != nil {
+		return err
+	}
+	return s.LocalStore.Enqueue(messages...)
+}
+
+func (s *memStore) Set(key string, value interface{}) error {
+	if err := s.call("Set"); err != nil {
+		return err
+	}
+	return s.LocalStore.Set(key, value)
+}
+
+func (s *memStore) Get(key string) (interface{}, error) {
+	if err := s.call("Get"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.Get(key)
+}
+
+func (s *memStore) IsAllowed(healthcare_id string) (bool, error) {
+	if err := s.call("IsAllowed"); err != nil {
+		return false, err
+	}
+	if s.denyFixedWindow {
+		return false, nil
+	}
+	return s.LocalStore.IsAllowed(healthcare_id)
+}
+
+func (s *memStore) IsAllowed_leaky_bucket(healthcare_id string) (bool, error) {
+	if err := s.call("IsAllowed_leaky_bucket"); err != nil {
+		return false, err
+	}
+	if s.denyLeakyBucket {
+		return false, nil
+	}
+	return s.LocalStore.IsAllowed_leaky_bucket(healthcare_id)
+}
+
+func (s *memStore) ListWebhooks(healthcare_id string) ([]*mod.Webhook, error) {
+	if err := s.call("ListWebhooks"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.ListWebhooks(healthcare_id)
+}
+
+func (s *memStore) LiveUpdatesSince(healthcare_id string, after int64) ([]rd.LiveUpdate, error) {
+	if err := s.call("LiveUpdatesSince"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.LiveUpdatesSince(healthcare_id, after)
+}
+
+func (s *memStore) ListNotifications(healthcare_id, status string, limit int64) ([]*mod.Notification, error) {
+	if err := s.call("ListNotifications"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.ListNotifications(healthcare_id, status, limit)
+}
+
+func (s *memStore) AnswerReminder(from, text string) (*mod.ReminderReply, error) {
+	if err := s.call("AnswerReminder"); err != nil {
+		return nil, err
+	}
+	return s.LocalStore.AnswerReminder(from, text)
+}
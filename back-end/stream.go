@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	mod "vaibhavyadav-dev/healthcareServer/databases"
+	"vaibhavyadav-dev/healthcareServer/i18n"
+	rd "vaibhavyadav-dev/healthcareServer/redis"
+)
+
+// A HIP's dashboard keeps GET /api/v2/stream open instead of polling, it gets appointment status
+// changes, new patient records and quota warnings as server-sent events as the event log has them.
+// The id of an event is its position in the log: a browser that reconnects sends the last one in
+// Last-Event-ID and gets what it missed first. EventSource can't set headers, so the token may
+// also come as ?access_token=.
+
+const (
+	// a comment this often keeps proxies from closing a quiet stream
+	streamHeartbeat = 15 * time.Second
+	// how long a browser waits before reconnecting, in milliseconds
+	streamRetry = 3000
+	// sent when the updates missed can't be replayed, the dashboard fetches everything again
+	streamResetEvent = "reset"
+)
+
+// StreamUpdates streams the live updates of the HIP until the client goes away
+func (s *APIServer) StreamUpdates(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	last, resume, err := lastEventID(r)
+	if err != nil {
+		return err
+	}
+	// subscribed before reading the log, so an update published in between is not lost
+	updates, cancel, err := s.store.SubscribeLive(healthcareID)
+	if err != nil {
+		return internalError(err)
+	}
+	defer cancel()
+	var missed []rd.LiveUpdate
+	reset := false
+	if resume {
+		missed, err = s.store.LiveUpdatesSince(healthcareID, last)
+		if errors.Is(err, mod.ErrLiveReplayTooOld) {
+			reset = true
+		} else if err != nil {
+			return internalError(err)
+		}
+	}
+
+	// nothing below can become a problem response, the status is sent
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx passes it on as it comes instead of buffering
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+	if reset {
+		writeStreamReset(w, r)
+	}
+	for _, update := range missed {
+		writeLiveUpdate(w, update)
+		last = update.ID
+	}
+	if err := rc.Flush(); err != nil {
+		return nil
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case update, ok := <-updates:
+			if !ok {
+				// fell behind or the server is stopping, the browser resumes from the log
+				return nil
+			}
+			// replayed already, or published again after a failed round
+			if update.ID <= last {
+				continue
+			}
+			writeLiveUpdate(w, update)
+			last = update.ID
+		case <-heartbeat.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return nil
+		}
+	}
+}
+
+// lastEventID is the Last-Event-ID a reconnecting browser sends, resume is false without one
+func lastEventID(r *http.Request) (last int64, resume bool, err error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		return 0, false, nil
+	}
+	last, err = strconv.ParseInt(value, 10, 64)
+	if err != nil || last < 0 {
+		return 0, false, newProblem(http.StatusBadRequest, i18n.InvalidLastEventID).withCause(err)
+	}
+	return last, true, nil
+}
+
+// writeLiveUpdate writes update as one event, the data is the event's JSON on a single line
+func writeLiveUpdate(w io.Writer, update rd.LiveUpdate) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", update.ID, update.Type, update.Data)
+}
+
+func writeStreamReset(w io.Writer, r *http.Request) {
+	data, _ := json.Marshal(statusResponse{Code: i18n.StreamReset, Message: msg(r, i18n.StreamReset)})
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", streamResetEvent, data)
+}
+
+// tokenFromQuery lets a client that can't set headers send its token as ?access_token=
+func tokenFromQuery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next(w, r)
+	}
+}
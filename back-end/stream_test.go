@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mod "vaibhavyadav-dev/healthcareServer/databases"
+	evts "vaibhavyadav-dev/healthcareServer/events"
+	"vaibhavyadav-dev/healthcareServer/i18n"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamErrors(t *testing.T) {
+	runCases(t, []handlerCase{
+		{name: "bad Last-Event-ID", method: "GET", path: v2 + "/stream", header: map[string]string{"Last-Event-ID": "yesterday"},
+			status: http.StatusBadRequest, code: i18n.InvalidLastEventID},
+		{name: "negative Last-Event-ID", method: "GET", path: v2 + "/stream", header: map[string]string{"Last-Event-ID": "-1"},
+			status: http.StatusBadRequest, code: i18n.InvalidLastEventID},
+		{name: "bad token in the query", method: "GET", path: v2 + "/stream?access_token=nope", header: map[string]string{"Authorization": ""},
+			status: http.StatusUnauthorized, code: i18n.InvalidToken},
+	})
+}
+
+type sseEvent struct {
+	ID, Event, Data string
+}
+
+// openStream connects to the HIP's stream like an EventSource would and hands over its events
+func openStream(t *testing.T, srv *httptest.Server, api *testAPI, lastEventID string) (<-chan sseEvent, func()) {
+	t.Helper()
+	req, err := http.NewRequest("GET", srv.URL+v2+"/stream?access_token="+api.token, nil)
+	require.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	events := make(chan sseEvent, 10)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(res.Body)
+		event := sseEvent{}
+		for scanner.Scan() {
+			line := scanner.Text()
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "id":
+				event.ID = value
+			case "event":
+				event.Event = value
+			case "data":
+				event.Data = value
+			case "":
+				if event.Event != "" {
+					events <- event
+				}
+				event = sseEvent{}
+			}
+		}
+	}()
+	return events, func() { res.Body.Close() }
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "stream closed")
+		return event
+	case <-time.After(3 * time.Second):
+		t.Fatal("nothing came on the stream")
+		return sseEvent{}
+	}
+}
+
+func TestStreamUpdates(t *testing.T) {
+	api := newTestAPI(t)
+	srv := httptest.NewServer(api.handler)
+	t.Cleanup(srv.Close)
+
+	events, disconnect := openStream(t, srv, api, "")
+	res := api.do("POST", v2+"/patients/{health_id}/records", `{"issue": "fever", "description": "high fever for two days", "medical_severity": "High"}`, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	record := nextEvent(t, events)
+	assert.Equal(t, "patient.record.created", record.Event)
+	assert.Contains(t, record.Data, api.vars["health_id"])
+
+	// another HIP's appointment never shows, this one's does
+	other, err := evts.Message(evts.AppointmentStatusChanged{HealthcareID: "HCID0000000000000002", AppointmentID: 9, HealthID: "HID-2", Status: "Confirmed"})
+	require.NoError(t, err)
+	require.NoError(t, api.store.Enqueue(other))
+	res = api.do("PATCH", v2+"/appointments/7", `{"health_id": "{health_id}", "status": "Confirmed"}`, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	appointment := nextEvent(t, events)
+	assert.Equal(t, "appointment.status.changed", appointment.Event)
+	var envelope struct {
+		Data struct {
+			HealthcareID  string `json:"healthcare_id"`
+			AppointmentID int64  `json:"appointment_id"`
+			Status        string `json:"status"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(appointment.Data), &envelope))
+	assert.Equal(t, api.hip.HealthcareID, envelope.Data.HealthcareID)
+	assert.Equal(t, int64(7), envelope.Data.AppointmentID)
+	assert.Equal(t, "Confirmed", envelope.Data.Status)
+
+	// offline while a record is written, the reconnect gets it first
+	disconnect()
+	res = api.do("POST", v2+"/patients/{health_id}/records", `{"issue": "cough", "description": "dry cough", "medical_severity": "Low"}`, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	events, disconnect = openStream(t, srv, api, appointment.ID)
+	defer disconnect()
+	missed := nextEvent(t, events)
+	assert.Equal(t, "patient.record.created", missed.Event)
+	assert.Contains(t, missed.Data, "dry cough")
+
+	// further behind than the log replays, the dashboard is told to start over
+	api.store.failWith("LiveUpdatesSince", mod.ErrLiveReplayTooOld)
+	events, disconnectFar := openStream(t, srv, api, "1")
+	defer disconnectFar()
+	reset := nextEvent(t, events)
+	assert.Equal(t, streamResetEvent, reset.Event)
+	assert.Contains(t, reset.Data, string(i18n.StreamReset))
+}
+
+func TestStreamAnotherHIPsAppointment(t *testing.T) {
+	api := newTestAPI(t)
+	srv := httptest.NewServer(api.handler)
+	t.Cleanup(srv.Close)
+	other := *api.hip
+	other.HealthcareID, other.HealthcareLicense = "HCID0000000000000002", "LIC-NAZRET-0002"
+	other.HealthcareName, other.Email = "Nazret Clinic", "hip@nazret.example"
+	_, err := api.store.LocalStore.SignUpAccount(&other)
+	require.NoError(t, err)
+	api.bookAppointment(t, 2, other.HealthcareID, api.vars["health_id"])
+	dashboard := *api
+	dashboard.token, err = createJWT(&other)
+	require.NoError(t, err)
+	events, disconnect := openStream(t, srv, &dashboard, "")
+	defer disconnect()
+
+	// naming the other HIP in the body doesn't make its appointment this HIP's
+	res := api.do("PATCH", v2+"/appointments/2", `{"health_id": "{health_id}", "healthcare_id": "HCID0000000000000002", "status": "Rejected"}`, nil)
+	require.Equal(t, http.StatusNotFound, res.Code, res.Body.String())
+
+	// the other HIP's own change is the first thing its dashboard shows
+	changed, err := evts.Message(evts.AppointmentStatusChanged{HealthcareID: other.HealthcareID, AppointmentID: 2, HealthID: api.vars["health_id"], Status: "Confirmed"})
+	require.NoError(t, err)
+	require.NoError(t, api.store.Enqueue(changed))
+	appointment := nextEvent(t, events)
+	assert.Equal(t, "appointment.status.changed", appointment.Event)
+	assert.Contains(t, appointment.Data, `"status":"Confirmed"`)
+}
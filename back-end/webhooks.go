@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	mod "vaibhavyadav-dev/healthcareServer/databases"
+	"vaibhavyadav-dev/healthcareServer/i18n"
+)
+
+// A HIP registers its own endpoints for the events of its facility, they get the same
+// envelopes the exchange carries, signed with the webhook's secret (see the webhooks package).
+// The secret is only in the responses that create or rotate it. Only v2 serves webhooks.
+
+func (s *APIServer) ListWebhooks(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	webhooks, err := s.store.ListWebhooks(healthcareID)
+	if err != nil {
+		return internalError(err)
+	}
+	if webhooks == nil {
+		webhooks = []*mod.Webhook{}
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"webhooks": webhooks,
+		"fetched":  len(webhooks),
+	})
+}
+
+func (s *APIServer) CreateWebhook(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	req := mod.Webhook{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err)
+	}
+	webhook, err := mod.NewWebhook(healthcareID, &req)
+	if err != nil {
+		return webhookProblem(err)
+	}
+	if err := s.store.CreateWebhook(webhook); err != nil {
+		return internalError(err)
+	}
+	return writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"code":    i18n.WebhookCreated,
+		"message": msg(r, i18n.WebhookCreated),
+		"webhook": webhook,
+	})
+}
+
+func (s *APIServer) GetWebhook(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	id, _, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	webhook, err := s.store.GetWebhook(healthcareID, id)
+	if err != nil {
+		return webhookProblem(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{"webhook": webhook})
+}
+
+// UpdateWebhook changes the url, filters, description or active of a webhook, rotate_secret gives it a new secret
+func (s *APIServer) UpdateWebhook(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	id, _, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	patch := mod.WebhookPatch{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return newProblem(http.StatusBadRequest, i18n.InvalidRequestBody).withCause(err)
+	}
+	if patch.URL == nil && patch.Events == nil && patch.Description == nil && patch.Active == nil && !patch.RotateSecret {
+		return newProblem(http.StatusBadRequest, i18n.NoFieldsToUpdate)
+	}
+	webhook, err := s.store.UpdateWebhook(healthcareID, id, &patch)
+	if err != nil {
+		return webhookProblem(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"code":    i18n.WebhookUpdated,
+		"message": msg(r, i18n.WebhookUpdated),
+		"webhook": webhook,
+	})
+}
+
+// DeleteWebhook removes the webhook with its deliveries, pending ones are not sent
+func (s *APIServer) DeleteWebhook(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	id, _, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	if err := s.store.DeleteWebhook(healthcareID, id); err != nil {
+		return webhookProblem(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"code":    i18n.WebhookDeleted,
+		"message": msg(r, i18n.WebhookDeleted),
+		"id":      id,
+	})
+}
+
+func (s *APIServer) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	id, _, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status != "" && status != "pending" && status != "delivered" && status != "failed" {
+		return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "status")
+	}
+	limit := 20
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 100 {
+			return newProblem(http.StatusBadRequest, i18n.InvalidQueryParam, "limit")
+		}
+	}
+	deliveries, err := s.store.ListWebhookDeliveries(healthcareID, id, status, int64(limit))
+	if err != nil {
+		return webhookProblem(err)
+	}
+	if deliveries == nil {
+		deliveries = []*mod.WebhookDelivery{}
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"deliveries": deliveries,
+		"fetched":    len(deliveries),
+	})
+}
+
+// GetWebhookDelivery is a delivery with the event it carries and every attempt made for it
+func (s *APIServer) GetWebhookDelivery(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	id, deliveryID, err := webhookDeliveryPath(r)
+	if err != nil {
+		return err
+	}
+	delivery, err := s.store.GetWebhookDelivery(healthcareID, id, deliveryID)
+	if err != nil {
+		return webhookProblem(err)
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{"delivery": delivery})
+}
+
+// RedeliverWebhook queues a delivery again whatever its status, it goes out within a second
+func (s *APIServer) RedeliverWebhook(w http.ResponseWriter, r *http.Request) error {
+	healthcareID, ok := r.Context().Value(contextKeyHealthCareID).(string)
+	if !ok {
+		return missingClaim("healthcareID")
+	}
+	id, deliveryID, err := webhookDeliveryPath(r)
+	if err != nil {
+		return err
+	}
+	delivery, err := s.store.RedeliverWebhook(healthcareID, id, deliveryID)
+	if err != nil {
+		return webhookProblem(err)
+	}
+	return writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"code":     i18n.WebhookRedeliveryQueued,
+		"message":  msg(r, i18n.WebhookRedeliveryQueued),
+		"delivery": delivery,
+	})
+}
+
+func webhookDeliveryPath(r *http.Request) (id, deliveryID int64, err error) {
+	if id, _, err = pathID(r, "id"); err != nil {
+		return 0, 0, err
+	}
+	deliveryID, _, err = pathID(r, "delivery_id")
+	return id, deliveryID, err
+}
+
+func webhookProblem(err error) *Problem {
+	var filterErr *mod.EventFilterError
+	switch {
+	case errors.Is(err, mod.ErrWebhookNotFound):
+		return newProblem(http.StatusNotFound, i18n.WebhookNotFound).withCause(err)
+	case errors.Is(err, mod.ErrWebhookDeliveryNotFound):
+		return newProblem(http.StatusNotFound, i18n.WebhookDeliveryNotFound).withCause(err)
+	case errors.Is(err, mod.ErrInvalidWebhookURL):
+		return newProblem(http.StatusUnprocessableEntity, i18n.InvalidWebhookURL).withCause(err)
+	case errors.As(err, &filterErr):
+		return newProblem(http.StatusUnprocessableEntity, i18n.InvalidEventFilter, filterErr.Filter).withCause(err)
+	}
+	var verr *mod.ValidationError
+	if errors.As(err, &verr) {
+		return validationProblem(err)
+	}
+	return internalError(err)
+}
@@ -0,0 +1,133 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// A webhook URL is chosen by the HIP, so posting to it must not reach anything inside our own
+// network: loopback, private, link-local (the cloud metadata endpoint among them) and the like.
+// CheckURL resolves the host when a webhook is registered, and the dialer checks the address it
+// actually connects to on every delivery, so a name that resolves elsewhere later is caught too.
+// AllowPrivate turns both checks off for development, where the receiver runs on the same machine.
+
+var (
+	ErrInvalidURL       = errors.New("webhook url must be https, without credentials")
+	ErrForbiddenAddress = errors.New("webhook host is a loopback, private or link-local address")
+)
+
+// how long registering a webhook waits for its host to resolve
+const resolveTimeout = 5 * time.Second
+
+// Resolve looks up the addresses of a host, tests replace it
+var Resolve = net.DefaultResolver.LookupIPAddr
+
+var allowPrivate atomic.Bool
+
+// AllowPrivate lets webhooks use plain http and reach loopback and private addresses,
+// WEBHOOKS_ALLOW_PRIVATE=true sets it for development
+func AllowPrivate(allow bool) {
+	allowPrivate.Store(allow)
+}
+
+// ranges the net.IP checks don't cover
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	// carrier-grade NAT, internal to the provider
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	// AWS metadata over IPv6
+	netip.MustParsePrefix("fd00:ec2::/32"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// forbidden reports whether ip is an address webhooks must not be posted to
+func forbidden(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, prefix := range forbiddenPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL makes sure raw is an https URL whose host resolves only to public addresses
+func CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.User != nil {
+		return ErrInvalidURL
+	}
+	host := u.Hostname()
+	if allowPrivate.Load() {
+		// plain http is for a receiver on this machine or network
+		if u.Scheme == "https" || (u.Scheme == "http" && local(host)) {
+			return nil
+		}
+		return ErrInvalidURL
+	}
+	if u.Scheme != "https" {
+		return ErrInvalidURL
+	}
+
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		defer cancel()
+		addrs, err := Resolve(ctx, host)
+		if err != nil {
+			return fmt.Errorf("failed to resolve webhook host %s: %w", host, err)
+		}
+		ips = ips[:0]
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if forbidden(ip) {
+			return fmt.Errorf("%w: %s is %s", ErrForbiddenAddress, host, ip)
+		}
+	}
+	return nil
+}
+
+// local is localhost or a literal loopback or private address
+func local(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && forbidden(ip)
+}
+
+// checkDial refuses a connection to a forbidden address, it runs after the name was resolved
+func checkDial(network, address string, _ syscall.RawConn) error {
+	if allowPrivate.Load() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || forbidden(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, address)
+	}
+	return nil
+}
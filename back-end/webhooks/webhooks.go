@@ -0,0 +1,144 @@
+// Package webhooks posts events to the endpoints HIPs registered for them. Every request
+// is signed with the webhook's secret so the receiver can tell it came from this server:
+//
+//	X-Webhook-Signature: sha256=<hex HMAC-SHA256 of "<X-Webhook-Timestamp>.<body>">
+//
+// The timestamp is part of what is signed, a receiver should refuse one that is too old
+// (see Verify) so a captured request can't be replayed later. The body is the event's
+// envelope as it is published to the exchange.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+
+	// how long a receiver has to answer
+	Timeout = 10 * time.Second
+	// how much of a response is kept in the attempt log
+	maxResponse = 1024
+)
+
+var (
+	ErrBadSignature = errors.New("webhook signature does not match")
+	ErrTooOld       = errors.New("webhook timestamp is outside the tolerance")
+)
+
+var client = &http.Client{
+	Timeout: Timeout,
+	// a redirect could send the signed body somewhere the HIP didn't register
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	Transport:     transport(),
+}
+
+// transport connects straight to the receiver, never through a proxy, so checkDial sees its address
+func transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = (&net.Dialer{Timeout: Timeout, Control: checkDial}).DialContext
+	return t
+}
+
+// Request is one attempt to deliver an event
+type Request struct {
+	DeliveryID int64
+	URL        string
+	Secret     string
+	EventType  string
+	Body       []byte
+}
+
+// Response is how the receiver answered, StatusCode is 0 when it couldn't be reached
+type Response struct {
+	StatusCode int
+	// the start of the response body
+	Body     string
+	Duration time.Duration
+	Err      error
+}
+
+// OK is a 2xx answer, anything else is tried again
+func (r Response) OK() bool {
+	return r.Err == nil && r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// NewSecret is the signing secret of a new webhook
+func NewSecret() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(key), nil
+}
+
+// Sign is the X-Webhook-Signature value of body sent at timestamp (unix seconds)
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a received request's signature and that it was sent within tolerance of now,
+// what a receiver written in Go would do with the two headers
+func Verify(secret, signature, timestamp string, body []byte, tolerance time.Duration) error {
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", TimestampHeader, err)
+	}
+	if age := time.Since(time.Unix(sent, 0)); age > tolerance || age < -tolerance {
+		return ErrTooOld
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, sent, body))) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Post sends req once and reports how it went, it never retries
+func Post(req Request) Response {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.URL, bytes.NewReader(req.Body))
+	if err != nil {
+		return Response{Err: err}
+	}
+	timestamp := time.Now().Unix()
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", "EthioHealthCare-Webhooks/1")
+	httpReq.Header.Set(EventHeader, req.EventType)
+	httpReq.Header.Set(DeliveryHeader, strconv.FormatInt(req.DeliveryID, 10))
+	httpReq.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	httpReq.Header.Set(SignatureHeader, Sign(req.Secret, timestamp, req.Body))
+
+	start := time.Now()
+	res, err := client.Do(httpReq)
+	if err != nil {
+		return Response{Duration: time.Since(start), Err: err}
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxResponse))
+	response := Response{StatusCode: res.StatusCode, Body: strings.ToValidUTF8(string(body), ""), Duration: time.Since(start)}
+	if !response.OK() {
+		response.Err = fmt.Errorf("receiver answered %s", res.Status)
+	}
+	return response
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mod "vaibhavyadav-dev/healthcareServer/databases"
+	evts "vaibhavyadav-dev/healthcareServer/events"
+	"vaibhavyadav-dev/healthcareServer/i18n"
+	"vaibhavyadav-dev/healthcareServer/webhooks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addWebhook registers a webhook for the HIP straight in the store, its id is {webhook_id}
+func (api *testAPI) addWebhook(t *testing.T, healthcareID, url string, filters ...string) *mod.Webhook {
+	t.Helper()
+	webhook, err := mod.NewWebhook(healthcareID, &mod.Webhook{URL: url, Events: filters})
+	require.NoError(t, err)
+	require.NoError(t, api.store.LocalStore.CreateWebhook(webhook))
+	api.vars["webhook_id"] = jsonNumber(webhook.ID)
+	return webhook
+}
+
+// resolveHosts points the test hosts at documentation addresses, internal.example at a private one
+func resolveHosts(t *testing.T) {
+	resolve := webhooks.Resolve
+	webhooks.Resolve = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		switch {
+		case host == "internal.example":
+			return []net.IPAddr{{IP: net.ParseIP("10.0.0.7")}}, nil
+		case strings.HasSuffix(host, ".example"):
+			return []net.IPAddr{{IP: net.ParseIP("203.0.113.10")}}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	t.Cleanup(func() { webhooks.Resolve = resolve })
+}
+
+func TestWebhooks(t *testing.T) {
+	resolveHosts(t)
+	registered := func(t *testing.T, api *testAPI) {
+		api.addWebhook(t, api.hip.HealthcareID, "https://hip.example/hooks", "appointment.status.changed")
+	}
+	runCases(t, []handlerCase{
+		{name: "created", method: "POST", path: v2 + "/webhooks",
+			body:   `{"url": "https://hip.example/hooks", "events": ["appointment.status.changed", "patient.profile.*"], "description": "front desk"}`,
+			status: http.StatusCreated, code: i18n.WebhookCreated,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				webhook := body["webhook"].(map[string]interface{})
+				assert.Contains(t, webhook["secret"], "whsec_")
+				assert.Equal(t, true, webhook["active"])
+				// the secret is never shown again
+				listed := api.do("GET", v2+"/webhooks", "", nil)
+				assert.NotContains(t, listed.Body.String(), webhook["secret"])
+			}},
+		{name: "plain http", method: "POST", path: v2 + "/webhooks", body: `{"url": "http://hip.example/hooks", "events": ["#"]}`,
+			status: http.StatusUnprocessableEntity, code: i18n.InvalidWebhookURL},
+		{name: "host on a private network", method: "POST", path: v2 + "/webhooks", body: `{"url": "https://internal.example/hooks", "events": ["#"]}`,
+			status: http.StatusUnprocessableEntity, code: i18n.InvalidWebhookURL},
+		{name: "metadata address", method: "POST", path: v2 + "/webhooks", body: `{"url": "https://169.254.169.254/latest/meta-data", "events": ["#"]}`,
+			status: http.StatusUnprocessableEntity, code: i18n.InvalidWebhookURL},
+		{name: "receiver on this machine", method: "POST", path: v2 + "/webhooks", body: `{"url": "http://127.0.0.1:8080/hooks", "events": ["#"]}`,
+			status: http.StatusUnprocessableEntity, code: i18n.InvalidWebhookURL},
+		{name: "host that doesn't resolve", method: "POST", path: v2 + "/webhooks", body: `{"url": "https://hip.invalid/hooks", "events": ["#"]}`,
+			status: http.StatusUnprocessableEntity, code: i18n.InvalidWebhookURL},
+		{name: "filter matching nothing", method: "POST", path: v2 + "/webhooks", body: `{"url": "https://hip.example/hooks", "events": ["appointment.booked"]}`,
+			status: http.StatusUnprocessableEntity, code: i18n.InvalidEventFilter},
+		{name: "no filters", method: "POST", path: v2 + "/webhooks", body: `{"url": "https://hip.example/hooks"}`,
+			status: http.StatusUnprocessableEntity, code: i18n.ValidationFailed},
+		{name: "not json", method: "POST", path: v2 + "/webhooks", body: `url=https://hip.example`,
+			status: http.StatusBadRequest, code: i18n.InvalidRequestBody},
+
+		{name: "listed", method: "GET", path: v2 + "/webhooks", setup: registered, status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, float64(1), body["fetched"])
+			}},
+		{name: "list unavailable", method: "GET", path: v2 + "/webhooks",
+			setup:  func(t *testing.T, api *testAPI) { api.store.failWith("ListWebhooks", errStoreDown) },
+			status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "one", method: "GET", path: v2 + "/webhooks/{webhook_id}", setup: registered, status: http.StatusOK},
+		{name: "another HIP's", method: "GET", path: v2 + "/webhooks/{webhook_id}",
+			setup: func(t *testing.T, api *testAPI) {
+				other := *api.hip
+				other.HealthcareID, other.HealthcareLicense = "HCID0000000000000002", "LIC-NAZRET-0002"
+				other.HealthcareName, other.Email = "Nazret Clinic", "hip@nazret.example"
+				_, err := api.store.LocalStore.SignUpAccount(&other)
+				require.NoError(t, err)
+				api.addWebhook(t, other.HealthcareID, "https://nazret.example/hooks", "#")
+			},
+			status: http.StatusNotFound, code: i18n.WebhookNotFound},
+
+		{name: "paused", method: "PATCH", path: v2 + "/webhooks/{webhook_id}", body: `{"active": false}`, setup: registered,
+			status: http.StatusOK, code: i18n.WebhookUpdated,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				webhook := body["webhook"].(map[string]interface{})
+				assert.Equal(t, false, webhook["active"])
+				assert.Nil(t, webhook["secret"])
+			}},
+		{name: "secret rotated", method: "PATCH", path: v2 + "/webhooks/{webhook_id}", body: `{"rotate_secret": true}`, setup: registered,
+			status: http.StatusOK, code: i18n.WebhookUpdated,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Contains(t, body["webhook"].(map[string]interface{})["secret"], "whsec_")
+			}},
+		{name: "nothing to change", method: "PATCH", path: v2 + "/webhooks/{webhook_id}", body: `{}`, setup: registered,
+			status: http.StatusBadRequest, code: i18n.NoFieldsToUpdate},
+		{name: "changed to a bad filter", method: "PATCH", path: v2 + "/webhooks/{webhook_id}", body: `{"events": ["patients.#"]}`, setup: registered,
+			status: http.StatusUnprocessableEntity, code: i18n.InvalidEventFilter},
+
+		{name: "deleted", method: "DELETE", path: v2 + "/webhooks/{webhook_id}", setup: registered, status: http.StatusOK, code: i18n.WebhookDeleted,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, http.StatusNotFound, api.do("GET", v2+"/webhooks/{webhook_id}", "", nil).Code)
+			}},
+		{name: "delete unknown", method: "DELETE", path: v2 + "/webhooks/99", status: http.StatusNotFound, code: i18n.WebhookNotFound},
+
+		{name: "no deliveries yet", method: "GET", path: v2 + "/webhooks/{webhook_id}/deliveries", setup: registered, status: http.StatusOK,
+			check: func(t *testing.T, api *testAPI, rec *httptest.ResponseRecorder, body map[string]interface{}) {
+				assert.Equal(t, float64(0), body["fetched"])
+			}},
+		{name: "deliveries with unknown status", method: "GET", path: v2 + "/webhooks/{webhook_id}/deliveries?status=lost", setup: registered,
+			status: http.StatusBadRequest, code: i18n.InvalidQueryParam},
+		{name: "unknown delivery", method: "GET", path: v2 + "/webhooks/{webhook_id}/deliveries/99", setup: registered,
+			status: http.StatusNotFound, code: i18n.WebhookDeliveryNotFound},
+		{name: "redeliver unknown delivery", method: "POST", path: v2 + "/webhooks/{webhook_id}/deliveries/99/redeliver", setup: registered,
+			status: http.StatusNotFound, code: i18n.WebhookDeliveryNotFound},
+	})
+}
+
+// receiver is a HIP's endpoint, it checks every request's signature and answers with status
+type receiver struct {
+	*httptest.Server
+	status   atomic.Int32
+	received chan map[string]interface{}
+}
+
+func newReceiver(t *testing.T, secret *string) *receiver {
+	rcv := &receiver{received: make(chan map[string]interface{}, 10)}
+	rcv.status.Store(http.StatusOK)
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.NoError(t, webhooks.Verify(*secret, r.Header.Get(webhooks.SignatureHeader), r.Header.Get(webhooks.TimestampHeader), body, time.Minute))
+		event := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, event["type"], r.Header.Get(webhooks.EventHeader))
+		w.WriteHeader(int(rcv.status.Load()))
+		rcv.received <- event
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+func (rcv *receiver) next(t *testing.T) map[string]interface{} {
+	t.Helper()
+	select {
+	case event := <-rcv.received:
+		return event
+	case <-time.After(3 * time.Second):
+		t.Fatal("nothing delivered to the webhook")
+		return nil
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	// the receiver listens on 127.0.0.1, as in development
+	webhooks.AllowPrivate(true)
+	t.Cleanup(func() { webhooks.AllowPrivate(false) })
+	api := newTestAPI(t)
+	var secret string
+	rcv := newReceiver(t, &secret)
+
+	res := api.do("POST", v2+"/webhooks", fmt.Sprintf(`{"url": %q, "events": ["patient.record.created", "appointment.#"]}`, rcv.URL), nil)
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+	var created struct {
+		Webhook mod.Webhook `json:"webhook"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &created))
+	secret = created.Webhook.Secret
+	api.vars["webhook_id"] = jsonNumber(created.Webhook.ID)
+
+	// a new record reaches the HIP's endpoint signed
+	res = api.do("POST", v2+"/patients/{health_id}/records", `{"issue": "fever", "description": "high fever for two days", "medical_severity": "High"}`, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	event := rcv.next(t)
+	assert.Equal(t, "patient.record.created", event["type"])
+	assert.Equal(t, api.vars["health_id"], event["data"].(map[string]interface{})["health_id"])
+
+	// the endpoint is down when the appointment is confirmed
+	rcv.status.Store(http.StatusServiceUnavailable)
+	res = api.do("PATCH", v2+"/appointments/7", `{"health_id": "{health_id}", "status": "Confirmed"}`, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	assert.Equal(t, "appointment.status.changed", rcv.next(t)["type"])
+
+	var failed *mod.WebhookDelivery
+	require.Eventually(t, func() bool {
+		deliveries, err := api.store.ListWebhookDeliveries(api.hip.HealthcareID, created.Webhook.ID, "pending", 10)
+		require.NoError(t, err)
+		if len(deliveries) == 1 && deliveries[0].Attempts == 1 {
+			failed = deliveries[0]
+		}
+		return failed != nil
+	}, 3*time.Second, 20*time.Millisecond)
+	assert.Equal(t, http.StatusServiceUnavailable, *failed.LastStatusCode)
+	api.vars["delivery_id"] = jsonNumber(failed.ID)
+
+	// back up, the admin sends it again instead of waiting for the retry
+	rcv.status.Store(http.StatusOK)
+	res = api.do("POST", v2+"/webhooks/{webhook_id}/deliveries/{delivery_id}/redeliver", "", nil)
+	require.Equal(t, http.StatusAccepted, res.Code, res.Body.String())
+	assert.Equal(t, "appointment.status.changed", rcv.next(t)["type"])
+
+	require.Eventually(t, func() bool {
+		res := api.do("GET", v2+"/webhooks/{webhook_id}/deliveries/{delivery_id}", "", nil)
+		var body struct {
+			Delivery mod.WebhookDelivery `json:"delivery"`
+		}
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+		return body.Delivery.Status == "delivered" && len(body.Delivery.History) == 2
+	}, 3*time.Second, 20*time.Millisecond)
+
+	res = api.do("GET", v2+"/webhooks/{webhook_id}/deliveries?status=delivered", "", nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	assert.Contains(t, res.Body.String(), `"fetched":2`)
+}
+
+func TestWebhookAnotherHIPsAppointment(t *testing.T) {
+	webhooks.AllowPrivate(true)
+	t.Cleanup(func() { webhooks.AllowPrivate(false) })
+	api := newTestAPI(t)
+	other := *api.hip
+	other.HealthcareID, other.HealthcareLicense = "HCID0000000000000002", "LIC-NAZRET-0002"
+	other.HealthcareName, other.Email = "Nazret Clinic", "hip@nazret.example"
+	_, err := api.store.LocalStore.SignUpAccount(&other)
+	require.NoError(t, err)
+	var secret string
+	rcv := newReceiver(t, &secret)
+	webhook := api.addWebhook(t, other.HealthcareID, rcv.URL, "appointment.#")
+	secret = webhook.Secret
+	api.bookAppointment(t, 2, other.HealthcareID, api.vars["health_id"])
+
+	// naming the other HIP in the body doesn't make its appointment this HIP's
+	res := api.do("PATCH", v2+"/appointments/2", `{"health_id": "{health_id}", "healthcare_id": "HCID0000000000000002", "status": "Rejected"}`, nil)
+	require.Equal(t, http.StatusNotFound, res.Code, res.Body.String())
+
+	// the other HIP's own change is the first thing its webhook gets
+	changed, err := evts.Message(evts.AppointmentStatusChanged{HealthcareID: other.HealthcareID, AppointmentID: 2, HealthID: api.vars["health_id"], Status: "Confirmed"})
+	require.NoError(t, err)
+	require.NoError(t, api.store.Enqueue(changed))
+	event := rcv.next(t)
+	assert.Equal(t, "Confirmed", event["data"].(map[string]interface{})["status"])
+	deliveries, err := api.store.ListWebhookDeliveries(other.HealthcareID, webhook.ID, "", 10)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+}